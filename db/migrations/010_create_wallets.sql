@@ -0,0 +1,68 @@
+-- Migration: Customer wallets with store credit and gift cards
+-- Balances are kept in minor units (cents) so they can never drift through float rounding.
+
+CREATE TABLE IF NOT EXISTS wallets (
+    user_id VARCHAR(255) PRIMARY KEY,
+    balance_minor BIGINT NOT NULL DEFAULT 0,
+    currency VARCHAR(3) NOT NULL DEFAULT 'usd',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    -- Last line of defence: a balance can never go negative even if application checks are bypassed
+    CONSTRAINT check_wallet_balance_non_negative CHECK (balance_minor >= 0)
+);
+
+CREATE TABLE IF NOT EXISTS wallet_transactions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id VARCHAR(255) NOT NULL REFERENCES wallets(user_id),
+    amount_minor BIGINT NOT NULL CHECK (amount_minor <> 0),
+    balance_after_minor BIGINT NOT NULL CHECK (balance_after_minor >= 0),
+    kind VARCHAR(50) NOT NULL,
+    reference_id VARCHAR(255),
+    description TEXT,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT check_wallet_transaction_kind
+        CHECK (kind IN ('refund_credit', 'gift_card_redemption', 'promotion', 'reservation_payment', 'reservation_payment_reversal'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_wallet_transactions_user ON wallet_transactions(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_wallet_transactions_reference ON wallet_transactions(reference_id) WHERE reference_id IS NOT NULL;
+
+-- The transaction log is append-only
+CREATE OR REPLACE FUNCTION prevent_wallet_transaction_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'wallet_transactions is append-only';
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS wallet_transactions_immutable ON wallet_transactions;
+CREATE TRIGGER wallet_transactions_immutable
+    BEFORE UPDATE OR DELETE ON wallet_transactions
+    FOR EACH ROW
+    EXECUTE FUNCTION prevent_wallet_transaction_changes();
+
+CREATE TABLE IF NOT EXISTS gift_cards (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    code VARCHAR(32) NOT NULL UNIQUE,
+    amount_minor BIGINT NOT NULL CHECK (amount_minor > 0),
+    currency VARCHAR(3) NOT NULL DEFAULT 'usd',
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    purchaser_user_id VARCHAR(255),
+    recipient_email VARCHAR(255),
+    payment_id VARCHAR(255) UNIQUE,
+    redeemed_by VARCHAR(255),
+    redeemed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT check_gift_card_status CHECK (status IN ('active', 'redeemed', 'void'))
+);
+
+-- Portion of a reservation paid from the wallet (the rest is paid by card)
+ALTER TABLE reservations ADD COLUMN IF NOT EXISTS wallet_amount DECIMAL(10,2) NOT NULL DEFAULT 0;
+
+COMMENT ON TABLE wallets IS 'Per-user store credit balance in minor units';
+COMMENT ON TABLE wallet_transactions IS 'Immutable log of every wallet credit and debit';
+COMMENT ON COLUMN reservations.wallet_amount IS 'Amount of the reservation paid from the customer wallet';
@@ -0,0 +1,52 @@
+-- Migration: Make pay-at-store a first-class payment method and track cash collection
+
+-- Backfill existing rows from the payment_id conventions used so far, only when the
+-- columns are first added: a re-run must not reset payments collected since
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_name = 'reservations' AND column_name = 'payment_method') THEN
+        ALTER TABLE reservations
+        ADD COLUMN payment_method VARCHAR(30) NOT NULL DEFAULT 'card',
+        ADD COLUMN IF NOT EXISTS payment_status VARCHAR(20) NOT NULL DEFAULT 'paid';
+
+        UPDATE reservations
+        SET payment_method = 'pay_at_store', payment_status = 'unpaid'
+        WHERE payment_id LIKE 'pay_at_store_%'
+           OR payment_id LIKE 'guest-pay-%'
+           OR payment_id LIKE 'pay-%';
+
+        UPDATE reservations
+        SET payment_method = 'wallet'
+        WHERE payment_id LIKE 'wallet_%';
+    END IF;
+END $$;
+
+ALTER TABLE reservations
+ADD COLUMN IF NOT EXISTS payment_status VARCHAR(20) NOT NULL DEFAULT 'paid',
+ADD COLUMN IF NOT EXISTS amount_collected DECIMAL(10,2),
+ADD COLUMN IF NOT EXISTS collection_method VARCHAR(20),
+ADD COLUMN IF NOT EXISTS collected_at TIMESTAMP WITH TIME ZONE,
+ADD COLUMN IF NOT EXISTS collected_by VARCHAR(255);
+
+ALTER TABLE reservations DROP CONSTRAINT IF EXISTS check_payment_method;
+ALTER TABLE reservations ADD CONSTRAINT check_payment_method
+    CHECK (payment_method IN ('card', 'wallet', 'pay_at_store'));
+
+ALTER TABLE reservations DROP CONSTRAINT IF EXISTS check_payment_status;
+ALTER TABLE reservations ADD CONSTRAINT check_payment_status
+    CHECK (payment_status IN ('unpaid', 'paid', 'refunded'));
+
+ALTER TABLE reservations DROP CONSTRAINT IF EXISTS check_collection_method;
+ALTER TABLE reservations ADD CONSTRAINT check_collection_method
+    CHECK (collection_method IS NULL OR collection_method IN ('cash', 'bank_transfer'));
+
+-- End-of-day reconciliation looks up pay-at-store reservations per store and day
+CREATE INDEX IF NOT EXISTS idx_reservations_pay_at_store
+ON reservations (store_id, pickup_timestamp)
+WHERE payment_method = 'pay_at_store';
+
+COMMENT ON COLUMN reservations.payment_method IS 'How the customer pays: card, wallet or pay_at_store';
+COMMENT ON COLUMN reservations.payment_status IS 'unpaid until staff collect payment for pay_at_store reservations';
+COMMENT ON COLUMN reservations.amount_collected IS 'Amount staff actually collected at pickup';
+COMMENT ON COLUMN reservations.collection_method IS 'cash or bank_transfer';
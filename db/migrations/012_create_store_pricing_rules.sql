@@ -0,0 +1,24 @@
+-- Migration: Time-based dynamic discounting rules per store
+-- A rule drops the bag price to a percentage of the regular price once the pickup
+-- window is within starts_minutes_before_close of closing, never going below floor_price.
+
+CREATE TABLE IF NOT EXISTS store_pricing_rules (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    store_id VARCHAR(36) NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    starts_minutes_before_close INTEGER NOT NULL CHECK (starts_minutes_before_close > 0 AND starts_minutes_before_close <= 1440),
+    percent_of_price DECIMAL(5,2) NOT NULL CHECK (percent_of_price > 0 AND percent_of_price <= 100),
+    floor_price DECIMAL(10,2) NOT NULL DEFAULT 0 CHECK (floor_price >= 0),
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_store_pricing_rules_store ON store_pricing_rules(store_id) WHERE enabled = true;
+
+-- Record the price actually charged and which rule produced it
+ALTER TABLE reservations ADD COLUMN IF NOT EXISTS unit_price DECIMAL(10,2);
+ALTER TABLE reservations ADD COLUMN IF NOT EXISTS pricing_rule_id UUID;
+
+COMMENT ON TABLE store_pricing_rules IS 'Per-store rules that lower the bag price as the pickup window closes';
+COMMENT ON COLUMN reservations.unit_price IS 'Per-bag price charged at checkout';
+COMMENT ON COLUMN reservations.pricing_rule_id IS 'Dynamic pricing rule applied at checkout (NULL for the regular price)';
@@ -0,0 +1,9 @@
+-- Migration: Link Firebase users to Stripe Customers so cards can be saved
+
+CREATE TABLE IF NOT EXISTS payment_customers (
+    user_id VARCHAR(255) PRIMARY KEY,
+    stripe_customer_id VARCHAR(255) NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON TABLE payment_customers IS 'Stripe Customer for each Firebase user that has saved a card or paid with one';
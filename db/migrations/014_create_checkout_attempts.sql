@@ -0,0 +1,26 @@
+-- Migration: Track card checkouts from PaymentIntent creation to payment or abandonment
+
+CREATE TABLE IF NOT EXISTS checkout_attempts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    payment_intent_id VARCHAR(255) NOT NULL UNIQUE,
+    user_id VARCHAR(255) NOT NULL,
+    store_id VARCHAR(36) NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    quantity INTEGER NOT NULL,
+    amount DECIMAL(10,2) NOT NULL,
+    wallet_amount DECIMAL(10,2) NOT NULL DEFAULT 0,
+    customer_email VARCHAR(255),
+    state VARCHAR(20) NOT NULL DEFAULT 'created',
+    reservation_id UUID,
+    reminder_sent_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT check_checkout_state CHECK (state IN ('created', 'paid', 'abandoned', 'failed'))
+);
+
+-- The abandonment job scans open attempts by age; the funnel report groups by store and day
+CREATE INDEX IF NOT EXISTS idx_checkout_attempts_open ON checkout_attempts(created_at) WHERE state = 'created';
+CREATE INDEX IF NOT EXISTS idx_checkout_attempts_store ON checkout_attempts(store_id, created_at);
+
+COMMENT ON TABLE checkout_attempts IS 'One row per card PaymentIntent created by CreateReservation';
+COMMENT ON COLUMN checkout_attempts.amount IS 'Reservation total including the wallet share';
+COMMENT ON COLUMN checkout_attempts.state IS 'created, paid, abandoned (timed out and cancelled) or failed (card declined)';
@@ -0,0 +1,4 @@
+-- Migration: Owners can run several stores (branches)
+-- The oldest store is the owner's primary store, used by the unscoped /api/store-owner routes.
+
+CREATE INDEX IF NOT EXISTS idx_stores_owner ON stores(owner_id, created_at);
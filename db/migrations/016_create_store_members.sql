@@ -0,0 +1,40 @@
+-- Migration: Store staff accounts with roles and email invitations
+-- stores.owner_id stays as the store's creator; access is decided by store_members.
+
+CREATE TABLE IF NOT EXISTS store_members (
+    store_id VARCHAR(36) NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    user_id VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL,
+    invited_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (store_id, user_id),
+    CONSTRAINT check_store_member_role CHECK (role IN ('owner', 'manager', 'staff'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_store_members_user ON store_members(user_id);
+
+-- Every existing store owner becomes the owner member of their stores
+INSERT INTO store_members (store_id, user_id, role, created_at)
+SELECT id, owner_id, 'owner', created_at FROM stores WHERE owner_id IS NOT NULL
+ON CONFLICT (store_id, user_id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS store_invitations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    store_id VARCHAR(36) NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    invited_by VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    accepted_by VARCHAR(255),
+    accepted_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT check_store_invitation_role CHECK (role IN ('manager', 'staff')),
+    CONSTRAINT check_store_invitation_status CHECK (status IN ('pending', 'accepted', 'revoked'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_store_invitations_store ON store_invitations(store_id, created_at);
+
+COMMENT ON TABLE store_members IS 'Users who can act for a store and their role there';
+COMMENT ON COLUMN store_invitations.token IS 'Random token sent by email and exchanged for a membership';
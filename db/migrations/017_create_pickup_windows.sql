@@ -0,0 +1,20 @@
+-- Migration: Dated pickup windows generated from the weekly pickup_schedules
+-- Reservations bind to a window instead of copying the free-text stores.pickup_time.
+
+CREATE TABLE IF NOT EXISTS pickup_windows (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    store_id VARCHAR(36) NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ends_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT check_pickup_window_range CHECK (ends_at > starts_at),
+    CONSTRAINT unique_pickup_window UNIQUE (store_id, starts_at, ends_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_pickup_windows_upcoming ON pickup_windows(store_id, ends_at);
+
+ALTER TABLE reservations ADD COLUMN IF NOT EXISTS pickup_window_id UUID REFERENCES pickup_windows(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_reservations_pickup_window ON reservations(pickup_window_id);
+
+COMMENT ON TABLE pickup_windows IS 'Concrete pickup slots for the next days, regenerated when the schedule changes and by the refresh-pickup-windows job';
+COMMENT ON COLUMN reservations.pickup_window_id IS 'Window the customer picks up in; pickup_timestamp is its start';
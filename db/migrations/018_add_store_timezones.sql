@@ -0,0 +1,38 @@
+-- Migration: Per-store timezones and timezone-aware timestamps
+-- Pickup schedules stay as local clock times; they are converted with the store's zone.
+
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT 'Asia/Ho_Chi_Minh';
+
+-- Every store so far is in Vietnam; the few with another country get its zone
+UPDATE stores SET timezone = CASE LOWER(TRIM(country))
+    WHEN 'thailand' THEN 'Asia/Bangkok'
+    WHEN 'cambodia' THEN 'Asia/Phnom_Penh'
+    WHEN 'laos' THEN 'Asia/Vientiane'
+    WHEN 'singapore' THEN 'Asia/Singapore'
+    WHEN 'malaysia' THEN 'Asia/Kuala_Lumpur'
+    WHEN 'philippines' THEN 'Asia/Manila'
+    ELSE 'Asia/Ho_Chi_Minh'
+END
+WHERE timezone = 'Asia/Ho_Chi_Minh';
+
+-- Some databases were created with plain TIMESTAMP columns. The server always wrote them
+-- from a UTC session, so their values are UTC wall-clock times.
+DO $$
+DECLARE
+    col RECORD;
+BEGIN
+    FOR col IN
+        SELECT table_name, column_name
+        FROM information_schema.columns
+        WHERE table_schema = 'public'
+        AND data_type = 'timestamp without time zone'
+        AND table_name IN ('stores', 'reservations', 'pickup_windows', 'checkout_attempts', 'store_invitations')
+    LOOP
+        EXECUTE format(
+            'ALTER TABLE %I ALTER COLUMN %I TYPE TIMESTAMP WITH TIME ZONE USING %I AT TIME ZONE ''UTC''',
+            col.table_name, col.column_name, col.column_name
+        );
+    END LOOP;
+END $$;
+
+COMMENT ON COLUMN stores.timezone IS 'IANA zone used for pickup schedules, "today" and times shown to customers';
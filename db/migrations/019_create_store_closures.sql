@@ -0,0 +1,24 @@
+-- Migration: Holiday and one-off closures that override the weekly pickup schedule
+
+CREATE TABLE IF NOT EXISTS store_closures (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    store_id VARCHAR(36) NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    closed BOOLEAN NOT NULL DEFAULT true,
+    start_time TIME,
+    end_time TIME,
+    reason TEXT,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT check_closure_dates CHECK (end_date >= start_date),
+    CONSTRAINT check_closure_hours CHECK (closed OR (start_time IS NOT NULL AND end_time IS NOT NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_store_closures_store ON store_closures(store_id, end_date);
+
+-- Windows that stop applying but already have reservations are kept and cancelled
+ALTER TABLE pickup_windows ADD COLUMN IF NOT EXISTS cancelled BOOLEAN NOT NULL DEFAULT false;
+
+COMMENT ON TABLE store_closures IS 'Dates (in the store''s timezone) when the store is closed or has special pickup hours';
+COMMENT ON COLUMN store_closures.closed IS 'true: no pickups at all; false: start_time-end_time replace the weekly schedule';
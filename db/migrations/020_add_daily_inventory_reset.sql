@@ -0,0 +1,41 @@
+-- Migration: Daily inventory reset from bag_details.daily_count
+
+-- Store-local time of the daily reset; NULL resets at the start of the day's first
+-- pickup window, or at midnight for stores without one
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS inventory_reset_time TIME;
+-- Set when the store stopped selling because it sold out, so a replenish can turn selling
+-- back on without overriding an owner who paused the store themselves
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS sold_out_paused BOOLEAN NOT NULL DEFAULT false;
+
+-- "Today only" quantities that replace the daily count on one date
+CREATE TABLE IF NOT EXISTS inventory_overrides (
+    store_id VARCHAR(36) NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    business_date DATE NOT NULL,
+    quantity INTEGER NOT NULL,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (store_id, business_date),
+    CONSTRAINT check_inventory_override_quantity CHECK (quantity >= 0)
+);
+
+-- One row per reset, scheduled or triggered by an override for today
+CREATE TABLE IF NOT EXISTS inventory_resets (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    store_id VARCHAR(36) NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    business_date DATE NOT NULL,
+    trigger VARCHAR(20) NOT NULL,
+    source VARCHAR(20) NOT NULL,
+    quantity INTEGER NOT NULL,
+    previous_quantity INTEGER,
+    reset_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT check_inventory_reset_trigger CHECK (trigger IN ('scheduled', 'override')),
+    CONSTRAINT check_inventory_reset_source CHECK (source IN ('daily_count', 'override'))
+);
+
+-- The scheduled reset runs once per store-local day
+CREATE UNIQUE INDEX IF NOT EXISTS idx_inventory_resets_scheduled
+    ON inventory_resets(store_id, business_date) WHERE trigger = 'scheduled';
+CREATE INDEX IF NOT EXISTS idx_inventory_resets_store ON inventory_resets(store_id, created_at DESC);
+
+COMMENT ON TABLE inventory_resets IS 'Audit of items_left/bags_available being restored by the reset-daily-inventory job or a today-only override';
@@ -0,0 +1,21 @@
+-- Migration: Store photos uploaded to object storage and resized into variants
+
+CREATE TABLE IF NOT EXISTS store_images (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    store_id VARCHAR(36) NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL,
+    storage_prefix TEXT NOT NULL,
+    thumbnail_url TEXT NOT NULL,
+    card_url TEXT NOT NULL,
+    hero_url TEXT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    uploaded_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT check_store_image_kind CHECK (kind IN ('image', 'background', 'avatar'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_store_images_store ON store_images(store_id, kind, created_at DESC);
+
+COMMENT ON TABLE store_images IS 'Uploaded store photos; the variant matching the kind is copied to stores.image_url, background_url or avatar_url';
+COMMENT ON COLUMN store_images.storage_prefix IS 'Object key prefix; variants are stored as <prefix>/<variant>.jpg';
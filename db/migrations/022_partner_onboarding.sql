@@ -0,0 +1,68 @@
+-- Migration: Partner onboarding pipeline
+-- partner_contacts used to be created by SubmitPartnerContact on every request; it now
+-- holds partner applications moving through onboarding stages.
+
+CREATE TABLE IF NOT EXISTS partner_contacts (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    phone VARCHAR(50) NOT NULL,
+    store_name VARCHAR(255) NOT NULL,
+    message TEXT,
+    status VARCHAR(20) DEFAULT 'new',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE partner_contacts ADD COLUMN IF NOT EXISTS stage VARCHAR(30) NOT NULL DEFAULT 'lead';
+ALTER TABLE partner_contacts ADD COLUMN IF NOT EXISTS assigned_to VARCHAR(255);
+ALTER TABLE partner_contacts ADD COLUMN IF NOT EXISTS partner_user_id VARCHAR(255);
+ALTER TABLE partner_contacts ADD COLUMN IF NOT EXISTS store_id VARCHAR(36) REFERENCES stores(id) ON DELETE SET NULL;
+ALTER TABLE partner_contacts ADD COLUMN IF NOT EXISTS stage_changed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE partner_contacts ALTER COLUMN created_at TYPE TIMESTAMP WITH TIME ZONE USING created_at AT TIME ZONE 'UTC';
+
+-- Old statuses: new -> lead, contacted -> contacted, completed -> approved. Only while
+-- the status column is still there; it is dropped below.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_name = 'partner_contacts' AND column_name = 'status') THEN
+        UPDATE partner_contacts SET stage = CASE status
+            WHEN 'contacted' THEN 'contacted'
+            WHEN 'completed' THEN 'approved'
+            ELSE 'lead'
+        END
+        WHERE stage = 'lead';
+    END IF;
+END $$;
+
+ALTER TABLE partner_contacts DROP COLUMN IF EXISTS status;
+ALTER TABLE partner_contacts DROP CONSTRAINT IF EXISTS check_partner_stage;
+ALTER TABLE partner_contacts ADD CONSTRAINT check_partner_stage
+    CHECK (stage IN ('lead', 'contacted', 'documents_received', 'approved', 'live', 'rejected'));
+
+CREATE INDEX IF NOT EXISTS idx_partner_contacts_stage ON partner_contacts(stage, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS partner_application_notes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    application_id INTEGER NOT NULL REFERENCES partner_contacts(id) ON DELETE CASCADE,
+    author_id VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_partner_application_notes ON partner_application_notes(application_id, created_at);
+
+-- Every stage change, for the application's timeline
+CREATE TABLE IF NOT EXISTS partner_application_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    application_id INTEGER NOT NULL REFERENCES partner_contacts(id) ON DELETE CASCADE,
+    from_stage VARCHAR(30),
+    to_stage VARCHAR(30) NOT NULL,
+    changed_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_partner_application_events ON partner_application_events(application_id, created_at);
+
+COMMENT ON COLUMN partner_contacts.partner_user_id IS 'Firebase UID provisioned for the partner on approval';
+COMMENT ON COLUMN partner_contacts.store_id IS 'Store created for the partner on approval';
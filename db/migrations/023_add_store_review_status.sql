@@ -0,0 +1,37 @@
+-- Migration: Store moderation before going live
+-- Only approved stores appear on the home page and in search. New stores start as
+-- drafts; material edits to an approved store send it back to pending_review.
+
+-- Stores that were already public stay public. This only runs when the column is first
+-- added; a re-run must not approve drafts waiting for moderation.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_name = 'stores' AND column_name = 'review_status') THEN
+        ALTER TABLE stores ADD COLUMN review_status VARCHAR(20) NOT NULL DEFAULT 'draft';
+        UPDATE stores SET review_status = 'approved';
+    END IF;
+END $$;
+
+ALTER TABLE stores DROP CONSTRAINT IF EXISTS check_store_review_status;
+ALTER TABLE stores ADD CONSTRAINT check_store_review_status
+    CHECK (review_status IN ('draft', 'pending_review', 'approved', 'suspended'));
+
+CREATE INDEX IF NOT EXISTS idx_stores_review_status ON stores(review_status);
+
+CREATE TABLE IF NOT EXISTS store_reviews (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    store_id VARCHAR(36) NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    action VARCHAR(20) NOT NULL,
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    reason TEXT,
+    changed_fields TEXT[],
+    actor_id VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT check_store_review_action CHECK (action IN ('submitted', 'edited', 'approved', 'rejected', 'suspended'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_store_reviews_store ON store_reviews(store_id, created_at DESC);
+
+COMMENT ON TABLE store_reviews IS 'History of store review status changes; the latest row holds the reason shown to the owner';
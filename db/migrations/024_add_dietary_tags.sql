@@ -0,0 +1,24 @@
+-- Migration: Dietary and allergen tags
+-- Stores and bags carry tags from a fixed vocabulary (see handlers/dietary.go); customers
+-- save the tags they care about on their profile and home and search filter on them.
+
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS dietary_tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE bag_details ADD COLUMN IF NOT EXISTS dietary_tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_stores_dietary_tags ON stores USING GIN (dietary_tags);
+
+-- user_profiles used to be created on first use by the profile handlers
+CREATE TABLE IF NOT EXISTS user_profiles (
+    user_id TEXT PRIMARY KEY,
+    name TEXT,
+    phone TEXT,
+    email TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+ALTER TABLE user_profiles ADD COLUMN IF NOT EXISTS dietary_preferences TEXT[] NOT NULL DEFAULT '{}';
+
+COMMENT ON COLUMN stores.dietary_tags IS 'Dietary and allergen tags that apply to every bag of the store';
+COMMENT ON COLUMN bag_details.dietary_tags IS 'Dietary and allergen tags of this bag, on top of the store tags';
+COMMENT ON COLUMN user_profiles.dietary_preferences IS 'Diet tags the customer requires and allergen tags they avoid';
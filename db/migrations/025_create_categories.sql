@@ -0,0 +1,55 @@
+-- Migration: Managed store categories
+-- store_type is free text ("Bakery", "bakery", "Bánh mì"), so stores are also mapped to a
+-- category from a two-level tree. store_type is kept as the owner typed it.
+
+CREATE TABLE IF NOT EXISTS categories (
+    slug VARCHAR(50) PRIMARY KEY,
+    parent_slug VARCHAR(50) REFERENCES categories(slug),
+    label_vi VARCHAR(100) NOT NULL,
+    label_en VARCHAR(100) NOT NULL,
+    icon VARCHAR(20) NOT NULL DEFAULT '',
+    sort_order INTEGER NOT NULL DEFAULT 0,
+    -- Lowercase spellings of store_type that map to this category
+    aliases TEXT[] NOT NULL DEFAULT '{}',
+    active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT check_category_slug CHECK (slug ~ '^[a-z0-9_]+$')
+);
+
+CREATE INDEX IF NOT EXISTS idx_categories_parent ON categories(parent_slug);
+
+INSERT INTO categories (slug, parent_slug, label_vi, label_en, icon, sort_order, aliases) VALUES
+    ('bakery', NULL, 'Tiệm bánh', 'Bakery', '🥐', 10, ARRAY['tiem banh', 'bakeries', 'banh', 'bánh']),
+    ('restaurant', NULL, 'Nhà hàng', 'Restaurant', '🍽️', 20, ARRAY['nha hang', 'restaurants', 'quán ăn', 'quan an']),
+    ('cafe', NULL, 'Cà phê', 'Café', '☕', 30, ARRAY['ca phe', 'cafe', 'coffee', 'coffee shop', 'quán cà phê', 'quan ca phe']),
+    ('dessert', NULL, 'Tráng miệng', 'Desserts', '🍨', 40, ARRAY['trang mieng', 'dessert', 'chè', 'che', 'kem', 'ice cream']),
+    ('grocery', NULL, 'Tạp hóa', 'Grocery', '🛒', 50, ARRAY['tap hoa', 'grocery store', 'minimart', 'mini mart'])
+ON CONFLICT (slug) DO NOTHING;
+
+INSERT INTO categories (slug, parent_slug, label_vi, label_en, icon, sort_order, aliases) VALUES
+    ('banh_mi', 'bakery', 'Bánh mì', 'Bánh mì', '🥖', 10, ARRAY['banh mi', 'banhmi', 'sandwich']),
+    ('pastry', 'bakery', 'Bánh ngọt', 'Pastries & cakes', '🍰', 20, ARRAY['banh ngot', 'cake', 'cakes', 'pastries']),
+    ('vietnamese', 'restaurant', 'Món Việt', 'Vietnamese', '🍜', 10, ARRAY['mon viet', 'phở', 'pho', 'bún', 'bun', 'cơm', 'com']),
+    ('asian', 'restaurant', 'Món Á', 'Asian', '🍱', 20, ARRAY['mon a', 'sushi', 'japanese', 'korean', 'chinese', 'thai']),
+    ('western', 'restaurant', 'Món Âu', 'Western', '🍕', 30, ARRAY['mon au', 'pizza', 'burger', 'italian']),
+    ('supermarket', 'grocery', 'Siêu thị', 'Supermarket', '🏬', 10, ARRAY['sieu thi', 'supermarkets']),
+    ('fruit_vegetables', 'grocery', 'Rau củ quả', 'Fruit & vegetables', '🥬', 20, ARRAY['rau cu qua', 'fruit', 'vegetables', 'produce'])
+ON CONFLICT (slug) DO NOTHING;
+
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS category_slug VARCHAR(50) REFERENCES categories(slug);
+
+CREATE INDEX IF NOT EXISTS idx_stores_category ON stores(category_slug);
+
+-- Map existing stores from their store_type, preferring the more specific subcategory
+UPDATE stores s SET category_slug = (
+    SELECT c.slug FROM categories c
+    WHERE lower(trim(s.store_type)) IN (c.slug, lower(c.label_vi), lower(c.label_en))
+       OR lower(trim(s.store_type)) = ANY(c.aliases)
+    ORDER BY c.parent_slug IS NULL, c.sort_order
+    LIMIT 1
+)
+WHERE s.category_slug IS NULL AND s.store_type IS NOT NULL;
+
+COMMENT ON TABLE categories IS 'Two-level store category tree used for browsing and search facets';
+COMMENT ON COLUMN stores.category_slug IS 'Category resolved from store_type; NULL when nothing matched';
@@ -0,0 +1,30 @@
+-- Migration: Owner-managed store highlights
+-- Owners keep an ordered list of highlights picked from suggestions or typed in. The
+-- row UpdateBagDetails writes for the bag category is kept apart as source 'category'.
+
+ALTER TABLE store_highlights ADD COLUMN IF NOT EXISTS position INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE store_highlights ADD COLUMN IF NOT EXISTS created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
+
+-- Until now only UpdateBagDetails wrote highlights. This only runs when the column is
+-- first added; a re-run must not relabel highlights owners have entered since.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_name = 'store_highlights' AND column_name = 'source') THEN
+        ALTER TABLE store_highlights ADD COLUMN source VARCHAR(20) NOT NULL DEFAULT 'custom';
+        UPDATE store_highlights SET source = 'category';
+    END IF;
+END $$;
+
+ALTER TABLE store_highlights DROP CONSTRAINT IF EXISTS check_store_highlight_source;
+ALTER TABLE store_highlights ADD CONSTRAINT check_store_highlight_source
+    CHECK (source IN ('category', 'suggested', 'custom'));
+
+-- Drop duplicates so the unique index can be built
+DELETE FROM store_highlights a
+USING store_highlights b
+WHERE a.store_id = b.store_id AND lower(a.highlight) = lower(b.highlight) AND a.ctid > b.ctid;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_store_highlights_unique ON store_highlights(store_id, lower(highlight));
+CREATE INDEX IF NOT EXISTS idx_store_highlights_store ON store_highlights(store_id, position);
+CREATE INDEX IF NOT EXISTS idx_store_highlights_text ON store_highlights(lower(highlight));
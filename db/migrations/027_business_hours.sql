@@ -0,0 +1,11 @@
+-- Migration: Weekly business hours
+-- A JSON array of {"day", "startTime", "endTime", "enabled"} ranges written by the
+-- business hours endpoint with days as "Monday" and times as "HH:MM". An end time at or
+-- before the start time runs overnight into the next day.
+
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS business_hours JSONB;
+
+-- Hours were never editable through the API; drop anything that is not an array so
+-- the open-now search filter can read every row
+UPDATE stores SET business_hours = NULL
+WHERE business_hours IS NOT NULL AND jsonb_typeof(business_hours::jsonb) <> 'array';
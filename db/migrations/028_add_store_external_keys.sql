@@ -0,0 +1,10 @@
+-- Migration: External keys for imported stores
+-- `savor-server import-stores` upserts stores by the key ops give each row of the
+-- spreadsheet, so re-running an import updates the stores it created.
+
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS external_key VARCHAR(100);
+
+ALTER TABLE stores DROP CONSTRAINT IF EXISTS stores_external_key_unique;
+ALTER TABLE stores ADD CONSTRAINT stores_external_key_unique UNIQUE (external_key);
+
+COMMENT ON COLUMN stores.external_key IS 'Key of the import row that created the store; NULL for stores created in the app';
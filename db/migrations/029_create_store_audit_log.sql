@@ -0,0 +1,32 @@
+-- Migration: Append-only audit log of store changes
+-- Every change an owner, staff member or admin makes to a store's settings, bag,
+-- inventory, hours, closures or members is recorded with who made it, the fields that changed, the source IP and the
+-- request ID. There is no foreign key so the history outlives the store.
+
+CREATE TABLE IF NOT EXISTS store_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    store_id VARCHAR(36) NOT NULL,
+    actor_id VARCHAR(255),
+    action VARCHAR(50) NOT NULL,
+    changes JSONB NOT NULL DEFAULT '{}',
+    ip_address VARCHAR(45),
+    request_id VARCHAR(64),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_store_audit_log_store ON store_audit_log(store_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_store_audit_log_actor ON store_audit_log(actor_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_store_audit_log_created ON store_audit_log(created_at);
+
+CREATE OR REPLACE FUNCTION prevent_store_audit_log_changes() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'store_audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS store_audit_log_append_only ON store_audit_log;
+CREATE TRIGGER store_audit_log_append_only
+    BEFORE UPDATE OR DELETE ON store_audit_log
+    FOR EACH ROW EXECUTE FUNCTION prevent_store_audit_log_changes();
+
+COMMENT ON TABLE store_audit_log IS 'Append-only history of store changes; changes maps each changed field to {"before", "after"}';
@@ -0,0 +1,54 @@
+-- Migration: Store offboarding and data retention
+-- A store leaving Savor is closed, never deleted: reservations and checkout attempts are
+-- financial records and must outlive the store. Reservations used to be deleted with
+-- their store (ON DELETE CASCADE); deleting a store with sales history now fails.
+
+ALTER TABLE reservations DROP CONSTRAINT IF EXISTS fk_reservations_store;
+ALTER TABLE reservations ADD CONSTRAINT fk_reservations_store
+    FOREIGN KEY (store_id) REFERENCES stores(id) ON DELETE RESTRICT;
+
+ALTER TABLE checkout_attempts DROP CONSTRAINT IF EXISTS checkout_attempts_store_id_fkey;
+ALTER TABLE checkout_attempts ADD CONSTRAINT checkout_attempts_store_id_fkey
+    FOREIGN KEY (store_id) REFERENCES stores(id) ON DELETE RESTRICT;
+
+-- closed is a review status so the store drops out of listings like a suspended one,
+-- and only an admin approval brings it back
+ALTER TABLE stores DROP CONSTRAINT IF EXISTS check_store_review_status;
+ALTER TABLE stores ADD CONSTRAINT check_store_review_status
+    CHECK (review_status IN ('draft', 'pending_review', 'approved', 'suspended', 'closed'));
+
+ALTER TABLE store_reviews DROP CONSTRAINT IF EXISTS check_store_review_action;
+ALTER TABLE store_reviews ADD CONSTRAINT check_store_review_action
+    CHECK (action IN ('submitted', 'edited', 'approved', 'rejected', 'suspended', 'closed'));
+
+-- Reservations cancelled by the store closing are refunded to the card by the
+-- refund-closed-store-reservations job until payment_status is refunded
+ALTER TABLE reservations ADD COLUMN IF NOT EXISTS cancellation_reason VARCHAR(30);
+CREATE INDEX IF NOT EXISTS idx_reservations_pending_refunds
+ON reservations (updated_at)
+WHERE cancellation_reason = 'store_closed' AND payment_status = 'paid';
+
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS closed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS closed_by VARCHAR(255);
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS records_retained_until TIMESTAMP WITH TIME ZONE;
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS pii_anonymized_at TIMESTAMP WITH TIME ZONE;
+
+-- The anonymize-closed-stores job may redact the owner's personal data from a closed
+-- store's audit entries; nothing else may change them
+CREATE OR REPLACE FUNCTION prevent_store_audit_log_changes() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND current_setting('savor.redacting_audit_log', true) = 'on' THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'store_audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+-- The anonymize-closed-stores job looks for closed stores past their retention period
+CREATE INDEX IF NOT EXISTS idx_stores_records_retained_until
+ON stores (records_retained_until)
+WHERE review_status = 'closed' AND pii_anonymized_at IS NULL;
+
+COMMENT ON COLUMN stores.closed_at IS 'When the store last left the platform; review_status is closed while it is off';
+COMMENT ON COLUMN stores.records_retained_until IS 'Financial records are kept with the owner''s details until then; owner PII is anonymized afterwards';
+COMMENT ON COLUMN stores.pii_anonymized_at IS 'When owner contact details were removed; an anonymized store cannot be reopened';
@@ -0,0 +1,11 @@
+-- Migration: Geospatial index on store locations
+-- Home, search and category browsing select stores within a radius with
+-- earth_box(ll_to_earth(lat, lng), radius) @> ll_to_earth(s.latitude, s.longitude);
+-- this index lets that filter skip stores outside the box instead of scanning them all.
+
+CREATE EXTENSION IF NOT EXISTS cube;
+CREATE EXTENSION IF NOT EXISTS earthdistance;
+
+CREATE INDEX IF NOT EXISTS idx_stores_earth_location
+ON stores USING gist (ll_to_earth(latitude, longitude))
+WHERE latitude IS NOT NULL AND longitude IS NOT NULL;
@@ -0,0 +1,8 @@
+-- Migration: One reservation per card payment
+-- /confirm and the checkout expiry job can finalize the same PaymentIntent at once;
+-- this index makes the second insert a no-op. Pay-at-store payment IDs are built from
+-- the checkout time and are not unique, so only card payments are covered.
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reservations_card_payment_id
+ON reservations(payment_id)
+WHERE payment_method = 'card' AND payment_id IS NOT NULL;
@@ -16,6 +16,7 @@ import (
 	firebase "firebase.google.com/go/v4"
 	"firebase.google.com/go/v4/auth"
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 )
 
 type SignUpInput struct {
@@ -290,19 +291,21 @@ func GetProfile(c *gin.Context) {
 	}
 
 	var name, phone, email string
+	dietaryPreferences := pq.StringArray{}
 	err := db.DB.QueryRow(`
-        SELECT COALESCE(name,''), COALESCE(phone,''), COALESCE(email,'')
+        SELECT COALESCE(name,''), COALESCE(phone,''), COALESCE(email,''), dietary_preferences
         FROM user_profiles WHERE user_id = $1
-    `, userId).Scan(&name, &phone, &email)
+    `, userId).Scan(&name, &phone, &email, &dietaryPreferences)
 
 	if err != nil {
 		log.Printf("GetProfile: No profile found for user_id %s (error: %v), returning empty profile", userId, err)
 		// Return minimal profile if not found
 		c.JSON(http.StatusOK, gin.H{
-			"user_id": userId,
-			"name":    "",
-			"phone":   "",
-			"email":   "",
+			"user_id":            userId,
+			"name":               "",
+			"phone":              "",
+			"email":              "",
+			"dietaryPreferences": []string{},
 		})
 		return
 	}
@@ -310,10 +313,11 @@ func GetProfile(c *gin.Context) {
 	log.Printf("GetProfile: Found profile for user_id %s - name: '%s', phone: '%s', email: '%s'", userId, name, phone, email)
 
 	c.JSON(http.StatusOK, gin.H{
-		"user_id": userId,
-		"name":    name,
-		"phone":   phone,
-		"email":   email,
+		"user_id":            userId,
+		"name":               name,
+		"phone":              phone,
+		"email":              email,
+		"dietaryPreferences": dietaryPreferences,
 	})
 }
 
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"savor-server/db"
+	"savor-server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const minutesPerWeek = 7 * 24 * 60
+
+// storeOpenNowSQL is true when store s is open right now by its weekly business hours in
+// its own zone. A range whose end is not after its start runs overnight into the next
+// day, so yesterday's overnight ranges are checked too.
+const storeOpenNowSQL = `EXISTS (
+	SELECT 1
+	FROM jsonb_array_elements(CASE WHEN jsonb_typeof(s.business_hours::jsonb) = 'array'
+		THEN s.business_hours::jsonb ELSE '[]'::jsonb END) h,
+		LATERAL (SELECT NOW() AT TIME ZONE s.timezone as local) n
+	WHERE COALESCE((h->>'enabled')::boolean, false)
+	AND (
+		(h->>'day' = to_char(n.local, 'FMDay')
+			AND n.local::time >= (h->>'startTime')::time
+			AND ((h->>'endTime')::time <= (h->>'startTime')::time OR n.local::time < (h->>'endTime')::time))
+		OR (h->>'day' = to_char(n.local - interval '1 day', 'FMDay')
+			AND (h->>'endTime')::time <= (h->>'startTime')::time
+			AND n.local::time < (h->>'endTime')::time)
+	)
+)`
+
+// storeOpenNowFilterSQL limits to open stores when the boolean param is true
+func storeOpenNowFilterSQL(param string) string {
+	return `(NOT ` + param + `::boolean OR ` + storeOpenNowSQL + `)`
+}
+
+// hoursRange is one enabled business hours range as minutes from Sunday 00:00
+type hoursRange struct {
+	weekday  time.Weekday
+	start    int // minutes after midnight
+	duration int
+}
+
+func (r hoursRange) weekStart() int {
+	return int(r.weekday)*24*60 + r.start
+}
+
+// normalizeBusinessHours validates the weekly hours and rewrites days as "Monday" and
+// times as "HH:MM", the form storeOpenNowSQL compares against. An end time at or before
+// the start time means the range closes the next day, e.g. 18:00-02:00.
+func normalizeBusinessHours(hours []models.BusinessHours) ([]models.BusinessHours, error) {
+	normalized := make([]models.BusinessHours, 0, len(hours))
+	var ranges []hoursRange
+	for i, h := range hours {
+		weekday, ok := parseWeekday(h.Day)
+		if !ok {
+			return nil, fmt.Errorf("business hours %d: unknown day %q", i+1, h.Day)
+		}
+		startHour, startMinute, err := parseClockTime(h.StartTime)
+		if err != nil {
+			return nil, fmt.Errorf("business hours %d: invalid startTime", i+1)
+		}
+		endHour, endMinute, err := parseClockTime(h.EndTime)
+		if err != nil {
+			return nil, fmt.Errorf("business hours %d: invalid endTime", i+1)
+		}
+		start, end := startHour*60+startMinute, endHour*60+endMinute
+		if start == end {
+			return nil, fmt.Errorf("business hours %d: startTime and endTime are the same; use 00:00-23:59 for a whole day", i+1)
+		}
+
+		normalized = append(normalized, models.BusinessHours{
+			Day:       weekday.String(),
+			StartTime: fmt.Sprintf("%02d:%02d", startHour, startMinute),
+			EndTime:   fmt.Sprintf("%02d:%02d", endHour, endMinute),
+			Enabled:   h.Enabled,
+		})
+		if h.Enabled {
+			ranges = append(ranges, newHoursRange(weekday, start, end))
+		}
+	}
+
+	if err := checkHoursOverlap(ranges); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+func newHoursRange(weekday time.Weekday, start, end int) hoursRange {
+	duration := end - start
+	if duration <= 0 {
+		duration += 24 * 60
+	}
+	return hoursRange{weekday: weekday, start: start, duration: duration}
+}
+
+// checkHoursOverlap rejects ranges that overlap, including an overnight Saturday range
+// running into Sunday's hours
+func checkHoursOverlap(ranges []hoursRange) error {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].weekStart() < ranges[j].weekStart() })
+	for i := range ranges {
+		next := ranges[(i+1)%len(ranges)]
+		nextStart := next.weekStart()
+		if i == len(ranges)-1 {
+			if len(ranges) == 1 {
+				break
+			}
+			nextStart += minutesPerWeek
+		}
+		if ranges[i].weekStart()+ranges[i].duration > nextStart {
+			return fmt.Errorf("business hours on %s overlap with %s", ranges[i].weekday, next.weekday)
+		}
+	}
+	return nil
+}
+
+// parseWeekday accepts the day names isScheduleDay does
+func parseWeekday(day string) (time.Weekday, bool) {
+	for w := time.Sunday; w <= time.Saturday; w++ {
+		if isScheduleDay(day, w) {
+			return w, true
+		}
+	}
+	return 0, false
+}
+
+// storeOpeningTimes works out from the weekly hours whether the store is open at now and,
+// if so, when it closes, and when it next opens. Dates fully closed by a store closure
+// are skipped. next is zero when the store has no enabled hours.
+func storeOpeningTimes(hours []models.BusinessHours, closures []StoreClosure, loc *time.Location, now time.Time) (open bool, closesAt, next time.Time) {
+	byDay := make(map[time.Weekday][]hoursRange)
+	for _, h := range hours {
+		if !h.Enabled {
+			continue
+		}
+		weekday, ok := parseWeekday(h.Day)
+		startHour, startMinute, errStart := parseClockTime(h.StartTime)
+		endHour, endMinute, errEnd := parseClockTime(h.EndTime)
+		if !ok || errStart != nil || errEnd != nil {
+			continue
+		}
+		byDay[weekday] = append(byDay[weekday], newHoursRange(weekday, startHour*60+startMinute, endHour*60+endMinute))
+	}
+	if len(byDay) == 0 {
+		return false, time.Time{}, time.Time{}
+	}
+	for _, ranges := range byDay {
+		sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+	}
+
+	// Start from yesterday for overnight ranges; look far enough ahead to get past a
+	// long closure
+	today := startOfDay(now, loc)
+	for offset := -1; offset <= 60; offset++ {
+		date := today.AddDate(0, 0, offset)
+		if closure := closureOn(closures, date); closure != nil && closure.Closed {
+			continue
+		}
+		for _, r := range byDay[date.Weekday()] {
+			start := date.Add(time.Duration(r.start) * time.Minute)
+			end := start.Add(time.Duration(r.duration) * time.Minute)
+			if !now.Before(start) && now.Before(end) {
+				open, closesAt = true, end
+			}
+			if start.After(now) {
+				return open, closesAt, start
+			}
+		}
+	}
+	return open, closesAt, time.Time{}
+}
+
+// applyOpeningHours sets IsOpenNow and NextOpeningTime on stores loaded with their
+// timezone and business_hours
+func applyOpeningHours(stores []models.Store, now time.Time) {
+	for i := range stores {
+		applyStoreOpeningHours(&stores[i], nil, now)
+	}
+}
+
+func applyStoreOpeningHours(store *models.Store, closures []StoreClosure, now time.Time) {
+	hours, err := store.GetBusinessHours()
+	if err != nil {
+		log.Printf("WARNING: Invalid business hours for store %s: %v", store.ID, err)
+		return
+	}
+	open, _, next := storeOpeningTimes(hours, closures, storeLocation(store.Timezone), now)
+	store.IsOpenNow = open
+	store.NextOpeningTime = sql.NullTime{Time: next, Valid: !next.IsZero()}
+}
+
+// GetBusinessHours returns the store's weekly hours and whether it is open now
+func GetBusinessHours(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermViewStore)
+	if !ok {
+		return
+	}
+
+	var store models.Store
+	err := db.DB.Get(&store, `SELECT id, timezone, business_hours FROM stores WHERE id = $1`, storeID)
+	if err != nil {
+		log.Printf("ERROR: Failed to load business hours for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get business hours"})
+		return
+	}
+	respondBusinessHours(c, store)
+}
+
+type UpdateBusinessHoursRequest struct {
+	Hours []models.BusinessHours `json:"hours"`
+}
+
+// UpdateBusinessHours replaces the store's weekly hours. A day may have several ranges;
+// days without an enabled range are closed.
+func UpdateBusinessHours(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req UpdateBusinessHoursRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hours, err := normalizeBusinessHours(req.Hours)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
+		return
+	}
+
+	encoded, err := json.Marshal(hours)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save business hours"})
+		return
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var store models.Store
+	audit, err := beginStoreAudit(tx, storeID)
+	if err == nil {
+		err = tx.Get(&store, `
+			UPDATE stores SET business_hours = $1, updated_at = NOW()
+			WHERE id = $2
+			RETURNING id, timezone, business_hours
+		`, string(encoded), storeID)
+	}
+	if err == nil {
+		err = audit.record(c, tx, StoreAuditBusinessHoursUpdated)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to save business hours for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save business hours"})
+		return
+	}
+	respondBusinessHours(c, store)
+}
+
+func respondBusinessHours(c *gin.Context, store models.Store) {
+	hours, err := store.GetBusinessHours()
+	if err != nil {
+		log.Printf("WARNING: Invalid business hours for store %s: %v", store.ID, err)
+		hours = []models.BusinessHours{}
+	}
+
+	loc := storeLocation(store.Timezone)
+	open, closesAt, next := storeOpeningTimes(hours, nil, loc, time.Now())
+	response := gin.H{
+		"hours":           hours,
+		"timezone":        loc.String(),
+		"isOpenNow":       open,
+		"nextOpeningTime": nil,
+	}
+	if open {
+		response["closesAt"] = closesAt.In(loc)
+	}
+	if !next.IsZero() {
+		response["nextOpeningTime"] = next.In(loc)
+	}
+	c.JSON(http.StatusOK, response)
+}
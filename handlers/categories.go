@@ -0,0 +1,531 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"savor-server/db"
+	"savor-server/models"
+	"savor-server/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultBrowseRadiusKm   = 10.0
+	maxBrowseRadiusKm       = 50.0
+	browseStoresPerCategory = 10
+	categoryStoresLimit     = 50
+)
+
+var categorySlugPattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+type Category struct {
+	Slug       string         `json:"slug" db:"slug"`
+	ParentSlug *string        `json:"parentSlug" db:"parent_slug"`
+	LabelVi    string         `json:"labelVi" db:"label_vi"`
+	LabelEn    string         `json:"labelEn" db:"label_en"`
+	Icon       string         `json:"icon" db:"icon"`
+	SortOrder  int            `json:"sortOrder" db:"sort_order"`
+	Aliases    pq.StringArray `json:"aliases" db:"aliases"`
+	Active     bool           `json:"active" db:"active"`
+	StoreCount int            `json:"storeCount" db:"-"`
+	Children   []*Category    `json:"children,omitempty" db:"-"`
+}
+
+// storeInCategorySQL matches stores in the category named by param or one of its
+// subcategories; an empty category matches every store
+func storeInCategorySQL(param string) string {
+	return `(` + param + `::text = '' OR s.category_slug IN (
+		SELECT slug FROM categories WHERE slug = ` + param + `::text OR parent_slug = ` + param + `::text
+	))`
+}
+
+// storeDistanceSQL is the straight-line distance in meters from store s to the point
+// (latParam, lngParam)
+func storeDistanceSQL(latParam, lngParam string) string {
+	return `earth_distance(ll_to_earth(s.latitude, s.longitude), ll_to_earth(` + latParam + `, ` + lngParam + `))`
+}
+
+// categoryForStoreType maps an owner-typed store type such as "Bakery" or "bánh mì" to
+// the best matching active category, preferring subcategories. NULL when nothing matches.
+func categoryForStoreType(q sqlx.Queryer, storeType string) (sql.NullString, error) {
+	var slug sql.NullString
+	storeType = strings.ToLower(strings.TrimSpace(storeType))
+	if storeType == "" {
+		return slug, nil
+	}
+	err := sqlx.Get(q, &slug, `
+		SELECT slug FROM categories
+		WHERE active AND (
+			$1::text IN (slug, lower(label_vi), lower(label_en)) OR $1::text = ANY(aliases)
+		)
+		ORDER BY parent_slug IS NULL, sort_order
+		LIMIT 1
+	`, storeType)
+	if err == sql.ErrNoRows {
+		return sql.NullString{}, nil
+	}
+	return slug, err
+}
+
+// loadCategoryTree returns the top-level categories with their subcategories, each
+// counting the live stores in it (a parent includes its children's stores)
+func loadCategoryTree(includeInactive bool) ([]*Category, error) {
+	var categories []*Category
+	err := db.DB.Select(&categories, `
+		SELECT slug, parent_slug, label_vi, label_en, icon, sort_order, aliases, active
+		FROM categories
+		WHERE active OR $1
+		ORDER BY sort_order, label_vi
+	`, includeInactive)
+	if err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		Slug  string `db:"category_slug"`
+		Count int    `db:"count"`
+	}
+	err = db.DB.Select(&counts, `
+		SELECT s.category_slug, COUNT(*) as count
+		FROM stores s
+		WHERE s.category_slug IS NOT NULL AND s.is_selling = true AND s.review_status = 'approved'
+		GROUP BY s.category_slug
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	bySlug := make(map[string]*Category, len(categories))
+	for _, category := range categories {
+		bySlug[category.Slug] = category
+	}
+	for _, count := range counts {
+		if category, ok := bySlug[count.Slug]; ok {
+			category.StoreCount += count.Count
+			if category.ParentSlug != nil {
+				if parent, ok := bySlug[*category.ParentSlug]; ok {
+					parent.StoreCount += count.Count
+				}
+			}
+		}
+	}
+
+	roots := []*Category{}
+	for _, category := range categories {
+		if category.ParentSlug == nil {
+			roots = append(roots, category)
+		} else if parent, ok := bySlug[*category.ParentSlug]; ok {
+			parent.Children = append(parent.Children, category)
+		}
+	}
+	return roots, nil
+}
+
+// ListCategories returns the active category tree
+func ListCategories(c *gin.Context) {
+	categories, err := loadCategoryTree(false)
+	if err != nil {
+		log.Printf("ERROR: Failed to load categories: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load categories"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"categories": categories})
+}
+
+// CategoryStores is one browse row: nearby stores of a top-level category
+type CategoryStores struct {
+	Category *Category `json:"category"`
+	Stores   []Store   `json:"stores"`
+}
+
+type browseStore struct {
+	models.Store
+	BrowseCategory string  `db:"browse_category"`
+	DistanceMeters float64 `db:"distance_meters"`
+	CategoryRank   int     `db:"category_rank"`
+}
+
+// browseQuery reads latitude, longitude, radius (km) and dietary preferences shared by
+// the browse endpoints
+func browseQuery(c *gin.Context) (lat, lng, radiusMeters float64, required, avoided []string, ok bool) {
+	lat, errLat := strconv.ParseFloat(c.Query("latitude"), 64)
+	lng, errLng := strconv.ParseFloat(c.Query("longitude"), 64)
+	if errLat != nil || errLng != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Valid latitude and longitude are required"})
+		return
+	}
+
+	radiusMeters, ok = requestRadiusMeters(c)
+	if !ok {
+		return
+	}
+
+	preferences, err := requestDietaryPreferences(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	required, avoided = splitDietaryPreferences(preferences)
+	return lat, lng, radiusMeters, required, avoided, true
+}
+
+// requestRadiusMeters reads the optional radius query param, in km
+func requestRadiusMeters(c *gin.Context) (float64, bool) {
+	radiusKm := defaultBrowseRadiusKm
+	if r := c.Query("radius"); r != "" {
+		parsed, err := strconv.ParseFloat(r, 64)
+		if err != nil || parsed <= 0 || parsed > maxBrowseRadiusKm {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("radius must be between 0 and %.0f km", maxBrowseRadiusKm)})
+			return 0, false
+		}
+		radiusKm = parsed
+	}
+	return radiusKm * 1000, true
+}
+
+// loadNearbyCategoryStores returns live stores within radiusMeters that are in category
+// (or any category if empty), nearest first and at most perCategory per top-level category
+func loadNearbyCategoryStores(lat, lng, radiusMeters float64, category string, perCategory int, required, avoided []string) ([]browseStore, error) {
+	var stores []browseStore
+	err := db.DB.Select(&stores, `
+		SELECT * FROM (
+			SELECT
+				s.id,
+				s.title,
+				COALESCE(s.description, '') as description,
+				COALESCE(s.pickup_time, '') as pickup_time,
+				COALESCE(s.price::numeric, 0.0) as price,
+				COALESCE(s.original_price::numeric, s.price::numeric, 0.0) as original_price,
+				COALESCE(s.discounted_price::numeric, s.price::numeric, 0.0) as discounted_price,
+				COALESCE(s.image_url, '') as image_url,
+				COALESCE(s.rating, 0.0) as rating,
+				COALESCE(s.reviews, 0) as reviews_count,
+				COALESCE(s.address, '') as address,
+				COALESCE(s.items_left, 0) as items_left,
+				s.latitude,
+				s.longitude,
+				COALESCE(s.google_maps_url, '') as google_maps_url,
+				s.category_slug,
+				s.timezone,
+				s.business_hours,
+				`+storeHighlightsSQL+` as highlights,
+				`+storeDietaryTagsSQL+` as dietary_tags,
+				COALESCE(c.parent_slug, c.slug) as browse_category,
+				`+storeDistanceSQL("$1", "$2")+` as distance_meters,
+				ROW_NUMBER() OVER (
+					PARTITION BY COALESCE(c.parent_slug, c.slug)
+					ORDER BY `+storeDistanceSQL("$1", "$2")+`
+				) as category_rank
+			FROM stores s
+			JOIN categories c ON c.slug = s.category_slug AND c.active
+			WHERE s.is_selling = true AND s.review_status = 'approved' AND NOT `+storeClosedTodaySQL+`
+				AND s.latitude <> 0 AND s.longitude <> 0
+				AND earth_box(ll_to_earth($1, $2), $3) @> ll_to_earth(s.latitude, s.longitude)
+				AND `+storeDistanceSQL("$1", "$2")+` <= $3
+				AND `+storeInCategorySQL("$4")+`
+				AND `+storeDietaryFilterSQL("$5", "$6")+`
+		) nearby
+		WHERE category_rank <= $7
+		ORDER BY browse_category, distance_meters
+	`, lat, lng, radiusMeters, category, pq.Array(required), pq.Array(avoided), perCategory)
+	if err != nil {
+		return nil, err
+	}
+
+	// Dynamic pricing works on the embedded stores
+	modelStores := make([]models.Store, len(stores))
+	for i := range stores {
+		modelStores[i] = stores[i].Store
+	}
+	applyDynamicPricing(modelStores, time.Now())
+	applyOpeningHours(modelStores, time.Now())
+	for i := range stores {
+		stores[i].Store = modelStores[i]
+		distance := services.FormatDistance(stores[i].DistanceMeters)
+		stores[i].Distance = &distance
+	}
+	return stores, nil
+}
+
+// BrowseCategories returns, for every top-level category with nearby live stores, the
+// closest of them
+func BrowseCategories(c *gin.Context) {
+	lat, lng, radius, required, avoided, ok := browseQuery(c)
+	if !ok {
+		return
+	}
+
+	categories, err := loadCategoryTree(false)
+	if err != nil {
+		log.Printf("ERROR: Failed to load categories: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load categories"})
+		return
+	}
+
+	stores, err := loadNearbyCategoryStores(lat, lng, radius, "", browseStoresPerCategory, required, avoided)
+	if err != nil {
+		log.Printf("ERROR: Failed to browse categories: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load stores"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"categories": groupStoresByCategory(categories, stores)})
+}
+
+// GetCategoryStores returns the nearby live stores of one category and its subcategories
+func GetCategoryStores(c *gin.Context) {
+	slug := c.Param("slug")
+	lat, lng, radius, required, avoided, ok := browseQuery(c)
+	if !ok {
+		return
+	}
+
+	var category Category
+	err := db.DB.Get(&category, `
+		SELECT slug, parent_slug, label_vi, label_en, icon, sort_order, aliases, active
+		FROM categories WHERE slug = $1 AND active
+	`, slug)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to load category %s: %v", slug, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load category"})
+		return
+	}
+
+	stores, err := loadNearbyCategoryStores(lat, lng, radius, slug, categoryStoresLimit, required, avoided)
+	if err != nil {
+		log.Printf("ERROR: Failed to load stores for category %s: %v", slug, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load stores"})
+		return
+	}
+
+	modelStores := make([]models.Store, len(stores))
+	for i := range stores {
+		modelStores[i] = stores[i].Store
+	}
+	c.JSON(http.StatusOK, CategoryStores{Category: &category, Stores: convertToStores(modelStores)})
+}
+
+// groupStoresByCategory keeps the category tree order and drops categories without stores
+func groupStoresByCategory(categories []*Category, stores []browseStore) []CategoryStores {
+	byCategory := make(map[string][]models.Store)
+	for _, s := range stores {
+		byCategory[s.BrowseCategory] = append(byCategory[s.BrowseCategory], s.Store)
+	}
+
+	rows := []CategoryStores{}
+	for _, category := range categories {
+		if len(byCategory[category.Slug]) == 0 {
+			continue
+		}
+		rows = append(rows, CategoryStores{Category: category, Stores: convertToStores(byCategory[category.Slug])})
+	}
+	return rows
+}
+
+// CategoryFacet counts the search results in a category, its subcategories included
+type CategoryFacet struct {
+	Slug       string  `json:"slug"`
+	ParentSlug *string `json:"parentSlug"`
+	LabelVi    string  `json:"labelVi"`
+	LabelEn    string  `json:"labelEn"`
+	Icon       string  `json:"icon"`
+	Count      int     `json:"count"`
+}
+
+// categoryFacets turns per-category result counts into facets for the category tree,
+// in tree order and without empty categories
+func categoryFacets(counts map[string]int) ([]CategoryFacet, error) {
+	categories, err := loadCategoryTree(false)
+	if err != nil {
+		return nil, err
+	}
+
+	facets := []CategoryFacet{}
+	add := func(category *Category, count int) {
+		if count > 0 {
+			facets = append(facets, CategoryFacet{
+				Slug:       category.Slug,
+				ParentSlug: category.ParentSlug,
+				LabelVi:    category.LabelVi,
+				LabelEn:    category.LabelEn,
+				Icon:       category.Icon,
+				Count:      count,
+			})
+		}
+	}
+	for _, root := range categories {
+		total := counts[root.Slug]
+		for _, child := range root.Children {
+			total += counts[child.Slug]
+		}
+		add(root, total)
+		for _, child := range root.Children {
+			add(child, counts[child.Slug])
+		}
+	}
+	return facets, nil
+}
+
+type CategoryRequest struct {
+	Slug       string   `json:"slug"`
+	ParentSlug *string  `json:"parentSlug"`
+	LabelVi    string   `json:"labelVi" binding:"required"`
+	LabelEn    string   `json:"labelEn" binding:"required"`
+	Icon       string   `json:"icon"`
+	SortOrder  int      `json:"sortOrder"`
+	Aliases    []string `json:"aliases"`
+	Active     *bool    `json:"active"`
+}
+
+// normalizeCategoryAliases lowercases aliases so they match categoryForStoreType
+func normalizeCategoryAliases(aliases []string) []string {
+	normalized := []string{}
+	seen := make(map[string]bool, len(aliases))
+	for _, alias := range aliases {
+		alias = strings.ToLower(strings.TrimSpace(alias))
+		if alias != "" && !seen[alias] {
+			seen[alias] = true
+			normalized = append(normalized, alias)
+		}
+	}
+	return normalized
+}
+
+// validateCategoryParent keeps the tree two levels deep: a parent must be top-level
+func validateCategoryParent(parentSlug *string) error {
+	if parentSlug == nil || *parentSlug == "" {
+		return nil
+	}
+	var grandparent sql.NullString
+	err := db.DB.Get(&grandparent, `SELECT parent_slug FROM categories WHERE slug = $1`, *parentSlug)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("parent category '%s' not found", *parentSlug)
+	}
+	if err != nil {
+		return err
+	}
+	if grandparent.Valid {
+		return fmt.Errorf("parent category '%s' is itself a subcategory", *parentSlug)
+	}
+	return nil
+}
+
+// ListAllCategories is the admin view of the tree, inactive categories included
+func ListAllCategories(c *gin.Context) {
+	categories, err := loadCategoryTree(true)
+	if err != nil {
+		log.Printf("ERROR: Failed to load categories: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load categories"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"categories": categories})
+}
+
+func CreateCategory(c *gin.Context) {
+	var req CategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !categorySlugPattern.MatchString(req.Slug) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "slug must be lowercase letters, digits and underscores"})
+		return
+	}
+	if req.ParentSlug != nil && *req.ParentSlug == "" {
+		req.ParentSlug = nil
+	}
+	if err := validateCategoryParent(req.ParentSlug); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	active := req.Active == nil || *req.Active
+
+	var category Category
+	err := db.DB.Get(&category, `
+		INSERT INTO categories (slug, parent_slug, label_vi, label_en, icon, sort_order, aliases, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (slug) DO NOTHING
+		RETURNING slug, parent_slug, label_vi, label_en, icon, sort_order, aliases, active
+	`, req.Slug, req.ParentSlug, strings.TrimSpace(req.LabelVi), strings.TrimSpace(req.LabelEn),
+		req.Icon, req.SortOrder, pq.Array(normalizeCategoryAliases(req.Aliases)), active)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "A category with this slug already exists"})
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to create category %s: %v", req.Slug, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, category)
+}
+
+// UpdateCategory edits labels, icon, order, aliases and whether the category is shown.
+// The slug is permanent; deactivating hides the category but keeps stores mapped to it.
+func UpdateCategory(c *gin.Context) {
+	slug := c.Param("slug")
+
+	var req CategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ParentSlug != nil && *req.ParentSlug == "" {
+		req.ParentSlug = nil
+	}
+	if req.ParentSlug != nil && *req.ParentSlug == slug {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A category cannot be its own parent"})
+		return
+	}
+	if err := validateCategoryParent(req.ParentSlug); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ParentSlug != nil {
+		var hasChildren bool
+		if err := db.DB.Get(&hasChildren, `SELECT EXISTS(SELECT 1 FROM categories WHERE parent_slug = $1)`, slug); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update category"})
+			return
+		}
+		if hasChildren {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A category with subcategories must stay top-level"})
+			return
+		}
+	}
+
+	var category Category
+	err := db.DB.Get(&category, `
+		UPDATE categories
+		SET parent_slug = $1, label_vi = $2, label_en = $3, icon = $4, sort_order = $5,
+			aliases = $6, active = COALESCE($7, active), updated_at = NOW()
+		WHERE slug = $8
+		RETURNING slug, parent_slug, label_vi, label_en, icon, sort_order, aliases, active
+	`, req.ParentSlug, strings.TrimSpace(req.LabelVi), strings.TrimSpace(req.LabelEn), req.Icon,
+		req.SortOrder, pq.Array(normalizeCategoryAliases(req.Aliases)), req.Active, slug)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to update category %s: %v", slug, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update category"})
+		return
+	}
+
+	c.JSON(http.StatusOK, category)
+}
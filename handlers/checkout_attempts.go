@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"savor-server/db"
+	"savor-server/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// Checkout attempt states (see check_checkout_state)
+const (
+	CheckoutStateCreated   = "created"
+	CheckoutStatePaid      = "paid"
+	CheckoutStateAbandoned = "abandoned"
+	CheckoutStateFailed    = "failed"
+)
+
+const defaultCheckoutAbandonAfter = 30 * time.Minute
+
+// errCheckoutFailed is returned for a payment whose checkout attempt already failed
+var errCheckoutFailed = errors.New("checkout attempt has failed")
+
+type CheckoutAttempt struct {
+	ID              string         `db:"id"`
+	PaymentIntentID string         `db:"payment_intent_id"`
+	UserID          string         `db:"user_id"`
+	StoreID         string         `db:"store_id"`
+	Quantity        int            `db:"quantity"`
+	Amount          float64        `db:"amount"`
+	WalletAmount    float64        `db:"wallet_amount"`
+	CustomerEmail   sql.NullString `db:"customer_email"`
+	State           string         `db:"state"`
+	CreatedAt       time.Time      `db:"created_at"`
+}
+
+type CheckoutFunnelRow struct {
+	StoreID         string  `json:"storeId" db:"store_id"`
+	StoreName       string  `json:"storeName" db:"store_name"`
+	Created         int     `json:"created" db:"created"`
+	Paid            int     `json:"paid" db:"paid"`
+	Abandoned       int     `json:"abandoned" db:"abandoned"`
+	Failed          int     `json:"failed" db:"failed"`
+	Open            int     `json:"open" db:"open"`
+	PaidAmount      float64 `json:"paidAmount" db:"paid_amount"`
+	AbandonedAmount float64 `json:"abandonedAmount" db:"abandoned_amount"`
+	ConversionRate  float64 `json:"conversionRate" db:"-"`
+}
+
+// recordCheckoutAttempt stores a new card checkout. The expiry job returns the wallet
+// share of attempts that are never paid, so it must be written with the wallet debit.
+func recordCheckoutAttempt(tx *sqlx.Tx, pi *services.PaymentIntent, userID, storeID string, quantity int, totalMinor, walletMinor int64, email string) error {
+	_, err := tx.Exec(`
+		INSERT INTO checkout_attempts (payment_intent_id, user_id, store_id, quantity, amount, wallet_amount, customer_email)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+		ON CONFLICT (payment_intent_id) DO NOTHING
+	`, pi.ID, userID, storeID, quantity, fromMinorUnits(totalMinor), fromMinorUnits(walletMinor), email)
+	return err
+}
+
+// markCheckoutAttempt moves an open attempt to its final state
+func markCheckoutAttempt(paymentIntentID, state string, reservationID *string) {
+	_, err := db.DB.Exec(`
+		UPDATE checkout_attempts
+		SET state = $1, reservation_id = COALESCE($2, reservation_id), updated_at = NOW()
+		WHERE payment_intent_id = $3 AND state = $4
+	`, state, reservationID, paymentIntentID, CheckoutStateCreated)
+	if err != nil {
+		log.Printf("WARNING: Failed to mark checkout attempt %s as %s: %v", paymentIntentID, state, err)
+	}
+}
+
+func checkoutAbandonAfter() time.Duration {
+	if minutes, err := strconv.Atoi(os.Getenv("CHECKOUT_ABANDON_AFTER_MINUTES")); err == nil && minutes > 0 {
+		return time.Duration(minutes) * time.Minute
+	}
+	return defaultCheckoutAbandonAfter
+}
+
+// ExpireAbandonedCheckouts cancels PaymentIntents that were never confirmed, returns any
+// wallet share taken for them and reminds the customer if the store still has bags.
+func ExpireAbandonedCheckouts() error {
+	var attempts []CheckoutAttempt
+	err := db.DB.Select(&attempts, `
+		SELECT id, payment_intent_id, user_id, store_id, quantity, amount, wallet_amount, customer_email, state, created_at
+		FROM checkout_attempts
+		WHERE state = $1 AND created_at < $2
+		ORDER BY created_at
+		LIMIT 100
+	`, CheckoutStateCreated, time.Now().Add(-checkoutAbandonAfter()))
+	if err != nil {
+		return fmt.Errorf("failed to load open checkout attempts: %v", err)
+	}
+
+	for _, attempt := range attempts {
+		if err := expireCheckoutAttempt(attempt); err != nil {
+			log.Printf("WARNING: Failed to expire checkout attempt %s: %v", attempt.PaymentIntentID, err)
+		}
+	}
+	return nil
+}
+
+func expireCheckoutAttempt(attempt CheckoutAttempt) error {
+	pi, err := services.Payments.GetPaymentIntent(attempt.PaymentIntentID)
+	if err != nil {
+		return err
+	}
+
+	// The customer paid but never called /confirm; record the reservation instead, or
+	// refund the payment if the bags sold out meanwhile
+	if pi.Status == services.PaymentIntentStatusSucceeded {
+		_, err := finalizeCardReservation(attempt.UserID, pi)
+		if err == errNotEnoughBags {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to finalize paid checkout: %v", err)
+		}
+		log.Printf("Recovered paid checkout %s for user %s", pi.ID, attempt.UserID)
+		return nil
+	}
+
+	if pi.Status != services.PaymentIntentStatusCanceled {
+		if err := services.Payments.CancelPaymentIntent(pi.ID); err != nil {
+			return fmt.Errorf("failed to cancel payment intent: %v", err)
+		}
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE checkout_attempts SET state = $1, updated_at = NOW()
+		WHERE id = $2 AND state = $3
+	`, CheckoutStateAbandoned, attempt.ID, CheckoutStateCreated)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil
+	}
+
+	if walletMinor := toMinorUnits(attempt.WalletAmount); walletMinor > 0 {
+		_, err := applyWalletTransaction(tx, attempt.UserID, walletMinor, WalletTxPaymentReversal, pi.ID, "Checkout expired", "system")
+		if err != nil {
+			return fmt.Errorf("failed to return wallet share: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	sendCheckoutReminder(attempt)
+	return nil
+}
+
+// sendCheckoutReminder nudges the customer back if the store is still selling bags.
+// Customers who did not give an email at checkout are never contacted.
+func sendCheckoutReminder(attempt CheckoutAttempt) {
+	if !attempt.CustomerEmail.Valid || attempt.CustomerEmail.String == "" {
+		return
+	}
+
+	var store struct {
+		Title         string `db:"title"`
+		BagsAvailable int    `db:"bags_available"`
+	}
+	err := db.DB.Get(&store, `
+		SELECT title, COALESCE(bags_available, 0) as bags_available
+		FROM stores
+		WHERE id = $1 AND is_selling = true
+	`, attempt.StoreID)
+	if err != nil || store.BagsAvailable <= 0 {
+		return
+	}
+
+	emailService := services.GetEmailService()
+	if !emailService.IsConfigured() {
+		return
+	}
+	err = emailService.SendCheckoutReminder(attempt.CustomerEmail.String, services.CheckoutReminderEmailData{
+		StoreName:     store.Title,
+		Quantity:      attempt.Quantity,
+		BagsAvailable: store.BagsAvailable,
+	})
+	if err != nil {
+		log.Printf("WARNING: Failed to send checkout reminder for %s: %v", attempt.PaymentIntentID, err)
+		return
+	}
+
+	if _, err := db.DB.Exec(`UPDATE checkout_attempts SET reminder_sent_at = NOW() WHERE id = $1`, attempt.ID); err != nil {
+		log.Printf("WARNING: Failed to record checkout reminder for %s: %v", attempt.PaymentIntentID, err)
+	}
+}
+
+// GetCheckoutFunnel reports created vs. paid vs. abandoned card checkouts per store
+func GetCheckoutFunnel(c *gin.Context) {
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+	if v := c.Query("from"); v != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date. Use YYYY-MM-DD"})
+			return
+		}
+		from = parsed
+	}
+	if v := c.Query("to"); v != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date. Use YYYY-MM-DD"})
+			return
+		}
+		to = parsed.AddDate(0, 0, 1)
+	}
+
+	rows := make([]CheckoutFunnelRow, 0)
+	err := db.DB.Select(&rows, `
+		SELECT
+			a.store_id,
+			COALESCE(s.title, '') as store_name,
+			COUNT(*) as created,
+			COUNT(*) FILTER (WHERE a.state = 'paid') as paid,
+			COUNT(*) FILTER (WHERE a.state = 'abandoned') as abandoned,
+			COUNT(*) FILTER (WHERE a.state = 'failed') as failed,
+			COUNT(*) FILTER (WHERE a.state = 'created') as open,
+			COALESCE(SUM(a.amount) FILTER (WHERE a.state = 'paid'), 0) as paid_amount,
+			COALESCE(SUM(a.amount) FILTER (WHERE a.state = 'abandoned'), 0) as abandoned_amount
+		FROM checkout_attempts a
+		LEFT JOIN stores s ON s.id = a.store_id
+		WHERE a.created_at >= $1 AND a.created_at < $2
+		GROUP BY a.store_id, s.title
+		ORDER BY created DESC
+	`, from, to)
+	if err != nil {
+		log.Printf("ERROR: Failed to load checkout funnel: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get checkout funnel"})
+		return
+	}
+
+	var totals CheckoutFunnelRow
+	for i := range rows {
+		if rows[i].Created > 0 {
+			rows[i].ConversionRate = float64(rows[i].Paid) / float64(rows[i].Created)
+		}
+		totals.Created += rows[i].Created
+		totals.Paid += rows[i].Paid
+		totals.Abandoned += rows[i].Abandoned
+		totals.Failed += rows[i].Failed
+		totals.Open += rows[i].Open
+		totals.PaidAmount += rows[i].PaidAmount
+		totals.AbandonedAmount += rows[i].AbandonedAmount
+	}
+	totals.PaidAmount = fromMinorUnits(toMinorUnits(totals.PaidAmount))
+	totals.AbandonedAmount = fromMinorUnits(toMinorUnits(totals.AbandonedAmount))
+	if totals.Created > 0 {
+		totals.ConversionRate = float64(totals.Paid) / float64(totals.Created)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":   from.Format("2006-01-02"),
+		"to":     to.AddDate(0, 0, -1).Format("2006-01-02"),
+		"stores": rows,
+		"totals": totals,
+	})
+}
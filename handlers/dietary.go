@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"savor-server/db"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	// DietaryKindDiet tags say what a bag is suitable for; a customer who saves one only
+	// sees stores tagged with it
+	DietaryKindDiet = "diet"
+	// DietaryKindAllergen tags say what a bag may contain; a customer who saves one does
+	// not see stores tagged with it
+	DietaryKindAllergen = "allergen"
+)
+
+type DietaryTag struct {
+	Tag   string `json:"tag"`
+	Kind  string `json:"kind"`
+	Label string `json:"label"`
+}
+
+// dietaryTags is the controlled vocabulary for stores, bags and customer preferences
+var dietaryTags = []DietaryTag{
+	{"vegetarian", DietaryKindDiet, "Vegetarian"},
+	{"vegan", DietaryKindDiet, "Vegan"},
+	{"halal", DietaryKindDiet, "Halal"},
+	{"gluten_free", DietaryKindDiet, "Gluten free"},
+	{"contains_nuts", DietaryKindAllergen, "Contains nuts"},
+	{"contains_peanuts", DietaryKindAllergen, "Contains peanuts"},
+	{"contains_gluten", DietaryKindAllergen, "Contains gluten"},
+	{"contains_dairy", DietaryKindAllergen, "Contains dairy"},
+	{"contains_eggs", DietaryKindAllergen, "Contains eggs"},
+	{"contains_soy", DietaryKindAllergen, "Contains soy"},
+	{"contains_seafood", DietaryKindAllergen, "Contains seafood"},
+	{"contains_pork", DietaryKindAllergen, "Contains pork"},
+}
+
+// impliedDietaryTags are added whenever the key is set: a vegan bag is also vegetarian
+var impliedDietaryTags = map[string][]string{
+	"vegan": {"vegetarian"},
+}
+
+// storeDietaryTagsSQL is the tags of store s together with the tags of its bags
+const storeDietaryTagsSQL = `ARRAY(
+	SELECT tag FROM (
+		SELECT unnest(s.dietary_tags) AS tag
+		UNION SELECT unnest(bd.dietary_tags) FROM bag_details bd WHERE bd.store_id = s.id
+	) store_tags ORDER BY tag
+)`
+
+// storeDietaryFilterSQL keeps stores that have every tag in requiredParam and none of
+// the tags in avoidedParam; empty arrays match every store
+func storeDietaryFilterSQL(requiredParam, avoidedParam string) string {
+	return `(` + storeDietaryTagsSQL + ` @> ` + requiredParam + `::text[] AND NOT ` + storeDietaryTagsSQL + ` && ` + avoidedParam + `::text[])`
+}
+
+func dietaryTagKind(tag string) string {
+	for _, t := range dietaryTags {
+		if t.Tag == tag {
+			return t.Kind
+		}
+	}
+	return ""
+}
+
+// normalizeDietaryTags lowercases, validates, de-duplicates and sorts tags
+func normalizeDietaryTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := []string{}
+	add := func(tag string) {
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		if dietaryTagKind(tag) == "" {
+			return nil, fmt.Errorf("unknown dietary tag %q", tag)
+		}
+		add(tag)
+		for _, implied := range impliedDietaryTags[tag] {
+			add(implied)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// splitDietaryPreferences separates the diet tags a customer requires from the
+// allergen tags they avoid
+func splitDietaryPreferences(preferences []string) (required, avoided []string) {
+	required, avoided = []string{}, []string{}
+	for _, tag := range preferences {
+		switch dietaryTagKind(tag) {
+		case DietaryKindDiet:
+			required = append(required, tag)
+		case DietaryKindAllergen:
+			avoided = append(avoided, tag)
+		}
+	}
+	return required, avoided
+}
+
+// dietaryWarnings lists the customer's preferences a store does not meet: allergens it
+// is tagged with and diets it is not tagged with
+func dietaryWarnings(preferences, storeTags []string) []string {
+	tagged := make(map[string]bool, len(storeTags))
+	for _, tag := range storeTags {
+		tagged[tag] = true
+	}
+	warnings := []string{}
+	for _, tag := range preferences {
+		kind := dietaryTagKind(tag)
+		if (kind == DietaryKindAllergen && tagged[tag]) || (kind == DietaryKindDiet && !tagged[tag]) {
+			warnings = append(warnings, tag)
+		}
+	}
+	return warnings
+}
+
+func loadDietaryPreferences(userID string) ([]string, error) {
+	var preferences pq.StringArray
+	err := db.DB.Get(&preferences, `SELECT dietary_preferences FROM user_profiles WHERE user_id = $1`, userID)
+	if err == sql.ErrNoRows {
+		return []string{}, nil
+	}
+	return preferences, err
+}
+
+// requestDietaryPreferences returns the ?dietary= tags (comma separated) if given, or
+// else the signed-in customer's saved preferences. An empty ?dietary= turns filtering off.
+func requestDietaryPreferences(c *gin.Context) ([]string, error) {
+	if param, ok := c.GetQuery("dietary"); ok {
+		return normalizeDietaryTags(strings.Split(param, ","))
+	}
+
+	userID := c.GetString("user_id")
+	if userID == "" {
+		return []string{}, nil
+	}
+	preferences, err := loadDietaryPreferences(userID)
+	if err != nil {
+		// Searching without the filter beats failing the page
+		log.Printf("WARNING: Failed to load dietary preferences for user %s: %v", userID, err)
+		return []string{}, nil
+	}
+	return preferences, nil
+}
+
+// ListDietaryTags returns the vocabulary stores, bags and preferences are tagged from
+func ListDietaryTags(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"tags": dietaryTags})
+}
+
+func GetDietaryPreferences(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	preferences, err := loadDietaryPreferences(userID)
+	if err != nil {
+		log.Printf("ERROR: Failed to load dietary preferences for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load dietary preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dietaryPreferences": preferences})
+}
+
+type UpdateDietaryPreferencesRequest struct {
+	DietaryPreferences []string `json:"dietaryPreferences"`
+}
+
+// UpdateDietaryPreferences replaces the customer's saved diet and allergen tags
+func UpdateDietaryPreferences(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req UpdateDietaryPreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	preferences, err := normalizeDietaryTags(req.DietaryPreferences)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err = db.DB.Exec(`
+		INSERT INTO user_profiles (user_id, dietary_preferences)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET
+			dietary_preferences = EXCLUDED.dietary_preferences,
+			updated_at = NOW()
+	`, userID, pq.Array(preferences))
+	if err != nil {
+		log.Printf("ERROR: Failed to save dietary preferences for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save dietary preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dietaryPreferences": preferences})
+}
+
+type UpdateStoreDietaryTagsRequest struct {
+	DietaryTags []string `json:"dietaryTags"`
+}
+
+// UpdateStoreDietaryTags sets the tags that apply to every bag of the store. Tags of a
+// single bag are sent with the bag details.
+func UpdateStoreDietaryTags(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req UpdateStoreDietaryTagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tags, err := normalizeDietaryTags(req.DietaryTags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	audit, err := beginStoreAudit(tx, storeID)
+	if err == nil {
+		_, err = tx.Exec(`UPDATE stores SET dietary_tags = $1, updated_at = NOW() WHERE id = $2`, pq.Array(tags), storeID)
+	}
+	if err == nil {
+		err = audit.record(c, tx, StoreAuditDietaryTagsUpdated)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to update dietary tags for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update dietary tags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dietaryTags": tags})
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"savor-server/db"
 	"savor-server/models"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -65,7 +66,8 @@ func GetFavorites(c *gin.Context) {
 	var modelStores []models.Store
 	err := db.DB.Select(&modelStores, `
         SELECT s.*, 
-               CASE WHEN ss.store_id IS NOT NULL THEN true ELSE false END as is_saved
+               CASE WHEN ss.store_id IS NOT NULL THEN true ELSE false END as is_saved,
+               `+storeHighlightsSQL+` as highlights
         FROM stores s
         INNER JOIN saved_stores ss ON s.id = ss.store_id 
         WHERE ss.user_id = $1 AND s.is_selling = true
@@ -78,6 +80,9 @@ func GetFavorites(c *gin.Context) {
 		return
 	}
 
+	applyDynamicPricing(modelStores, time.Now())
+	applyOpeningHours(modelStores, time.Now())
+
 	// Convert model stores to response format
 	responseStores := make([]Store, len(modelStores))
 	for i, s := range modelStores {
@@ -101,23 +106,37 @@ func GetFavorites(c *gin.Context) {
 			price = s.Price.Float64
 		}
 
+		regularPrice := price
+		if s.RegularPrice.Valid {
+			regularPrice = s.RegularPrice.Float64
+		}
+
 		rating := 0.0
 		if s.Rating.Valid {
 			rating = s.Rating.Float64
 		}
 
+		var nextOpening *time.Time
+		if s.NextOpeningTime.Valid {
+			nextOpening = &s.NextOpeningTime.Time
+		}
+
 		responseStores[i] = Store{
-			ID:          s.ID,
-			Title:       s.Title,
-			Description: description,
-			PickUpTime:  pickupTime,
-			Distance:    distance,
-			Price:       price,
-			ImageURL:    s.ImageURL,
-			Rating:      rating,
-			IsSaved:     true,
-			Latitude:    s.Latitude,
-			Longitude:   s.Longitude,
+			ID:              s.ID,
+			Title:           s.Title,
+			Description:     description,
+			PickUpTime:      pickupTime,
+			Distance:        distance,
+			Price:           price,
+			RegularPrice:    regularPrice,
+			ImageURL:        s.ImageURL,
+			Rating:          rating,
+			IsSaved:         true,
+			Latitude:        s.Latitude,
+			Longitude:       s.Longitude,
+			Highlights:      s.Highlights,
+			IsOpenNow:       s.IsOpenNow,
+			NextOpeningTime: nextOpening,
 		}
 	}
 
@@ -12,7 +12,7 @@ import (
 )
 
 // @Summary     Calculate distance to store
-// @Description Calculate the distance and duration from user's location to a store
+// @Description Calculate the distance and duration from user's location to a store; estimated is true when the route could not be looked up
 // @Tags        maps
 // @Accept      json
 // @Produce     json
@@ -25,9 +25,9 @@ import (
 // @Failure     500 {object} map[string]string "Internal server error"
 // @Router      /api/maps/distance [get]
 func CalculateDistance(c *gin.Context) {
-	if services.GoogleMaps == nil {
-		log.Printf("ERROR: CalculateDistance called but Google Maps service is not available.")
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Google Maps service not available"})
+	if services.Distances == nil {
+		log.Printf("ERROR: CalculateDistance called but no distance provider is configured.")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Distance service not available"})
 		return
 	}
 
@@ -72,7 +72,7 @@ func CalculateDistance(c *gin.Context) {
 		return
 	}
 
-	result, err := services.GoogleMaps.CalculateDistance(userLat, userLng, storeLat, storeLng)
+	result, err := services.Distances.CalculateDistance(c.Request.Context(), userLat, userLng, storeLat, storeLng)
 	if err != nil {
 		log.Printf("ERROR: service.CalculateDistance failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -82,6 +82,17 @@ func CalculateDistance(c *gin.Context) {
 	c.JSON(http.StatusOK, result)
 }
 
+// GetDistanceCacheStats reports how often travel-time lookups are answered from the
+// cache (Admin only)
+func GetDistanceCacheStats(c *gin.Context) {
+	cache, ok := services.Distances.(*services.CachedDistance)
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": true, "stats": cache.Stats()})
+}
+
 // @Summary     Get directions to store
 // @Description Get directions and Google Maps URL from user's location to a store
 // @Tags        maps
@@ -224,13 +235,15 @@ func GetStoreWithDistance(c *gin.Context) {
 	var distanceResult *services.DistanceResult
 	var directionsResult *services.DirectionsResult
 
-	if services.GoogleMaps != nil {
-		distanceResult, err = services.GoogleMaps.CalculateDistance(userLat, userLng, store.Latitude, store.Longitude)
+	if services.Distances != nil {
+		distanceResult, err = services.Distances.CalculateDistance(c.Request.Context(), userLat, userLng, store.Latitude, store.Longitude)
 		if err != nil {
 			log.Printf("ERROR: Could not calculate distance for store %s: %v", storeID, err)
 			// Don't return, just log the error and continue
 		}
+	}
 
+	if services.GoogleMaps != nil {
 		directionsResult, err = services.GoogleMaps.GetDirections(userLat, userLng, store.Latitude, store.Longitude)
 		if err != nil {
 			log.Printf("ERROR: Could not get directions for store %s: %v", storeID, err)
@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"savor-server/db"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	// maxStoreHighlights caps the highlights an owner manages; the bag category row
+	// written by UpdateBagDetails does not count
+	maxStoreHighlights = 5
+	maxHighlightLength = 40
+)
+
+const (
+	HighlightSourceCategory  = "category"
+	HighlightSourceSuggested = "suggested"
+	HighlightSourceCustom    = "custom"
+)
+
+// suggestedHighlights are offered to owners; anything else is saved as a custom highlight
+var suggestedHighlights = []string{
+	"Freshly baked",
+	"Bestseller",
+	"Great value",
+	"Large portions",
+	"Organic",
+	"Locally sourced",
+	"Family owned",
+	"Eco-friendly packaging",
+	"Vegetarian options",
+	"Open late",
+}
+
+// storeHighlightsSQL is the ordered highlights of store s, so list queries return them
+// without a join and GROUP BY
+const storeHighlightsSQL = `ARRAY(
+	SELECT sh.highlight FROM store_highlights sh
+	WHERE sh.store_id = s.id
+	ORDER BY sh.position, sh.created_at
+)`
+
+// storeHighlightMatchSQL is true when a highlight of store s matches the ILIKE pattern param
+func storeHighlightMatchSQL(param string) string {
+	return `EXISTS (SELECT 1 FROM store_highlights sh WHERE sh.store_id = s.id AND sh.highlight ILIKE ` + param + `)`
+}
+
+type StoreHighlight struct {
+	ID        string `json:"id" db:"id"`
+	Highlight string `json:"highlight" db:"highlight"`
+	Position  int    `json:"position" db:"position"`
+	Source    string `json:"source" db:"source"`
+}
+
+// normalizeHighlight trims a highlight and matches it to a suggestion, whose spelling wins
+func normalizeHighlight(highlight string) (text, source string, err error) {
+	text = strings.Join(strings.Fields(highlight), " ")
+	if text == "" {
+		return "", "", fmt.Errorf("highlight cannot be empty")
+	}
+	if utf8.RuneCountInString(text) > maxHighlightLength {
+		return "", "", fmt.Errorf("highlight must be at most %d characters", maxHighlightLength)
+	}
+	for _, suggestion := range suggestedHighlights {
+		if strings.EqualFold(suggestion, text) {
+			return suggestion, HighlightSourceSuggested, nil
+		}
+	}
+	return text, HighlightSourceCustom, nil
+}
+
+func loadStoreHighlights(q sqlx.Queryer, storeID string) ([]StoreHighlight, error) {
+	highlights := []StoreHighlight{}
+	err := sqlx.Select(q, &highlights, `
+		SELECT id, highlight, position, source
+		FROM store_highlights
+		WHERE store_id = $1
+		ORDER BY position, created_at
+	`, storeID)
+	return highlights, err
+}
+
+// setCategoryHighlight replaces the highlight derived from the bag category, leaving the
+// owner's highlights alone
+func setCategoryHighlight(tx sqlx.Execer, storeID, category string) error {
+	_, err := tx.Exec(`DELETE FROM store_highlights WHERE store_id = $1 AND source = $2`, storeID, HighlightSourceCategory)
+	if err != nil || strings.TrimSpace(category) == "" {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO store_highlights (store_id, highlight, position, source)
+		VALUES ($1, $2, 0, $3)
+		ON CONFLICT (store_id, lower(highlight)) DO NOTHING
+	`, storeID, strings.TrimSpace(category), HighlightSourceCategory)
+	return err
+}
+
+func GetStoreHighlights(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermViewStore)
+	if !ok {
+		return
+	}
+
+	highlights, err := loadStoreHighlights(db.DB, storeID)
+	if err != nil {
+		log.Printf("ERROR: Failed to load highlights for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load highlights"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"highlights":    highlights,
+		"suggestions":   suggestedHighlights,
+		"maxHighlights": maxStoreHighlights,
+	})
+}
+
+type AddStoreHighlightRequest struct {
+	Highlight string `json:"highlight" binding:"required"`
+}
+
+// AddStoreHighlight appends one highlight to the end of the list
+func AddStoreHighlight(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req AddStoreHighlightRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	text, source, err := normalizeHighlight(req.Highlight)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Serialize concurrent edits of the same store's list; the snapshot locks the store
+	audit, err := beginStoreAudit(tx, storeID)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add highlight"})
+		return
+	}
+
+	var count, lastPosition int
+	err = tx.QueryRow(`
+		SELECT COUNT(*), COALESCE(MAX(position), 0)
+		FROM store_highlights
+		WHERE store_id = $1 AND source <> $2
+	`, storeID, HighlightSourceCategory).Scan(&count, &lastPosition)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add highlight"})
+		return
+	}
+	if count >= maxStoreHighlights {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A store can have at most %d highlights", maxStoreHighlights)})
+		return
+	}
+
+	var highlight StoreHighlight
+	err = tx.Get(&highlight, `
+		INSERT INTO store_highlights (store_id, highlight, position, source)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (store_id, lower(highlight)) DO NOTHING
+		RETURNING id, highlight, position, source
+	`, storeID, text, lastPosition+1, source)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "The store already has this highlight"})
+		return
+	}
+	if err == nil {
+		err = audit.record(c, tx, StoreAuditHighlightsUpdated)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to add highlight for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add highlight"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, highlight)
+}
+
+type ReplaceStoreHighlightsRequest struct {
+	Highlights []string `json:"highlights"`
+}
+
+// ReplaceStoreHighlights sets the whole ordered list, which is how owners reorder
+func ReplaceStoreHighlights(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req ReplaceStoreHighlightsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	texts, sources, err := normalizeHighlightList(req.Highlights)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	audit, err := beginStoreAudit(tx, storeID)
+	if err == nil {
+		err = replaceStoreHighlights(tx, storeID, texts, sources)
+	}
+	var highlights []StoreHighlight
+	if err == nil {
+		highlights, err = loadStoreHighlights(tx, storeID)
+	}
+	if err == nil {
+		err = audit.record(c, tx, StoreAuditHighlightsUpdated)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to replace highlights for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save highlights"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"highlights": highlights})
+}
+
+// normalizeHighlightList normalizes an ordered list of owner highlights, rejecting
+// duplicates and lists over maxStoreHighlights
+func normalizeHighlightList(list []string) (texts, sources []string, err error) {
+	if len(list) > maxStoreHighlights {
+		return nil, nil, fmt.Errorf("a store can have at most %d highlights", maxStoreHighlights)
+	}
+
+	texts = make([]string, 0, len(list))
+	sources = make([]string, 0, len(list))
+	seen := make(map[string]bool, len(list))
+	for _, h := range list {
+		text, source, err := normalizeHighlight(h)
+		if err != nil {
+			return nil, nil, err
+		}
+		if seen[strings.ToLower(text)] {
+			return nil, nil, fmt.Errorf("duplicate highlight '%s'", text)
+		}
+		seen[strings.ToLower(text)] = true
+		texts = append(texts, text)
+		sources = append(sources, source)
+	}
+	return texts, sources, nil
+}
+
+// replaceStoreHighlights swaps the owner's highlights for texts, in order. The category
+// row stays; an owner highlight with the same text replaces it.
+func replaceStoreHighlights(tx sqlx.Execer, storeID string, texts, sources []string) error {
+	_, err := tx.Exec(`
+		DELETE FROM store_highlights
+		WHERE store_id = $1 AND (source <> $2 OR lower(highlight) = ANY($3))
+	`, storeID, HighlightSourceCategory, pq.Array(lowerAll(texts)))
+	if err == nil && len(texts) > 0 {
+		_, err = tx.Exec(`
+			INSERT INTO store_highlights (store_id, highlight, position, source)
+			SELECT $1, h.highlight, h.position, h.source
+			FROM unnest($2::text[], $3::text[]) WITH ORDINALITY AS h(highlight, source, position)
+		`, storeID, pq.Array(texts), pq.Array(sources))
+	}
+	return err
+}
+
+func DeleteStoreHighlight(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	audit, err := beginStoreAudit(tx, storeID)
+	var result sql.Result
+	if err == nil {
+		result, err = tx.Exec(`DELETE FROM store_highlights WHERE id::text = $1 AND store_id = $2`, c.Param("id"), storeID)
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to delete highlight %s for store %s: %v", c.Param("id"), storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete highlight"})
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Highlight not found"})
+		return
+	}
+
+	err = audit.record(c, tx, StoreAuditHighlightsUpdated)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to delete highlight %s for store %s: %v", c.Param("id"), storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete highlight"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Highlight deleted"})
+}
+
+func lowerAll(values []string) []string {
+	lowered := make([]string, len(values))
+	for i, v := range values {
+		lowered[i] = strings.ToLower(v)
+	}
+	return lowered
+}
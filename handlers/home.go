@@ -1,17 +1,21 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
-	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"savor-server/db"
 	"savor-server/models"
 	"savor-server/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 )
 
 type Store struct {
@@ -20,9 +24,11 @@ type Store struct {
 	Description     string   `json:"description"`
 	PickUpTime      string   `json:"pickUpTime"`
 	Distance        string   `json:"distance"`
+	TravelTime      *string  `json:"travelTime,omitempty"` // Only when travel_time=true
 	Price           float64  `json:"price"`
 	OriginalPrice   float64  `json:"originalPrice"`
 	DiscountedPrice float64  `json:"discountedPrice"`
+	RegularPrice    float64  `json:"regularPrice"`
 	ImageURL        string   `json:"imageUrl"`
 	Rating          float64  `json:"rating"`
 	Address         string   `json:"address"` // THIS WAS MISSING!
@@ -33,6 +39,11 @@ type Store struct {
 	ReviewsCount    int64    `json:"reviewsCount"`
 	ItemsLeft       int64    `json:"itemsLeft"`
 	Highlights      []string `json:"highlights"`
+	DietaryTags     []string `json:"dietaryTags"`
+	Category        string   `json:"category"`
+	IsOpenNow       bool     `json:"isOpenNow"`
+	// In the store's zone; null when the store has not set business hours
+	NextOpeningTime *time.Time `json:"nextOpeningTime"`
 }
 
 type HomePageResponse struct {
@@ -45,6 +56,73 @@ type HomePageResponse struct {
 	EmailVerified     bool    `json:"emailVerified"`
 }
 
+const (
+	homeStoresLimit         = 20
+	searchStoresLimit       = 50
+	searchNearbyStoresLimit = 20
+	travelTimeBudget        = 2 * time.Second
+)
+
+// nearbyStore is a store with its straight-line distance from the customer, NULL when
+// either location is unknown
+type nearbyStore struct {
+	models.Store
+	DistanceMeters sql.NullFloat64 `db:"distance_meters"`
+}
+
+// withDistances unwraps nearby stores, showing each one's straight-line distance
+func withDistances(nearby []nearbyStore) []models.Store {
+	stores := make([]models.Store, len(nearby))
+	for i, n := range nearby {
+		stores[i] = n.Store
+		if n.DistanceMeters.Valid {
+			distance := services.FormatDistance(n.DistanceMeters.Float64)
+			stores[i].Distance = &distance
+		}
+	}
+	return stores
+}
+
+// addTravelTimes looks up the driving time to every store in one batch. If the lookup
+// fails or takes longer than travelTimeBudget the stores keep only their straight-line
+// distance.
+func addTravelTimes(ctx context.Context, stores []models.Store, lat, lng float64) {
+	if services.Distances == nil || len(stores) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, travelTimeBudget)
+	defer cancel()
+
+	destinations := make([]services.LatLng, len(stores))
+	for i, store := range stores {
+		destinations[i] = services.LatLng{Lat: store.Latitude, Lng: store.Longitude}
+	}
+	results, err := services.Distances.CalculateDistances(ctx, services.LatLng{Lat: lat, Lng: lng}, destinations)
+	if err != nil {
+		log.Printf("[BACKEND] Travel time lookup for %d stores failed: %v", len(stores), err)
+		return
+	}
+	for i, result := range results {
+		if result != nil {
+			formatted := formatTravelTime(result)
+			stores[i].TravelTime = &formatted
+		}
+	}
+}
+
+// formatTravelTime renders a driving time in whole minutes, rounded up; estimates get a ~
+func formatTravelTime(result *services.DistanceResult) string {
+	minutes := (result.Seconds + 59) / 60
+	formatted := fmt.Sprintf("%d min", max(minutes, 1))
+	if minutes >= 60 {
+		formatted = fmt.Sprintf("%d h %d min", minutes/60, minutes%60)
+	}
+	if result.Estimated {
+		return "~" + formatted
+	}
+	return formatted
+}
+
 // @Summary     Get home page data
 // @Description Get personalized home page data including recommended stores and pickup times
 // @Tags        home
@@ -52,6 +130,9 @@ type HomePageResponse struct {
 // @Produce     json
 // @Param       latitude query number true "User's latitude"
 // @Param       longitude query number true "User's longitude"
+// @Param       radius query number false "Search radius in km, default 10, max 50"
+// @Param       travel_time query bool false "Add driving time to each store"
+// @Param       dietary query string false "Comma separated dietary tags; defaults to the signed-in user's preferences"
 // @Success     200 {object} HomePageResponse
 // @Failure     400 {object} map[string]string "Invalid parameters"
 // @Failure     401 {object} map[string]string "Unauthorized"
@@ -83,78 +164,28 @@ func GetHomePageData(c *gin.Context) {
 		return
 	}
 
-	var stores []models.Store
-
-	// COMMENTED OUT: Original query with saved_stores table (causing type mismatch)
-	/*
-		err = db.DB.Select(&stores, `
-			WITH saved_status AS (
-				SELECT store_id, true as is_saved
-				FROM saved_stores
-				WHERE user_id = $1
-			)
-			SELECT
-				s.id,
-				s.title,
-				s.description,
-				s.pickup_time,
-				COALESCE(s.distance, '0 km') as distance,
-				COALESCE(s.price, 0.0) as price,
-				COALESCE(s.original_price, s.price) as original_price,
-				COALESCE(s.discounted_price, s.price) as discounted_price,
-				s.background_url,
-				s.image_url,
-				COALESCE(s.rating, 0.0) as rating,
-				COALESCE(s.reviews, 0) as reviews,
-				COALESCE(s.reviews_count, s.reviews) as reviews_count,
-				s.address,
-				COALESCE(s.items_left, 0) as items_left,
-				COALESCE(s.bags_available, s.items_left) as bags_available,
-				s.latitude,
-				s.longitude,
-				s.google_maps_url,
-				s.is_selling,
-				COALESCE(ss.is_saved, false) as is_saved,
-				array_agg(DISTINCT sh.highlight) FILTER (WHERE sh.highlight IS NOT NULL) as highlights
-			FROM stores s
-			LEFT JOIN saved_status ss ON s.id = ss.store_id
-			LEFT JOIN store_highlights sh ON s.id = sh.store_id
-			WHERE s.is_selling = true
-			GROUP BY
-				s.id,
-				s.title,
-				s.description,
-				s.pickup_time,
-				s.distance,
-				s.price,
-				s.original_price,
-				s.discounted_price,
-				s.background_url,
-				s.image_url,
-				s.rating,
-				s.reviews,
-				s.reviews_count,
-				s.address,
-				s.items_left,
-				s.bags_available,
-				s.latitude,
-				s.longitude,
-				s.google_maps_url,
-				s.is_selling,
-				ss.is_saved
-			ORDER BY s.rating DESC
-			LIMIT 20
-		`, userID)
-	*/
-
-	// NEW QUERY: Simplified without saved_stores and store_highlights tables
-	err = db.DB.Select(&stores, `
-		SELECT DISTINCT ON (s.id)
+	// Hide stores that do not fit the customer's diet or contain their allergens
+	preferences, err := requestDietaryPreferences(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	requiredTags, avoidedTags := splitDietaryPreferences(preferences)
+
+	radius, ok := requestRadiusMeters(c)
+	if !ok {
+		return
+	}
+
+	// Nearest stores first, within the radius; the GiST index on ll_to_earth(latitude,
+	// longitude) serves the earth_box filter
+	var nearby []nearbyStore
+	err = db.DB.Select(&nearby, `
+		SELECT
 			s.id, 
 			s.title, 
 			COALESCE(s.description, '') as description,
 			COALESCE(s.pickup_time, '') as pickup_time,
-			COALESCE(s.distance, '0 km') as distance,
 			COALESCE(s.price::numeric, 0.0) as price,
 			COALESCE(s.original_price::numeric, s.price::numeric, 0.0) as original_price,
 			COALESCE(s.discounted_price::numeric, s.price::numeric, 0.0) as discounted_price,
@@ -166,17 +197,26 @@ func GetHomePageData(c *gin.Context) {
 			COALESCE(s.address, '') as address,
 			COALESCE(s.items_left, 0) as items_left,
 			COALESCE(s.bags_available, s.items_left, 0) as bags_available,
-			COALESCE(s.latitude, 0.0) as latitude,
-			COALESCE(s.longitude, 0.0) as longitude,
+			s.latitude,
+			s.longitude,
 			COALESCE(s.google_maps_url, '') as google_maps_url,
 			COALESCE(s.is_selling, true) as is_selling,
 			false as is_saved,  -- Set to false for now since we're not checking saved_stores
-			ARRAY[]::text[] as highlights  -- Empty array since we're not checking store_highlights
+			`+storeHighlightsSQL+` as highlights,
+			`+storeDietaryTagsSQL+` as dietary_tags,
+			s.category_slug,
+			s.timezone,
+			s.business_hours,
+			`+storeDistanceSQL("$1", "$2")+` as distance_meters
 		FROM stores s
-		WHERE s.is_selling = true
-		ORDER BY s.id, s.rating DESC, s.created_at DESC
-		LIMIT 20
-	`)
+		WHERE s.is_selling = true AND s.review_status = 'approved' AND NOT `+storeClosedTodaySQL+`
+			AND s.latitude <> 0 AND s.longitude <> 0
+			AND earth_box(ll_to_earth($1, $2), $3) @> ll_to_earth(s.latitude, s.longitude)
+			AND `+storeDistanceSQL("$1", "$2")+` <= $3
+			AND `+storeDietaryFilterSQL("$4", "$5")+`
+		ORDER BY distance_meters, s.rating DESC
+		LIMIT $6
+	`, userLat, userLng, radius, pq.Array(requiredTags), pq.Array(avoidedTags), homeStoresLimit)
 
 	if err != nil {
 		log.Printf("Database query failed: %v", err)
@@ -184,67 +224,11 @@ func GetHomePageData(c *gin.Context) {
 		return
 	}
 
-	// Calculate distances using Google Maps API and sort by distance
-	if services.GoogleMaps != nil {
-
-		// Create a slice to hold stores with their calculated distance in meters
-		type storeWithDistance struct {
-			store          models.Store
-			distanceStr    string
-			distanceMeters int
-		}
-		var storesWithDistances []storeWithDistance
-
-		for _, store := range stores {
-			if store.Latitude != 0 && store.Longitude != 0 {
-				distanceResult, err := services.GoogleMaps.CalculateDistance(userLat, userLng, store.Latitude, store.Longitude)
-				if err == nil && distanceResult != nil {
-					distanceStr := distanceResult.Distance
-					store.Distance = &distanceStr
-					storesWithDistances = append(storesWithDistances, storeWithDistance{
-						store:          store,
-						distanceStr:    distanceStr,
-						distanceMeters: distanceResult.Meters,
-					})
-				} else {
-					log.Printf("[BACKEND] Distance calculation failed for store %s: %v", store.ID, err)
-					// Add store with default distance if calculation fails
-					defaultDistance := "~km"
-					store.Distance = &defaultDistance
-					storesWithDistances = append(storesWithDistances, storeWithDistance{
-						store:          store,
-						distanceStr:    defaultDistance,
-						distanceMeters: 999999, // Large number to put at end
-					})
-				}
-			} else {
-				// Add store with default distance if no coordinates
-				defaultDistance := "~km"
-				store.Distance = &defaultDistance
-				storesWithDistances = append(storesWithDistances, storeWithDistance{
-					store:          store,
-					distanceStr:    defaultDistance,
-					distanceMeters: 999999, // Large number to put at end
-				})
-			}
-		}
-
-		// Sort stores by distance (closest first)
-		sort.Slice(storesWithDistances, func(i, j int) bool {
-			return storesWithDistances[i].distanceMeters < storesWithDistances[j].distanceMeters
-		})
-
-		// Extract sorted stores (limit to top 20 closest)
-		maxStores := len(storesWithDistances)
-		if maxStores > 20 {
-			maxStores = 20
-		}
-		stores = make([]models.Store, maxStores)
-		for i := 0; i < maxStores; i++ {
-			stores[i] = storesWithDistances[i].store
-		}
-
-	} else {
+	stores := withDistances(nearby)
+	applyDynamicPricing(stores, time.Now())
+	applyOpeningHours(stores, time.Now())
+	if c.Query("travel_time") == "true" {
+		addTravelTimes(c.Request.Context(), stores, userLat, userLng)
 	}
 
 	// Split stores into recommended and pickup tomorrow based on pickup time
@@ -263,7 +247,7 @@ func GetHomePageData(c *gin.Context) {
 			Distance int    `json:"distance"`
 		}{
 			City:     "Current Location",
-			Distance: 5,
+			Distance: int(radius / 1000),
 		},
 		RecommendedStores: convertToStores(recommended),
 		PickUpTomorrow:    convertToStores(tomorrow),
@@ -279,6 +263,12 @@ func GetHomePageData(c *gin.Context) {
 // @Accept      json
 // @Produce     json
 // @Param       query query string true "Search query"
+// @Param       latitude query number false "User's latitude; nearest stores first when given"
+// @Param       longitude query number false "User's longitude"
+// @Param       travel_time query bool false "Add driving time to each store"
+// @Param       category query string false "Category slug; subcategories are included"
+// @Param       open_now query bool false "Only stores open now by their business hours"
+// @Param       dietary query string false "Comma separated dietary tags; defaults to the signed-in user's preferences"
 // @Success     200 {array} Store
 // @Failure     400 {object} map[string]string "Invalid parameters"
 // @Router      /api/home/search [get]
@@ -310,76 +300,23 @@ func SearchStores(c *gin.Context) {
 		}
 	}
 
-	// userID := c.GetString("user_id")
-	var modelStores []models.Store
-
-	// COMMENTED OUT: Original query with saved_stores table (causing type mismatch)
-	/*
-		err := db.DB.Select(&modelStores, `
-			WITH saved_status AS (
-				SELECT store_id, true as is_saved
-				FROM saved_stores
-				WHERE user_id = $1
-			)
-			SELECT
-				s.id,
-				s.title,
-				s.description,
-				s.pickup_time,
-				COALESCE(s.distance, '0 km') as distance,
-				COALESCE(s.price, 0.0) as price,
-				COALESCE(s.original_price, s.price) as original_price,
-				COALESCE(s.discounted_price, s.price) as discounted_price,
-				s.background_url,
-				s.image_url,
-				COALESCE(s.rating, 0.0) as rating,
-				COALESCE(s.reviews, 0) as reviews,
-				COALESCE(s.reviews_count, s.reviews) as reviews_count,
-				s.address,
-				COALESCE(s.items_left, 0) as items_left,
-				COALESCE(s.bags_available, s.items_left) as bags_available,
-				s.latitude,
-				s.longitude,
-				s.google_maps_url,
-				s.is_selling,
-				COALESCE(ss.is_saved, false) as is_saved,
-				array_agg(DISTINCT sh.highlight) FILTER (WHERE sh.highlight IS NOT NULL) as highlights
-			FROM stores s
-			LEFT JOIN saved_status ss ON s.id = ss.store_id
-			LEFT JOIN store_highlights sh ON s.id = sh.store_id
-			WHERE
-				s.title ILIKE $2 OR
-				s.description ILIKE $2 OR
-				s.address ILIKE $2
-			GROUP BY
-				s.id,
-				s.title,
-				s.description,
-				s.pickup_time,
-				s.distance,
-				s.price,
-				s.original_price,
-				s.discounted_price,
-				s.background_url,
-				s.image_url,
-				s.rating,
-				s.reviews,
-				s.reviews_count,
-				s.address,
-				s.items_left,
-				s.bags_available,
-				s.latitude,
-				s.longitude,
-				s.google_maps_url,
-				s.is_selling,
-				ss.is_saved
-			ORDER BY s.rating DESC
-			LIMIT 20
-		`, userID, "%"+query+"%")
-	*/
-
-	// NEW QUERY: Simplified without saved_stores and store_highlights tables
-	err := db.DB.Select(&modelStores, `
+	preferences, err := requestDietaryPreferences(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	requiredTags, avoidedTags := splitDietaryPreferences(preferences)
+
+	// With a location the nearest matches come first, each with its straight-line distance
+	hasLocation := userLat != 0 && userLng != 0
+	limit := searchStoresLimit
+	if hasLocation {
+		limit = searchNearbyStoresLimit
+	}
+
+	// NEW QUERY: Simplified without saved_stores; highlights come from storeHighlightsSQL
+	var nearby []nearbyStore
+	err = db.DB.Select(&nearby, `
 		SELECT 
 			s.id, 
 			s.title, 
@@ -402,14 +339,22 @@ func SearchStores(c *gin.Context) {
 			COALESCE(s.google_maps_url, '') as google_maps_url,
 			true as is_selling,
 			false as is_saved,  -- Set to false for now since we're not checking saved_stores
-			ARRAY[]::text[] as highlights  -- Empty array since we're not checking store_highlights
+			`+storeHighlightsSQL+` as highlights,
+			`+storeDietaryTagsSQL+` as dietary_tags,
+			s.category_slug,
+			s.timezone,
+			s.business_hours,
+			CASE WHEN $6 AND s.latitude <> 0 AND s.longitude <> 0
+				THEN `+storeDistanceSQL("$7", "$8")+`
+			END as distance_meters
 		FROM stores s
-		WHERE 
-			COALESCE(s.title, '') ILIKE $1 OR 
-			COALESCE(s.description, '') ILIKE $1 OR 
-			COALESCE(s.address, '') ILIKE $1
-		LIMIT 50
-	`, "%"+query+"%")
+		WHERE `+searchStoresFilterSQL+`
+			AND `+storeInCategorySQL("$4")+`
+			AND `+storeOpenNowFilterSQL("$5")+`
+		ORDER BY distance_meters NULLS LAST
+		LIMIT $9
+	`, "%"+query+"%", pq.Array(requiredTags), pq.Array(avoidedTags), c.Query("category"), c.Query("open_now") == "true",
+		hasLocation, userLat, userLng, limit)
 
 	if err != nil {
 		log.Printf("[BACKEND] ERROR: Search query failed: %v", err)
@@ -417,64 +362,11 @@ func SearchStores(c *gin.Context) {
 		return
 	}
 
-	// Calculate distances using Google Maps API if user location is provided and sort by distance
-	if services.GoogleMaps != nil && userLat != 0 && userLng != 0 {
-		// Create a slice to hold stores with their calculated distance in meters
-		type storeWithDistance struct {
-			store          models.Store
-			distanceStr    string
-			distanceMeters int
-		}
-		var storesWithDistances []storeWithDistance
-
-		for _, store := range modelStores {
-			if store.Latitude != 0 && store.Longitude != 0 {
-				distanceResult, err := services.GoogleMaps.CalculateDistance(userLat, userLng, store.Latitude, store.Longitude)
-				if err == nil && distanceResult != nil {
-					distanceStr := distanceResult.Distance
-					store.Distance = &distanceStr
-					storesWithDistances = append(storesWithDistances, storeWithDistance{
-						store:          store,
-						distanceStr:    distanceStr,
-						distanceMeters: distanceResult.Meters,
-					})
-				} else {
-					// Add store with default distance if calculation fails
-					defaultDistance := "~km"
-					store.Distance = &defaultDistance
-					storesWithDistances = append(storesWithDistances, storeWithDistance{
-						store:          store,
-						distanceStr:    defaultDistance,
-						distanceMeters: 999999, // Large number to put at end
-					})
-				}
-			} else {
-				// Add store with default distance if no coordinates
-				defaultDistance := "~km"
-				store.Distance = &defaultDistance
-				storesWithDistances = append(storesWithDistances, storeWithDistance{
-					store:          store,
-					distanceStr:    defaultDistance,
-					distanceMeters: 999999, // Large number to put at end
-				})
-			}
-		}
-
-		// Sort stores by distance (closest first)
-		sort.Slice(storesWithDistances, func(i, j int) bool {
-			return storesWithDistances[i].distanceMeters < storesWithDistances[j].distanceMeters
-		})
-
-		// Extract sorted stores (limit to top 20 closest)
-		maxStores := len(storesWithDistances)
-		if maxStores > 20 {
-			maxStores = 20
-		}
-		modelStores = make([]models.Store, maxStores)
-		for i := 0; i < maxStores; i++ {
-			modelStores[i] = storesWithDistances[i].store
-		}
-
+	modelStores := withDistances(nearby)
+	applyDynamicPricing(modelStores, time.Now())
+	applyOpeningHours(modelStores, time.Now())
+	if hasLocation && c.Query("travel_time") == "true" {
+		addTravelTimes(c.Request.Context(), modelStores, userLat, userLng)
 	}
 
 	// Convert model stores to response stores
@@ -482,6 +374,71 @@ func SearchStores(c *gin.Context) {
 	c.JSON(http.StatusOK, stores)
 }
 
+// searchStoresFilterSQL matches the pattern $1 against the name, description, address and
+// highlights, and the dietary tags $2 (required) and $3 (avoided); SearchStores and
+// SearchFacets share it
+var searchStoresFilterSQL = `(
+			COALESCE(s.title, '') ILIKE $1 OR
+			COALESCE(s.description, '') ILIKE $1 OR
+			COALESCE(s.address, '') ILIKE $1 OR
+			` + storeHighlightMatchSQL("$1") + `
+		) AND s.review_status = 'approved' AND NOT ` + storeClosedTodaySQL + `
+			AND ` + storeDietaryFilterSQL("$2", "$3")
+
+// @Summary     Search facets
+// @Description Count search results per category, ignoring any category filter
+// @Tags        home
+// @Produce     json
+// @Param       query query string true "Search query"
+// @Param       dietary query string false "Comma separated dietary tags; defaults to the signed-in user's preferences"
+// @Param       open_now query bool false "Only stores open now by their business hours"
+// @Success     200 {array} CategoryFacet
+// @Failure     400 {object} map[string]string "Invalid parameters"
+// @Router      /api/home/search/facets [get]
+func SearchFacets(c *gin.Context) {
+	query := c.Query("query")
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query required"})
+		return
+	}
+
+	preferences, err := requestDietaryPreferences(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	requiredTags, avoidedTags := splitDietaryPreferences(preferences)
+
+	var rows []struct {
+		Category string `db:"category_slug"`
+		Count    int    `db:"count"`
+	}
+	err = db.DB.Select(&rows, `
+		SELECT s.category_slug, COUNT(*) as count
+		FROM stores s
+		WHERE `+searchStoresFilterSQL+` AND s.category_slug IS NOT NULL
+			AND `+storeOpenNowFilterSQL("$4")+`
+		GROUP BY s.category_slug
+	`, "%"+query+"%", pq.Array(requiredTags), pq.Array(avoidedTags), c.Query("open_now") == "true")
+	if err != nil {
+		log.Printf("[BACKEND] ERROR: Search facets query failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load search facets"})
+		return
+	}
+
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.Category] = row.Count
+	}
+	facets, err := categoryFacets(counts)
+	if err != nil {
+		log.Printf("[BACKEND] ERROR: Failed to load categories for facets: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load search facets"})
+		return
+	}
+	c.JSON(http.StatusOK, facets)
+}
+
 func convertToStores(modelStores []models.Store) []Store {
 	stores := make([]Store, len(modelStores))
 	for i, s := range modelStores {
@@ -515,6 +472,11 @@ func convertToStores(modelStores []models.Store) []Store {
 			discountedPrice = s.DiscountedPrice.Float64
 		}
 
+		regularPrice := discountedPrice
+		if s.RegularPrice.Valid {
+			regularPrice = s.RegularPrice.Float64
+		}
+
 		rating := 0.0
 		if s.Rating.Valid {
 			rating = s.Rating.Float64
@@ -535,15 +497,22 @@ func convertToStores(modelStores []models.Store) []Store {
 			googleMapsURL = s.GoogleMapsURL.String
 		}
 
+		var nextOpening *time.Time
+		if s.NextOpeningTime.Valid {
+			nextOpening = &s.NextOpeningTime.Time
+		}
+
 		stores[i] = Store{
 			ID:              s.ID,
 			Title:           s.Title,
 			Description:     description,
 			PickUpTime:      pickupTime,
 			Distance:        distance,
+			TravelTime:      s.TravelTime,
 			Price:           price,
 			OriginalPrice:   originalPrice,
 			DiscountedPrice: discountedPrice,
+			RegularPrice:    regularPrice,
 			ImageURL:        s.ImageURL,
 			Rating:          rating,
 			ReviewsCount:    reviewsCount,
@@ -554,6 +523,10 @@ func convertToStores(modelStores []models.Store) []Store {
 			Longitude:       s.Longitude,
 			GoogleMapsURL:   googleMapsURL,
 			Highlights:      s.Highlights,
+			DietaryTags:     s.DietaryTags,
+			Category:        s.CategorySlug.String,
+			IsOpenNow:       s.IsOpenNow,
+			NextOpeningTime: nextOpening,
 		}
 	}
 	return stores
@@ -0,0 +1,570 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"savor-server/db"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// Inventory reset triggers and quantity sources (see check_inventory_reset_*)
+const (
+	InventoryResetScheduled = "scheduled"
+	InventoryResetOverride  = "override"
+
+	InventorySourceDailyCount = "daily_count"
+	InventorySourceOverride   = "override"
+)
+
+// InventoryReset is one audit row of a store's inventory being restored
+type InventoryReset struct {
+	ID               string    `json:"id" db:"id"`
+	BusinessDate     string    `json:"businessDate" db:"business_date"`
+	Trigger          string    `json:"trigger" db:"trigger"`
+	Source           string    `json:"source" db:"source"`
+	Quantity         int       `json:"quantity" db:"quantity"`
+	PreviousQuantity *int      `json:"previousQuantity" db:"previous_quantity"`
+	ResetBy          *string   `json:"resetBy" db:"reset_by"`
+	CreatedAt        time.Time `json:"createdAt" db:"created_at"`
+}
+
+type InventoryOverride struct {
+	BusinessDate string    `json:"businessDate" db:"business_date"`
+	Quantity     int       `json:"quantity" db:"quantity"`
+	CreatedBy    *string   `json:"createdBy" db:"created_by"`
+	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
+}
+
+type UpdateInventorySettingsRequest struct {
+	// Store-local "HH:MM"; empty resets at the start of the first pickup window
+	ResetTime string `json:"resetTime"`
+}
+
+type SetInventoryOverrideRequest struct {
+	Date     string `json:"date"`
+	Quantity *int   `json:"quantity" binding:"required,min=0"`
+}
+
+// updateSellingFromInventory stops selling when a store runs out of bags and starts
+// again once it is replenished, unless the owner paused it themselves. Call it after
+// every change to items_left or bags_available.
+func updateSellingFromInventory(exec sqlx.Execer, storeID string) error {
+	_, err := exec.Exec(`
+		UPDATE stores SET
+			is_selling = CASE
+				WHEN COALESCE(bags_available, items_left, 0) <= 0 THEN false
+				WHEN sold_out_paused THEN true
+				ELSE is_selling
+			END,
+			sold_out_paused = CASE
+				WHEN COALESCE(bags_available, items_left, 0) <= 0 THEN sold_out_paused OR is_selling
+				ELSE false
+			END
+		WHERE id = $1
+	`, storeID)
+	return err
+}
+
+var errNotEnoughBags = errors.New("not enough bags left")
+
+// takeBags removes quantity bags from a store that is selling and updates its selling
+// status. It returns errNotEnoughBags, leaving the store unchanged, when fewer are left.
+func takeBags(exec sqlx.Execer, storeID string, quantity int) error {
+	result, err := exec.Exec(`
+		UPDATE stores
+		SET bags_available = COALESCE(bags_available, items_left) - $1,
+		    items_left = GREATEST(0, items_left - $1),
+		    updated_at = NOW()
+		WHERE id = $2 AND COALESCE(bags_available, items_left) >= $1 AND is_selling
+	`, quantity, storeID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errNotEnoughBags
+	}
+	return updateSellingFromInventory(exec, storeID)
+}
+
+// hasBags reports whether a store that is selling has at least quantity bags left. It
+// only screens a checkout before the card is charged; takeBags has the final say.
+func hasBags(storeID string, quantity int) (bool, error) {
+	var ok bool
+	err := db.DB.Get(&ok, `
+		SELECT COALESCE(is_selling, false) AND COALESCE(bags_available, items_left, 0) >= $2 FROM stores WHERE id = $1
+	`, storeID, quantity)
+	return ok, err
+}
+
+// returnReservedBags puts a cancelled reservation's bags back on sale. Bags reserved
+// before the store's last inventory reset came out of an earlier count, so they are
+// not returned; that would inflate today's inventory.
+func returnReservedBags(tx *sqlx.Tx, reservationID string) error {
+	var storeID string
+	err := tx.Get(&storeID, `
+		UPDATE stores s
+		SET items_left = s.items_left + r.quantity,
+		    bags_available = s.bags_available + r.quantity,
+		    updated_at = NOW()
+		FROM reservations r
+		WHERE r.id = $1 AND s.id = r.store_id
+			AND NOT EXISTS (
+				SELECT 1 FROM inventory_resets ir
+				WHERE ir.store_id = r.store_id AND ir.created_at >= r.created_at
+			)
+		RETURNING s.id
+	`, reservationID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return updateSellingFromInventory(tx, storeID)
+}
+
+// inventoryResetAt is when today's reset is due in the store's zone: the configured
+// reset time, else the start of today's first pickup window, else midnight
+func inventoryResetAt(tx *sqlx.Tx, storeID string, resetTime sql.NullString, localNow time.Time) (time.Time, error) {
+	midnight := startOfDay(localNow, localNow.Location())
+	if resetTime.Valid {
+		hour, minute, err := parseClockTime(resetTime.String)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid inventory reset time %q: %v", resetTime.String, err)
+		}
+		return time.Date(midnight.Year(), midnight.Month(), midnight.Day(), hour, minute, 0, 0, midnight.Location()), nil
+	}
+
+	var firstWindow sql.NullTime
+	err := tx.Get(&firstWindow, `
+		SELECT MIN(starts_at) FROM pickup_windows
+		WHERE store_id = $1 AND starts_at >= $2 AND starts_at < $3 AND NOT cancelled
+	`, storeID, midnight, midnight.AddDate(0, 0, 1))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to load first pickup window: %v", err)
+	}
+	if firstWindow.Valid {
+		return firstWindow.Time.In(midnight.Location()), nil
+	}
+	return midnight, nil
+}
+
+// resetStoreInventory restores the store's bags for its local business date from the
+// date's override or bag_details.daily_count and records the reset. The store row is
+// locked so a reset and a reservation cannot interleave.
+func resetStoreInventory(tx *sqlx.Tx, storeID, businessDate, trigger, resetBy string) (*InventoryReset, error) {
+	var previous sql.NullInt64
+	err := tx.Get(&previous, `
+		SELECT COALESCE(bags_available, items_left) FROM stores WHERE id = $1 FOR UPDATE
+	`, storeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock store: %v", err)
+	}
+
+	source := InventorySourceOverride
+	var quantity int
+	err = tx.Get(&quantity, `
+		SELECT quantity FROM inventory_overrides WHERE store_id = $1 AND business_date = $2::date
+	`, storeID, businessDate)
+	if err == sql.ErrNoRows {
+		source = InventorySourceDailyCount
+		err = tx.Get(&quantity, `SELECT daily_count FROM bag_details WHERE store_id = $1`, storeID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load daily count: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE stores SET items_left = $1, bags_available = $1, updated_at = NOW() WHERE id = $2
+	`, quantity, storeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reset inventory: %v", err)
+	}
+	if err := updateSellingFromInventory(tx, storeID); err != nil {
+		return nil, fmt.Errorf("failed to update selling status: %v", err)
+	}
+
+	var previousQuantity *int
+	if previous.Valid {
+		p := int(previous.Int64)
+		previousQuantity = &p
+	}
+	var by *string
+	if resetBy != "" {
+		by = &resetBy
+	}
+
+	var reset InventoryReset
+	err = tx.Get(&reset, `
+		INSERT INTO inventory_resets (store_id, business_date, trigger, source, quantity, previous_quantity, reset_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, business_date::text as business_date, trigger, source, quantity, previous_quantity, reset_by, created_at
+	`, storeID, businessDate, trigger, source, quantity, previousQuantity, by)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record inventory reset: %v", err)
+	}
+	return &reset, nil
+}
+
+// ResetDailyInventory restores every store's bags once per store-local day when its
+// reset is due. Stores without bag details have no daily count and are left alone.
+func ResetDailyInventory() error {
+	var stores []struct {
+		ID        string         `db:"id"`
+		Timezone  string         `db:"timezone"`
+		ResetTime sql.NullString `db:"inventory_reset_time"`
+	}
+	err := db.DB.Select(&stores, `
+		SELECT s.id, s.timezone, s.inventory_reset_time::text as inventory_reset_time
+		FROM stores s
+		JOIN bag_details bd ON bd.store_id = s.id
+		WHERE s.review_status <> $1
+	`, StoreReviewClosed)
+	if err != nil {
+		return fmt.Errorf("failed to load stores: %v", err)
+	}
+
+	now := time.Now()
+	reset := 0
+	for _, s := range stores {
+		done, err := resetDueStoreInventory(s.ID, s.ResetTime, now.In(storeLocation(s.Timezone)))
+		if err != nil {
+			log.Printf("WARNING: Failed to reset inventory for store %s: %v", s.ID, err)
+			continue
+		}
+		if done {
+			reset++
+		}
+	}
+	if reset > 0 {
+		log.Printf("Reset daily inventory for %d stores", reset)
+	}
+	return nil
+}
+
+func resetDueStoreInventory(storeID string, resetTime sql.NullString, localNow time.Time) (bool, error) {
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	businessDate := localNow.Format("2006-01-02")
+	var alreadyReset bool
+	err = tx.Get(&alreadyReset, `
+		SELECT EXISTS (
+			SELECT 1 FROM inventory_resets
+			WHERE store_id = $1 AND business_date = $2::date AND trigger = $3
+		)
+	`, storeID, businessDate, InventoryResetScheduled)
+	if err != nil || alreadyReset {
+		return false, err
+	}
+
+	due, err := inventoryResetAt(tx, storeID, resetTime, localNow)
+	if err != nil {
+		return false, err
+	}
+	if localNow.Before(due) {
+		return false, nil
+	}
+
+	if _, err := resetStoreInventory(tx, storeID, businessDate, InventoryResetScheduled, ""); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// GetStoreInventory shows the store's daily count, today's override and recent resets
+func GetStoreInventory(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
+		return
+	}
+
+	var store struct {
+		ItemsLeft     sql.NullInt64  `db:"items_left"`
+		BagsAvailable sql.NullInt64  `db:"bags_available"`
+		DailyCount    sql.NullInt64  `db:"daily_count"`
+		ResetTime     sql.NullString `db:"inventory_reset_time"`
+		IsSelling     bool           `db:"is_selling"`
+		SoldOutPaused bool           `db:"sold_out_paused"`
+		Timezone      string         `db:"timezone"`
+	}
+	err := db.DB.Get(&store, `
+		SELECT s.items_left, s.bags_available, bd.daily_count,
+			to_char(s.inventory_reset_time, 'HH24:MI') as inventory_reset_time,
+			COALESCE(s.is_selling, false) as is_selling, s.sold_out_paused, s.timezone
+		FROM stores s
+		LEFT JOIN bag_details bd ON bd.store_id = s.id
+		WHERE s.id = $1
+	`, storeID)
+	if err != nil {
+		log.Printf("ERROR: Failed to load inventory for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get inventory"})
+		return
+	}
+
+	today := time.Now().In(storeLocation(store.Timezone)).Format("2006-01-02")
+	overrides := make([]InventoryOverride, 0)
+	err = db.DB.Select(&overrides, `
+		SELECT business_date::text as business_date, quantity, created_by, created_at
+		FROM inventory_overrides
+		WHERE store_id = $1 AND business_date >= $2::date
+		ORDER BY business_date
+	`, storeID, today)
+	if err != nil {
+		log.Printf("ERROR: Failed to load inventory overrides for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get inventory"})
+		return
+	}
+
+	resets := make([]InventoryReset, 0)
+	err = db.DB.Select(&resets, `
+		SELECT id, business_date::text as business_date, trigger, source, quantity, previous_quantity, reset_by, created_at
+		FROM inventory_resets
+		WHERE store_id = $1
+		ORDER BY created_at DESC
+		LIMIT 14
+	`, storeID)
+	if err != nil {
+		log.Printf("ERROR: Failed to load inventory resets for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get inventory"})
+		return
+	}
+
+	var resetTime *string
+	if store.ResetTime.Valid {
+		resetTime = &store.ResetTime.String
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"itemsLeft":     store.ItemsLeft.Int64,
+		"bagsAvailable": store.BagsAvailable.Int64,
+		"dailyCount":    store.DailyCount.Int64,
+		"resetTime":     resetTime,
+		"isSelling":     store.IsSelling,
+		"soldOutPaused": store.SoldOutPaused,
+		"overrides":     overrides,
+		"resets":        resets,
+	})
+}
+
+// UpdateInventorySettings sets the store-local time of the daily reset
+func UpdateInventorySettings(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req UpdateInventorySettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var resetTime *string
+	if req.ResetTime != "" {
+		hour, minute, err := parseClockTime(req.ResetTime)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resetTime"})
+			return
+		}
+		t := fmt.Sprintf("%02d:%02d", hour, minute)
+		resetTime = &t
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	audit, err := beginStoreAudit(tx, storeID)
+	if err == nil {
+		_, err = tx.Exec(`UPDATE stores SET inventory_reset_time = $1, updated_at = NOW() WHERE id = $2`, resetTime, storeID)
+	}
+	if err == nil {
+		err = audit.record(c, tx, StoreAuditInventorySettingsUpdated)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to update inventory reset time for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update inventory settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Inventory settings updated", "resetTime": resetTime})
+}
+
+// SetInventoryOverride replaces the daily count for one date, today by default. An
+// override for today takes effect immediately if today's reset has already run.
+func SetInventoryOverride(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req SetInventoryOverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
+		return
+	}
+
+	today := time.Now().In(storeLocationByID(storeID)).Format("2006-01-02")
+	if req.Date == "" {
+		req.Date = today
+	}
+	if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+	if req.Date < today {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Override must not be in the past"})
+		return
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var previous *int
+	err = tx.Get(&previous, `
+		SELECT (SELECT quantity FROM inventory_overrides WHERE store_id = $1 AND business_date = $2::date)
+	`, storeID, req.Date)
+	if err != nil {
+		log.Printf("ERROR: Failed to load inventory override for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save override"})
+		return
+	}
+
+	var override InventoryOverride
+	err = tx.Get(&override, `
+		INSERT INTO inventory_overrides (store_id, business_date, quantity, created_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (store_id, business_date) DO UPDATE
+		SET quantity = EXCLUDED.quantity, created_by = EXCLUDED.created_by, created_at = NOW()
+		RETURNING business_date::text as business_date, quantity, created_by, created_at
+	`, storeID, req.Date, *req.Quantity, userID)
+	if err == nil {
+		err = recordStoreAudit(c, tx, storeID, StoreAuditInventoryOverrideSet, map[string]StoreAuditChange{
+			"override:" + req.Date: {Before: auditValue(previous), After: auditValue(override.Quantity)},
+		})
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to save inventory override for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save override"})
+		return
+	}
+
+	var reset *InventoryReset
+	if req.Date == today {
+		var resetToday bool
+		err = tx.Get(&resetToday, `
+			SELECT EXISTS (
+				SELECT 1 FROM inventory_resets
+				WHERE store_id = $1 AND business_date = $2::date AND trigger = $3
+			)
+		`, storeID, today, InventoryResetScheduled)
+		if err == nil && resetToday {
+			reset, err = resetStoreInventory(tx, storeID, today, InventoryResetOverride, userID)
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to apply inventory override for store %s: %v", storeID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply override"})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"override": override, "reset": reset})
+}
+
+// DeleteInventoryOverride goes back to the daily count for a date. Bags already
+// restored today are kept until the next reset.
+func DeleteInventoryOverride(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
+		return
+	}
+
+	date := c.Param("date")
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+		return
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var quantity int
+	err = tx.Get(&quantity, `
+		DELETE FROM inventory_overrides WHERE store_id = $1 AND business_date = $2::date
+		RETURNING quantity
+	`, storeID, date)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Override not found"})
+		return
+	}
+	if err == nil {
+		err = recordStoreAudit(c, tx, storeID, StoreAuditInventoryOverrideDeleted, map[string]StoreAuditChange{
+			"override:" + date: {Before: auditValue(quantity), After: auditValue(nil)},
+		})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to delete inventory override for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete override"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Override deleted"})
+}
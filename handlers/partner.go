@@ -1,16 +1,52 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"savor-server/db"
+	"savor-server/middleware"
+	"savor-server/services"
 
+	"firebase.google.com/go/v4/auth"
 	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
 )
 
+// Partner onboarding stages (see check_partner_stage)
+const (
+	PartnerStageLead              = "lead"
+	PartnerStageContacted         = "contacted"
+	PartnerStageDocumentsReceived = "documents_received"
+	PartnerStageApproved          = "approved"
+	PartnerStageLive              = "live"
+	PartnerStageRejected          = "rejected"
+)
+
+// partnerStageTransitions lists where an application may move from each stage.
+// Approval goes through ApprovePartnerApplication because it provisions the partner.
+var partnerStageTransitions = map[string][]string{
+	PartnerStageLead:              {PartnerStageContacted, PartnerStageRejected},
+	PartnerStageContacted:         {PartnerStageDocumentsReceived, PartnerStageRejected},
+	PartnerStageDocumentsReceived: {PartnerStageContacted, PartnerStageApproved, PartnerStageRejected},
+	PartnerStageApproved:          {PartnerStageLive},
+	PartnerStageRejected:          {PartnerStageLead},
+}
+
+func canMovePartnerStage(from, to string) bool {
+	for _, next := range partnerStageTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
 // PartnerContactRequest represents the partner contact form data
 type PartnerContactRequest struct {
 	Name      string `json:"name" binding:"required"`
@@ -20,18 +56,55 @@ type PartnerContactRequest struct {
 	Message   string `json:"message"`
 }
 
-// PartnerContact represents the database model for partner contacts
+// PartnerContact is a partner application submitted through the contact form
 type PartnerContact struct {
-	ID        int       `json:"id" db:"id"`
-	Name      string    `json:"name" db:"name"`
-	Email     string    `json:"email" db:"email"`
-	Phone     string    `json:"phone" db:"phone"`
-	StoreName string    `json:"store_name" db:"store_name"`
-	Message   string    `json:"message" db:"message"`
+	ID             int       `json:"id" db:"id"`
+	Name           string    `json:"name" db:"name"`
+	Email          string    `json:"email" db:"email"`
+	Phone          string    `json:"phone" db:"phone"`
+	StoreName      string    `json:"store_name" db:"store_name"`
+	Message        *string   `json:"message" db:"message"`
+	Stage          string    `json:"stage" db:"stage"`
+	AssignedTo     *string   `json:"assigned_to" db:"assigned_to"`
+	PartnerUserID  *string   `json:"partner_user_id" db:"partner_user_id"`
+	StoreID        *string   `json:"store_id" db:"store_id"`
+	StageChangedAt time.Time `json:"stage_changed_at" db:"stage_changed_at"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+type PartnerApplicationNote struct {
+	ID        string    `json:"id" db:"id"`
+	AuthorID  string    `json:"author_id" db:"author_id"`
+	Body      string    `json:"body" db:"body"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	Status    string    `json:"status" db:"status"` // 'new', 'contacted', 'completed'
 }
 
+type PartnerApplicationEvent struct {
+	ID        string    `json:"id" db:"id"`
+	FromStage *string   `json:"from_stage" db:"from_stage"`
+	ToStage   string    `json:"to_stage" db:"to_stage"`
+	ChangedBy *string   `json:"changed_by" db:"changed_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// ApprovePartnerRequest fills in the store created for the partner; the store name
+// and phone come from the application
+type ApprovePartnerRequest struct {
+	StoreType string  `json:"storeType"`
+	Street    string  `json:"street"`
+	City      string  `json:"city"`
+	State     string  `json:"state"`
+	ZipCode   string  `json:"zipCode"`
+	Country   string  `json:"country"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Timezone  string  `json:"timezone"`
+	Note      string  `json:"note"`
+}
+
+const partnerContactColumns = `id, name, email, phone, store_name, message, stage, assigned_to,
+	partner_user_id, store_id, stage_changed_at, created_at`
+
 // @Summary Submit partner contact form
 // @Description Submit a contact form for potential store partners
 // @Tags partner
@@ -53,39 +126,25 @@ func SubmitPartnerContact(c *gin.Context) {
 		return
 	}
 
-	// Create the partner_contacts table if it doesn't exist
-	createTableQuery := `
-		CREATE TABLE IF NOT EXISTS partner_contacts (
-			id SERIAL PRIMARY KEY,
-			name VARCHAR(255) NOT NULL,
-			email VARCHAR(255) NOT NULL,
-			phone VARCHAR(50) NOT NULL,
-			store_name VARCHAR(255) NOT NULL,
-			message TEXT,
-			status VARCHAR(20) DEFAULT 'new',
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		);
-	`
-
-	_, err := db.DB.Exec(createTableQuery)
+	tx, err := db.DB.Beginx()
 	if err != nil {
-		log.Printf("Error creating partner_contacts table: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Database initialization error",
-		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save contact information"})
 		return
 	}
+	defer tx.Rollback()
 
-	// Insert the contact form data
-	insertQuery := `
-		INSERT INTO partner_contacts (name, email, phone, store_name, message, status, created_at)
-		VALUES ($1, $2, $3, $4, $5, 'new', CURRENT_TIMESTAMP)
-		RETURNING id, created_at
-	`
-
-	var contactID int
-	var createdAt time.Time
-	err = db.DB.QueryRow(insertQuery, req.Name, req.Email, req.Phone, req.StoreName, req.Message).Scan(&contactID, &createdAt)
+	var contact PartnerContact
+	err = tx.Get(&contact, `
+		INSERT INTO partner_contacts (name, email, phone, store_name, message, stage)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+partnerContactColumns,
+		req.Name, strings.ToLower(strings.TrimSpace(req.Email)), req.Phone, req.StoreName, req.Message, PartnerStageLead)
+	if err == nil {
+		err = recordPartnerStageChange(tx, contact.ID, "", PartnerStageLead, "")
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		log.Printf("Error inserting partner contact: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -95,127 +154,483 @@ func SubmitPartnerContact(c *gin.Context) {
 	}
 
 	log.Printf("New partner contact submitted: ID=%d, Name=%s, Email=%s, Store=%s",
-		contactID, req.Name, req.Email, req.StoreName)
+		contact.ID, req.Name, req.Email, req.StoreName)
+
+	go sendPartnerStageEmail(contact, "")
 
 	c.JSON(http.StatusOK, gin.H{
 		"success":    true,
 		"message":    "Contact form submitted successfully",
-		"contact_id": contactID,
-		"created_at": createdAt,
+		"contact_id": contact.ID,
+		"created_at": contact.CreatedAt,
 	})
 }
 
-// @Summary Get all partner contacts (Admin only)
-// @Description Retrieve all partner contact submissions for admin review
+// recordPartnerStageChange adds a stage change to the application's timeline
+func recordPartnerStageChange(tx *sqlx.Tx, applicationID int, from, to, changedBy string) error {
+	_, err := tx.Exec(`
+		INSERT INTO partner_application_events (application_id, from_stage, to_stage, changed_by)
+		VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, ''))
+	`, applicationID, from, to, changedBy)
+	return err
+}
+
+// sendPartnerStageEmail tells the applicant their application reached a new stage
+func sendPartnerStageEmail(contact PartnerContact, setupURL string) {
+	emailService := services.GetEmailService()
+	if !emailService.IsConfigured() {
+		return
+	}
+	err := emailService.SendPartnerApplicationUpdate(contact.Email, services.PartnerApplicationEmailData{
+		Name:      contact.Name,
+		StoreName: contact.StoreName,
+		Stage:     contact.Stage,
+		SetupURL:  setupURL,
+	})
+	if err != nil {
+		log.Printf("WARNING: Failed to send partner application email to %s: %v", contact.Email, err)
+	}
+}
+
+// lockPartnerApplication loads an application for update, writing 404/500 on failure
+func lockPartnerApplication(c *gin.Context, tx *sqlx.Tx) (*PartnerContact, bool) {
+	var contact PartnerContact
+	err := tx.Get(&contact, `SELECT `+partnerContactColumns+` FROM partner_contacts WHERE id::text = $1 FOR UPDATE`, c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Partner application not found"})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error loading partner application %s: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load partner application"})
+		return nil, false
+	}
+	return &contact, true
+}
+
+// @Summary List partner applications (Admin only)
+// @Description List partner applications, optionally filtered by stage or assigned rep
 // @Tags partner
 // @Produce json
-// @Success 200 {array} PartnerContact "List of partner contacts"
+// @Param stage query string false "Onboarding stage"
+// @Param assignedTo query string false "Assigned rep user ID"
+// @Success 200 {array} PartnerContact "List of partner applications"
 // @Failure 500 {object} map[string]interface{} "Internal server error"
-// @Router /api/partner/contacts [get]
-func GetPartnerContacts(c *gin.Context) {
-	query := `
-		SELECT id, name, email, phone, store_name, message, status, created_at
+// @Router /api/admin/partner-applications [get]
+func ListPartnerApplications(c *gin.Context) {
+	contacts := make([]PartnerContact, 0)
+	err := db.DB.Select(&contacts, `
+		SELECT `+partnerContactColumns+`
 		FROM partner_contacts
+		WHERE ($1 = '' OR stage = $1) AND ($2 = '' OR assigned_to = $2)
 		ORDER BY created_at DESC
-	`
-
-	rows, err := db.DB.Query(query)
+	`, c.Query("stage"), c.Query("assignedTo"))
 	if err != nil {
-		log.Printf("Error querying partner contacts: %v", err)
+		log.Printf("Error querying partner applications: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to retrieve contacts",
+			"error": "Failed to retrieve applications",
 		})
 		return
 	}
-	defer rows.Close()
 
-	var contacts []PartnerContact
-	for rows.Next() {
-		var contact PartnerContact
-		err := rows.Scan(
-			&contact.ID,
-			&contact.Name,
-			&contact.Email,
-			&contact.Phone,
-			&contact.StoreName,
-			&contact.Message,
-			&contact.Status,
-			&contact.CreatedAt,
-		)
-		if err != nil {
-			log.Printf("Error scanning partner contact: %v", err)
-			continue
-		}
-		contacts = append(contacts, contact)
+	c.JSON(http.StatusOK, gin.H{
+		"applications": contacts,
+		"total":        len(contacts),
+	})
+}
+
+// @Summary Get a partner application (Admin only)
+// @Description Get a partner application with its notes and stage history
+// @Tags partner
+// @Produce json
+// @Param id path int true "Application ID"
+// @Success 200 {object} map[string]interface{} "Application, notes and events"
+// @Failure 404 {object} map[string]interface{} "Not found"
+// @Router /api/admin/partner-applications/{id} [get]
+func GetPartnerApplication(c *gin.Context) {
+	var contact PartnerContact
+	err := db.DB.Get(&contact, `SELECT `+partnerContactColumns+` FROM partner_contacts WHERE id::text = $1`, c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Partner application not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading partner application %s: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load partner application"})
+		return
+	}
+
+	notes := make([]PartnerApplicationNote, 0)
+	events := make([]PartnerApplicationEvent, 0)
+	err = db.DB.Select(&notes, `
+		SELECT id, author_id, body, created_at FROM partner_application_notes
+		WHERE application_id = $1 ORDER BY created_at
+	`, contact.ID)
+	if err == nil {
+		err = db.DB.Select(&events, `
+			SELECT id, from_stage, to_stage, changed_by, created_at FROM partner_application_events
+			WHERE application_id = $1 ORDER BY created_at
+		`, contact.ID)
+	}
+	if err != nil {
+		log.Printf("Error loading partner application %d history: %v", contact.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load partner application"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"contacts": contacts,
-		"total":    len(contacts),
+		"application": contact,
+		"notes":       notes,
+		"events":      events,
 	})
 }
 
-// @Summary Update partner contact status
-// @Description Update the status of a partner contact (Admin only)
+// @Summary Move a partner application to another stage (Admin only)
+// @Description Move an application along the onboarding pipeline and email the applicant
 // @Tags partner
 // @Accept json
 // @Produce json
-// @Param id path int true "Contact ID"
-// @Param request body map[string]string true "Status update"
-// @Success 200 {object} map[string]interface{} "Success response"
+// @Param id path int true "Application ID"
+// @Param request body map[string]string true "Stage update"
+// @Success 200 {object} map[string]interface{} "Updated application"
 // @Failure 400 {object} map[string]interface{} "Bad request"
-// @Failure 500 {object} map[string]interface{} "Internal server error"
-// @Router /api/partner/contacts/{id}/status [put]
-func UpdatePartnerContactStatus(c *gin.Context) {
-	contactID := c.Param("id")
-
+// @Failure 409 {object} map[string]interface{} "Stage change not allowed"
+// @Router /api/admin/partner-applications/{id}/stage [put]
+func UpdatePartnerApplicationStage(c *gin.Context) {
 	var req struct {
-		Status string `json:"status" binding:"required"`
+		Stage string `json:"stage" binding:"required"`
+		Note  string `json:"note"`
 	}
-
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request data",
-		})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+	if req.Stage == PartnerStageApproved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use the approve endpoint to approve a partner"})
+		return
+	}
+	if _, ok := partnerStageTransitions[req.Stage]; !ok && req.Stage != PartnerStageLive {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stage"})
 		return
 	}
 
-	// Validate status
-	validStatuses := map[string]bool{
-		"new":       true,
-		"contacted": true,
-		"completed": true,
+	adminID := c.GetString("user_id")
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
 	}
+	defer tx.Rollback()
 
-	if !validStatuses[req.Status] {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid status. Must be one of: new, contacted, completed",
-		})
+	contact, ok := lockPartnerApplication(c, tx)
+	if !ok {
+		return
+	}
+	if !canMovePartnerStage(contact.Stage, req.Stage) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Cannot move application from %s to %s", contact.Stage, req.Stage)})
+		return
+	}
+	if req.Stage == PartnerStageLive && contact.StoreID == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Application has no store yet"})
 		return
 	}
 
-	updateQuery := `
-		UPDATE partner_contacts 
-		SET status = $1 
+	from := contact.Stage
+	err = tx.Get(contact, `
+		UPDATE partner_contacts SET stage = $1, stage_changed_at = NOW()
 		WHERE id = $2
-		RETURNING id, status
-	`
+		RETURNING `+partnerContactColumns, req.Stage, contact.ID)
+	if err == nil {
+		err = recordPartnerStageChange(tx, contact.ID, from, req.Stage, adminID)
+	}
+	if err == nil && strings.TrimSpace(req.Note) != "" {
+		err = addPartnerNote(tx, contact.ID, adminID, req.Note)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error updating partner application %d stage: %v", contact.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update application stage"})
+		return
+	}
+
+	go sendPartnerStageEmail(*contact, "")
+
+	c.JSON(http.StatusOK, gin.H{"application": contact})
+}
+
+func addPartnerNote(tx *sqlx.Tx, applicationID int, authorID, body string) error {
+	_, err := tx.Exec(`
+		INSERT INTO partner_application_notes (application_id, author_id, body) VALUES ($1, $2, $3)
+	`, applicationID, authorID, strings.TrimSpace(body))
+	return err
+}
 
-	var updatedID int
-	var updatedStatus string
-	err := db.DB.QueryRow(updateQuery, req.Status, contactID).Scan(&updatedID, &updatedStatus)
+// @Summary Add a note to a partner application (Admin only)
+// @Tags partner
+// @Accept json
+// @Produce json
+// @Param id path int true "Application ID"
+// @Param request body map[string]string true "Note"
+// @Success 201 {object} PartnerApplicationNote "Created note"
+// @Router /api/admin/partner-applications/{id}/notes [post]
+func AddPartnerApplicationNote(c *gin.Context) {
+	var req struct {
+		Body string `json:"body" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Body) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Note body is required"})
+		return
+	}
+
+	var note PartnerApplicationNote
+	err := db.DB.Get(&note, `
+		INSERT INTO partner_application_notes (application_id, author_id, body)
+		SELECT id, $2, $3 FROM partner_contacts WHERE id::text = $1
+		RETURNING id, author_id, body, created_at
+	`, c.Param("id"), c.GetString("user_id"), strings.TrimSpace(req.Body))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Partner application not found"})
+		return
+	}
 	if err != nil {
-		log.Printf("Error updating partner contact status: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to update contact status",
-		})
+		log.Printf("Error adding note to partner application %s: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add note"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": fmt.Sprintf("Contact status updated to %s", updatedStatus),
-		"id":      updatedID,
-		"status":  updatedStatus,
-	})
+	c.JSON(http.StatusCreated, gin.H{"note": note})
+}
+
+// @Summary Assign a rep to a partner application (Admin only)
+// @Description Assign an admin as the application's rep; an empty assignedTo unassigns it
+// @Tags partner
+// @Accept json
+// @Produce json
+// @Param id path int true "Application ID"
+// @Param request body map[string]string true "Assignee"
+// @Success 200 {object} map[string]interface{} "Updated application"
+// @Router /api/admin/partner-applications/{id}/assignee [put]
+func AssignPartnerApplication(c *gin.Context) {
+	var req struct {
+		AssignedTo string `json:"assignedTo"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+	if req.AssignedTo != "" && !middleware.IsAdmin(req.AssignedTo) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Applications can only be assigned to admins"})
+		return
+	}
+
+	var contact PartnerContact
+	err := db.DB.Get(&contact, `
+		UPDATE partner_contacts SET assigned_to = NULLIF($1, '')
+		WHERE id::text = $2
+		RETURNING `+partnerContactColumns, req.AssignedTo, c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Partner application not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error assigning partner application %s: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign application"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"application": contact})
+}
+
+// ApprovePartnerApplication provisions the partner: a Firebase account for the
+// applicant's email (reused if it already exists), a stores row they own and a link
+// to set their password, which is emailed to them.
+//
+// @Summary Approve a partner application (Admin only)
+// @Tags partner
+// @Accept json
+// @Produce json
+// @Param id path int true "Application ID"
+// @Param request body ApprovePartnerRequest false "Store details"
+// @Success 200 {object} map[string]interface{} "Approved application"
+// @Failure 409 {object} map[string]interface{} "Application cannot be approved"
+// @Router /api/admin/partner-applications/{id}/approve [post]
+func ApprovePartnerApplication(authClient *auth.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ApprovePartnerRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if req.Timezone != "" && !isValidTimezone(req.Timezone) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timezone"})
+			return
+		}
+
+		var contact PartnerContact
+		err := db.DB.Get(&contact, `SELECT `+partnerContactColumns+` FROM partner_contacts WHERE id::text = $1`, c.Param("id"))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Partner application not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load partner application"})
+			return
+		}
+		if !canMovePartnerStage(contact.Stage, PartnerStageApproved) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Cannot approve an application in stage %s", contact.Stage)})
+			return
+		}
+
+		// Partners often apply without an address; the owner then places the store while
+		// setting it up
+		var location *StoreCoordinates
+		if strings.TrimSpace(req.Street) != "" || req.Latitude != 0 || req.Longitude != 0 {
+			location, err = resolveStoreCoordinates(c.Request.Context(), StoreAddress{
+				Street:    req.Street,
+				City:      req.City,
+				State:     req.State,
+				Country:   req.Country,
+				Latitude:  req.Latitude,
+				Longitude: req.Longitude,
+			})
+			if respondStoreLocationError(c, err) {
+				return
+			}
+		}
+
+		// Firebase is outside the transaction; a retry after a database error finds
+		// the account created by the first attempt
+		partnerUID, err := provisionPartnerAccount(c.Request.Context(), authClient, contact)
+		if err != nil {
+			log.Printf("ERROR: Failed to provision Firebase account for partner application %d: %v", contact.ID, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to create partner account"})
+			return
+		}
+
+		adminID := c.GetString("user_id")
+		tx, err := db.DB.Beginx()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+			return
+		}
+		defer tx.Rollback()
+
+		locked, ok := lockPartnerApplication(c, tx)
+		if !ok {
+			return
+		}
+		if !canMovePartnerStage(locked.Stage, PartnerStageApproved) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Cannot approve an application in stage %s", locked.Stage)})
+			return
+		}
+
+		storeID, err := createPartnerStore(tx, partnerUID, *locked, req, location)
+		if err == nil {
+			err = tx.Get(&contact, `
+				UPDATE partner_contacts
+				SET stage = $1, stage_changed_at = NOW(), partner_user_id = $2, store_id = $3
+				WHERE id = $4
+				RETURNING `+partnerContactColumns, PartnerStageApproved, partnerUID, storeID, locked.ID)
+		}
+		if err == nil {
+			err = recordPartnerStageChange(tx, locked.ID, locked.Stage, PartnerStageApproved, adminID)
+		}
+		if err == nil && strings.TrimSpace(req.Note) != "" {
+			err = addPartnerNote(tx, locked.ID, adminID, req.Note)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to approve partner application %d: %v", locked.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve application"})
+			return
+		}
+
+		setupURL, err := authClient.PasswordResetLink(context.Background(), contact.Email)
+		if err != nil {
+			log.Printf("WARNING: Failed to create password setup link for %s: %v", contact.Email, err)
+		}
+		go sendPartnerStageEmail(contact, setupURL)
+
+		c.JSON(http.StatusOK, gin.H{
+			"application": contact,
+			"storeId":     storeID,
+		})
+	}
+}
+
+// provisionPartnerAccount returns the Firebase UID for the applicant's email,
+// creating the account if there is none
+func provisionPartnerAccount(ctx context.Context, authClient *auth.Client, contact PartnerContact) (string, error) {
+	user, err := authClient.GetUserByEmail(ctx, contact.Email)
+	if err == nil {
+		return user.UID, nil
+	}
+	if !auth.IsUserNotFound(err) {
+		return "", err
+	}
+
+	params := (&auth.UserToCreate{}).
+		Email(contact.Email).
+		DisplayName(contact.Name)
+	user, err = authClient.CreateUser(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Created Firebase account %s for partner application %d", user.UID, contact.ID)
+	return user.UID, nil
+}
+
+// createPartnerStore creates the partner's store, not selling until they finish setting it
+// up. location is nil when the application had no address.
+func createPartnerStore(tx *sqlx.Tx, ownerID string, contact PartnerContact, req ApprovePartnerRequest, location *StoreCoordinates) (string, error) {
+	var mapsURL sql.NullString
+	if location != nil {
+		req.Latitude, req.Longitude = location.Latitude, location.Longitude
+		mapsURL = sql.NullString{String: location.GoogleMapsURL, Valid: true}
+	}
+
+	timezone := req.Timezone
+	if timezone == "" {
+		timezone = defaultTimezoneFor(req.Country, req.Latitude, req.Longitude)
+	}
+
+	var parts []string
+	for _, p := range []string{req.Street, req.City, strings.TrimSpace(req.State + " " + req.ZipCode), req.Country} {
+		if strings.TrimSpace(p) != "" {
+			parts = append(parts, strings.TrimSpace(p))
+		}
+	}
+
+	category, err := categoryForStoreType(tx, req.StoreType)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve store category: %v", err)
+	}
+
+	var storeID string
+	err = tx.Get(&storeID, `
+		INSERT INTO stores (
+			owner_id, title, store_type, address, city, state, zip_code,
+			phone, latitude, longitude, background_url, image_url, price, is_selling,
+			timezone, category_slug, google_maps_url
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, '', '', 5, false, $11, $12, $13)
+		RETURNING id
+	`, ownerID, contact.StoreName, req.StoreType, strings.Join(parts, ", "), req.City, req.State, req.ZipCode,
+		contact.Phone, req.Latitude, req.Longitude, timezone, category, mapsURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to create store: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO store_members (store_id, user_id, role) VALUES ($1, $2, $3)
+	`, storeID, ownerID, StoreRoleOwner)
+	if err != nil {
+		return "", fmt.Errorf("failed to add store owner: %v", err)
+	}
+	return storeID, nil
 }
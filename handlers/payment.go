@@ -1,13 +1,18 @@
 package handlers
 
 import (
+	"database/sql"
 	"fmt"
+	"log"
 	"net/http"
 	"savor-server/db"
+	"savor-server/services"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/stripe/stripe-go/v74"
-	"github.com/stripe/stripe-go/v74/paymentintent"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
 )
 
 type ReservationRequest struct {
@@ -15,12 +20,86 @@ type ReservationRequest struct {
 	Quantity      int     `json:"quantity" binding:"required,min=1"`
 	TotalAmount   float64 `json:"totalAmount" binding:"required"`
 	PaymentMethod string  `json:"paymentMethod" binding:"required"`
-	PickupTime    string  `json:"pickupTime" binding:"required"`
+	// PickupTime is only used for stores without a pickup schedule
+	PickupTime string `json:"pickupTime"`
+	// PickupWindowId picks one of GET /api/stores/:id/pickup-windows; defaults to the next one
+	PickupWindowId string `json:"pickupWindowId"`
+	// WalletAmount is the part of TotalAmount paid from the customer's wallet.
+	// When it covers the whole total no card payment is created.
+	WalletAmount float64 `json:"walletAmount"`
+	// PaymentMethodId charges one of the user's saved cards (one-tap checkout)
+	PaymentMethodId string `json:"paymentMethodId"`
+	// SaveCard keeps the card entered for this payment for next time
+	SaveCard bool   `json:"saveCard"`
+	Email    string `json:"email"`
 }
 
+// PayAtStoreRequest creates an unpaid reservation that is settled in cash or by
+// bank transfer at pickup. No Stripe objects are involved.
 type PayAtStoreRequest struct {
-	PaymentIntentId string `json:"paymentIntentId" binding:"required"`
-	PaymentMethod   string `json:"paymentMethod" binding:"required"`
+	StoreId        string `json:"storeId" binding:"required"`
+	Quantity       int    `json:"quantity" binding:"required,min=1"`
+	PickupTime     string `json:"pickupTime"`
+	PickupWindowId string `json:"pickupWindowId"`
+	Name           string `json:"name"`
+	Email          string `json:"email"`
+	Phone          string `json:"phone"`
+}
+
+// Reservation payment methods (see check_payment_method)
+const (
+	PaymentMethodCard       = "card"
+	PaymentMethodWallet     = "wallet"
+	PaymentMethodPayAtStore = "pay_at_store"
+)
+
+// Reservation payment statuses (see check_payment_status)
+const (
+	PaymentStatusUnpaid   = "unpaid"
+	PaymentStatusPaid     = "paid"
+	PaymentStatusRefunded = "refunded"
+)
+
+// paymentMethodLabel returns the Vietnamese label shown to customers in emails
+func paymentMethodLabel(method string) string {
+	switch method {
+	case PaymentMethodCard:
+		return "Thanh toán bằng thẻ"
+	case PaymentMethodWallet:
+		return "Ví Savor"
+	case PaymentMethodPayAtStore:
+		return "Trả tiền tại cửa hàng"
+	default:
+		return method
+	}
+}
+
+// lookupStoreUnitPrice returns the per-bag price customers are charged for a store. A
+// discounted price of 0 means none is set, as in storeBasePrice.
+// It returns errStoreOffboarded for a closed store and errStoreNotApproved for any
+// other store that is not approved, so every checkout path refuses them.
+func lookupStoreUnitPrice(storeID string) (float64, error) {
+	var store struct {
+		Price        sql.NullFloat64 `db:"price"`
+		ReviewStatus string          `db:"review_status"`
+	}
+	err := db.DB.Get(&store, `
+		SELECT COALESCE(NULLIF(discounted_price, 0), price) as price, review_status FROM stores WHERE id = $1
+	`, storeID)
+	if err != nil {
+		return 0, err
+	}
+	switch store.ReviewStatus {
+	case StoreReviewApproved:
+		if !store.Price.Valid || store.Price.Float64 <= 0 {
+			return 0, fmt.Errorf("store %s has no price", storeID)
+		}
+		return store.Price.Float64, nil
+	case StoreReviewClosed:
+		return 0, errStoreOffboarded
+	default:
+		return 0, errStoreNotApproved
+	}
 }
 
 func CreateReservation(c *gin.Context) {
@@ -32,31 +111,340 @@ func CreateReservation(c *gin.Context) {
 		return
 	}
 
-	// Convert amount to cents for Stripe
-	amountInCents := int64(req.TotalAmount * 100)
+	userID := c.GetString("user_id")
+
+	// Charge the price in effect now, including any dynamic discount, not the client's total
+	quote, err := quoteCheckout(req.StoreId, req.Quantity, time.Now())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(404, gin.H{"error": "Store not found"})
+			return
+		}
+		if err == errStoreOffboarded || err == errStoreNotApproved {
+			respondPickupError(c, req.StoreId, err)
+			return
+		}
+		fmt.Println("Failed to get store price", err)
+		c.JSON(500, gin.H{"error": "Failed to get store price"})
+		return
+	}
+	if toMinorUnits(req.TotalAmount) != quote.TotalMinor {
+		log.Printf("Reservation total for store %s adjusted from %.2f to %.2f", req.StoreId, req.TotalAmount, fromMinorUnits(quote.TotalMinor))
+	}
+
+	pickup, err := resolveReservationPickup(req.StoreId, req.PickupWindowId, req.PickupTime, time.Now())
+	if err != nil {
+		respondPickupError(c, req.StoreId, err)
+		return
+	}
+
+	totalMinor := quote.TotalMinor
+	walletMinor := toMinorUnits(req.WalletAmount)
+	cardMinor, ok := splitWalletPayment(totalMinor, walletMinor)
+	if !ok {
+		c.JSON(400, gin.H{"error": "Invalid wallet amount"})
+		return
+	}
+
+	if cardMinor == 0 {
+		payReservationWithWallet(c, userID, req, quote, pickup)
+		return
+	}
 
-	// Create Stripe PaymentIntent with only card payment method
-	params := &stripe.PaymentIntentParams{
-		Amount:             stripe.Int64(amountInCents),
-		Currency:           stripe.String("usd"),
-		PaymentMethodTypes: []*string{stripe.String("card")},
+	// Refuse before charging the card; the bags are only taken once it is paid
+	available, err := hasBags(req.StoreId, req.Quantity)
+	if err != nil {
+		fmt.Println("Failed to check store availability", err)
+		c.JSON(500, gin.H{"error": "Failed to check store availability"})
+		return
+	}
+	if !available {
+		c.JSON(409, gin.H{"error": "Not enough bags left"})
+		return
 	}
 
-	// Add metadata using SetMetadata
-	params.AddMetadata("storeId", req.StoreId)
-	params.AddMetadata("quantity", fmt.Sprintf("%d", req.Quantity))
-	params.AddMetadata("pickup_time", req.PickupTime)
+	// One-tap checkout and saving a card both need the user's Stripe Customer
+	var customerID string
+	if req.PaymentMethodId != "" || req.SaveCard {
+		customerID, err = ensurePaymentCustomer(userID, req.Email)
+		if err != nil {
+			log.Printf("ERROR: Failed to get payment customer for user %s: %v", userID, err)
+			c.JSON(500, gin.H{"error": "Failed to create customer"})
+			return
+		}
+	}
+	if req.PaymentMethodId != "" {
+		owned, err := ownsPaymentMethod(userID, req.PaymentMethodId)
+		if err != nil {
+			log.Printf("ERROR: Failed to verify payment method %s: %v", req.PaymentMethodId, err)
+			c.JSON(500, gin.H{"error": "Failed to verify payment method"})
+			return
+		}
+		if !owned {
+			c.JSON(400, gin.H{"error": "Unknown payment method"})
+			return
+		}
+	}
 
-	pi, err := paymentintent.New(params)
+	// Only the remainder after the wallet share is charged to the card
+	pi, err := services.Payments.CreatePaymentIntent(services.CreatePaymentIntentParams{
+		AmountMinor:     cardMinor,
+		Currency:        "usd",
+		CustomerID:      customerID,
+		PaymentMethodID: req.PaymentMethodId,
+		SaveCard:        req.SaveCard,
+		Metadata:        cardCheckoutMetadata(userID, req.StoreId, req.Quantity, quote, pickup, walletMinor),
+	})
 	if err != nil {
 		fmt.Println("Failed to create payment intent", err)
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
+	// Split payment: take the wallet share now so it cannot be spent twice while the card is pending
+	if err := startCardCheckout(pi, userID, req.StoreId, req.Quantity, totalMinor, walletMinor, req.Email); err != nil {
+		if cancelErr := services.Payments.CancelPaymentIntent(pi.ID); cancelErr != nil {
+			log.Printf("WARNING: Failed to cancel payment intent %s: %v", pi.ID, cancelErr)
+		}
+		if err == errInsufficientWalletBalance {
+			c.JSON(400, gin.H{"error": "Insufficient wallet balance"})
+			return
+		}
+		log.Printf("ERROR: Failed to start checkout for payment intent %s: %v", pi.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to start checkout"})
+		return
+	}
+
+	if req.PaymentMethodId == "" {
+		c.JSON(200, gin.H{
+			"clientSecret":    pi.ClientSecret,
+			"paymentIntentId": pi.ID,
+			"totalAmount":     fromMinorUnits(totalMinor),
+			"unitPrice":       quote.UnitPrice,
+			"walletAmount":    fromMinorUnits(walletMinor),
+		})
+		return
+	}
+
+	// One-tap checkout: charge the saved card right away
+	pi, err = confirmSavedCardPayment(userID, pi, walletMinor)
+	if err != nil {
+		log.Printf("Saved card payment %s failed: %v", pi.ID, err)
+		c.JSON(402, gin.H{"error": "Card payment failed"})
+		return
+	}
+
+	if pi.Status != services.PaymentIntentStatusSucceeded {
+		// e.g. 3-D Secure: the client finishes with the secret, then calls /confirm
+		c.JSON(200, gin.H{
+			"clientSecret":    pi.ClientSecret,
+			"paymentIntentId": pi.ID,
+			"requiresAction":  true,
+			"totalAmount":     fromMinorUnits(totalMinor),
+			"unitPrice":       quote.UnitPrice,
+			"walletAmount":    fromMinorUnits(walletMinor),
+		})
+		return
+	}
+
+	reservation, err := finalizeCardReservation(userID, pi)
+	if err != nil {
+		respondCardReservationError(c, err)
+		return
+	}
+
 	c.JSON(200, gin.H{
-		"clientSecret":    pi.ClientSecret,
+		"status":          "success",
 		"paymentIntentId": pi.ID,
+		"reservation":     reservation,
+	})
+}
+
+// splitWalletPayment returns the share of a checkout total left for the card after the
+// wallet share. It reports false if the wallet share is negative or more than the total.
+func splitWalletPayment(totalMinor, walletMinor int64) (int64, bool) {
+	if walletMinor < 0 || walletMinor > totalMinor {
+		return 0, false
+	}
+	return totalMinor - walletMinor, true
+}
+
+// cardCheckoutMetadata is what a card PaymentIntent carries so the reservation can be
+// recorded whenever the payment completes (see cardCheckoutFromIntent)
+func cardCheckoutMetadata(userID, storeID string, quantity int, quote checkoutQuote, pickup reservationPickup, walletMinor int64) map[string]string {
+	metadata := map[string]string{
+		"user_id":             userID,
+		"storeId":             storeID,
+		"quantity":            fmt.Sprintf("%d", quantity),
+		"pickup_time":         pickup.Label,
+		"wallet_amount_minor": strconv.FormatInt(walletMinor, 10),
+		"unit_price_minor":    strconv.FormatInt(toMinorUnits(quote.UnitPrice), 10),
+	}
+	if quote.PricingRuleID != nil {
+		metadata["pricing_rule_id"] = *quote.PricingRuleID
+	}
+	if pickup.WindowID != nil {
+		metadata["pickup_window_id"] = *pickup.WindowID
+	}
+	return metadata
+}
+
+// cardCheckout is a card checkout rebuilt from its PaymentIntent
+type cardCheckout struct {
+	StoreID  string
+	Quantity int
+	// WalletMinor was debited at checkout; TotalMinor adds the card charge to it
+	WalletMinor   int64
+	TotalMinor    int64
+	UnitPrice     *float64
+	PricingRuleID *string
+}
+
+// cardCheckoutFromIntent reads back what cardCheckoutMetadata stored. The unit price and
+// rule are the ones fixed when the PaymentIntent was created.
+func cardCheckoutFromIntent(pi *services.PaymentIntent) cardCheckout {
+	checkout := cardCheckout{
+		StoreID:  pi.Metadata["storeId"],
+		Quantity: parseInt(pi.Metadata["quantity"]),
+	}
+	checkout.WalletMinor, _ = strconv.ParseInt(pi.Metadata["wallet_amount_minor"], 10, 64)
+	checkout.TotalMinor = pi.Amount + checkout.WalletMinor
+	if unitMinor, err := strconv.ParseInt(pi.Metadata["unit_price_minor"], 10, 64); err == nil {
+		price := fromMinorUnits(unitMinor)
+		checkout.UnitPrice = &price
+	}
+	if ruleID := pi.Metadata["pricing_rule_id"]; ruleID != "" {
+		checkout.PricingRuleID = &ruleID
+	}
+	return checkout
+}
+
+// confirmSavedCardPayment charges the saved card on pi. If the charge fails the
+// intent is cancelled and any wallet share taken for it is returned.
+func confirmSavedCardPayment(userID string, pi *services.PaymentIntent, walletMinor int64) (*services.PaymentIntent, error) {
+	confirmed, err := services.Payments.ConfirmPaymentIntent(pi.ID)
+	if err == nil {
+		return confirmed, nil
+	}
+	markCheckoutAttempt(pi.ID, CheckoutStateFailed, nil)
+
+	if cancelErr := services.Payments.CancelPaymentIntent(pi.ID); cancelErr != nil {
+		log.Printf("WARNING: Failed to cancel payment intent %s: %v", pi.ID, cancelErr)
+	}
+	if walletMinor > 0 {
+		if reverseErr := reverseWalletDebit(userID, walletMinor, pi.ID); reverseErr != nil {
+			log.Printf("ERROR: Failed to return wallet share for payment intent %s: %v", pi.ID, reverseErr)
+		}
+	}
+	return pi, err
+}
+
+// startCardCheckout records the checkout attempt for a new PaymentIntent and takes the
+// wallet share of a split payment, keyed by the PaymentIntent, in one transaction
+func startCardCheckout(pi *services.PaymentIntent, userID, storeID string, quantity int, totalMinor, walletMinor int64, email string) error {
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := recordCheckoutAttempt(tx, pi, userID, storeID, quantity, totalMinor, walletMinor, email); err != nil {
+		return fmt.Errorf("failed to record checkout attempt: %v", err)
+	}
+	if walletMinor > 0 {
+		if _, err := applyWalletTransaction(tx, userID, -walletMinor, WalletTxReservationPayment, pi.ID, "Reservation payment (split with card)", userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// reverseWalletDebit returns the wallet share of a split payment whose card part did not go through
+func reverseWalletDebit(userID string, amountMinor int64, paymentIntentID string) error {
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := applyWalletTransaction(tx, userID, amountMinor, WalletTxPaymentReversal, paymentIntentID, "Card payment failed", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// payReservationWithWallet creates a confirmed reservation paid entirely from the wallet
+func payReservationWithWallet(c *gin.Context, userID string, req ReservationRequest, quote checkoutQuote, pickup reservationPickup) {
+	reservationID := uuid.New().String()
+	totalMinor := quote.TotalMinor
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	txn, err := applyWalletTransaction(tx, userID, -totalMinor, WalletTxReservationPayment, reservationID, "Reservation payment", userID)
+	if err != nil {
+		if err == errInsufficientWalletBalance {
+			c.JSON(400, gin.H{"error": "Insufficient wallet balance"})
+			return
+		}
+		log.Printf("ERROR: Failed to debit wallet for user %s: %v", userID, err)
+		c.JSON(500, gin.H{"error": "Failed to debit wallet"})
+		return
+	}
+
+	if err := takeBags(tx, req.StoreId, req.Quantity); err != nil {
+		if err == errNotEnoughBags {
+			c.JSON(409, gin.H{"error": "Not enough bags left"})
+			return
+		}
+		fmt.Printf("WARNING: Failed to update bags_available for store %s: %v\n", req.StoreId, err)
+		c.JSON(500, gin.H{"error": "Failed to update store availability"})
+		return
+	}
+
+	paymentID := "wallet_" + txn.ID
+	_, err = tx.Exec(`
+		INSERT INTO reservations (
+			id, user_id, store_id, quantity, total_amount, status, payment_id, pickup_time,
+			pickup_timestamp, pickup_window_id, wallet_amount,
+			payment_method, payment_status, unit_price, pricing_rule_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+	`, reservationID, userID, req.StoreId, req.Quantity, fromMinorUnits(totalMinor),
+		"confirmed", paymentID, pickup.Label, pickup.Timestamp, pickup.WindowID, fromMinorUnits(totalMinor),
+		PaymentMethodWallet, PaymentStatusPaid, quote.UnitPrice, quote.PricingRuleID)
+	if err != nil {
+		fmt.Printf("Failed to create reservation record: %v\n", err)
+		c.JSON(500, gin.H{"error": "Failed to create reservation record"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"status":         "success",
+		"paidWithWallet": true,
+		"reservation": gin.H{
+			"id":              reservationID,
+			"storeId":         req.StoreId,
+			"userId":          userID,
+			"quantity":        req.Quantity,
+			"totalAmount":     fromMinorUnits(totalMinor),
+			"walletAmount":    fromMinorUnits(totalMinor),
+			"unitPrice":       quote.UnitPrice,
+			"status":          "confirmed",
+			"paymentId":       paymentID,
+			"pickupTime":      pickup.Label,
+			"pickupTimestamp": pickup.Timestamp,
+			"pickupWindowId":  pickup.WindowID,
+			"createdAt":       time.Now(),
+		},
 	})
 }
 
@@ -72,21 +460,120 @@ func ConfirmReservation(c *gin.Context) {
 	}
 
 	// Verify payment status
-	pi, err := paymentintent.Get(req.PaymentIntentId, nil)
+	pi, err := services.Payments.GetPaymentIntent(req.PaymentIntentId)
 	if err != nil {
 		fmt.Println("Failed to verify payment", err)
 		c.JSON(500, gin.H{"error": "Failed to verify payment"})
 		return
 	}
 
-	if pi.Status != stripe.PaymentIntentStatusSucceeded {
+	if pi.Status != services.PaymentIntentStatusSucceeded {
 		fmt.Println("Payment not completed", pi.Status)
 		c.JSON(400, gin.H{"error": "Payment not completed"})
 		return
 	}
 
-	// Create reservation record in database
-	_, err = db.DB.Exec(`
+	userID := c.GetString("user_id")
+	if owner := pi.Metadata["user_id"]; owner != "" && owner != userID {
+		c.JSON(403, gin.H{"error": "Payment belongs to another user"})
+		return
+	}
+
+	reservation, err := finalizeCardReservation(userID, pi)
+	if err != nil {
+		respondCardReservationError(c, err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"status":      "success",
+		"reservation": reservation,
+	})
+}
+
+// respondCardReservationError writes the response for a finalizeCardReservation error
+func respondCardReservationError(c *gin.Context, err error) {
+	if err == errNotEnoughBags {
+		c.JSON(409, gin.H{"error": "Not enough bags left, your payment was refunded"})
+		return
+	}
+	if err == errCheckoutFailed {
+		c.JSON(409, gin.H{"error": "Checkout has already failed"})
+		return
+	}
+	fmt.Printf("Failed to create reservation record: %v\n", err)
+	c.JSON(500, gin.H{"error": "Failed to create reservation record"})
+}
+
+// CardReservation is the reservation created for a succeeded card PaymentIntent
+type CardReservation struct {
+	ID           string  `json:"id"`
+	StoreID      string  `json:"storeId"`
+	UserID       string  `json:"userId"`
+	Quantity     int     `json:"quantity"`
+	TotalAmount  float64 `json:"totalAmount"`
+	WalletAmount float64 `json:"walletAmount"`
+	Status       string  `json:"status"`
+	PaymentID    string  `json:"paymentId"`
+}
+
+// finalizeCardReservation records the reservation for a succeeded PaymentIntent.
+// It is safe to call more than once for the same intent.
+func finalizeCardReservation(userID string, pi *services.PaymentIntent) (*CardReservation, error) {
+	// The wallet share of a split payment was already debited in CreateReservation
+	checkout := cardCheckoutFromIntent(pi)
+	storeID, quantity, walletMinor := checkout.StoreID, checkout.Quantity, checkout.WalletMinor
+	totalAmount := fromMinorUnits(checkout.TotalMinor)
+
+	reservation := &CardReservation{
+		StoreID:      storeID,
+		UserID:       userID,
+		Quantity:     quantity,
+		TotalAmount:  totalAmount,
+		WalletAmount: fromMinorUnits(walletMinor),
+		Status:       "confirmed",
+		PaymentID:    pi.ID,
+	}
+
+	// The pickup window was chosen when the PaymentIntent was created
+	pickup := lookupReservationPickup(storeID, pi.Metadata["pickup_window_id"], pi.Metadata["pickup_time"])
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// A one-tap checkout, /confirm and the expiry job may all finalize the same payment;
+	// they take turns on the checkout attempt
+	var state string
+	err = tx.Get(&state, `SELECT state FROM checkout_attempts WHERE payment_intent_id = $1 FOR UPDATE`, pi.ID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if state == CheckoutStateFailed {
+		return nil, errCheckoutFailed
+	}
+	err = tx.Get(&reservation.ID, `
+		SELECT id FROM reservations WHERE payment_id = $1 AND payment_method = $2
+	`, pi.ID, PaymentMethodCard)
+	if err == nil {
+		markCheckoutAttempt(pi.ID, CheckoutStatePaid, &reservation.ID)
+		return reservation, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if err := takeBags(tx, storeID, quantity); err == errNotEnoughBags {
+		return nil, refundSoldOutCheckout(tx, userID, pi, walletMinor)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to update store availability: %v", err)
+	}
+
+	// idx_reservations_card_payment_id stops a second reservation for the payment when
+	// there is no checkout attempt to take turns on
+	err = tx.QueryRow(`
 		INSERT INTO reservations (
 			user_id, 
 			store_id, 
@@ -94,61 +581,86 @@ func ConfirmReservation(c *gin.Context) {
 			total_amount, 
 			status, 
 			payment_id,
-			pickup_time
-		) VALUES ($1, $2, $3, $4, $5, $6, $7)
+			pickup_time,
+			pickup_timestamp,
+			pickup_window_id,
+			wallet_amount,
+			unit_price,
+			pricing_rule_id,
+			payment_method,
+			payment_status
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (payment_id) WHERE payment_method = 'card' AND payment_id IS NOT NULL DO NOTHING
+		RETURNING id
 	`,
-		c.GetString("user_id"),
-		pi.Metadata["storeId"],
-		parseInt(pi.Metadata["quantity"]),
-		float64(pi.Amount)/100,
+		userID,
+		storeID,
+		quantity,
+		totalAmount,
 		"confirmed",
 		pi.ID,
-		pi.Metadata["pickup_time"],
-	)
-
+		pickup.Label,
+		pickup.Timestamp,
+		pickup.WindowID,
+		fromMinorUnits(walletMinor),
+		checkout.UnitPrice,
+		checkout.PricingRuleID,
+		PaymentMethodCard,
+		PaymentStatusPaid,
+	).Scan(&reservation.ID)
+	if err == sql.ErrNoRows {
+		// Another caller recorded it first; give the bags back by rolling back
+		tx.Rollback()
+		if err := db.DB.Get(&reservation.ID, `
+			SELECT id FROM reservations WHERE payment_id = $1 AND payment_method = $2
+		`, pi.ID, PaymentMethodCard); err != nil {
+			return nil, err
+		}
+		markCheckoutAttempt(pi.ID, CheckoutStatePaid, &reservation.ID)
+		return reservation, nil
+	}
 	if err != nil {
-		fmt.Printf("Failed to create reservation record: %v\n", err)
-		c.JSON(500, gin.H{"error": "Failed to create reservation record"})
-		return
+		return nil, err
 	}
 
-	// Update bags_available count in stores table
-	_, err = db.DB.Exec(`
-		UPDATE stores 
-		SET bags_available = GREATEST(0, bags_available - $1), updated_at = NOW()
-		WHERE id = $2
-	`, parseInt(pi.Metadata["quantity"]), pi.Metadata["storeId"])
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	markCheckoutAttempt(pi.ID, CheckoutStatePaid, &reservation.ID)
 
+	return reservation, nil
+}
+
+// refundSoldOutCheckout gives back a card payment whose bags sold out before it was
+// recorded: the wallet share to the wallet and the rest to the card. It returns
+// errNotEnoughBags once the payment is given back; on any other error the attempt stays
+// open for the expiry job to retry.
+func refundSoldOutCheckout(tx *sqlx.Tx, userID string, pi *services.PaymentIntent, walletMinor int64) error {
+	_, err := tx.Exec(`
+		UPDATE checkout_attempts SET state = $1, updated_at = NOW()
+		WHERE payment_intent_id = $2 AND state = $3
+	`, CheckoutStateFailed, pi.ID, CheckoutStateCreated)
 	if err != nil {
-		fmt.Printf("WARNING: Failed to update bags_available for store %s: %v\n", pi.Metadata["storeId"], err)
-		// Don't fail the payment confirmation if bags_available update fails
-	} else {
-		fmt.Printf("Updated bags_available for store %s: decreased by %d\n", pi.Metadata["storeId"], parseInt(pi.Metadata["quantity"]))
+		return fmt.Errorf("failed to mark checkout attempt failed: %v", err)
 	}
-
-	// Create reservation record
-	reservation := struct {
-		StoreID     string  `json:"storeId"`
-		UserID      string  `json:"userId"`
-		Quantity    int     `json:"quantity"`
-		TotalAmount float64 `json:"totalAmount"`
-		Status      string  `json:"status"`
-		PaymentID   string  `json:"paymentId"`
-	}{
-		StoreID:     pi.Metadata["storeId"],
-		UserID:      c.GetString("userId"),
-		Quantity:    parseInt(pi.Metadata["quantity"]),
-		TotalAmount: float64(pi.Amount) / 100,
-		Status:      "confirmed",
-		PaymentID:   pi.ID,
+	if walletMinor > 0 {
+		if _, err := applyWalletTransaction(tx, userID, walletMinor, WalletTxPaymentReversal, pi.ID, "Bags sold out", "system"); err != nil {
+			return fmt.Errorf("failed to return wallet share: %v", err)
+		}
 	}
-
-	c.JSON(200, gin.H{
-		"status":      "success",
-		"reservation": reservation,
-	})
+	// Refund before committing so a failed refund leaves the attempt open
+	if err := services.Payments.RefundPaymentIntent(pi.ID, "sold-out", pi.Amount); err != nil {
+		return fmt.Errorf("failed to refund sold-out checkout: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Refunded payment intent %s: store %s sold out", pi.ID, pi.Metadata["storeId"])
+	return errNotEnoughBags
 }
 
+// ConfirmPayAtStore creates a pay-at-store reservation priced from the store.
+// The reservation stays unpaid until staff record the payment at pickup.
 func ConfirmPayAtStore(c *gin.Context) {
 	var req PayAtStoreRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -157,36 +669,61 @@ func ConfirmPayAtStore(c *gin.Context) {
 		return
 	}
 
-	// Get the payment intent to retrieve metadata
-	pi, err := paymentintent.Get(req.PaymentIntentId, nil)
+	userID := c.GetString("user_id")
+
+	// Get the currently effective store price for total amount calculation
+	quote, err := quoteCheckout(req.StoreId, req.Quantity, time.Now())
 	if err != nil {
-		fmt.Printf("Failed to retrieve payment intent: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve payment intent"})
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Store not found"})
+			return
+		}
+		if err == errStoreOffboarded || err == errStoreNotApproved {
+			respondPickupError(c, req.StoreId, err)
+			return
+		}
+		fmt.Println("Failed to get store details", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get store details"})
 		return
 	}
 
-	userID := c.GetString("user_id")
-	storeID := pi.Metadata["storeId"]
-	// fmt.Println("storeID", storeID)
-	quantity := parseInt(pi.Metadata["quantity"])
-	pickupTime := pi.Metadata["pickup_time"]
-	// Get store price for total amount calculation
-	var storePrice float64
-	err = db.DB.Get(&storePrice, "SELECT price FROM stores WHERE id = $1", storeID)
+	totalAmount := fromMinorUnits(quote.TotalMinor)
+
+	pickup, err := resolveReservationPickup(req.StoreId, req.PickupWindowId, req.PickupTime, time.Now())
 	if err != nil {
-		fmt.Println("Failed to get store details", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get store details"})
+		respondPickupError(c, req.StoreId, err)
 		return
 	}
 
-	totalAmount := storePrice * float64(quantity)
+	reservationID := uuid.New().String()
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := takeBags(tx, req.StoreId, req.Quantity); err != nil {
+		if err == errNotEnoughBags {
+			c.JSON(http.StatusConflict, gin.H{"error": "Not enough bags left"})
+			return
+		}
+		fmt.Printf("WARNING: Failed to update bags_available for store %s: %v\n", req.StoreId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store availability"})
+		return
+	}
 
 	// Insert the reservation
-	_, err = db.DB.Exec(`
+	_, err = tx.Exec(`
 		INSERT INTO reservations 
-		(user_id, store_id, quantity, total_amount, status, payment_id, pickup_time)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-		userID, storeID, quantity, totalAmount, "pending", "pay_at_store_"+req.PaymentIntentId, pickupTime)
+		(id, user_id, store_id, quantity, total_amount, status, payment_id, pickup_time, pickup_timestamp, pickup_window_id,
+		 customer_name, customer_email, phone_number, payment_method, payment_status, unit_price, pricing_rule_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`,
+		reservationID, userID, req.StoreId, req.Quantity, totalAmount, "confirmed",
+		"pay_at_store_"+reservationID, pickup.Label, pickup.Timestamp, pickup.WindowID,
+		req.Name, req.Email, req.Phone, PaymentMethodPayAtStore, PaymentStatusUnpaid,
+		quote.UnitPrice, quote.PricingRuleID)
 
 	if err != nil {
 		fmt.Printf("Failed to create reservation: %v\n", err)
@@ -194,23 +731,27 @@ func ConfirmPayAtStore(c *gin.Context) {
 		return
 	}
 
-	// Update bags_available count in stores table
-	_, err = db.DB.Exec(`
-		UPDATE stores 
-		SET bags_available = GREATEST(0, bags_available - $1), updated_at = NOW()
-		WHERE id = $2
-	`, quantity, storeID)
-
-	if err != nil {
-		fmt.Printf("WARNING: Failed to update bags_available for store %s: %v\n", storeID, err)
-		// Don't fail the reservation creation if bags_available update fails
-	} else {
-		fmt.Printf("Updated bags_available for store %s: decreased by %d\n", storeID, quantity)
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "success",
 		"message": "Reservation created successfully",
+		"reservation": gin.H{
+			"id":              reservationID,
+			"storeId":         req.StoreId,
+			"quantity":        req.Quantity,
+			"totalAmount":     totalAmount,
+			"unitPrice":       quote.UnitPrice,
+			"status":          "confirmed",
+			"paymentMethod":   PaymentMethodPayAtStore,
+			"paymentStatus":   PaymentStatusUnpaid,
+			"pickupTime":      pickup.Label,
+			"pickupTimestamp": pickup.Timestamp,
+			"pickupWindowId":  pickup.WindowID,
+		},
 	})
 }
 
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"savor-server/db"
+	"savor-server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// lookupPaymentCustomer returns the user's Stripe Customer ID, or "" if they have none yet
+func lookupPaymentCustomer(userID string) (string, error) {
+	var customerID string
+	err := db.DB.Get(&customerID, `SELECT stripe_customer_id FROM payment_customers WHERE user_id = $1`, userID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return customerID, err
+}
+
+// ensurePaymentCustomer returns the user's Stripe Customer ID, creating the customer on first use
+func ensurePaymentCustomer(userID, email string) (string, error) {
+	customerID, err := lookupPaymentCustomer(userID)
+	if err != nil || customerID != "" {
+		return customerID, err
+	}
+
+	customerID, err = services.Payments.CreateCustomer(userID, email)
+	if err != nil {
+		return "", err
+	}
+
+	// Another request may have linked a customer first; keep whichever row won
+	_, err = db.DB.Exec(`
+		INSERT INTO payment_customers (user_id, stripe_customer_id)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO NOTHING
+	`, userID, customerID)
+	if err != nil {
+		return "", err
+	}
+	return lookupPaymentCustomer(userID)
+}
+
+// CreateSetupIntent starts saving a card for the user without charging it
+func CreateSetupIntent(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	// The body is optional
+	_ = c.ShouldBindJSON(&req)
+
+	customerID, err := ensurePaymentCustomer(userID, req.Email)
+	if err != nil {
+		log.Printf("ERROR: Failed to get payment customer for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create customer"})
+		return
+	}
+
+	si, err := services.Payments.CreateSetupIntent(customerID)
+	if err != nil {
+		log.Printf("ERROR: Failed to create setup intent for customer %s: %v", customerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create setup intent"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"clientSecret":  si.ClientSecret,
+		"setupIntentId": si.ID,
+		"customerId":    customerID,
+	})
+}
+
+// GetPaymentMethods lists the user's saved cards
+func GetPaymentMethods(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	customerID, err := lookupPaymentCustomer(userID)
+	if err != nil {
+		log.Printf("ERROR: Failed to get payment customer for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get payment methods"})
+		return
+	}
+	if customerID == "" {
+		c.JSON(http.StatusOK, gin.H{"paymentMethods": []services.SavedPaymentMethod{}})
+		return
+	}
+
+	methods, err := services.Payments.ListPaymentMethods(customerID)
+	if err != nil {
+		log.Printf("ERROR: Failed to list payment methods for customer %s: %v", customerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get payment methods"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"paymentMethods": methods})
+}
+
+// DeletePaymentMethod removes a saved card from the user's customer
+func DeletePaymentMethod(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	paymentMethodID := c.Param("id")
+	owned, err := ownsPaymentMethod(userID, paymentMethodID)
+	if err != nil {
+		log.Printf("ERROR: Failed to verify payment method %s: %v", paymentMethodID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove payment method"})
+		return
+	}
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment method not found"})
+		return
+	}
+
+	if err := services.Payments.DetachPaymentMethod(paymentMethodID); err != nil {
+		log.Printf("ERROR: Failed to detach payment method %s: %v", paymentMethodID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove payment method"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payment method removed"})
+}
+
+// ownsPaymentMethod checks that a saved card is attached to the user's customer
+func ownsPaymentMethod(userID, paymentMethodID string) (bool, error) {
+	customerID, err := lookupPaymentCustomer(userID)
+	if err != nil || customerID == "" {
+		return false, err
+	}
+
+	methods, err := services.Payments.ListPaymentMethods(customerID)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range methods {
+		if m.ID == paymentMethodID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
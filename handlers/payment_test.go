@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"testing"
+
+	"savor-server/services"
+)
+
+func TestSplitWalletPayment(t *testing.T) {
+	tests := []struct {
+		name        string
+		totalMinor  int64
+		walletMinor int64
+		wantCard    int64
+		wantOK      bool
+	}{
+		{name: "card only", totalMinor: 1497, walletMinor: 0, wantCard: 1497, wantOK: true},
+		{name: "wallet and card", totalMinor: 1497, walletMinor: 500, wantCard: 997, wantOK: true},
+		{name: "wallet covers it all", totalMinor: 1497, walletMinor: 1497, wantCard: 0, wantOK: true},
+		{name: "wallet share above the total", totalMinor: 1497, walletMinor: 1498},
+		{name: "negative wallet share", totalMinor: 1497, walletMinor: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			card, ok := splitWalletPayment(tt.totalMinor, tt.walletMinor)
+			if ok != tt.wantOK || card != tt.wantCard {
+				t.Errorf("splitWalletPayment(%d, %d) = %d, %v, want %d, %v",
+					tt.totalMinor, tt.walletMinor, card, ok, tt.wantCard, tt.wantOK)
+			}
+		})
+	}
+}
+
+// TestCardCheckoutRoundTrip checks that a split checkout charges the card only the
+// remainder and that the reservation recorded from the paid intent gets the full total
+func TestCardCheckoutRoundTrip(t *testing.T) {
+	ruleID := "rule-1"
+	windowID := "window-1"
+
+	tests := []struct {
+		name        string
+		quote       checkoutQuote
+		walletMinor int64
+		pickup      reservationPickup
+	}{
+		{
+			name:  "card only",
+			quote: checkoutQuote{UnitPrice: 4.99, TotalMinor: 1497},
+		},
+		{
+			name:        "wallet and card with a pricing rule",
+			quote:       checkoutQuote{UnitPrice: 3.50, TotalMinor: 700, PricingRuleID: &ruleID},
+			walletMinor: 250,
+			pickup:      reservationPickup{WindowID: &windowID, Label: "Pick up Friday 01/03 5:00 PM - 7:00 PM"},
+		},
+		{
+			name:        "one cent left for the card",
+			quote:       checkoutQuote{UnitPrice: 0.99, TotalMinor: 99},
+			walletMinor: 98,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cardMinor, ok := splitWalletPayment(tt.quote.TotalMinor, tt.walletMinor)
+			if !ok {
+				t.Fatalf("splitWalletPayment(%d, %d) refused the split", tt.quote.TotalMinor, tt.walletMinor)
+			}
+			metadata := cardCheckoutMetadata("user-1", "store-1", 2, tt.quote, tt.pickup, tt.walletMinor)
+			if metadata["pickup_time"] != tt.pickup.Label {
+				t.Errorf("pickup_time = %q, want %q", metadata["pickup_time"], tt.pickup.Label)
+			}
+			if tt.pickup.WindowID != nil && metadata["pickup_window_id"] != *tt.pickup.WindowID {
+				t.Errorf("pickup_window_id = %q, want %q", metadata["pickup_window_id"], *tt.pickup.WindowID)
+			}
+
+			checkout := cardCheckoutFromIntent(&services.PaymentIntent{ID: "pi_1", Amount: cardMinor, Metadata: metadata})
+			if checkout.StoreID != "store-1" || checkout.Quantity != 2 {
+				t.Errorf("store, quantity = %q, %d, want store-1, 2", checkout.StoreID, checkout.Quantity)
+			}
+			if checkout.TotalMinor != tt.quote.TotalMinor {
+				t.Errorf("TotalMinor = %d, want %d", checkout.TotalMinor, tt.quote.TotalMinor)
+			}
+			if checkout.WalletMinor != tt.walletMinor {
+				t.Errorf("WalletMinor = %d, want %d", checkout.WalletMinor, tt.walletMinor)
+			}
+			if checkout.UnitPrice == nil || *checkout.UnitPrice != tt.quote.UnitPrice {
+				t.Errorf("UnitPrice = %v, want %v", checkout.UnitPrice, tt.quote.UnitPrice)
+			}
+			if (checkout.PricingRuleID == nil) != (tt.quote.PricingRuleID == nil) ||
+				(checkout.PricingRuleID != nil && *checkout.PricingRuleID != *tt.quote.PricingRuleID) {
+				t.Errorf("PricingRuleID = %v, want %v", checkout.PricingRuleID, tt.quote.PricingRuleID)
+			}
+		})
+	}
+}
+
+func TestCardCheckoutFromIntentWithoutMetadata(t *testing.T) {
+	checkout := cardCheckoutFromIntent(&services.PaymentIntent{ID: "pi_1", Amount: 500, Metadata: map[string]string{}})
+	if checkout.TotalMinor != 500 || checkout.WalletMinor != 0 {
+		t.Errorf("TotalMinor, WalletMinor = %d, %d, want 500, 0", checkout.TotalMinor, checkout.WalletMinor)
+	}
+	if checkout.UnitPrice != nil || checkout.PricingRuleID != nil {
+		t.Errorf("UnitPrice, PricingRuleID = %v, %v, want nil", checkout.UnitPrice, checkout.PricingRuleID)
+	}
+}
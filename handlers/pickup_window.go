@@ -0,0 +1,417 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"savor-server/db"
+	"savor-server/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// pickupWindowHorizonDays is how far ahead windows are generated from the weekly schedule
+const pickupWindowHorizonDays = 14
+
+var (
+	errPickupWindowUnavailable = errors.New("pickup window is not available")
+	errStoreClosed             = errors.New("store is closed today")
+	errStoreOffboarded         = errors.New("store has left the platform")
+	errStoreNotApproved        = errors.New("store is not approved")
+)
+
+// PickupWindow is one dated pickup slot of a store
+type PickupWindow struct {
+	ID       string    `json:"id" db:"id"`
+	StoreID  string    `json:"storeId" db:"store_id"`
+	StartsAt time.Time `json:"startsAt" db:"starts_at"`
+	EndsAt   time.Time `json:"endsAt" db:"ends_at"`
+	Label    string    `json:"label" db:"-"`
+}
+
+// pickupWindowLabel keeps the wording of the old stores.pickup_time strings. The times
+// are formatted in their own location, so convert them to the store's zone first.
+func pickupWindowLabel(startsAt, endsAt time.Time) string {
+	return fmt.Sprintf("Pick up %s %s - %s", startsAt.Format("Monday 02/01"), startsAt.Format("3:04 PM"), endsAt.Format("3:04 PM"))
+}
+
+// localize renders the window in the store's zone
+func (w *PickupWindow) localize(loc *time.Location) {
+	w.StartsAt = w.StartsAt.In(loc)
+	w.EndsAt = w.EndsAt.In(loc)
+	w.Label = pickupWindowLabel(w.StartsAt, w.EndsAt)
+}
+
+// reservationPickupFor binds a reservation to the window, shown in the store's zone
+func reservationPickupFor(window PickupWindow, loc *time.Location) reservationPickup {
+	window.localize(loc)
+	return reservationPickup{
+		WindowID:  &window.ID,
+		Timestamp: window.StartsAt,
+		Label:     window.Label,
+	}
+}
+
+// buildPickupWindows expands the weekly schedule into dated windows for the given
+// number of days starting with from's day. Closures replace the schedule on their
+// dates: no windows on closed days, only the special hours otherwise. Times are local
+// clock times in from's location, so from must be in the store's zone; days that
+// change DST keep their local times.
+func buildPickupWindows(schedules []models.PickupSchedule, closures []StoreClosure, from time.Time, days int) []PickupWindow {
+	var windows []PickupWindow
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	for i := 0; i < days; i++ {
+		date := day.AddDate(0, 0, i)
+
+		if closure := closureOn(closures, date); closure != nil {
+			if closure.Closed || closure.StartTime == nil || closure.EndTime == nil {
+				continue
+			}
+			if w, ok := pickupWindowOn(date, closure.StoreID, *closure.StartTime, *closure.EndTime); ok {
+				windows = append(windows, w)
+			}
+			continue
+		}
+
+		for _, s := range schedules {
+			if !s.Enabled || !isScheduleDay(s.Day, date.Weekday()) {
+				continue
+			}
+			if w, ok := pickupWindowOn(date, s.StoreID, s.StartTime, s.EndTime); ok {
+				windows = append(windows, w)
+			}
+		}
+	}
+
+	sort.Slice(windows, func(i, j int) bool { return windows[i].StartsAt.Before(windows[j].StartsAt) })
+	return windows
+}
+
+// pickupWindowOn places local start and end clock times on a date
+func pickupWindowOn(date time.Time, storeID, startTime, endTime string) (PickupWindow, bool) {
+	startHour, startMinute, err := parseClockTime(startTime)
+	if err != nil {
+		log.Printf("WARNING: Invalid pickup start time %q for store %s: %v", startTime, storeID, err)
+		return PickupWindow{}, false
+	}
+	endHour, endMinute, err := parseClockTime(endTime)
+	if err != nil {
+		log.Printf("WARNING: Invalid pickup end time %q for store %s: %v", endTime, storeID, err)
+		return PickupWindow{}, false
+	}
+
+	startsAt := time.Date(date.Year(), date.Month(), date.Day(), startHour, startMinute, 0, 0, date.Location())
+	endsAt := time.Date(date.Year(), date.Month(), date.Day(), endHour, endMinute, 0, 0, date.Location())
+	// A window such as 10:00 PM - 1:00 AM ends the next day
+	if !endsAt.After(startsAt) {
+		endsAt = endsAt.AddDate(0, 0, 1)
+	}
+	return PickupWindow{StoreID: storeID, StartsAt: startsAt, EndsAt: endsAt}, true
+}
+
+// syncPickupWindows regenerates the store's upcoming windows from its schedule and
+// closures. Windows that no longer apply are removed, or cancelled if a reservation is
+// bound to them, so they can no longer be booked.
+func syncPickupWindows(tx *sqlx.Tx, storeID string, now time.Time) error {
+	var schedules []models.PickupSchedule
+	err := tx.Select(&schedules, `
+		SELECT store_id, day, enabled, start_time::text as start_time, end_time::text as end_time
+		FROM pickup_schedules
+		WHERE store_id = $1 AND enabled = true
+	`, storeID)
+	if err != nil {
+		return fmt.Errorf("failed to load pickup schedule: %v", err)
+	}
+
+	var store struct {
+		Timezone string `db:"timezone"`
+		Closed   bool   `db:"closed"`
+	}
+	err = tx.Get(&store, `SELECT timezone, review_status = $2 as closed FROM stores WHERE id = $1`, storeID, StoreReviewClosed)
+	if err != nil {
+		return fmt.Errorf("failed to load store timezone: %v", err)
+	}
+	// A store that left the platform keeps its schedule for a reopening but has no windows
+	if store.Closed {
+		schedules = nil
+	}
+	localNow := now.In(storeLocation(store.Timezone))
+
+	closures, err := loadStoreClosures(tx, storeID, localNow.Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[[2]int64]bool)
+	for _, w := range buildPickupWindows(schedules, closures, localNow, pickupWindowHorizonDays) {
+		if !w.EndsAt.After(now) {
+			continue
+		}
+		wanted[[2]int64{w.StartsAt.Unix(), w.EndsAt.Unix()}] = true
+
+		_, err := tx.Exec(`
+			INSERT INTO pickup_windows (store_id, starts_at, ends_at)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (store_id, starts_at, ends_at) DO UPDATE SET cancelled = false
+		`, storeID, w.StartsAt, w.EndsAt)
+		if err != nil {
+			return fmt.Errorf("failed to insert pickup window: %v", err)
+		}
+	}
+
+	var existing []PickupWindow
+	err = tx.Select(&existing, `
+		SELECT id, store_id, starts_at, ends_at FROM pickup_windows WHERE store_id = $1 AND ends_at > $2
+	`, storeID, now)
+	if err != nil {
+		return fmt.Errorf("failed to load pickup windows: %v", err)
+	}
+	var stale []string
+	for _, w := range existing {
+		if !wanted[[2]int64{w.StartsAt.Unix(), w.EndsAt.Unix()}] {
+			stale = append(stale, w.ID)
+		}
+	}
+
+	// Windows no longer in the schedule go away unless someone already booked them;
+	// booked ones are cancelled instead
+	if len(stale) > 0 {
+		_, err = tx.Exec(`
+			DELETE FROM pickup_windows w
+			WHERE w.id::text = ANY($1)
+			AND NOT EXISTS (SELECT 1 FROM reservations r WHERE r.pickup_window_id = w.id)
+		`, pq.Array(stale))
+		if err != nil {
+			return fmt.Errorf("failed to remove old pickup windows: %v", err)
+		}
+		_, err = tx.Exec(`UPDATE pickup_windows SET cancelled = true WHERE id::text = ANY($1)`, pq.Array(stale))
+		if err != nil {
+			return fmt.Errorf("failed to cancel old pickup windows: %v", err)
+		}
+	}
+
+	// stores.pickup_timestamp is kept as the next window's start for older clients
+	_, err = tx.Exec(`
+		UPDATE stores SET pickup_timestamp = (
+			SELECT MIN(starts_at) FROM pickup_windows WHERE store_id = $1 AND ends_at > $2 AND NOT cancelled
+		)
+		WHERE id = $1 AND EXISTS (SELECT 1 FROM pickup_windows WHERE store_id = $1 AND ends_at > $2 AND NOT cancelled)
+	`, storeID, now)
+	if err != nil {
+		return fmt.Errorf("failed to update store pickup time: %v", err)
+	}
+	return nil
+}
+
+// RefreshPickupWindows rolls every scheduled store's windows forward so there are
+// always pickupWindowHorizonDays of them
+func RefreshPickupWindows() error {
+	var storeIDs []string
+	err := db.DB.Select(&storeIDs, `
+		SELECT DISTINCT ps.store_id
+		FROM pickup_schedules ps
+		JOIN stores s ON s.id = ps.store_id
+		WHERE ps.enabled = true AND s.review_status <> $1
+	`, StoreReviewClosed)
+	if err != nil {
+		return fmt.Errorf("failed to load scheduled stores: %v", err)
+	}
+
+	now := time.Now()
+	for _, storeID := range storeIDs {
+		if err := refreshStorePickupWindows(storeID, now); err != nil {
+			log.Printf("WARNING: Failed to refresh pickup windows for store %s: %v", storeID, err)
+		}
+	}
+	return nil
+}
+
+func refreshStorePickupWindows(storeID string, now time.Time) error {
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := syncPickupWindows(tx, storeID, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadUpcomingPickupWindows returns the store's windows that have not ended yet, soonest first
+func loadUpcomingPickupWindows(storeID string, now time.Time, limit int) ([]PickupWindow, error) {
+	windows := make([]PickupWindow, 0)
+	err := db.DB.Select(&windows, `
+		SELECT id, store_id, starts_at, ends_at
+		FROM pickup_windows
+		WHERE store_id = $1 AND ends_at > $2 AND NOT cancelled
+		ORDER BY starts_at
+		LIMIT $3
+	`, storeID, now, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	loc := storeLocationByID(storeID)
+	for i := range windows {
+		windows[i].localize(loc)
+	}
+	return windows, nil
+}
+
+// GetStorePickupWindows lists the next pickup windows a customer can book
+func GetStorePickupWindows(c *gin.Context) {
+	storeID := c.Param("id")
+
+	limit := 10
+	if v := c.Query("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 || parsed > 50 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 50"})
+			return
+		}
+		limit = parsed
+	}
+
+	windows, err := loadUpcomingPickupWindows(storeID, time.Now(), limit)
+	if err != nil {
+		log.Printf("ERROR: Failed to load pickup windows for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get pickup windows"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"windows":  windows,
+		"timezone": storeLocationByID(storeID).String(),
+	})
+}
+
+// reservationPickup is what a reservation records about when it is picked up
+type reservationPickup struct {
+	WindowID  *string
+	Timestamp time.Time
+	Label     string
+}
+
+// resolveReservationPickup binds a new reservation to a pickup window: the requested
+// one, or the store's next window if none was requested. Stores without a schedule
+// keep the old behaviour of stores.pickup_timestamp and the client's pickup time text.
+// It returns errPickupWindowUnavailable if the requested window cannot be booked or a
+// scheduled store has none left, errStoreClosed if an unscheduled store is closed today
+// and errStoreOffboarded if the store has left the platform (its windows are cancelled).
+func resolveReservationPickup(storeID, windowID, fallbackLabel string, now time.Time) (reservationPickup, error) {
+	var window PickupWindow
+	var err error
+	if windowID != "" {
+		err = db.DB.Get(&window, `
+			SELECT id, store_id, starts_at, ends_at
+			FROM pickup_windows
+			WHERE id::text = $1 AND store_id = $2 AND ends_at > $3 AND NOT cancelled
+		`, windowID, storeID, now)
+		if err == sql.ErrNoRows {
+			return reservationPickup{}, errPickupWindowUnavailable
+		}
+	} else {
+		err = db.DB.Get(&window, `
+			SELECT id, store_id, starts_at, ends_at
+			FROM pickup_windows
+			WHERE store_id = $1 AND ends_at > $2 AND NOT cancelled
+			ORDER BY starts_at
+			LIMIT 1
+		`, storeID, now)
+	}
+
+	if err == nil {
+		return reservationPickupFor(window, storeLocationByID(storeID)), nil
+	}
+	if err != sql.ErrNoRows {
+		return reservationPickup{}, err
+	}
+
+	var store struct {
+		PickupTimestamp time.Time `db:"pickup_timestamp"`
+		Timezone        string    `db:"timezone"`
+		Scheduled       bool      `db:"scheduled"`
+		ClosedToday     bool      `db:"closed_today"`
+		Offboarded      bool      `db:"offboarded"`
+	}
+	err = db.DB.Get(&store, `
+		SELECT s.pickup_timestamp, s.timezone,
+			EXISTS (SELECT 1 FROM pickup_schedules ps WHERE ps.store_id = s.id AND ps.enabled = true) as scheduled,
+			`+storeClosedTodaySQL+` as closed_today,
+			s.review_status = $2 as offboarded
+		FROM stores s
+		WHERE s.id = $1
+	`, storeID, StoreReviewClosed)
+	if err != nil {
+		log.Printf("WARNING: Failed to get store pickup timestamp for store %s: %v", storeID, err)
+		store.PickupTimestamp = now.Add(2 * time.Hour)
+	}
+	if store.Offboarded {
+		return reservationPickup{}, errStoreOffboarded
+	}
+	// A scheduled store without a window left, e.g. closed for the coming days, cannot be booked
+	if store.Scheduled {
+		return reservationPickup{}, errPickupWindowUnavailable
+	}
+	if store.ClosedToday {
+		return reservationPickup{}, errStoreClosed
+	}
+	return reservationPickup{
+		Timestamp: store.PickupTimestamp.In(storeLocation(store.Timezone)),
+		Label:     fallbackLabel,
+	}, nil
+}
+
+// lookupReservationPickup rebuilds the pickup chosen at checkout for a payment that
+// completes later; the window may have started by then.
+func lookupReservationPickup(storeID, windowID, fallbackLabel string) reservationPickup {
+	if windowID != "" {
+		var window PickupWindow
+		err := db.DB.Get(&window, `
+			SELECT id, store_id, starts_at, ends_at FROM pickup_windows WHERE id::text = $1 AND store_id = $2
+		`, windowID, storeID)
+		if err == nil {
+			return reservationPickupFor(window, storeLocationByID(storeID))
+		}
+		log.Printf("WARNING: Pickup window %s of store %s not found: %v", windowID, storeID, err)
+	}
+
+	pickup, err := resolveReservationPickup(storeID, "", fallbackLabel, time.Now())
+	if err != nil {
+		log.Printf("WARNING: Failed to resolve pickup for store %s: %v", storeID, err)
+		pickup = reservationPickup{Label: fallbackLabel, Timestamp: time.Now().Add(2 * time.Hour)}
+	}
+	return pickup
+}
+
+// respondPickupError writes the response for a resolveReservationPickup error
+func respondPickupError(c *gin.Context, storeID string, err error) {
+	if err == errPickupWindowUnavailable {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Pickup window is not available"})
+		return
+	}
+	if err == errStoreClosed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Store is closed today"})
+		return
+	}
+	if err == errStoreOffboarded {
+		c.JSON(http.StatusGone, gin.H{"error": "Store is no longer on Savor"})
+		return
+	}
+	if err == errStoreNotApproved {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Store is not taking reservations"})
+		return
+	}
+	log.Printf("ERROR: Failed to resolve pickup window for store %s: %v", storeID, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get pickup window"})
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"savor-server/models"
+)
+
+func TestBuildPickupWindows(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule := func(day, start, end string) models.PickupSchedule {
+		return models.PickupSchedule{StoreID: "s1", Day: day, Enabled: true, StartTime: start, EndTime: end}
+	}
+	everyDay := func(start, end string) []models.PickupSchedule {
+		var schedules []models.PickupSchedule
+		for _, day := range []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"} {
+			schedules = append(schedules, schedule(day, start, end))
+		}
+		return schedules
+	}
+	clock := func(value string) *string { return &value }
+	at := func(value string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", value, newYork)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	type window struct {
+		starts string
+		hours  float64
+	}
+	tests := []struct {
+		name      string
+		schedules []models.PickupSchedule
+		closures  []StoreClosure
+		from      string
+		days      int
+		want      []window
+	}{
+		{
+			name:      "weekly schedule only on its days",
+			schedules: []models.PickupSchedule{schedule("Friday", "17:00", "19:00"), schedule("sat", "10:00", "11:30")},
+			from:      "2024-02-28 09:00",
+			days:      7,
+			want:      []window{{"2024-03-01 17:00", 2}, {"2024-03-02 10:00", 1.5}},
+		},
+		{
+			name:      "disabled days and bad times are skipped",
+			schedules: []models.PickupSchedule{{StoreID: "s1", Day: "Friday", StartTime: "17:00", EndTime: "19:00"}, schedule("Saturday", "late", "19:00")},
+			from:      "2024-03-01 09:00",
+			days:      2,
+		},
+		{
+			name:      "window past midnight ends the next day",
+			schedules: []models.PickupSchedule{schedule("Friday", "10:00 PM", "1:00 AM")},
+			from:      "2024-03-01 09:00",
+			days:      1,
+			want:      []window{{"2024-03-01 22:00", 3}},
+		},
+		{
+			name:      "spring forward keeps local times",
+			schedules: everyDay("17:00", "19:00"),
+			from:      "2024-03-09 23:00",
+			days:      3,
+			want:      []window{{"2024-03-09 17:00", 2}, {"2024-03-10 17:00", 2}, {"2024-03-11 17:00", 2}},
+		},
+		{
+			name:      "window across the spring forward gap is an hour shorter",
+			schedules: []models.PickupSchedule{schedule("Sunday", "01:00", "04:00")},
+			from:      "2024-03-10 00:00",
+			days:      1,
+			want:      []window{{"2024-03-10 01:00", 2}},
+		},
+		{
+			name:      "window across the fall back repeat is an hour longer",
+			schedules: []models.PickupSchedule{schedule("Sunday", "00:30", "02:30")},
+			from:      "2024-11-03 00:00",
+			days:      1,
+			want:      []window{{"2024-11-03 00:30", 3}},
+		},
+		{
+			name:      "closed days have no windows",
+			schedules: everyDay("17:00", "19:00"),
+			closures:  []StoreClosure{{StoreID: "s1", StartDate: "2024-03-02", EndDate: "2024-03-03", Closed: true}},
+			from:      "2024-03-01 09:00",
+			days:      4,
+			want:      []window{{"2024-03-01 17:00", 2}, {"2024-03-04 17:00", 2}},
+		},
+		{
+			name:      "special hours replace the schedule",
+			schedules: everyDay("17:00", "19:00"),
+			closures:  []StoreClosure{{StoreID: "s1", StartDate: "2024-03-02", EndDate: "2024-03-02", StartTime: clock("12:00"), EndTime: clock("13:00")}},
+			from:      "2024-03-01 09:00",
+			days:      3,
+			want:      []window{{"2024-03-01 17:00", 2}, {"2024-03-02 12:00", 1}, {"2024-03-03 17:00", 2}},
+		},
+		{
+			name:      "a closed day wins over special hours",
+			schedules: everyDay("17:00", "19:00"),
+			closures: []StoreClosure{
+				{StoreID: "s1", StartDate: "2024-03-02", EndDate: "2024-03-02", StartTime: clock("12:00"), EndTime: clock("13:00")},
+				{StoreID: "s1", StartDate: "2024-03-01", EndDate: "2024-03-02", Closed: true},
+			},
+			from: "2024-03-01 09:00",
+			days: 3,
+			want: []window{{"2024-03-03 17:00", 2}},
+		},
+		{
+			name:      "closure on the DST change day",
+			schedules: everyDay("17:00", "19:00"),
+			closures:  []StoreClosure{{StoreID: "s1", StartDate: "2024-03-10", EndDate: "2024-03-10", Closed: true}},
+			from:      "2024-03-09 09:00",
+			days:      3,
+			want:      []window{{"2024-03-09 17:00", 2}, {"2024-03-11 17:00", 2}},
+		},
+		{
+			name: "windows are sorted by start",
+			schedules: []models.PickupSchedule{
+				schedule("Friday", "18:00", "19:00"),
+				schedule("Friday", "08:00", "09:00"),
+			},
+			from: "2024-03-01 00:00",
+			days: 1,
+			want: []window{{"2024-03-01 08:00", 1}, {"2024-03-01 18:00", 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildPickupWindows(tt.schedules, tt.closures, at(tt.from), tt.days)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d windows %v, want %d", len(got), got, len(tt.want))
+			}
+			for i, w := range tt.want {
+				if !got[i].StartsAt.Equal(at(w.starts)) {
+					t.Errorf("window %d starts %v, want %s", i, got[i].StartsAt, w.starts)
+				}
+				if hours := got[i].EndsAt.Sub(got[i].StartsAt).Hours(); hours != w.hours {
+					t.Errorf("window %d lasts %vh, want %vh", i, hours, w.hours)
+				}
+				if got[i].StartsAt.Location() != newYork {
+					t.Errorf("window %d is in %v, want the store's zone", i, got[i].StartsAt.Location())
+				}
+			}
+		})
+	}
+}
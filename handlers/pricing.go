@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"savor-server/db"
+	"savor-server/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// PricingRule lowers a store's bag price as its pickup window approaches closing
+type PricingRule struct {
+	ID                       string  `json:"id" db:"id"`
+	StoreID                  string  `json:"-" db:"store_id"`
+	StartsMinutesBeforeClose int     `json:"startsMinutesBeforeClose" db:"starts_minutes_before_close"`
+	PercentOfPrice           float64 `json:"percentOfPrice" db:"percent_of_price"`
+	FloorPrice               float64 `json:"floorPrice" db:"floor_price"`
+	Enabled                  bool    `json:"enabled" db:"enabled"`
+}
+
+type PricingRuleInput struct {
+	StartsMinutesBeforeClose int     `json:"startsMinutesBeforeClose"`
+	PercentOfPrice           float64 `json:"percentOfPrice"`
+	FloorPrice               float64 `json:"floorPrice"`
+	Enabled                  *bool   `json:"enabled"`
+}
+
+// storePricing holds what is needed to evaluate a store's rules at a given time
+type storePricing struct {
+	rules   []PricingRule
+	closeAt *time.Time
+}
+
+// effectivePrice applies the cheapest active rule to basePrice. A rule is active
+// from StartsMinutesBeforeClose before the pickup window closes until it closes.
+func (p *storePricing) effectivePrice(basePrice float64, now time.Time) (float64, *PricingRule) {
+	if p == nil || p.closeAt == nil || basePrice <= 0 || !now.Before(*p.closeAt) {
+		return basePrice, nil
+	}
+
+	best := basePrice
+	var applied *PricingRule
+	for i := range p.rules {
+		rule := p.rules[i]
+		if !rule.Enabled {
+			continue
+		}
+		startsAt := p.closeAt.Add(-time.Duration(rule.StartsMinutesBeforeClose) * time.Minute)
+		if now.Before(startsAt) {
+			continue
+		}
+
+		price := fromMinorUnits(toMinorUnits(basePrice * rule.PercentOfPrice / 100))
+		if price < rule.FloorPrice {
+			price = rule.FloorPrice
+		}
+		if price < best {
+			best = price
+			applied = &p.rules[i]
+		}
+	}
+	return best, applied
+}
+
+// loadStorePricing loads enabled rules and the close time of today's pickup window for the given stores
+func loadStorePricing(storeIDs []string, now time.Time) (map[string]*storePricing, error) {
+	result := make(map[string]*storePricing)
+	if len(storeIDs) == 0 {
+		return result, nil
+	}
+
+	var rules []PricingRule
+	err := db.DB.Select(&rules, `
+		SELECT id, store_id, starts_minutes_before_close, percent_of_price, floor_price, enabled
+		FROM store_pricing_rules
+		WHERE store_id = ANY($1) AND enabled = true
+	`, pq.Array(storeIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pricing rules: %v", err)
+	}
+	if len(rules) == 0 {
+		return result, nil
+	}
+
+	for _, rule := range rules {
+		if result[rule.StoreID] == nil {
+			result[rule.StoreID] = &storePricing{}
+		}
+		result[rule.StoreID].rules = append(result[rule.StoreID].rules, rule)
+	}
+
+	ruleStoreIDs := make([]string, 0, len(result))
+	for id := range result {
+		ruleStoreIDs = append(ruleStoreIDs, id)
+	}
+
+	closeTimes, err := loadPickupCloseTimes(ruleStoreIDs, now)
+	if err != nil {
+		return nil, err
+	}
+	for id, closeAt := range closeTimes {
+		closeAt := closeAt
+		result[id].closeAt = &closeAt
+	}
+
+	return result, nil
+}
+
+// loadPickupCloseTimes returns, per store, when the current or next pickup window
+// of the store's local day ends
+func loadPickupCloseTimes(storeIDs []string, now time.Time) (map[string]time.Time, error) {
+	var windows []struct {
+		StoreID  string    `db:"store_id"`
+		StartsAt time.Time `db:"starts_at"`
+		EndsAt   time.Time `db:"ends_at"`
+		Timezone string    `db:"timezone"`
+	}
+	// Windows are at most a day away from "today" in any zone
+	err := db.DB.Select(&windows, `
+		SELECT w.store_id, w.starts_at, w.ends_at, s.timezone
+		FROM pickup_windows w
+		JOIN stores s ON s.id = w.store_id
+		WHERE w.store_id = ANY($1) AND w.ends_at > $2 AND w.starts_at < $3 AND NOT w.cancelled
+		ORDER BY w.ends_at
+	`, pq.Array(storeIDs), now, now.Add(48*time.Hour))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pickup windows: %v", err)
+	}
+
+	closeTimes := make(map[string]time.Time)
+	for _, w := range windows {
+		if _, ok := closeTimes[w.StoreID]; ok {
+			continue
+		}
+		loc := storeLocation(w.Timezone)
+		if w.StartsAt.Before(startOfDay(now, loc).AddDate(0, 0, 1)) {
+			closeTimes[w.StoreID] = w.EndsAt.In(loc)
+		}
+	}
+	return closeTimes, nil
+}
+
+// applyDynamicPricing replaces each store's price with the currently effective price
+func applyDynamicPricing(stores []models.Store, now time.Time) {
+	storeIDs := make([]string, 0, len(stores))
+	for _, s := range stores {
+		storeIDs = append(storeIDs, s.ID)
+	}
+
+	pricing, err := loadStorePricing(storeIDs, now)
+	if err != nil {
+		// Fall back to regular prices rather than failing the listing
+		log.Printf("WARNING: Failed to load dynamic pricing: %v", err)
+		return
+	}
+
+	for i := range stores {
+		applyStorePricing(&stores[i], pricing[stores[i].ID], now)
+	}
+}
+
+// applySingleStorePricing is applyDynamicPricing for a store detail page
+func applySingleStorePricing(store *models.Store, now time.Time) {
+	pricing, err := loadStorePricing([]string{store.ID}, now)
+	if err != nil {
+		log.Printf("WARNING: Failed to load dynamic pricing for store %s: %v", store.ID, err)
+		pricing = nil
+	}
+	applyStorePricing(store, pricing[store.ID], now)
+}
+
+func applyStorePricing(store *models.Store, pricing *storePricing, now time.Time) {
+	basePrice := storeBasePrice(*store)
+	store.RegularPrice = sql.NullFloat64{Float64: basePrice, Valid: true}
+
+	price, rule := pricing.effectivePrice(basePrice, now)
+	if rule == nil {
+		return
+	}
+	store.Price = sql.NullFloat64{Float64: price, Valid: true}
+	store.DiscountedPrice = sql.NullFloat64{Float64: price, Valid: true}
+	store.AppliedPricingRuleID = rule.ID
+}
+
+// storeBasePrice is the regular per-bag price before any dynamic discount
+func storeBasePrice(store models.Store) float64 {
+	if store.DiscountedPrice.Valid && store.DiscountedPrice.Float64 > 0 {
+		return store.DiscountedPrice.Float64
+	}
+	if store.Price.Valid {
+		return store.Price.Float64
+	}
+	return 0
+}
+
+// currentUnitPrice returns the per-bag price to charge right now and the rule that produced it
+func currentUnitPrice(storeID string, now time.Time) (float64, *PricingRule, error) {
+	basePrice, err := lookupStoreUnitPrice(storeID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	pricing, err := loadStorePricing([]string{storeID}, now)
+	if err != nil {
+		log.Printf("WARNING: Failed to load dynamic pricing for store %s: %v", storeID, err)
+		return basePrice, nil, nil
+	}
+
+	price, rule := pricing[storeID].effectivePrice(basePrice, now)
+	return price, rule, nil
+}
+
+// checkoutQuote is the server-side price of a reservation at the moment of checkout
+type checkoutQuote struct {
+	UnitPrice     float64
+	TotalMinor    int64
+	PricingRuleID *string
+}
+
+func quoteCheckout(storeID string, quantity int, now time.Time) (checkoutQuote, error) {
+	unitPrice, rule, err := currentUnitPrice(storeID, now)
+	if err != nil {
+		return checkoutQuote{}, err
+	}
+
+	quote := checkoutQuote{
+		UnitPrice:  unitPrice,
+		TotalMinor: toMinorUnits(unitPrice * float64(quantity)),
+	}
+	if rule != nil {
+		quote.PricingRuleID = &rule.ID
+	}
+	return quote, nil
+}
+
+func loadPricingRules(storeID string) ([]PricingRule, error) {
+	rules := make([]PricingRule, 0)
+	err := db.DB.Select(&rules, `
+		SELECT id, store_id, starts_minutes_before_close, percent_of_price, floor_price, enabled
+		FROM store_pricing_rules
+		WHERE store_id = $1
+		ORDER BY starts_minutes_before_close DESC
+	`, storeID)
+	return rules, err
+}
+
+// replacePricingRules swaps a store's rules for the given set
+func replacePricingRules(tx *sqlx.Tx, storeID string, rules []PricingRuleInput) error {
+	if _, err := tx.Exec(`DELETE FROM store_pricing_rules WHERE store_id = $1`, storeID); err != nil {
+		return err
+	}
+
+	for _, r := range rules {
+		enabled := true
+		if r.Enabled != nil {
+			enabled = *r.Enabled
+		}
+		_, err := tx.Exec(`
+			INSERT INTO store_pricing_rules (store_id, starts_minutes_before_close, percent_of_price, floor_price, enabled)
+			VALUES ($1, $2, $3, $4, $5)
+		`, storeID, r.StartsMinutesBeforeClose, r.PercentOfPrice, r.FloorPrice, enabled)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validatePricingRules(rules []PricingRuleInput) error {
+	for i, r := range rules {
+		if r.StartsMinutesBeforeClose <= 0 || r.StartsMinutesBeforeClose > 1440 {
+			return fmt.Errorf("pricing rule %d: startsMinutesBeforeClose must be between 1 and 1440", i+1)
+		}
+		if r.PercentOfPrice <= 0 || r.PercentOfPrice > 100 {
+			return fmt.Errorf("pricing rule %d: percentOfPrice must be between 0 and 100", i+1)
+		}
+		if r.FloorPrice < 0 {
+			return fmt.Errorf("pricing rule %d: floorPrice cannot be negative", i+1)
+		}
+	}
+	return nil
+}
+
+// isScheduleDay matches pickup_schedules.day values such as "Monday", "monday" or "Mon"
+func isScheduleDay(day string, weekday time.Weekday) bool {
+	day = strings.ToLower(strings.TrimSpace(day))
+	if len(day) < 3 {
+		return false
+	}
+	return strings.HasPrefix(strings.ToLower(weekday.String()), day[:3])
+}
+
+// parseClockTime accepts "17:00", "17:00:00", "5:00 PM" and "5 PM"
+func parseClockTime(value string) (int, int, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	for _, layout := range []string{"15:04", "15:04:05", "3:04 PM", "3:04PM", "3 PM", "3PM"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Hour(), t.Minute(), nil
+		}
+	}
+
+	if hour, err := strconv.Atoi(value); err == nil && hour >= 0 && hour < 24 {
+		return hour, 0, nil
+	}
+	return 0, 0, fmt.Errorf("unrecognized time %q", value)
+}
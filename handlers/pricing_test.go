@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEffectivePrice(t *testing.T) {
+	closeAt := time.Date(2024, 3, 1, 20, 0, 0, 0, time.UTC)
+	rule := func(id string, minutes int, percent, floor float64) PricingRule {
+		return PricingRule{ID: id, StartsMinutesBeforeClose: minutes, PercentOfPrice: percent, FloorPrice: floor, Enabled: true}
+	}
+	disabled := rule("disabled", 120, 10, 0)
+	disabled.Enabled = false
+
+	tests := []struct {
+		name      string
+		pricing   *storePricing
+		basePrice float64
+		now       time.Time
+		wantPrice float64
+		wantRule  string
+	}{
+		{
+			name:      "no pricing",
+			basePrice: 10,
+			now:       closeAt.Add(-time.Minute),
+			wantPrice: 10,
+		},
+		{
+			name:      "no pickup window today",
+			pricing:   &storePricing{rules: []PricingRule{rule("r1", 60, 50, 0)}},
+			basePrice: 10,
+			now:       closeAt.Add(-time.Minute),
+			wantPrice: 10,
+		},
+		{
+			name:      "before the rule starts",
+			pricing:   &storePricing{rules: []PricingRule{rule("r1", 60, 50, 0)}, closeAt: &closeAt},
+			basePrice: 10,
+			now:       closeAt.Add(-61 * time.Minute),
+			wantPrice: 10,
+		},
+		{
+			name:      "rule starts exactly on time",
+			pricing:   &storePricing{rules: []PricingRule{rule("r1", 60, 50, 0)}, closeAt: &closeAt},
+			basePrice: 10,
+			now:       closeAt.Add(-60 * time.Minute),
+			wantPrice: 5,
+			wantRule:  "r1",
+		},
+		{
+			name:      "window has closed",
+			pricing:   &storePricing{rules: []PricingRule{rule("r1", 60, 50, 0)}, closeAt: &closeAt},
+			basePrice: 10,
+			now:       closeAt,
+			wantPrice: 10,
+		},
+		{
+			name:      "floor price holds the discount up",
+			pricing:   &storePricing{rules: []PricingRule{rule("r1", 60, 20, 4)}, closeAt: &closeAt},
+			basePrice: 10,
+			now:       closeAt.Add(-30 * time.Minute),
+			wantPrice: 4,
+			wantRule:  "r1",
+		},
+		{
+			name:      "floor above the base price never raises it",
+			pricing:   &storePricing{rules: []PricingRule{rule("r1", 60, 90, 12)}, closeAt: &closeAt},
+			basePrice: 10,
+			now:       closeAt.Add(-30 * time.Minute),
+			wantPrice: 10,
+		},
+		{
+			name: "overlapping rules apply the cheapest",
+			pricing: &storePricing{rules: []PricingRule{
+				rule("early", 120, 80, 0),
+				rule("late", 30, 50, 0),
+			}, closeAt: &closeAt},
+			basePrice: 10,
+			now:       closeAt.Add(-15 * time.Minute),
+			wantPrice: 5,
+			wantRule:  "late",
+		},
+		{
+			name: "overlapping rules compare after their floors",
+			pricing: &storePricing{rules: []PricingRule{
+				rule("early", 120, 70, 0),
+				rule("late", 30, 40, 8),
+			}, closeAt: &closeAt},
+			basePrice: 10,
+			now:       closeAt.Add(-15 * time.Minute),
+			wantPrice: 7,
+			wantRule:  "early",
+		},
+		{
+			name: "only rules that have started count",
+			pricing: &storePricing{rules: []PricingRule{
+				rule("early", 120, 80, 0),
+				rule("late", 30, 50, 0),
+			}, closeAt: &closeAt},
+			basePrice: 10,
+			now:       closeAt.Add(-60 * time.Minute),
+			wantPrice: 8,
+			wantRule:  "early",
+		},
+		{
+			name:      "disabled rules are ignored",
+			pricing:   &storePricing{rules: []PricingRule{disabled}, closeAt: &closeAt},
+			basePrice: 10,
+			now:       closeAt.Add(-time.Minute),
+			wantPrice: 10,
+		},
+		{
+			name:      "discounts round to the cent",
+			pricing:   &storePricing{rules: []PricingRule{rule("r1", 60, 33, 0)}, closeAt: &closeAt},
+			basePrice: 4.99,
+			now:       closeAt.Add(-time.Minute),
+			wantPrice: 1.65,
+			wantRule:  "r1",
+		},
+		{
+			name:      "stores without a price stay unpriced",
+			pricing:   &storePricing{rules: []PricingRule{rule("r1", 60, 50, 2)}, closeAt: &closeAt},
+			basePrice: 0,
+			now:       closeAt.Add(-time.Minute),
+			wantPrice: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			price, applied := tt.pricing.effectivePrice(tt.basePrice, tt.now)
+			if price != tt.wantPrice {
+				t.Errorf("price = %v, want %v", price, tt.wantPrice)
+			}
+			gotRule := ""
+			if applied != nil {
+				gotRule = applied.ID
+			}
+			if gotRule != tt.wantRule {
+				t.Errorf("rule = %q, want %q", gotRule, tt.wantRule)
+			}
+		})
+	}
+}
@@ -36,12 +36,16 @@ type ReservationResponse struct {
 	PaymentID       string     `db:"payment_id" json:"paymentId"`
 	PickupTime      *string    `db:"pickup_time" json:"pickupTime,omitempty"`
 	PickupTimestamp *time.Time `db:"pickup_timestamp" json:"pickupTimestamp,omitempty"`
+	PickupWindowID  *string    `db:"pickup_window_id" json:"pickupWindowId,omitempty"`
+	StoreTimezone   string     `db:"store_timezone" json:"storeTimezone,omitempty"`
 	CreatedAt       time.Time  `db:"created_at" json:"createdAt"`
 	OriginalPrice   float64    `db:"original_price" json:"originalPrice"`
 	DiscountedPrice float64    `db:"discounted_price" json:"discountedPrice"`
 	CustomerName    string     `db:"customer_name" json:"customerName,omitempty"`
 	CustomerEmail   string     `db:"customer_email" json:"customerEmail,omitempty"`
 	PhoneNumber     string     `db:"phone_number" json:"phoneNumber,omitempty"`
+	PaymentMethod   string     `db:"payment_method" json:"paymentMethod"`
+	PaymentStatus   string     `db:"payment_status" json:"paymentStatus"`
 }
 
 func GetUserReservations(c *gin.Context) {
@@ -91,12 +95,16 @@ func GetUserReservations(c *gin.Context) {
 			r.payment_id,
 			r.pickup_time,
 			r.pickup_timestamp,
+			r.pickup_window_id,
+			s.timezone as store_timezone,
 			r.created_at,
 			s.original_price,
 			s.discounted_price,
 			COALESCE(r.customer_name, u.email, 'Guest User') as customer_name,
 			COALESCE(r.customer_email, u.email, '') as customer_email,
-			COALESCE(r.phone_number, '') as phone_number
+			COALESCE(r.phone_number, '') as phone_number,
+			r.payment_method,
+			r.payment_status
 		FROM reservations r
 		JOIN stores s ON r.store_id = s.id
 		LEFT JOIN users u ON r.user_id = u.id::text
@@ -118,6 +126,14 @@ func GetUserReservations(c *gin.Context) {
 	twentyFourHoursAgo := now.Add(-24 * time.Hour)
 
 	for _, reservation := range allReservations {
+		// Show times in the store's zone
+		loc := storeLocation(reservation.StoreTimezone)
+		reservation.CreatedAt = reservation.CreatedAt.In(loc)
+		if reservation.PickupTimestamp != nil {
+			pickup := reservation.PickupTimestamp.In(loc)
+			reservation.PickupTimestamp = &pickup
+		}
+
 		if reservation.CreatedAt.After(twentyFourHoursAgo) {
 			// Created within last 24 hours - current reservation
 			currentReservations = append(currentReservations, reservation)
@@ -214,6 +230,7 @@ type GuestReservationRequest struct {
 	OriginalPrice   float64 `json:"originalPrice"`
 	DiscountedPrice float64 `json:"discountedPrice"`
 	PickupTime      string  `json:"pickupTime"`
+	PickupWindowID  string  `json:"pickupWindowId"`
 	Name            string  `json:"name"`
 	Email           string  `json:"email,omitempty"`
 	Phone           string  `json:"phone,omitempty"`
@@ -249,6 +266,31 @@ func CreateAuthenticatedReservation(c *gin.Context) {
 	log.Printf("Creating authenticated reservation for user %s: %v", userID, req)
 	fmt.Printf("Creating authenticated reservation for user %s: %v", userID, req)
 
+	// Pay-at-store reservations are priced by the server at the currently effective price, never by the client
+	quote, err := quoteCheckout(req.StoreID, req.Quantity, time.Now())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Store not found"})
+			return
+		}
+		if err == errStoreOffboarded || err == errStoreNotApproved {
+			respondPickupError(c, req.StoreID, err)
+			return
+		}
+		log.Printf("ERROR: Failed to get store price for store %s: %v", req.StoreID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get store details"})
+		return
+	}
+	req.TotalAmount = fromMinorUnits(quote.TotalMinor)
+
+	// Bind the reservation to a pickup window; its label replaces the client's pickup time text
+	pickup, err := resolveReservationPickup(req.StoreID, req.PickupWindowID, req.PickupTime, time.Now())
+	if err != nil {
+		respondPickupError(c, req.StoreID, err)
+		return
+	}
+	req.PickupTime = pickup.Label
+
 	// Create a new reservation (use UUID for DB uuid type)
 	reservation := ReservationResponse{
 		ID:              uuid.New().String(),
@@ -265,15 +307,19 @@ func CreateAuthenticatedReservation(c *gin.Context) {
 		Status:          "confirmed",
 		PaymentID:       fmt.Sprintf("pay-%d", time.Now().Unix()),
 		PickupTime:      &req.PickupTime,
+		PickupTimestamp: &pickup.Timestamp,
+		PickupWindowID:  pickup.WindowID,
 		CreatedAt:       time.Now(),
 		CustomerName:    req.Name,
 		CustomerEmail:   req.Email,
 		PhoneNumber:     req.Phone,
+		PaymentMethod:   PaymentMethodPayAtStore,
+		PaymentStatus:   PaymentStatusUnpaid,
 	}
 
 	// Check if reservations table exists
 	var tableExists bool
-	err := db.DB.Get(&tableExists, `
+	err = db.DB.Get(&tableExists, `
 		SELECT EXISTS (
 			SELECT FROM information_schema.tables 
 			WHERE table_schema = 'public' 
@@ -293,24 +339,35 @@ func CreateAuthenticatedReservation(c *gin.Context) {
 		return
 	}
 
-	// Get store pickup timestamp directly from database
-	var pickupTimestamp time.Time
-	err = db.DB.Get(&pickupTimestamp, `SELECT pickup_timestamp FROM stores WHERE id = $1`, req.StoreID)
+	tx, err := db.DB.Beginx()
 	if err != nil {
-		log.Printf("WARNING: Failed to get store pickup timestamp for store %s: %v", req.StoreID, err)
-		pickupTimestamp = time.Now().Add(2 * time.Hour)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := takeBags(tx, req.StoreID, req.Quantity); err != nil {
+		if err == errNotEnoughBags {
+			c.JSON(http.StatusConflict, gin.H{"error": "Not enough bags left"})
+			return
+		}
+		log.Printf("ERROR: Failed to update items_left and bags_available for store %s: %v", req.StoreID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update items_left and bags_available"})
+		return
 	}
 
 	// Insert into database
-	_, err = db.DB.Exec(`
+	_, err = tx.Exec(`
 		INSERT INTO reservations (
 			id, user_id, store_id, quantity, total_amount, 
-			status, payment_id, pickup_time, pickup_timestamp, created_at,
-			customer_name, customer_email, phone_number
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			status, payment_id, pickup_time, pickup_timestamp, pickup_window_id, created_at,
+			customer_name, customer_email, phone_number, payment_method, payment_status,
+			unit_price, pricing_rule_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
 	`, reservation.ID, userID, req.StoreID, req.Quantity, req.TotalAmount,
-		reservation.Status, reservation.PaymentID, req.PickupTime, pickupTimestamp, reservation.CreatedAt,
-		req.Name, req.Email, req.Phone)
+		reservation.Status, reservation.PaymentID, req.PickupTime, pickup.Timestamp, pickup.WindowID, reservation.CreatedAt,
+		req.Name, req.Email, req.Phone, reservation.PaymentMethod, reservation.PaymentStatus,
+		quote.UnitPrice, quote.PricingRuleID)
 
 	if err != nil {
 		log.Printf("ERROR: Failed to insert reservation into database: %v", err)
@@ -318,20 +375,9 @@ func CreateAuthenticatedReservation(c *gin.Context) {
 		return
 	}
 
-	// Update items_left and bags_available count in stores table
-	_, err = db.DB.Exec(`
-		UPDATE stores 
-		SET items_left = GREATEST(0, items_left - $1), 
-		    bags_available = GREATEST(0, bags_available - $1), 
-		    updated_at = NOW()
-		WHERE id = $2
-	`, req.Quantity, req.StoreID)
-
-	if err != nil {
-		log.Printf("ERROR: Failed to update items_left and bags_available for store %s: %v", req.StoreID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update items_left and bags_available"})
-	} else {
-		log.Printf("Updated items_left and bags_available for store %s: decreased by %d", req.StoreID, req.Quantity)
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
 	}
 
 	// Send email confirmation (don't fail if email fails)
@@ -349,7 +395,7 @@ func CreateAuthenticatedReservation(c *gin.Context) {
 					PickupTime:      req.PickupTime,
 					ReservationID:   reservation.ID,
 					Status:          getStatusTextVietnamese(reservation.Status),
-					PaymentType:     "Trả tiền tại cửa hàng",
+					PaymentType:     paymentMethodLabel(reservation.PaymentMethod),
 					CreatedAt:       reservation.CreatedAt,
 					OriginalPrice:   req.OriginalPrice,
 					DiscountedPrice: req.DiscountedPrice,
@@ -402,14 +448,30 @@ func CreateGuestReservation(c *gin.Context) {
 		return
 	}
 
-	// Get store pickup timestamp directly from database
-	var pickupTimestamp time.Time
-	err := db.DB.Get(&pickupTimestamp, `SELECT pickup_timestamp FROM stores WHERE id = $1`, req.StoreID)
+	// Pay-at-store reservations are priced by the server at the currently effective price, never by the client
+	quote, err := quoteCheckout(req.StoreID, req.Quantity, time.Now())
 	if err != nil {
-		log.Printf("WARNING: Failed to get store pickup timestamp for store %s: %v", req.StoreID, err)
-		// Fallback: current time + 2 hours
-		pickupTimestamp = time.Now().Add(2 * time.Hour)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Store not found"})
+			return
+		}
+		if err == errStoreOffboarded || err == errStoreNotApproved {
+			respondPickupError(c, req.StoreID, err)
+			return
+		}
+		log.Printf("ERROR: Failed to get store price for store %s: %v", req.StoreID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get store details"})
+		return
+	}
+	req.TotalAmount = fromMinorUnits(quote.TotalMinor)
+
+	// Bind the reservation to a pickup window; its label replaces the client's pickup time text
+	pickup, err := resolveReservationPickup(req.StoreID, req.PickupWindowID, req.PickupTime, time.Now())
+	if err != nil {
+		respondPickupError(c, req.StoreID, err)
+		return
 	}
+	req.PickupTime = pickup.Label
 
 	// Create a new reservation with UUID
 	reservationID := uuid.New().String()
@@ -428,23 +490,45 @@ func CreateGuestReservation(c *gin.Context) {
 		Status:          "confirmed",
 		PaymentID:       fmt.Sprintf("guest-pay-%d", time.Now().Unix()),
 		PickupTime:      &req.PickupTime,
-		PickupTimestamp: &pickupTimestamp,
+		PickupTimestamp: &pickup.Timestamp,
+		PickupWindowID:  pickup.WindowID,
 		CreatedAt:       time.Now(),
 		CustomerName:    req.Name,
 		CustomerEmail:   req.Email,
 		PhoneNumber:     req.Phone,
+		PaymentMethod:   PaymentMethodPayAtStore,
+		PaymentStatus:   PaymentStatusUnpaid,
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := takeBags(tx, req.StoreID, req.Quantity); err != nil {
+		if err == errNotEnoughBags {
+			c.JSON(http.StatusConflict, gin.H{"error": "Not enough bags left"})
+			return
+		}
+		log.Printf("ERROR: Failed to update items_left and bags_available for store %s: %v", req.StoreID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update items_left and bags_available"})
+		return
 	}
 
 	// Insert into database with NULL user_id for guest reservations
-	_, err = db.DB.Exec(`
+	_, err = tx.Exec(`
 		INSERT INTO reservations (
 			id, user_id, store_id, quantity, total_amount, 
-			status, payment_id, pickup_time, pickup_timestamp, created_at,
-			customer_name, customer_email, phone_number
-		) VALUES ($1, NULL, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			status, payment_id, pickup_time, pickup_timestamp, pickup_window_id, created_at,
+			customer_name, customer_email, phone_number, payment_method, payment_status,
+			unit_price, pricing_rule_id
+		) VALUES ($1, NULL, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
 	`, reservationID, req.StoreID, req.Quantity, req.TotalAmount,
-		reservation.Status, reservation.PaymentID, req.PickupTime, pickupTimestamp, reservation.CreatedAt,
-		req.Name, req.Email, req.Phone)
+		reservation.Status, reservation.PaymentID, req.PickupTime, pickup.Timestamp, pickup.WindowID, reservation.CreatedAt,
+		req.Name, req.Email, req.Phone, reservation.PaymentMethod, reservation.PaymentStatus,
+		quote.UnitPrice, quote.PricingRuleID)
 
 	if err != nil {
 		log.Printf("ERROR: Failed to insert guest reservation into database: %v", err)
@@ -452,20 +536,9 @@ func CreateGuestReservation(c *gin.Context) {
 		return
 	}
 
-	// Update items_left and bags_available count in stores table
-	_, err = db.DB.Exec(`
-		UPDATE stores 
-		SET items_left = GREATEST(0, items_left - $1), 
-		    bags_available = GREATEST(0, bags_available - $1), 
-		    updated_at = NOW()
-		WHERE id = $2
-	`, req.Quantity, req.StoreID)
-
-	if err != nil {
-		log.Printf("WARNING: Failed to update items_left and bags_available for store %s: %v", req.StoreID, err)
-		// Don't fail the reservation creation if items_left update fails
-	} else {
-		log.Printf("Updated items_left and bags_available for store %s: decreased by %d", req.StoreID, req.Quantity)
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
 	}
 
 	log.Printf("Guest reservation created successfully in database: %s", reservationID)
@@ -485,7 +558,7 @@ func CreateGuestReservation(c *gin.Context) {
 					PickupTime:      req.PickupTime,
 					ReservationID:   reservationID,
 					Status:          getStatusTextVietnamese(reservation.Status),
-					PaymentType:     "Trả tiền tại cửa hàng",
+					PaymentType:     paymentMethodLabel(reservation.PaymentMethod),
 					CreatedAt:       reservation.CreatedAt,
 					OriginalPrice:   req.OriginalPrice,
 					DiscountedPrice: req.DiscountedPrice,
@@ -549,88 +622,191 @@ func GetSessionReservations(c *gin.Context) {
 	c.JSON(http.StatusOK, []ReservationResponse{})
 }
 
+// reservationPickupEndSQL is when reservation r can no longer be picked up: the end of
+// its pickup window, else its pickup time; infinity when it has neither
+const reservationPickupEndSQL = `COALESCE(
+	(SELECT w.ends_at FROM pickup_windows w WHERE w.id = r.pickup_window_id),
+	r.pickup_timestamp,
+	'infinity'::timestamptz
+)`
+
+// DeleteReservation cancels a logged-in user's reservation before its pickup ends. An
+// unpaid pay-at-store reservation is simply cancelled. A confirmed, paid one is kept,
+// cancelled and refunded: any wallet share goes back to the wallet, and the card-paid
+// part is refunded to the card or, with ?refundTo=wallet, as instant store credit.
 func DeleteReservation(c *gin.Context) {
 	reservationID := c.Param("id")
 	userID := c.GetString("user_id")
+	refundToWallet := c.Query("refundTo") == "wallet"
 
-	// For logged-in users, delete from database
-	fmt.Printf("Attempting to delete reservation %s for user %s", reservationID, userID)
-	log.Printf("Attempting to delete reservation %s for user %s", reservationID, userID)
-
-	// First, get the reservation details to update bags_available
-	var storeID string
-	var quantity int
-	err := db.DB.QueryRow(`
-		SELECT store_id, quantity FROM reservations 
-		WHERE id = $1 AND user_id = $2
-	`, reservationID, userID).Scan(&storeID, &quantity)
+	log.Printf("Attempting to cancel reservation %s for user %s", reservationID, userID)
 
+	tx, err := db.DB.Beginx()
 	if err != nil {
-		if err == sql.ErrNoRows {
-			fmt.Printf("Reservation %s not found for user %s", reservationID, userID)
-			c.JSON(http.StatusNotFound, gin.H{"error": "Reservation not found"})
-			return
-		}
-		fmt.Printf("ERROR: Failed to get reservation details %s: %v", reservationID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reservation details"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
 		return
 	}
-
-	// Delete the reservation
-	result, err := db.DB.Exec(`
-		DELETE FROM reservations 
-		WHERE id = $1 AND user_id = $2
+	defer tx.Rollback()
+
+	// Lock the reservation so a concurrent cancellation cannot refund it twice
+	var reservation struct {
+		TotalAmount   float64 `db:"total_amount"`
+		WalletAmount  float64 `db:"wallet_amount"`
+		PaymentID     string  `db:"payment_id"`
+		PaymentMethod string  `db:"payment_method"`
+		PaymentStatus string  `db:"payment_status"`
+		Status        string  `db:"status"`
+		// Set when the reservation was cancelled for the customer, e.g. by the store closing
+		CancellationReason sql.NullString `db:"cancellation_reason"`
+		PickupOpen         bool           `db:"pickup_open"`
+	}
+	err = tx.Get(&reservation, `
+		SELECT r.total_amount, r.wallet_amount, COALESCE(r.payment_id, '') as payment_id,
+			r.payment_method, r.payment_status, r.status, r.cancellation_reason,
+			`+reservationPickupEndSQL+` > NOW() as pickup_open
+		FROM reservations r
+		WHERE r.id = $1 AND r.user_id = $2
+		FOR UPDATE OF r
 	`, reservationID, userID)
-
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reservation not found"})
+		return
+	}
 	if err != nil {
-		fmt.Printf("ERROR: Failed to delete reservation %s: %v", reservationID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete reservation"})
+		log.Printf("ERROR: Failed to get reservation details %s: %v", reservationID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reservation details"})
 		return
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		fmt.Printf("ERROR: Failed to confirm deletion: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm deletion"})
+	// A reservation cancelled by the store closing is already refunded
+	if reservation.CancellationReason.Valid {
+		c.JSON(http.StatusConflict, gin.H{"error": "Reservation was already cancelled and refunded"})
 		return
 	}
 
-	if rowsAffected == 0 {
-		fmt.Printf("Reservation %s not found for user %s", reservationID, userID)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Reservation not found"})
+	// A pay-at-store reservation is paid at pickup, so until then there is nothing to refund
+	if reservation.PaymentMethod == PaymentMethodPayAtStore && reservation.PaymentStatus == PaymentStatusUnpaid {
+		if (reservation.Status != "pending" && reservation.Status != "confirmed") || !reservation.PickupOpen {
+			c.JSON(http.StatusConflict, gin.H{"error": "Only upcoming reservations can be cancelled"})
+			return
+		}
+		if _, err := tx.Exec(`UPDATE reservations SET status = 'cancelled' WHERE id = $1`, reservationID); err != nil {
+			log.Printf("ERROR: Failed to cancel reservation %s: %v", reservationID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel reservation"})
+			return
+		}
+		if err := returnReservedBags(tx, reservationID); err != nil {
+			log.Printf("ERROR: Failed to return bags of reservation %s: %v", reservationID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel reservation"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+		log.Printf("Cancelled unpaid reservation %s for user %s", reservationID, userID)
+		c.JSON(http.StatusOK, gin.H{"message": "Reservation cancelled"})
 		return
 	}
 
-	// Update items_left count in stores table (increase by the quantity that was reserved)
-	_, err = db.DB.Exec(`
-		UPDATE stores 
-		SET items_left = items_left + $1, updated_at = NOW()
-		WHERE id = $2
-	`, quantity, storeID)
+	if reservation.Status != "confirmed" || reservation.PaymentStatus != PaymentStatusPaid || !reservation.PickupOpen {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only confirmed, paid reservations can be cancelled before pickup"})
+		return
+	}
+	// Money collected at the counter can only be handed back there
+	if reservation.PaymentMethod == PaymentMethodPayAtStore {
+		c.JSON(http.StatusConflict, gin.H{"error": "Reservations paid at the store must be cancelled by the store"})
+		return
+	}
 
+	_, err = tx.Exec(`
+		UPDATE reservations SET status = 'cancelled', payment_status = $2 WHERE id = $1
+	`, reservationID, PaymentStatusRefunded)
 	if err != nil {
-		log.Printf("WARNING: Failed to update items_left for store %s: %v", storeID, err)
-		// Don't fail the deletion if items_left update fails
-	} else {
-		log.Printf("Updated items_left for store %s: increased by %d", storeID, quantity)
+		log.Printf("ERROR: Failed to cancel reservation %s: %v", reservationID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel reservation"})
+		return
+	}
+	if err := returnReservedBags(tx, reservationID); err != nil {
+		log.Printf("ERROR: Failed to return bags of reservation %s: %v", reservationID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel reservation"})
+		return
 	}
 
-	fmt.Printf("Successfully deleted reservation %s for user %s", reservationID, userID)
-	c.JSON(http.StatusOK, gin.H{"message": "Reservation deleted"})
+	// The wallet share always goes back to the wallet; the card share goes to the
+	// wallet on request, else back to the card
+	refundMinor := toMinorUnits(reservation.WalletAmount)
+	cardMinor := int64(0)
+	if strings.HasPrefix(reservation.PaymentID, "pi_") {
+		cardMinor = toMinorUnits(reservation.TotalAmount - reservation.WalletAmount)
+	}
+	if refundToWallet {
+		refundMinor += cardMinor
+		cardMinor = 0
+	}
+
+	var refundTxn *WalletTransaction
+	if refundMinor > 0 {
+		refundTxn, err = applyWalletTransaction(tx, userID, refundMinor, WalletTxRefundCredit, reservationID, "Refund for cancelled reservation", userID)
+		if err != nil {
+			log.Printf("ERROR: Failed to credit wallet refund for reservation %s: %v", reservationID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refund reservation"})
+			return
+		}
+	}
+
+	// Refund the card last so a failure leaves the reservation untouched; a retried
+	// refund is a no-op at the gateway
+	if cardMinor > 0 {
+		if err := services.Payments.RefundPaymentIntent(reservation.PaymentID, reservationID, cardMinor); err != nil {
+			log.Printf("ERROR: Failed to refund reservation %s to the card: %v", reservationID, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to refund the card, please try again"})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	log.Printf("Cancelled reservation %s for user %s", reservationID, userID)
+	response := gin.H{"message": "Reservation cancelled"}
+	if refundTxn != nil {
+		response["walletRefund"] = toWalletTransactionResponse(*refundTxn)
+	}
+	if cardMinor > 0 {
+		response["cardRefund"] = fromMinorUnits(cardMinor)
+	}
+	c.JSON(http.StatusOK, response)
 }
 
+// DeleteGuestReservation cancels a guest reservation before its pickup ends. The
+// reservation row is kept as a record of the sale.
 func DeleteGuestReservation(c *gin.Context) {
 	reservationID := c.Param("id")
 
-	log.Printf("Attempting to delete guest reservation %s", reservationID)
+	log.Printf("Attempting to cancel guest reservation %s", reservationID)
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
 
-	// First, get the reservation details from database to update bags_available
-	var storeID string
-	var quantity int
-	err := db.DB.QueryRow(`
-		SELECT store_id, quantity FROM reservations 
-		WHERE id = $1 AND user_id IS NULL
-	`, reservationID).Scan(&storeID, &quantity)
+	var reservation struct {
+		Status             string         `db:"status"`
+		CancellationReason sql.NullString `db:"cancellation_reason"`
+		PickupOpen         bool           `db:"pickup_open"`
+	}
+	err = tx.Get(&reservation, `
+		SELECT r.status, r.cancellation_reason,
+			`+reservationPickupEndSQL+` > NOW() as pickup_open
+		FROM reservations r
+		WHERE r.id = $1 AND r.user_id IS NULL
+		FOR UPDATE OF r
+	`, reservationID)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -644,44 +820,32 @@ func DeleteGuestReservation(c *gin.Context) {
 		return
 	}
 
-	// Delete from database
-	result, err := db.DB.Exec(`
-		DELETE FROM reservations 
-		WHERE id = $1 AND user_id IS NULL
-	`, reservationID)
-
-	if err != nil {
-		log.Printf("ERROR: Failed to delete guest reservation %s: %v", reservationID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete reservation"})
+	if reservation.CancellationReason.Valid || (reservation.Status != "pending" && reservation.Status != "confirmed") || !reservation.PickupOpen {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only upcoming reservations can be cancelled"})
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		log.Printf("Guest reservation %s not found", reservationID)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Reservation not found"})
+	if _, err := tx.Exec(`UPDATE reservations SET status = 'cancelled' WHERE id = $1`, reservationID); err != nil {
+		log.Printf("ERROR: Failed to cancel guest reservation %s: %v", reservationID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel reservation"})
+		return
+	}
+	if err := returnReservedBags(tx, reservationID); err != nil {
+		log.Printf("ERROR: Failed to return bags of reservation %s: %v", reservationID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel reservation"})
 		return
 	}
 
-	// Update items_left count in stores table (increase by the quantity that was reserved)
-	_, err = db.DB.Exec(`
-		UPDATE stores 
-		SET items_left = items_left + $1, updated_at = NOW()
-		WHERE id = $2
-	`, quantity, storeID)
-
-	if err != nil {
-		log.Printf("WARNING: Failed to update items_left for store %s: %v", storeID, err)
-		// Don't fail the deletion if items_left update fails
-	} else {
-		log.Printf("Updated items_left for store %s: increased by %d", storeID, quantity)
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
 	}
 
 	// Also delete from session for backward compatibility
 	deleteFromSession(c, reservationID)
 
-	log.Printf("Successfully deleted guest reservation %s", reservationID)
-	c.JSON(http.StatusOK, gin.H{"message": "Reservation deleted"})
+	log.Printf("Cancelled guest reservation %s", reservationID)
+	c.JSON(http.StatusOK, gin.H{"message": "Reservation cancelled"})
 }
 
 // Helper function to delete reservation from session
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"savor-server/db"
 	"savor-server/models"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx/types"
@@ -45,6 +46,34 @@ func GetStoreDetail(c *gin.Context) {
 		return
 	}
 
+	applySingleStorePricing(&modelStore, time.Now())
+
+	// A closed day is skipped when working out the next opening
+	today := time.Now().In(storeLocation(modelStore.Timezone)).Format("2006-01-02")
+	closures, err := loadStoreClosures(db.DB, storeID, today)
+	if err != nil {
+		log.Printf("Failed to fetch closures for store %s: %v", storeID, err)
+	}
+	applyStoreOpeningHours(&modelStore, closures, time.Now())
+
+	err = db.DB.Get(&modelStore.Highlights, `SELECT `+storeHighlightsSQL+` FROM stores s WHERE s.id = $1`, storeID)
+	if err != nil {
+		log.Printf("Failed to fetch highlights for store %s: %v", storeID, err)
+	}
+
+	// The store's own tags plus those of its bags
+	err = db.DB.Get(&modelStore.DietaryTags, `SELECT `+storeDietaryTagsSQL+` FROM stores s WHERE s.id = $1`, storeID)
+	if err != nil {
+		log.Printf("Failed to fetch dietary tags for store %s: %v", storeID, err)
+	}
+
+	// Flag what clashes with the customer's diet instead of hiding the store
+	preferences, err := requestDietaryPreferences(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Check if store is saved by user
 	userID := c.GetString("user_id")
 
@@ -127,6 +156,11 @@ func GetStoreDetail(c *gin.Context) {
 		discountedPrice = modelStore.DiscountedPrice.Float64
 	}
 
+	var nextOpening *time.Time
+	if modelStore.NextOpeningTime.Valid {
+		nextOpening = &modelStore.NextOpeningTime.Time
+	}
+
 	price := 0.0
 	if modelStore.Price.Valid {
 		price = modelStore.Price.Float64
@@ -141,6 +175,8 @@ func GetStoreDetail(c *gin.Context) {
 		Price           float64        `json:"price"`
 		OriginalPrice   float64        `json:"originalPrice"`
 		DiscountedPrice float64        `json:"discountedPrice"`
+		RegularPrice    float64        `json:"regularPrice"`
+		PricingRuleID   string         `json:"pricingRuleId,omitempty"`
 		BackgroundURL   string         `json:"backgroundUrl"`
 		AvatarURL       string         `json:"avatarUrl"`
 		ImageURL        string         `json:"imageUrl"`
@@ -154,10 +190,16 @@ func GetStoreDetail(c *gin.Context) {
 		ItemsLeft       int            `json:"itemsLeft"`
 		Latitude        float64        `json:"latitude"`
 		Longitude       float64        `json:"longitude"`
+		Timezone        string         `json:"timezone"`
 		Highlights      pq.StringArray `json:"highlights"`
+		DietaryTags     pq.StringArray `json:"dietaryTags"`
+		DietaryWarnings []string       `json:"dietaryWarnings"`
 		IsSaved         bool           `json:"isSaved"`
 		StoreType       string         `json:"storeType"`
+		Category        string         `json:"category"`
 		BusinessHours   types.JSONText `json:"businessHours"`
+		IsOpenNow       bool           `json:"isOpenNow"`
+		NextOpeningTime *time.Time     `json:"nextOpeningTime"`
 	}{
 		ID:              modelStore.ID,
 		Title:           modelStore.Title,
@@ -167,6 +209,8 @@ func GetStoreDetail(c *gin.Context) {
 		Price:           price,
 		OriginalPrice:   originalPrice,
 		DiscountedPrice: discountedPrice,
+		RegularPrice:    modelStore.RegularPrice.Float64,
+		PricingRuleID:   modelStore.AppliedPricingRuleID,
 		BackgroundURL:   modelStore.BackgroundURL,
 		AvatarURL:       avatarURL,
 		ImageURL:        modelStore.ImageURL,
@@ -180,10 +224,16 @@ func GetStoreDetail(c *gin.Context) {
 		ItemsLeft:       itemsLeft,
 		Latitude:        modelStore.Latitude,
 		Longitude:       modelStore.Longitude,
+		Timezone:        storeLocation(modelStore.Timezone).String(),
 		Highlights:      modelStore.Highlights,
+		DietaryTags:     modelStore.DietaryTags,
+		DietaryWarnings: dietaryWarnings(preferences, modelStore.DietaryTags),
 		IsSaved:         saved,
 		StoreType:       modelStore.StoreType.String,
+		Category:        modelStore.CategorySlug.String,
 		BusinessHours:   modelStore.BusinessHours,
+		IsOpenNow:       modelStore.IsOpenNow,
+		NextOpeningTime: nextOpening,
 	}
 
 	c.JSON(http.StatusOK, responseStore)
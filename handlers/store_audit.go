@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"savor-server/db"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	StoreAuditStoreUpdated             = "store.updated"
+	StoreAuditSettingsUpdated          = "store.settings_updated"
+	StoreAuditSellingToggled           = "store.selling_toggled"
+	StoreAuditDietaryTagsUpdated       = "store.dietary_tags_updated"
+	StoreAuditBusinessHoursUpdated     = "store.business_hours_updated"
+	StoreAuditImageUploaded            = "store.image_uploaded"
+	StoreAuditReviewChanged            = "store.review_changed"
+	StoreAuditHighlightsUpdated        = "store.highlights_updated"
+	StoreAuditPickupScheduleUpdated    = "store.pickup_schedule_updated"
+	StoreAuditClosureCreated           = "closure.created"
+	StoreAuditClosureDeleted           = "closure.deleted"
+	StoreAuditMemberAdded              = "member.added"
+	StoreAuditMemberRoleChanged        = "member.role_changed"
+	StoreAuditMemberRemoved            = "member.removed"
+	StoreAuditStoreClosed              = "store.closed"
+	StoreAuditOwnerDataAnonymized      = "store.owner_data_anonymized"
+	StoreAuditBagUpdated               = "bag.updated"
+	StoreAuditBagCountUpdated          = "bag.count_updated"
+	StoreAuditInventorySettingsUpdated = "inventory.settings_updated"
+	StoreAuditInventoryOverrideSet     = "inventory.override_set"
+	StoreAuditInventoryOverrideDeleted = "inventory.override_deleted"
+)
+
+const (
+	defaultAuditLogLimit = 50
+	maxAuditLogLimit     = 200
+)
+
+// storeAuditSnapshotSQL is the audited state of store $1: its settings, bag, pricing
+// rules, highlights and weekly pickup schedule as one JSON object. The store row is locked so concurrent edits are diffed in
+// the order they commit.
+const storeAuditSnapshotSQL = `
+	SELECT to_jsonb(snapshot) FROM (
+		SELECT s.title, s.description, s.address, s.city, s.state, s.zip_code, s.country,
+			s.phone, s.latitude, s.longitude, s.google_maps_url, s.timezone,
+			s.store_type, s.category_slug, s.pickup_time,
+			s.price, s.original_price, s.discounted_price, s.items_left, s.bags_available,
+			s.is_selling, s.sold_out_paused, s.inventory_reset_time, s.review_status,
+			s.image_url, s.background_url, s.avatar_url, s.dietary_tags, s.business_hours,
+			b.category as bag_category, b.name as bag_name, b.description as bag_description,
+			b.size as bag_size, b.price as bag_price, b.min_value as bag_min_value,
+			b.daily_count as bag_daily_count, b.dietary_tags as bag_dietary_tags,
+			(
+				SELECT jsonb_agg(jsonb_build_object(
+					'startsMinutesBeforeClose', r.starts_minutes_before_close,
+					'percentOfPrice', r.percent_of_price,
+					'floorPrice', r.floor_price,
+					'enabled', r.enabled
+				) ORDER BY r.starts_minutes_before_close DESC)
+				FROM store_pricing_rules r WHERE r.store_id = s.id
+			) as pricing_rules,
+			(
+				SELECT jsonb_agg(h.highlight ORDER BY h.position)
+				FROM store_highlights h WHERE h.store_id = s.id
+			) as highlights,
+			(
+				SELECT jsonb_agg(jsonb_build_object(
+					'day', p.day,
+					'enabled', p.enabled,
+					'startTime', p.start_time,
+					'endTime', p.end_time
+				) ORDER BY p.day, p.start_time)
+				FROM pickup_schedules p WHERE p.store_id = s.id
+			) as pickup_schedule
+		FROM stores s
+		LEFT JOIN bag_details b ON b.store_id = s.id
+		WHERE s.id = $1
+		FOR UPDATE OF s
+	) snapshot`
+
+// StoreAuditChange is a field's value before and after a change
+type StoreAuditChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+type StoreAuditEntry struct {
+	ID        int64           `json:"id" db:"id"`
+	StoreID   string          `json:"storeId" db:"store_id"`
+	ActorID   *string         `json:"actorId" db:"actor_id"`
+	Action    string          `json:"action" db:"action"`
+	Changes   json.RawMessage `json:"changes" db:"changes"`
+	IPAddress *string         `json:"ipAddress" db:"ip_address"`
+	RequestID *string         `json:"requestId" db:"request_id"`
+	CreatedAt time.Time       `json:"createdAt" db:"created_at"`
+}
+
+const storeAuditEntryColumns = `id, store_id, actor_id, action, changes, ip_address, request_id, created_at`
+
+// storeAudit is the audited state of a store before a change. Take it inside the
+// transaction making the change, before the change, and record it after.
+type storeAudit struct {
+	storeID string
+	before  map[string]json.RawMessage
+}
+
+func beginStoreAudit(q sqlx.Queryer, storeID string) (*storeAudit, error) {
+	before, err := loadStoreAuditSnapshot(q, storeID)
+	if err != nil {
+		return nil, err
+	}
+	return &storeAudit{storeID: storeID, before: before}, nil
+}
+
+func loadStoreAuditSnapshot(q sqlx.Queryer, storeID string) (map[string]json.RawMessage, error) {
+	var raw []byte
+	if err := q.QueryRowx(storeAuditSnapshotSQL, storeID).Scan(&raw); err != nil {
+		return nil, fmt.Errorf("failed to snapshot store %s for the audit log: %v", storeID, err)
+	}
+	var snapshot map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode store snapshot: %v", err)
+	}
+	return snapshot, nil
+}
+
+// record diffs the store against the snapshot and logs the changed fields; nothing is
+// logged when a save changed nothing
+func (a *storeAudit) record(c *gin.Context, tx sqlx.Ext, action string) error {
+	after, err := loadStoreAuditSnapshot(tx, a.storeID)
+	if err != nil {
+		return err
+	}
+
+	changes := make(map[string]StoreAuditChange)
+	for field, value := range after {
+		if before := a.before[field]; !bytes.Equal(before, value) {
+			changes[field] = StoreAuditChange{Before: before, After: value}
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return recordStoreAudit(c, tx, a.storeID, action, changes)
+}
+
+// recordStoreAudit appends an entry for a change that is not part of the store snapshot.
+// c is nil for changes made by a background job, which have no actor.
+func recordStoreAudit(c *gin.Context, exec sqlx.Execer, storeID, action string, changes map[string]StoreAuditChange) error {
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit changes: %v", err)
+	}
+	var actorID, ipAddress, requestID string
+	if c != nil {
+		actorID, ipAddress, requestID = c.GetString("user_id"), c.ClientIP(), c.GetString("request_id")
+	}
+	_, err = exec.Exec(`
+		INSERT INTO store_audit_log (store_id, actor_id, action, changes, ip_address, request_id)
+		VALUES ($1, NULLIF($2, ''), $3, $4, NULLIF($5, ''), NULLIF($6, ''))
+	`, storeID, actorID, action, string(encoded), ipAddress, requestID)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	return nil
+}
+
+// auditValue encodes a value for StoreAuditChange; nil becomes JSON null
+func auditValue(v interface{}) json.RawMessage {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return encoded
+}
+
+// auditLogPage reads the limit and before (an entry ID to page back from) query params
+func auditLogPage(c *gin.Context) (limit int, before int64, ok bool) {
+	limit = defaultAuditLogLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return 0, 0, false
+		}
+		limit = min(n, maxAuditLogLimit)
+	}
+	if value := c.Query("before"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid before"})
+			return 0, 0, false
+		}
+		before = n
+	}
+	return limit, before, true
+}
+
+func respondAuditLog(c *gin.Context, entries []StoreAuditEntry, limit int) {
+	response := gin.H{"entries": entries, "nextBefore": nil}
+	if len(entries) == limit {
+		response["nextBefore"] = entries[len(entries)-1].ID
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetStoreAuditLog lists the store's changes, newest first
+func GetStoreAuditLog(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
+		return
+	}
+	limit, before, ok := auditLogPage(c)
+	if !ok {
+		return
+	}
+
+	entries := make([]StoreAuditEntry, 0)
+	err := db.DB.Select(&entries, `
+		SELECT `+storeAuditEntryColumns+`
+		FROM store_audit_log
+		WHERE store_id = $1 AND ($2 = '' OR action = $2) AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC
+		LIMIT $4
+	`, storeID, c.Query("action"), before, limit)
+	if err != nil {
+		log.Printf("ERROR: Failed to load audit log for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load audit log"})
+		return
+	}
+	respondAuditLog(c, entries, limit)
+}
+
+// ListStoreAuditLog queries the audit log across stores (Admin only). Filters: storeId,
+// actorId, action, from and to (YYYY-MM-DD, inclusive, UTC).
+func ListStoreAuditLog(c *gin.Context) {
+	limit, before, ok := auditLogPage(c)
+	if !ok {
+		return
+	}
+
+	var from, to time.Time
+	var err error
+	if value := c.Query("from"); value != "" {
+		if from, err = time.Parse("2006-01-02", value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be YYYY-MM-DD"})
+			return
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if to, err = time.Parse("2006-01-02", value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be YYYY-MM-DD"})
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+
+	entries := make([]StoreAuditEntry, 0)
+	err = db.DB.Select(&entries, `
+		SELECT `+storeAuditEntryColumns+`
+		FROM store_audit_log
+		WHERE ($1 = '' OR store_id = $1)
+			AND ($2 = '' OR actor_id = $2)
+			AND ($3 = '' OR action = $3)
+			AND ($4::timestamptz IS NULL OR created_at >= $4)
+			AND ($5::timestamptz IS NULL OR created_at < $5)
+			AND ($6 = 0 OR id < $6)
+		ORDER BY id DESC
+		LIMIT $7
+	`, c.Query("storeId"), c.Query("actorId"), c.Query("action"),
+		nullTime(from), nullTime(to), before, limit)
+	if err != nil {
+		log.Printf("ERROR: Failed to query audit log: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load audit log"})
+		return
+	}
+	respondAuditLog(c, entries, limit)
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
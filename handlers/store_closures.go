@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"savor-server/db"
+	"savor-server/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// storeClosedTodaySQL is true for a store s that is closed all day today in its own zone.
+// Home and search use it to hide the store without the owner flipping is_selling.
+const storeClosedTodaySQL = `EXISTS (
+	SELECT 1 FROM store_closures sc
+	WHERE sc.store_id = s.id AND sc.closed
+	AND (NOW() AT TIME ZONE s.timezone)::date BETWEEN sc.start_date AND sc.end_date
+)`
+
+// StoreClosure overrides the weekly pickup schedule for a date range: the store is
+// either closed or only has the special hours StartTime-EndTime on those dates
+type StoreClosure struct {
+	ID        string    `json:"id" db:"id"`
+	StoreID   string    `json:"storeId" db:"store_id"`
+	StartDate string    `json:"startDate" db:"start_date"`
+	EndDate   string    `json:"endDate" db:"end_date"`
+	Closed    bool      `json:"closed" db:"closed"`
+	StartTime *string   `json:"startTime" db:"start_time"`
+	EndTime   *string   `json:"endTime" db:"end_time"`
+	Reason    *string   `json:"reason" db:"reason"`
+	CreatedBy *string   `json:"createdBy" db:"created_by"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+type CreateStoreClosureRequest struct {
+	StartDate string `json:"startDate" binding:"required"`
+	EndDate   string `json:"endDate"`
+	Closed    bool   `json:"closed"`
+	StartTime string `json:"startTime"`
+	EndTime   string `json:"endTime"`
+	Reason    string `json:"reason"`
+}
+
+const storeClosureColumns = `id, store_id, start_date::text as start_date, end_date::text as end_date, closed,
+	to_char(start_time, 'HH24:MI') as start_time, to_char(end_time, 'HH24:MI') as end_time,
+	reason, created_by, created_at`
+
+// closureOn returns the closure covering date, preferring a full closure over special hours
+func closureOn(closures []StoreClosure, date time.Time) *StoreClosure {
+	day := date.Format("2006-01-02")
+	var found *StoreClosure
+	for i := range closures {
+		if day < closures[i].StartDate || day > closures[i].EndDate {
+			continue
+		}
+		if closures[i].Closed {
+			return &closures[i]
+		}
+		if found == nil {
+			found = &closures[i]
+		}
+	}
+	return found
+}
+
+// loadStoreClosures returns the store's closures that have not ended before fromDate
+func loadStoreClosures(q sqlx.Queryer, storeID, fromDate string) ([]StoreClosure, error) {
+	var closures []StoreClosure
+	err := sqlx.Select(q, &closures, `
+		SELECT `+storeClosureColumns+`
+		FROM store_closures
+		WHERE store_id = $1 AND end_date >= $2::date
+		ORDER BY start_date, created_at
+	`, storeID, fromDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load store closures: %v", err)
+	}
+	return closures, nil
+}
+
+// ListStoreClosures lists the store's current and upcoming closures
+func ListStoreClosures(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
+		return
+	}
+
+	closures := make([]StoreClosure, 0)
+	today := time.Now().In(storeLocationByID(storeID)).Format("2006-01-02")
+	err := db.DB.Select(&closures, `
+		SELECT `+storeClosureColumns+`
+		FROM store_closures
+		WHERE store_id = $1 AND end_date >= $2::date
+		ORDER BY start_date, created_at
+	`, storeID, today)
+	if err != nil {
+		log.Printf("ERROR: Failed to list closures for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get closures"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"closures": closures})
+}
+
+// CreateStoreClosure closes the store, or sets special pickup hours, for a date range.
+// Pickup windows on those dates are regenerated and customers holding reservations
+// for a day that is no longer available are notified.
+func CreateStoreClosure(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req CreateStoreClosureRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.EndDate == "" {
+		req.EndDate = req.StartDate
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
+		return
+	}
+
+	loc := storeLocationByID(storeID)
+	startDate, err := time.ParseInLocation("2006-01-02", req.StartDate, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "startDate must be YYYY-MM-DD"})
+		return
+	}
+	endDate, err := time.ParseInLocation("2006-01-02", req.EndDate, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "endDate must be YYYY-MM-DD"})
+		return
+	}
+	if endDate.Before(startDate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "endDate must not be before startDate"})
+		return
+	}
+	if endDate.Before(startOfDay(time.Now(), loc)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Closure must not be in the past"})
+		return
+	}
+
+	var startTime, endTime *string
+	if !req.Closed {
+		if req.StartTime == "" || req.EndTime == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "startTime and endTime are required unless the store is closed"})
+			return
+		}
+		startHour, startMinute, err := parseClockTime(req.StartTime)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid startTime"})
+			return
+		}
+		endHour, endMinute, err := parseClockTime(req.EndTime)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid endTime"})
+			return
+		}
+		start := fmt.Sprintf("%02d:%02d", startHour, startMinute)
+		end := fmt.Sprintf("%02d:%02d", endHour, endMinute)
+		startTime, endTime = &start, &end
+	}
+
+	var reason *string
+	if r := strings.TrimSpace(req.Reason); r != "" {
+		reason = &r
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var closure StoreClosure
+	err = tx.Get(&closure, `
+		WITH inserted AS (
+			INSERT INTO store_closures (store_id, start_date, end_date, closed, start_time, end_time, reason, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING *
+		)
+		SELECT `+storeClosureColumns+` FROM inserted
+	`, storeID, req.StartDate, req.EndDate, req.Closed, startTime, endTime, reason, userID)
+	if err != nil {
+		log.Printf("ERROR: Failed to create closure for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create closure"})
+		return
+	}
+
+	err = recordStoreAudit(c, tx, storeID, StoreAuditClosureCreated, map[string]StoreAuditChange{
+		"closure:" + closure.ID: {Before: auditValue(nil), After: auditValue(closure)},
+	})
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create closure"})
+		return
+	}
+
+	if err := syncPickupWindows(tx, storeID, time.Now()); err != nil {
+		log.Printf("ERROR: Failed to update pickup windows for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update pickup windows"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	go notifyClosureReservations(closure)
+
+	c.JSON(http.StatusCreated, gin.H{"closure": closure})
+}
+
+// DeleteStoreClosure removes a closure and restores the weekly schedule on its dates
+func DeleteStoreClosure(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var closure StoreClosure
+	err = tx.Get(&closure, `
+		WITH deleted AS (
+			DELETE FROM store_closures WHERE id::text = $1 AND store_id = $2
+			RETURNING *
+		)
+		SELECT `+storeClosureColumns+` FROM deleted
+	`, c.Param("id"), storeID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Closure not found"})
+		return
+	}
+	if err == nil {
+		err = recordStoreAudit(c, tx, storeID, StoreAuditClosureDeleted, map[string]StoreAuditChange{
+			"closure:" + closure.ID: {Before: auditValue(closure), After: auditValue(nil)},
+		})
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to delete closure for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete closure"})
+		return
+	}
+
+	if err := syncPickupWindows(tx, storeID, time.Now()); err != nil {
+		log.Printf("ERROR: Failed to update pickup windows for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update pickup windows"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Closure deleted"})
+}
+
+// notifyClosureReservations emails customers whose open reservation falls on the
+// closure's dates and can no longer be picked up as booked: the store is closed, or
+// their window was cancelled in favour of the special hours.
+func notifyClosureReservations(closure StoreClosure) {
+	emailService := services.GetEmailService()
+	if !emailService.IsConfigured() {
+		return
+	}
+
+	var affected []struct {
+		ID              string         `db:"id"`
+		Email           sql.NullString `db:"email"`
+		StoreName       string         `db:"store_name"`
+		Timezone        string         `db:"timezone"`
+		PickupTimestamp time.Time      `db:"pickup_timestamp"`
+	}
+	err := db.DB.Select(&affected, `
+		SELECT r.id, COALESCE(r.customer_email, u.email) as email, s.title as store_name,
+			s.timezone, r.pickup_timestamp
+		FROM reservations r
+		JOIN stores s ON r.store_id = s.id
+		LEFT JOIN users u ON r.user_id = u.id::text
+		LEFT JOIN pickup_windows w ON r.pickup_window_id = w.id
+		WHERE r.store_id = $1
+		AND r.status IN ('pending', 'confirmed')
+		AND r.pickup_timestamp IS NOT NULL
+		AND (r.pickup_timestamp AT TIME ZONE s.timezone)::date BETWEEN $2::date AND $3::date
+		AND ($4 OR w.cancelled)
+	`, closure.StoreID, closure.StartDate, closure.EndDate, closure.Closed)
+	if err != nil {
+		log.Printf("ERROR: Failed to find reservations affected by closure %s: %v", closure.ID, err)
+		return
+	}
+
+	specialHours := ""
+	if closure.StartTime != nil && closure.EndTime != nil {
+		specialHours = *closure.StartTime + " - " + *closure.EndTime
+	}
+	reason := ""
+	if closure.Reason != nil {
+		reason = *closure.Reason
+	}
+
+	for _, r := range affected {
+		if !r.Email.Valid || r.Email.String == "" {
+			continue
+		}
+		err := emailService.SendStoreClosureNotice(r.Email.String, services.StoreClosureEmailData{
+			StoreName:     r.StoreName,
+			ReservationID: r.ID,
+			PickupTime:    r.PickupTimestamp.In(storeLocation(r.Timezone)).Format("02/01/2006 15:04"),
+			Closed:        closure.Closed,
+			SpecialHours:  specialHours,
+			Reason:        reason,
+		})
+		if err != nil {
+			log.Printf("WARNING: Failed to send closure notice for reservation %s: %v", r.ID, err)
+		}
+	}
+	log.Printf("Closure %s of store %s affected %d reservations", closure.ID, closure.StoreID, len(affected))
+}
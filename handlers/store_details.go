@@ -5,8 +5,11 @@ import (
 	"net/http"
 	"savor-server/db"
 	"savor-server/models"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type UpdateBagDetailsRequest struct {
@@ -15,6 +18,21 @@ type UpdateBagDetailsRequest struct {
 	Description string `json:"description" binding:"required"`
 	Size        string `json:"size" binding:"required"`
 	DailyCount  int    `json:"dailyCount" binding:"required,min=1"`
+	// Dietary and allergen tags of this bag, from the dietary tag vocabulary
+	DietaryTags []string `json:"dietaryTags"`
+}
+
+// bagSizePricing is the bag price and the minimum value of its contents for a size;
+// unknown sizes are priced as medium
+func bagSizePricing(size string) (price, minValue float64) {
+	switch size {
+	case "small":
+		return 4.99, 15.00
+	case "large":
+		return 6.99, 21.00
+	default:
+		return 5.99, 18.00
+	}
 }
 
 type UpdateScheduleRequest struct {
@@ -27,6 +45,10 @@ type UpdateBagCountRequest struct {
 
 func UpdateBagDetails(c *gin.Context) {
 	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
 
 	// Check if this is a full update or just bag count update
 	contentType := c.GetHeader("Content-Type")
@@ -38,27 +60,35 @@ func UpdateBagDetails(c *gin.Context) {
 		}
 
 		// Get store ID
-		var storeID string
-		err := db.DB.QueryRow("SELECT id FROM stores WHERE owner_id = $1 LIMIT 1", userID).Scan(&storeID)
-		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Store not found"})
+		storeID, ok := authorizeStore(c, PermManageStore)
+		if !ok {
 			return
 		}
 
-		// Update only the items_left in stores and daily_count in bag_details
-		tx, err := db.DB.Begin()
+		// Update only today's bags in stores and daily_count in bag_details
+		tx, err := db.DB.Beginx()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
 			return
 		}
 
+		audit, err := beginStoreAudit(tx, storeID)
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store"})
+			return
+		}
+
 		// Update store table
 		_, err = tx.Exec(`
 			UPDATE stores 
-			SET items_left = $1
+			SET items_left = $1, bags_available = $1
 			WHERE id = $2`,
 			req.DailyCount, storeID)
 
+		if err == nil {
+			err = updateSellingFromInventory(tx, storeID)
+		}
 		if err != nil {
 			tx.Rollback()
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store"})
@@ -72,6 +102,9 @@ func UpdateBagDetails(c *gin.Context) {
 			WHERE store_id = $2`,
 			req.DailyCount, storeID)
 
+		if err == nil {
+			err = audit.record(c, tx, StoreAuditBagCountUpdated)
+		}
 		if err != nil {
 			tx.Rollback()
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update bag details"})
@@ -94,38 +127,48 @@ func UpdateBagDetails(c *gin.Context) {
 		return
 	}
 
-	// First get the store ID in a separate query
-	var storeID string
-	err := db.DB.QueryRow("SELECT id FROM stores WHERE owner_id = $1 LIMIT 1", userID).Scan(&storeID)
+	dietaryTags, err := normalizeDietaryTags(req.DietaryTags)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Store not found"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Calculate price based on size
-	var price, minValue float64
-	switch req.Size {
-	case "small":
-		price = 4.99
-		minValue = 15.00
-	case "medium":
-		price = 5.99
-		minValue = 18.00
-	case "large":
-		price = 6.99
-		minValue = 21.00
-	default:
-		price = 5.99
-		minValue = 18.00
+	// First get the store ID in a separate query
+	storeID, ok := authorizeStore(c, PermManagePricing)
+	if !ok {
+		return
 	}
 
+	price, minValue := bagSizePricing(req.Size)
+
 	// Start transaction
-	tx, err := db.DB.Begin()
+	tx, err := db.DB.Beginx()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
 		return
 	}
 
+	audit, err := beginStoreAudit(tx, storeID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store"})
+		return
+	}
+
+	before, err := loadStoreMaterial(tx, storeID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store"})
+		return
+	}
+
+	category, err := categoryForStoreType(tx, req.Category)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store"})
+		return
+	}
+
 	// Update store table with basic details
 	_, err = tx.Exec(`
         UPDATE stores 
@@ -134,36 +177,29 @@ func UpdateBagDetails(c *gin.Context) {
             price = $3, 
             original_price = $4, 
             items_left = $5,
-            store_type = $6
-        WHERE id = $7`,
+            store_type = $6,
+            category_slug = $7
+        WHERE id = $8`,
 		req.Name,
 		req.Description,
 		price,
 		minValue,
 		req.DailyCount,
 		req.Category,
+		category,
 		storeID)
 
-	if err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store"})
-		return
+	if err == nil {
+		err = flagMaterialEdits(tx, storeID, userID, before)
 	}
-
-	// Delete existing highlights for this store
-	_, err = tx.Exec(`DELETE FROM store_highlights WHERE store_id = $1`, storeID)
 	if err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store highlights"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store"})
 		return
 	}
 
-	// Insert new highlight for category
-	_, err = tx.Exec(`
-        INSERT INTO store_highlights (store_id, highlight)
-        VALUES ($1, $2)`,
-		storeID, req.Category)
-
+	// The category is shown as a highlight next to the owner's own highlights
+	err = setCategoryHighlight(tx, storeID, req.Category)
 	if err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store highlights"})
@@ -171,16 +207,10 @@ func UpdateBagDetails(c *gin.Context) {
 	}
 
 	// Update or insert bag details
-	_, err = tx.Exec(`
-        INSERT INTO bag_details 
-        (store_id, category, name, description, size, price, min_value, daily_count)
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-        ON CONFLICT (store_id) DO UPDATE
-        SET category = $2, name = $3, description = $4, size = $5, 
-            price = $6, min_value = $7, daily_count = $8`,
-		storeID, req.Category, req.Name, req.Description,
-		req.Size, price, minValue, req.DailyCount)
-
+	err = saveBagDetails(tx, storeID, req, dietaryTags)
+	if err == nil {
+		err = audit.record(c, tx, StoreAuditBagUpdated)
+	}
 	if err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update bag details"})
@@ -195,8 +225,27 @@ func UpdateBagDetails(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Store and bag details updated successfully"})
 }
 
+// saveBagDetails inserts or replaces the store's bag, priced by its size
+func saveBagDetails(tx sqlx.Execer, storeID string, req UpdateBagDetailsRequest, dietaryTags []string) error {
+	price, minValue := bagSizePricing(req.Size)
+	_, err := tx.Exec(`
+        INSERT INTO bag_details 
+        (store_id, category, name, description, size, price, min_value, daily_count, dietary_tags)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        ON CONFLICT (store_id) DO UPDATE
+        SET category = $2, name = $3, description = $4, size = $5, 
+            price = $6, min_value = $7, daily_count = $8, dietary_tags = $9`,
+		storeID, req.Category, req.Name, req.Description,
+		req.Size, price, minValue, req.DailyCount, pq.Array(dietaryTags))
+	return err
+}
+
 func UpdatePickupSchedule(c *gin.Context) {
 	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
 	var req UpdateScheduleRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -205,20 +254,25 @@ func UpdatePickupSchedule(c *gin.Context) {
 	}
 
 	// Get store ID
-	var storeID string
-	err := db.DB.QueryRow("SELECT id FROM stores WHERE owner_id = $1", userID).Scan(&storeID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Store not found"})
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
 		return
 	}
 
 	// Start transaction
-	tx, err := db.DB.Begin()
+	tx, err := db.DB.Beginx()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
 		return
 	}
 
+	audit, err := beginStoreAudit(tx, storeID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule"})
+		return
+	}
+
 	// Update store pickup time with the first enabled schedule
 	enabledSchedule := ""
 	for _, s := range req.Schedule {
@@ -232,8 +286,8 @@ func UpdatePickupSchedule(c *gin.Context) {
 		_, err = tx.Exec(`
             UPDATE stores 
             SET pickup_time = $1
-            WHERE owner_id = $2`,
-			enabledSchedule, userID)
+            WHERE id = $2`,
+			enabledSchedule, storeID)
 
 		if err != nil {
 			tx.Rollback()
@@ -266,6 +320,21 @@ func UpdatePickupSchedule(c *gin.Context) {
 		}
 	}
 
+	// Regenerate the dated pickup windows customers book
+	if err := syncPickupWindows(tx, storeID, time.Now()); err != nil {
+		tx.Rollback()
+		fmt.Printf("ERROR: Failed to generate pickup windows for store %s: %v\n", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update pickup windows"})
+		return
+	}
+
+	if err := audit.record(c, tx, StoreAuditPickupScheduleUpdated); err != nil {
+		tx.Rollback()
+		fmt.Printf("ERROR: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule"})
+		return
+	}
+
 	if err := tx.Commit(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
 		return
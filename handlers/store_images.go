@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"savor-server/db"
+	"savor-server/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxStoreImageBytes is the largest photo accepted; phone photos are usually 2-8 MB
+const maxStoreImageBytes = 10 << 20
+
+// storeImageColumns maps an upload kind to the stores column it replaces and the
+// variant written there: cards for the listing image, the full-width hero for the
+// background and the square thumbnail for the avatar
+var storeImageColumns = map[string]struct {
+	column  string
+	variant string
+}{
+	"image":      {"image_url", "card"},
+	"background": {"background_url", "hero"},
+	"avatar":     {"avatar_url", "thumbnail"},
+}
+
+type StoreImage struct {
+	ID           string    `json:"id" db:"id"`
+	Kind         string    `json:"kind" db:"kind"`
+	ThumbnailURL string    `json:"thumbnailUrl" db:"thumbnail_url"`
+	CardURL      string    `json:"cardUrl" db:"card_url"`
+	HeroURL      string    `json:"heroUrl" db:"hero_url"`
+	Width        int       `json:"width" db:"width"`
+	Height       int       `json:"height" db:"height"`
+	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
+}
+
+// UploadStoreImage accepts a multipart "image" file for the store's listing image,
+// background or avatar, stores the resized variants and points the store at them
+func UploadStoreImage(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	kind := c.Param("kind")
+	target, ok := storeImageColumns[kind]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Image kind must be 'image', 'background' or 'avatar'"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
+		return
+	}
+
+	if services.Storage == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Image uploads are not configured"})
+		return
+	}
+
+	// Leave room for the multipart headers around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxStoreImageBytes+1<<20)
+	fileHeader, err := c.FormFile("image")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image must be at most 10 MB"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing image file"})
+		return
+	}
+	if fileHeader.Size > maxStoreImageBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image must be at most 10 MB"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read image"})
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxStoreImageBytes+1))
+	file.Close()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read image"})
+		return
+	}
+
+	// The declared Content-Type is not trusted; the content decides
+	processed, err := services.ProcessImage(data, services.StoreImageVariants)
+	if err != nil {
+		if err == services.ErrUnsupportedImage {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Only JPEG and PNG images are supported"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prefix := "stores/" + storeID + "/" + kind + "/" + uuid.New().String()
+
+	urls := make(map[string]string, len(processed))
+	var width, height int
+	for _, p := range processed {
+		url, err := services.Storage.Put(c.Request.Context(), prefix+"/"+p.Variant.Name+".jpg", "image/jpeg", p.Data)
+		if err != nil {
+			log.Printf("ERROR: Failed to store %s image for store %s: %v", p.Variant.Name, storeID, err)
+			deleteStoreImageObjects(prefix)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to upload image"})
+			return
+		}
+		urls[p.Variant.Name] = url
+		if p.Variant.Name == "hero" {
+			width, height = p.Width, p.Height
+		}
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		deleteStoreImageObjects(prefix)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	audit, err := beginStoreAudit(tx, storeID)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		deleteStoreImageObjects(prefix)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
+		return
+	}
+
+	before, err := loadStoreMaterial(tx, storeID)
+	if err != nil {
+		deleteStoreImageObjects(prefix)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
+		return
+	}
+
+	var image StoreImage
+	err = tx.Get(&image, `
+		INSERT INTO store_images (store_id, kind, storage_prefix, thumbnail_url, card_url, hero_url, width, height, uploaded_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, kind, thumbnail_url, card_url, hero_url, width, height, created_at
+	`, storeID, kind, prefix, urls["thumbnail"], urls["card"], urls["hero"], width, height, userID)
+	if err == nil {
+		// target.column comes from storeImageColumns, never from the request
+		_, err = tx.Exec(`UPDATE stores SET `+target.column+` = $1, updated_at = NOW() WHERE id = $2`, urls[target.variant], storeID)
+	}
+	if err == nil {
+		err = flagMaterialEdits(tx, storeID, userID, before)
+	}
+	if err == nil {
+		err = audit.record(c, tx, StoreAuditImageUploaded)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to save %s image for store %s: %v", kind, storeID, err)
+		deleteStoreImageObjects(prefix)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"image": image,
+		"url":   urls[target.variant],
+	})
+}
+
+// deleteStoreImageObjects cleans up the variants of an upload that was not saved
+func deleteStoreImageObjects(prefix string) {
+	for _, v := range services.StoreImageVariants {
+		if err := services.Storage.Delete(context.Background(), prefix+"/"+v.Name+".jpg"); err != nil {
+			log.Printf("WARNING: Failed to delete %s/%s.jpg: %v", prefix, v.Name, err)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"savor-server/db"
+
+	"github.com/lib/pq"
+)
+
+// Store import CSV columns. Headers are matched case-insensitively; "id" is accepted for
+// external_key and "street" for address so the seed spreadsheet imports as it is. Lists
+// (highlights, dietary_tags, bag_dietary_tags) are separated by "|". On update an empty
+// optional cell keeps the store's current value.
+//
+//	external_key  required, the row's stable key; re-importing updates the same store
+//	title         required
+//	address       street address; with city, state, zip_code and country it is geocoded
+//	              when latitude and longitude are empty
+//	latitude, longitude, timezone, store_type, description, phone, pickup_time
+//	price, original_price, image_url, background_url, avatar_url
+//	owner_id      Firebase UID of the owner; defaults to the -owner flag
+//	highlights, dietary_tags
+//	bag_name, bag_category, bag_description, bag_size, daily_count, bag_dietary_tags
+//	              the surprise bag; bag_name, bag_size and daily_count are needed together
+const storeImportListSeparator = "|"
+
+var storeImportHeaderAliases = map[string]string{
+	"id":     "external_key",
+	"street": "address",
+	"name":   "title",
+}
+
+const (
+	StoreImportCreated = "created"
+	StoreImportUpdated = "updated"
+	StoreImportFailed  = "failed"
+)
+
+type StoreImportOptions struct {
+	// DryRun validates, geocodes and writes each row, then rolls it back
+	DryRun bool
+	// OwnerID owns stores whose row has no owner_id; empty leaves them without an owner
+	OwnerID string
+}
+
+// StoreImportResult is the outcome of one CSV row; Row is the line the row starts on
+type StoreImportResult struct {
+	Row               int    `json:"row"`
+	ExternalKey       string `json:"externalKey"`
+	StoreID           string `json:"storeId,omitempty"`
+	Action            string `json:"action"`
+	Error             string `json:"error,omitempty"`
+	NeedsConfirmation bool   `json:"needsConfirmation,omitempty"`
+}
+
+type storeImportRow struct {
+	ExternalKey   string
+	OwnerID       string
+	Title         string
+	Description   string
+	StoreType     string
+	Street        string
+	City          string
+	State         string
+	ZipCode       string
+	Country       string
+	Phone         string
+	Latitude      float64
+	Longitude     float64
+	Timezone      string
+	PickupTime    string
+	Price         sql.NullFloat64
+	OriginalPrice sql.NullFloat64
+	ImageURL      string
+	BackgroundURL string
+	AvatarURL     string
+
+	HighlightTexts   []string
+	HighlightSources []string
+	DietaryTags      []string
+
+	// Bag is nil when the row has no bag columns filled in
+	Bag            *UpdateBagDetailsRequest
+	BagDietaryTags []string
+}
+
+// ImportStores upserts the stores of a CSV file, one transaction per row so a bad row
+// does not stop the rest. The error is for problems with the file as a whole; row
+// problems are reported in the results.
+func ImportStores(ctx context.Context, r io.Reader, opts StoreImportOptions) ([]StoreImportResult, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		name = strings.NewReplacer(" ", "_", "-", "_").Replace(name)
+		if alias, ok := storeImportHeaderAliases[name]; ok {
+			name = alias
+		}
+		columns[name] = i
+	}
+	for _, required := range []string{"external_key", "title"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV has no %s column", required)
+		}
+	}
+
+	var results []StoreImportResult
+	firstRow := make(map[string]int)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		result := StoreImportResult{Action: StoreImportFailed}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				result.Row = parseErr.StartLine
+			}
+			result.Error = err.Error()
+			results = append(results, result)
+			// The reader carries on after a malformed row
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		result.Row = line
+		get := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		result.ExternalKey = get("external_key")
+
+		if first, ok := firstRow[result.ExternalKey]; ok && result.ExternalKey != "" {
+			result.Error = fmt.Sprintf("external_key %s is already used on row %d", result.ExternalKey, first)
+			results = append(results, result)
+			continue
+		}
+		firstRow[result.ExternalKey] = line
+
+		row, err := parseStoreImportRow(get)
+		if err == nil && row.OwnerID == "" {
+			row.OwnerID = opts.OwnerID
+		}
+		var location *StoreCoordinates
+		if err == nil {
+			location, err = resolveStoreCoordinates(ctx, StoreAddress{
+				Street:    row.Street,
+				City:      row.City,
+				State:     row.State,
+				Country:   row.Country,
+				Latitude:  row.Latitude,
+				Longitude: row.Longitude,
+			})
+		}
+		if err == nil {
+			result.NeedsConfirmation = location.NeedsConfirmation
+			result.StoreID, result.Action, err = importStoreRow(row, location, opts.DryRun)
+		}
+		if err != nil {
+			result.Action = StoreImportFailed
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// parseStoreImportRow validates one row
+func parseStoreImportRow(get func(column string) string) (storeImportRow, error) {
+	row := storeImportRow{
+		ExternalKey:   get("external_key"),
+		OwnerID:       get("owner_id"),
+		Title:         get("title"),
+		Description:   get("description"),
+		StoreType:     get("store_type"),
+		Street:        get("address"),
+		City:          get("city"),
+		State:         get("state"),
+		ZipCode:       get("zip_code"),
+		Country:       get("country"),
+		Phone:         get("phone"),
+		Timezone:      get("timezone"),
+		PickupTime:    get("pickup_time"),
+		ImageURL:      get("image_url"),
+		BackgroundURL: get("background_url"),
+		AvatarURL:     get("avatar_url"),
+	}
+	if row.ExternalKey == "" {
+		return row, errors.New("external_key is required")
+	}
+	if len(row.ExternalKey) > 100 {
+		return row, errors.New("external_key must be at most 100 characters")
+	}
+	if row.Title == "" {
+		return row, errors.New("title is required")
+	}
+	if row.Timezone != "" && !isValidTimezone(row.Timezone) {
+		return row, fmt.Errorf("invalid timezone %q", row.Timezone)
+	}
+
+	lat, lng := get("latitude"), get("longitude")
+	if (lat == "") != (lng == "") {
+		return row, errors.New("latitude and longitude must be given together")
+	}
+	if lat != "" {
+		var errLat, errLng error
+		row.Latitude, errLat = strconv.ParseFloat(lat, 64)
+		row.Longitude, errLng = strconv.ParseFloat(lng, 64)
+		if errLat != nil || errLng != nil {
+			return row, errors.New("latitude and longitude must be numbers")
+		}
+	} else if row.Street == "" {
+		return row, errors.New("address is required without latitude and longitude")
+	}
+
+	var err error
+	if row.Price, err = parseImportPrice(get("price")); err != nil {
+		return row, fmt.Errorf("price: %v", err)
+	}
+	if row.OriginalPrice, err = parseImportPrice(get("original_price")); err != nil {
+		return row, fmt.Errorf("original_price: %v", err)
+	}
+
+	if row.HighlightTexts, row.HighlightSources, err = normalizeHighlightList(splitImportList(get("highlights"))); err != nil {
+		return row, fmt.Errorf("highlights: %v", err)
+	}
+	if row.DietaryTags, err = normalizeDietaryTags(splitImportList(get("dietary_tags"))); err != nil {
+		return row, fmt.Errorf("dietary_tags: %v", err)
+	}
+
+	bag := UpdateBagDetailsRequest{
+		Category:    get("bag_category"),
+		Name:        get("bag_name"),
+		Description: get("bag_description"),
+		Size:        strings.ToLower(get("bag_size")),
+	}
+	dailyCount := get("daily_count")
+	if bag.Name != "" || bag.Category != "" || bag.Size != "" || dailyCount != "" {
+		if bag.Name == "" || bag.Size == "" || dailyCount == "" {
+			return row, errors.New("bag_name, bag_size and daily_count are required for a bag")
+		}
+		if bag.Size != "small" && bag.Size != "medium" && bag.Size != "large" {
+			return row, errors.New("bag_size must be small, medium or large")
+		}
+		if bag.DailyCount, err = strconv.Atoi(dailyCount); err != nil || bag.DailyCount < 1 {
+			return row, errors.New("daily_count must be a whole number of at least 1")
+		}
+		if row.BagDietaryTags, err = normalizeDietaryTags(splitImportList(get("bag_dietary_tags"))); err != nil {
+			return row, fmt.Errorf("bag_dietary_tags: %v", err)
+		}
+		row.Bag = &bag
+	}
+	return row, nil
+}
+
+func parseImportPrice(value string) (sql.NullFloat64, error) {
+	if value == "" {
+		return sql.NullFloat64{}, nil
+	}
+	price, err := strconv.ParseFloat(value, 64)
+	if err != nil || price < 0 {
+		return sql.NullFloat64{}, fmt.Errorf("invalid amount %q", value)
+	}
+	return sql.NullFloat64{Float64: price, Valid: true}, nil
+}
+
+func splitImportList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, storeImportListSeparator) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func optionalString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// importStoreRow upserts the store with its owner, highlights and bag in one transaction,
+// which a dry run rolls back
+func importStoreRow(row storeImportRow, location *StoreCoordinates, dryRun bool) (storeID, action string, err error) {
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	category, err := categoryForStoreType(tx, row.StoreType)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve store category: %v", err)
+	}
+
+	timezone := row.Timezone
+	if timezone == "" {
+		timezone = defaultTimezoneFor(row.Country, location.Latitude, location.Longitude)
+	}
+
+	// A bag prices the store unless the row has its own prices
+	price, originalPrice, itemsLeft := row.Price, row.OriginalPrice, sql.NullInt64{}
+	if row.Bag != nil {
+		bagPrice, minValue := bagSizePricing(row.Bag.Size)
+		if !price.Valid {
+			price = sql.NullFloat64{Float64: bagPrice, Valid: true}
+		}
+		if !originalPrice.Valid {
+			originalPrice = sql.NullFloat64{Float64: minValue, Valid: true}
+		}
+		itemsLeft = sql.NullInt64{Int64: int64(row.Bag.DailyCount), Valid: true}
+	}
+
+	var dietaryTags interface{}
+	if len(row.DietaryTags) > 0 {
+		dietaryTags = pq.Array(row.DietaryTags)
+	}
+
+	var created bool
+	err = tx.QueryRow(`
+		INSERT INTO stores (
+			external_key, owner_id, title, description, store_type, category_slug,
+			address, city, state, zip_code, country, phone,
+			latitude, longitude, google_maps_url, timezone, pickup_time,
+			price, original_price, items_left, image_url, background_url, avatar_url,
+			dietary_tags, is_selling
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17,
+			$18, $19, $20, $21, $22, $23, COALESCE($24::text[], '{}'), false
+		)
+		ON CONFLICT (external_key) DO UPDATE SET
+			owner_id = COALESCE(EXCLUDED.owner_id, stores.owner_id),
+			title = EXCLUDED.title,
+			description = COALESCE(EXCLUDED.description, stores.description),
+			store_type = COALESCE(EXCLUDED.store_type, stores.store_type),
+			category_slug = COALESCE(EXCLUDED.category_slug, stores.category_slug),
+			address = EXCLUDED.address,
+			city = COALESCE(EXCLUDED.city, stores.city),
+			state = COALESCE(EXCLUDED.state, stores.state),
+			zip_code = COALESCE(EXCLUDED.zip_code, stores.zip_code),
+			country = COALESCE(EXCLUDED.country, stores.country),
+			phone = COALESCE(EXCLUDED.phone, stores.phone),
+			latitude = EXCLUDED.latitude,
+			longitude = EXCLUDED.longitude,
+			google_maps_url = EXCLUDED.google_maps_url,
+			timezone = COALESCE(NULLIF($25, ''), stores.timezone),
+			pickup_time = COALESCE(EXCLUDED.pickup_time, stores.pickup_time),
+			price = COALESCE(EXCLUDED.price, stores.price),
+			original_price = COALESCE(EXCLUDED.original_price, stores.original_price),
+			items_left = COALESCE(EXCLUDED.items_left, stores.items_left),
+			image_url = COALESCE(NULLIF(EXCLUDED.image_url, ''), stores.image_url),
+			background_url = COALESCE(NULLIF(EXCLUDED.background_url, ''), stores.background_url),
+			avatar_url = COALESCE(EXCLUDED.avatar_url, stores.avatar_url),
+			dietary_tags = CASE WHEN $24::text[] IS NULL THEN stores.dietary_tags ELSE EXCLUDED.dietary_tags END,
+			updated_at = NOW()
+		RETURNING id, (xmax = 0) as created
+	`, row.ExternalKey, optionalString(row.OwnerID), row.Title, optionalString(row.Description),
+		optionalString(row.StoreType), category,
+		joinAddress(row.Street, row.City, strings.TrimSpace(row.State+" "+row.ZipCode), row.Country),
+		optionalString(row.City), optionalString(row.State), optionalString(row.ZipCode),
+		optionalString(row.Country), optionalString(row.Phone),
+		location.Latitude, location.Longitude, location.GoogleMapsURL, timezone, optionalString(row.PickupTime),
+		price, originalPrice, itemsLeft, row.ImageURL, row.BackgroundURL, optionalString(row.AvatarURL),
+		dietaryTags, row.Timezone,
+	).Scan(&storeID, &created)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to save store: %v", err)
+	}
+
+	if row.OwnerID != "" {
+		_, err = tx.Exec(`
+			INSERT INTO store_members (store_id, user_id, role) VALUES ($1, $2, $3)
+			ON CONFLICT (store_id, user_id) DO NOTHING
+		`, storeID, row.OwnerID, StoreRoleOwner)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to add store owner: %v", err)
+		}
+	}
+
+	if len(row.HighlightTexts) > 0 {
+		if err := replaceStoreHighlights(tx, storeID, row.HighlightTexts, row.HighlightSources); err != nil {
+			return "", "", fmt.Errorf("failed to save highlights: %v", err)
+		}
+	}
+
+	if row.Bag != nil {
+		if err := saveBagDetails(tx, storeID, *row.Bag, row.BagDietaryTags); err != nil {
+			return "", "", fmt.Errorf("failed to save bag details: %v", err)
+		}
+		if err := setCategoryHighlight(tx, storeID, row.Bag.Category); err != nil {
+			return "", "", fmt.Errorf("failed to save highlights: %v", err)
+		}
+	}
+
+	action = StoreImportUpdated
+	if created {
+		action = StoreImportCreated
+	}
+	if dryRun {
+		return storeID, action, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return "", "", fmt.Errorf("failed to commit: %v", err)
+	}
+	return storeID, action, nil
+}
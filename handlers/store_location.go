@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"savor-server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxStoreDistanceFromCityKm rejects pins that are clearly not in the stated city; it is
+// generous because city centroids can be far from suburbs
+const maxStoreDistanceFromCityKm = 50
+
+// StoreAddress is the address and optional pin an owner submits for a store
+type StoreAddress struct {
+	Street    string  `json:"street"`
+	City      string  `json:"city"`
+	State     string  `json:"state"`
+	Country   string  `json:"country"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// StoreCoordinates is where a store was placed. Geocoded is true when the coordinates
+// came from the address rather than the owner's pin; NeedsConfirmation asks the owner to
+// check the pin because the geocoder only matched the street or area.
+type StoreCoordinates struct {
+	Latitude          float64 `json:"latitude"`
+	Longitude         float64 `json:"longitude"`
+	FormattedAddress  string  `json:"formattedAddress,omitempty"`
+	GoogleMapsURL     string  `json:"googleMapsUrl"`
+	Geocoded          bool    `json:"geocoded"`
+	NeedsConfirmation bool    `json:"needsConfirmation"`
+}
+
+// storeLocationError is a problem the owner can fix by correcting the address or pin
+type storeLocationError struct {
+	message string
+}
+
+func (e *storeLocationError) Error() string {
+	return e.message
+}
+
+// joinAddress joins the non-empty parts with commas, skipping parts the address already
+// lists so a full street address followed by its city does not repeat the city
+func joinAddress(parts ...string) string {
+	var joined []string
+	seen := make(map[string]bool)
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p == "" || seen[strings.ToLower(p)] {
+			continue
+		}
+		joined = append(joined, p)
+		for _, part := range strings.Split(p, ",") {
+			seen[strings.ToLower(strings.TrimSpace(part))] = true
+		}
+	}
+	return strings.Join(joined, ", ")
+}
+
+// resolveStoreCoordinates geocodes the address when no pin was given and checks that the
+// resulting location is near the stated city
+func resolveStoreCoordinates(ctx context.Context, addr StoreAddress) (*StoreCoordinates, error) {
+	if addr.Latitude < -90 || addr.Latitude > 90 || addr.Longitude < -180 || addr.Longitude > 180 {
+		return nil, &storeLocationError{"Latitude or longitude is out of range"}
+	}
+
+	location := &StoreCoordinates{Latitude: addr.Latitude, Longitude: addr.Longitude}
+	hasPin := addr.Latitude != 0 || addr.Longitude != 0
+
+	if services.Geocoding == nil {
+		if !hasPin {
+			return nil, &storeLocationError{"Latitude and longitude are required because the address cannot be located automatically"}
+		}
+		location.GoogleMapsURL = services.MapsURL(location.Latitude, location.Longitude, "")
+		return location, nil
+	}
+
+	placeID := ""
+	if !hasPin {
+		result, err := services.Geocoding.Geocode(ctx, joinAddress(addr.Street, addr.City, addr.State, addr.Country))
+		if errors.Is(err, services.ErrAddressNotFound) {
+			return nil, &storeLocationError{"The address could not be found on the map; check it or set the pin by hand"}
+		}
+		if err != nil {
+			return nil, err
+		}
+		location.Latitude, location.Longitude = result.Latitude, result.Longitude
+		location.FormattedAddress = result.FormattedAddress
+		location.Geocoded = true
+		location.NeedsConfirmation = !result.Precise
+		placeID = result.PlaceID
+	}
+
+	if strings.TrimSpace(addr.City) != "" {
+		city, err := services.Geocoding.Geocode(ctx, joinAddress(addr.City, addr.State, addr.Country))
+		if err != nil {
+			// Not being able to check is no reason to turn the owner away
+			log.Printf("WARNING: Failed to geocode city %q: %v", addr.City, err)
+		} else if km := services.HaversineMeters(location.Latitude, location.Longitude, city.Latitude, city.Longitude) / 1000; km > maxStoreDistanceFromCityKm {
+			return nil, &storeLocationError{fmt.Sprintf("The store location is %.0f km from %s; check the address or move the pin", km, addr.City)}
+		}
+	}
+
+	location.GoogleMapsURL = services.MapsURL(location.Latitude, location.Longitude, placeID)
+	return location, nil
+}
+
+// respondStoreLocationError reports a failed resolveStoreCoordinates and reports whether
+// there was an error
+func respondStoreLocationError(c *gin.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	var locationErr *storeLocationError
+	if errors.As(err, &locationErr) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": locationErr.message})
+		return true
+	}
+	log.Printf("ERROR: Failed to geocode store address: %v", err)
+	c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to look up the address"})
+	return true
+}
+
+// LocateStoreAddress previews where an address lands so the owner can confirm or move
+// the pin before saving the store
+func LocateStoreAddress(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var addr StoreAddress
+	if err := c.ShouldBindJSON(&addr); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strings.TrimSpace(addr.Street) == "" && addr.Latitude == 0 && addr.Longitude == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A street address or a pin is required"})
+		return
+	}
+
+	location, err := resolveStoreCoordinates(c.Request.Context(), addr)
+	if respondStoreLocationError(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"location": location})
+}
@@ -6,13 +6,14 @@ import (
 	"net/http"
 	"savor-server/db"
 	"savor-server/models"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type BusinessDetails struct {
 	StoreName     string  `json:"businessName" binding:"required"`
-	StoreType     string  `json:"storeType" binding:"required"`
+	StoreType     string  `json:"storeType" binding:"required"` // free text or a category slug; mapped to a category
 	Street        string  `json:"street" binding:"required"`
 	City          string  `json:"city" binding:"required"`
 	State         string  `json:"state" binding:"required"`
@@ -21,8 +22,10 @@ type BusinessDetails struct {
 	Phone         string  `json:"phone" binding:"required"`
 	Latitude      float64 `json:"latitude"`
 	Longitude     float64 `json:"longitude"`
-	BackgroundUrl string  `json:"backgroundUrl" binding:"required"`
-	ImageUrl      string  `json:"imageUrl" binding:"required"`
+	BackgroundUrl string  `json:"backgroundUrl"` // optional: both photos can be uploaded later via UploadStoreImage
+	ImageUrl      string  `json:"imageUrl"`
+	// Timezone is an IANA zone; derived from the country and coordinates if empty
+	Timezone string `json:"timezone"`
 }
 
 type StoreResponse struct {
@@ -38,6 +41,15 @@ type StoreResponse struct {
 	Phone       string  `json:"phone"`
 	Latitude    float64 `json:"latitude"`
 	Longitude   float64 `json:"longitude"`
+	Timezone    string  `json:"timezone"`
+	// One of draft, pending_review, approved or suspended
+	ReviewStatus string   `json:"reviewStatus"`
+	DietaryTags  []string `json:"dietaryTags"`
+	Category     string   `json:"category"`
+	// Weekly hours; the open state is worked out in the store's zone
+	BusinessHours   []models.BusinessHours `json:"businessHours"`
+	IsOpenNow       bool                   `json:"isOpenNow"`
+	NextOpeningTime *time.Time             `json:"nextOpeningTime"`
 }
 
 func CreateStore(c *gin.Context) {
@@ -53,6 +65,34 @@ func CreateStore(c *gin.Context) {
 		return
 	}
 
+	location, err := resolveStoreCoordinates(c.Request.Context(), StoreAddress{
+		Street:    details.Street,
+		City:      details.City,
+		State:     details.State,
+		Country:   details.Country,
+		Latitude:  details.Latitude,
+		Longitude: details.Longitude,
+	})
+	if respondStoreLocationError(c, err) {
+		return
+	}
+	details.Latitude, details.Longitude = location.Latitude, location.Longitude
+
+	timezone := details.Timezone
+	if timezone == "" {
+		timezone = defaultTimezoneFor(details.Country, details.Latitude, details.Longitude)
+	} else if !isValidTimezone(timezone) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timezone"})
+		return
+	}
+
+	category, err := categoryForStoreType(db.DB, details.StoreType)
+	if err != nil {
+		fmt.Println("Error resolving store category:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create store"})
+		return
+	}
+
 	tx, err := db.DB.Begin()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
@@ -77,13 +117,15 @@ func CreateStore(c *gin.Context) {
 	err = tx.QueryRow(`
         INSERT INTO stores (
             owner_id, title, store_type, address, city, state, zip_code,
-            phone, latitude, longitude, description, background_url, image_url, price, is_selling
-        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+            phone, latitude, longitude, description, background_url, image_url, price, is_selling,
+            timezone, category_slug, google_maps_url
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
         RETURNING id`,
 		userID, details.StoreName, details.StoreType, fullAddress,
 		details.City, details.State, details.ZipCode,
 		details.Phone, details.Latitude, details.Longitude, sql.NullString{},
 		details.BackgroundUrl, details.ImageUrl, 5, false,
+		timezone, category, location.GoogleMapsURL,
 	).Scan(&storeID)
 
 	if err != nil {
@@ -92,6 +134,18 @@ func CreateStore(c *gin.Context) {
 		return
 	}
 
+	// The creator is the store's first owner member
+	_, err = tx.Exec(`
+        INSERT INTO store_members (store_id, user_id, role)
+        VALUES ($1, $2, 'owner')`,
+		storeID, userID)
+
+	if err != nil {
+		fmt.Println("Error adding store owner:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create store"})
+		return
+	}
+
 	if err = tx.Commit(); err != nil {
 		fmt.Println("Error committing transaction:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
@@ -99,8 +153,9 @@ func CreateStore(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "Store created successfully",
-		"id":      storeID,
+		"message":  "Store created successfully",
+		"id":       storeID,
+		"location": location,
 	})
 }
 
@@ -111,6 +166,11 @@ func GetMyStore(c *gin.Context) {
 		return
 	}
 
+	storeID, ok := authorizeStore(c, PermViewStore)
+	if !ok {
+		return
+	}
+
 	var modelStore models.Store
 	err := db.DB.Get(&modelStore, `
         SELECT 
@@ -125,10 +185,15 @@ func GetMyStore(c *gin.Context) {
             country,
             phone,
             latitude,
-            longitude
+            longitude,
+            timezone,
+            review_status,
+            dietary_tags,
+            category_slug,
+            business_hours
         FROM stores 
-        WHERE owner_id = $1
-    `, userID)
+        WHERE id = $1
+    `, storeID)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -171,19 +236,37 @@ func GetMyStore(c *gin.Context) {
 		country = modelStore.Country.String
 	}
 
+	hours, err := modelStore.GetBusinessHours()
+	if err != nil {
+		fmt.Println("Error reading business hours:", err)
+		hours = []models.BusinessHours{}
+	}
+	applyStoreOpeningHours(&modelStore, nil, time.Now())
+	var nextOpening *time.Time
+	if modelStore.NextOpeningTime.Valid {
+		nextOpening = &modelStore.NextOpeningTime.Time
+	}
+
 	response := StoreResponse{
-		ID:          modelStore.ID,
-		Title:       modelStore.Title,
-		Description: description,
-		StoreType:   modelStore.StoreType.String,
-		Address:     modelStore.Address,
-		City:        city,
-		State:       state,
-		ZipCode:     zipCode,
-		Country:     country,
-		Phone:       phone,
-		Latitude:    modelStore.Latitude,
-		Longitude:   modelStore.Longitude,
+		ID:              modelStore.ID,
+		Title:           modelStore.Title,
+		Description:     description,
+		StoreType:       modelStore.StoreType.String,
+		Address:         modelStore.Address,
+		City:            city,
+		State:           state,
+		ZipCode:         zipCode,
+		Country:         country,
+		Phone:           phone,
+		Latitude:        modelStore.Latitude,
+		Longitude:       modelStore.Longitude,
+		Timezone:        modelStore.Timezone,
+		ReviewStatus:    modelStore.ReviewStatus,
+		DietaryTags:     modelStore.DietaryTags,
+		Category:        modelStore.CategorySlug.String,
+		BusinessHours:   hours,
+		IsOpenNow:       modelStore.IsOpenNow,
+		NextOpeningTime: nextOpening,
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -202,32 +285,102 @@ func UpdateStore(c *gin.Context) {
 		return
 	}
 
+	if store.Timezone != "" && !isValidTimezone(store.Timezone) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timezone"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
+		return
+	}
+
+	// Address holds the full street address; a zero pin is geocoded from it
+	location, err := resolveStoreCoordinates(c.Request.Context(), StoreAddress{
+		Street:    store.Address,
+		City:      store.City.String,
+		State:     store.State.String,
+		Country:   store.Country.String,
+		Latitude:  store.Latitude,
+		Longitude: store.Longitude,
+	})
+	if respondStoreLocationError(c, err) {
+		return
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store"})
+		return
+	}
+	defer tx.Rollback()
+
+	audit, err := beginStoreAudit(tx, storeID)
+	if err != nil {
+		fmt.Println("Error snapshotting store:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store"})
+		return
+	}
+
+	before, err := loadStoreMaterial(tx, storeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store"})
+		return
+	}
+
+	category, err := categoryForStoreType(tx, store.StoreType.String)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store"})
+		return
+	}
+
+	// An empty timezone keeps the current one
 	query := `
         UPDATE stores 
         SET title = $1, description = $2, address = $3, city = $4, 
             state = $5, zip_code = $6, phone = $7, store_type = $8,
-            latitude = $9, longitude = $10
-        WHERE owner_id = $11
+            latitude = $9, longitude = $10, timezone = COALESCE(NULLIF($11, ''), timezone),
+            category_slug = $12, google_maps_url = $13
+        WHERE id = $14
         RETURNING id`
 
-	var storeID string
-	err := db.DB.QueryRow(
+	err = tx.QueryRow(
 		query,
 		store.Title, store.Description, store.Address, store.City,
 		store.State, store.ZipCode, store.Phone, store.StoreType,
-		store.Latitude, store.Longitude, userID,
+		location.Latitude, location.Longitude, store.Timezone, category, location.GoogleMapsURL, storeID,
 	).Scan(&storeID)
 
+	if err == nil {
+		err = flagMaterialEdits(tx, storeID, userID, before)
+	}
+	if err == nil {
+		err = audit.record(c, tx, StoreAuditStoreUpdated)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"id": storeID})
+	// Pickup windows are local times, so they move with the zone
+	if store.Timezone != "" {
+		if err := refreshStorePickupWindows(storeID, time.Now()); err != nil {
+			fmt.Printf("WARNING: Failed to regenerate pickup windows for store %s: %v\n", storeID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": storeID, "location": location})
 }
 
 func ToggleStoreSelling(c *gin.Context) {
 	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
 
 	var req struct {
 		IsSelling bool `json:"is_selling"`
@@ -238,13 +391,42 @@ func ToggleStoreSelling(c *gin.Context) {
 		return
 	}
 
-	_, err := db.DB.Exec(`
-		UPDATE stores 
-		SET is_selling = $1 
-		WHERE owner_id = $2`,
-		req.IsSelling, userID)
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store status"})
+		return
+	}
+	defer tx.Rollback()
 
+	audit, err := beginStoreAudit(tx, storeID)
+	var status string
+	if err == nil {
+		err = tx.Get(&status, `SELECT review_status FROM stores WHERE id = $1`, storeID)
+	}
+	if err == nil && status == StoreReviewClosed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Store is closed; submit it for review to reopen it"})
+		return
+	}
+	if err == nil {
+		_, err = tx.Exec(`
+		UPDATE stores 
+		SET is_selling = $1, sold_out_paused = false
+		WHERE id = $2`,
+			req.IsSelling, storeID)
+	}
+	if err == nil {
+		err = audit.record(c, tx, StoreAuditSellingToggled)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
+		fmt.Println("Error updating store status:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store status"})
 		return
 	}
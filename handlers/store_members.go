@@ -0,0 +1,489 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"savor-server/db"
+	"savor-server/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// Store invitation statuses (see check_store_invitation_status)
+const (
+	InvitationStatusPending  = "pending"
+	InvitationStatusAccepted = "accepted"
+	InvitationStatusRevoked  = "revoked"
+)
+
+const storeInvitationTTL = 7 * 24 * time.Hour
+
+type StoreMember struct {
+	UserID      string    `json:"userId" db:"user_id"`
+	Role        string    `json:"role" db:"role"`
+	InvitedBy   *string   `json:"invitedBy" db:"invited_by"`
+	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+	Permissions []string  `json:"permissions" db:"-"`
+}
+
+type StoreInvitation struct {
+	ID         string     `json:"id" db:"id"`
+	Email      string     `json:"email" db:"email"`
+	Role       string     `json:"role" db:"role"`
+	Status     string     `json:"status" db:"status"`
+	InvitedBy  string     `json:"invitedBy" db:"invited_by"`
+	ExpiresAt  time.Time  `json:"expiresAt" db:"expires_at"`
+	AcceptedBy *string    `json:"acceptedBy" db:"accepted_by"`
+	AcceptedAt *time.Time `json:"acceptedAt" db:"accepted_at"`
+	CreatedAt  time.Time  `json:"createdAt" db:"created_at"`
+}
+
+type CreateStoreInvitationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required"`
+}
+
+type UpdateStoreMemberRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// generateInvitationToken returns a random 64 character hex token
+func generateInvitationToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// invitationAcceptURL links to the web app page that posts the token back to
+// /api/store-invitations/accept once the user is signed in
+func invitationAcceptURL(token string) string {
+	base := os.Getenv("FRONTEND_URL")
+	if base == "" {
+		base = "https://savor-web-lemon.vercel.app"
+	}
+	return strings.TrimRight(base, "/") + "/store-invitations/accept?token=" + url.QueryEscape(token)
+}
+
+// CreateStoreInvitation invites someone by email to join the store as manager or staff
+func CreateStoreInvitation(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req CreateStoreInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Ownership is never handed out by invitation
+	if req.Role != StoreRoleManager && req.Role != StoreRoleStaff {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be 'manager' or 'staff'"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStaff)
+	if !ok {
+		return
+	}
+
+	token, err := generateInvitationToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+
+	var invitation StoreInvitation
+	err = db.DB.Get(&invitation, `
+		INSERT INTO store_invitations (store_id, email, role, token, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, email, role, status, invited_by, expires_at, accepted_by, accepted_at, created_at
+	`, storeID, strings.ToLower(strings.TrimSpace(req.Email)), req.Role, token, userID, time.Now().Add(storeInvitationTTL))
+	if err != nil {
+		log.Printf("ERROR: Failed to create invitation for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+
+	var storeName string
+	if err := db.DB.Get(&storeName, `SELECT title FROM stores WHERE id = $1`, storeID); err != nil {
+		log.Printf("WARNING: Failed to load store name for %s: %v", storeID, err)
+	}
+
+	acceptURL := invitationAcceptURL(token)
+	emailService := services.GetEmailService()
+	emailSent := false
+	if emailService.IsConfigured() {
+		err := emailService.SendStoreInvitation(invitation.Email, services.StoreInvitationEmailData{
+			StoreName: storeName,
+			Role:      invitation.Role,
+			AcceptURL: acceptURL,
+			ExpiresAt: invitation.ExpiresAt.In(storeLocationByID(storeID)),
+		})
+		if err != nil {
+			log.Printf("WARNING: Failed to send invitation email to %s: %v", invitation.Email, err)
+		} else {
+			emailSent = true
+		}
+	}
+
+	// The link is returned too so the owner can share it if the email does not arrive
+	c.JSON(http.StatusCreated, gin.H{
+		"invitation": invitation,
+		"inviteUrl":  acceptURL,
+		"emailSent":  emailSent,
+	})
+}
+
+// ListStoreInvitations lists the store's invitations, newest first
+func ListStoreInvitations(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStaff)
+	if !ok {
+		return
+	}
+
+	invitations := make([]StoreInvitation, 0)
+	err := db.DB.Select(&invitations, `
+		SELECT id, email, role, status, invited_by, expires_at, accepted_by, accepted_at, created_at
+		FROM store_invitations
+		WHERE store_id = $1
+		ORDER BY created_at DESC
+	`, storeID)
+	if err != nil {
+		log.Printf("ERROR: Failed to list invitations for store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get invitations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+}
+
+// RevokeStoreInvitation cancels a pending invitation so its link stops working
+func RevokeStoreInvitation(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStaff)
+	if !ok {
+		return
+	}
+
+	result, err := db.DB.Exec(`
+		UPDATE store_invitations SET status = $1
+		WHERE id = $2 AND store_id = $3 AND status = $4
+	`, InvitationStatusRevoked, c.Param("id"), storeID, InvitationStatusPending)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invitation"})
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pending invitation not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked"})
+}
+
+// AcceptStoreInvitation joins the signed-in user to the store the token was issued for
+func AcceptStoreInvitation(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	var invitation struct {
+		ID        string    `db:"id"`
+		StoreID   string    `db:"store_id"`
+		Role      string    `db:"role"`
+		InvitedBy string    `db:"invited_by"`
+		Status    string    `db:"status"`
+		ExpiresAt time.Time `db:"expires_at"`
+	}
+	err = tx.Get(&invitation, `
+		SELECT id, store_id, role, invited_by, status, expires_at
+		FROM store_invitations
+		WHERE token = $1
+		FOR UPDATE
+	`, strings.TrimSpace(req.Token))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get invitation"})
+		return
+	}
+	if invitation.Status != InvitationStatusPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Invitation has already been " + invitation.Status})
+		return
+	}
+	if time.Now().After(invitation.ExpiresAt) {
+		c.JSON(http.StatusGone, gin.H{"error": "Invitation has expired"})
+		return
+	}
+
+	// Existing members keep their current role (an owner must not be demoted by a stray link)
+	result, err := tx.Exec(`
+		INSERT INTO store_members (store_id, user_id, role, invited_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (store_id, user_id) DO NOTHING
+	`, invitation.StoreID, userID, invitation.Role, invitation.InvitedBy)
+	if err == nil {
+		if rows, _ := result.RowsAffected(); rows > 0 {
+			err = recordStoreAudit(c, tx, invitation.StoreID, StoreAuditMemberAdded, map[string]StoreAuditChange{
+				"member:" + userID: {Before: auditValue(nil), After: auditValue(invitation.Role)},
+			})
+		}
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to add member %s to store %s: %v", userID, invitation.StoreID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE store_invitations SET status = $1, accepted_by = $2, accepted_at = NOW()
+		WHERE id = $3
+	`, InvitationStatusAccepted, userID, invitation.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		return
+	}
+
+	var role string
+	if err := tx.Get(&role, `SELECT role FROM store_members WHERE store_id = $1 AND user_id = $2`, invitation.StoreID, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"storeId":     invitation.StoreID,
+		"role":        role,
+		"permissions": storeRolePermissions[role],
+	})
+}
+
+// ListStoreMembers lists everyone who can act for the store
+func ListStoreMembers(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStaff)
+	if !ok {
+		return
+	}
+
+	members := make([]StoreMember, 0)
+	err := db.DB.Select(&members, `
+		SELECT user_id, role, invited_by, created_at
+		FROM store_members
+		WHERE store_id = $1
+		ORDER BY CASE role WHEN 'owner' THEN 0 WHEN 'manager' THEN 1 ELSE 2 END, created_at
+	`, storeID)
+	if err != nil {
+		log.Printf("ERROR: Failed to list members of store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get members"})
+		return
+	}
+	for i := range members {
+		members[i].Permissions = storeRolePermissions[members[i].Role]
+	}
+
+	c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+// UpdateStoreMemberRole changes a member's role. The last owner cannot be demoted.
+func UpdateStoreMemberRole(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req UpdateStoreMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !isValidStoreRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be 'owner', 'manager' or 'staff'"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStaff)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	memberID := c.Param("userId")
+	currentRole, ok := lockStoreMember(c, tx, storeID, memberID)
+	if !ok {
+		return
+	}
+	if currentRole == StoreRoleOwner && req.Role != StoreRoleOwner && !hasOtherOwner(c, tx, storeID, memberID) {
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE store_members SET role = $1 WHERE store_id = $2 AND user_id = $3`, req.Role, storeID, memberID)
+	if err == nil && currentRole != req.Role {
+		err = recordStoreAudit(c, tx, storeID, StoreAuditMemberRoleChanged, map[string]StoreAuditChange{
+			"member:" + memberID: {Before: auditValue(currentRole), After: auditValue(req.Role)},
+		})
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"userId":      memberID,
+		"role":        req.Role,
+		"permissions": storeRolePermissions[req.Role],
+	})
+}
+
+// RemoveStoreMember takes away a member's access to the store. The last owner cannot be removed.
+func RemoveStoreMember(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStaff)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	memberID := c.Param("userId")
+	currentRole, ok := lockStoreMember(c, tx, storeID, memberID)
+	if !ok {
+		return
+	}
+	if currentRole == StoreRoleOwner && !hasOtherOwner(c, tx, storeID, memberID) {
+		return
+	}
+
+	_, err = tx.Exec(`DELETE FROM store_members WHERE store_id = $1 AND user_id = $2`, storeID, memberID)
+	if err == nil {
+		err = recordStoreAudit(c, tx, storeID, StoreAuditMemberRemoved, map[string]StoreAuditChange{
+			"member:" + memberID: {Before: auditValue(currentRole), After: auditValue(nil)},
+		})
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
+
+// lockStoreMember locks the store's owner rows and returns the member's role.
+// On failure it writes the error response and returns false.
+func lockStoreMember(c *gin.Context, tx *sqlx.Tx, storeID, memberID string) (string, bool) {
+	// Lock every owner row so two concurrent demotions cannot both pass the last-owner check
+	var owners []string
+	if err := tx.Select(&owners, `
+		SELECT user_id FROM store_members WHERE store_id = $1 AND role = 'owner' FOR UPDATE
+	`, storeID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get members"})
+		return "", false
+	}
+
+	var role string
+	err := tx.Get(&role, `SELECT role FROM store_members WHERE store_id = $1 AND user_id = $2`, storeID, memberID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get member"})
+		return "", false
+	}
+	return role, true
+}
+
+// hasOtherOwner writes a 409 and returns false if memberID is the store's only owner
+func hasOtherOwner(c *gin.Context, tx *sqlx.Tx, storeID, memberID string) bool {
+	var others int
+	if err := tx.Get(&others, `
+		SELECT COUNT(*) FROM store_members WHERE store_id = $1 AND role = 'owner' AND user_id <> $2
+	`, storeID, memberID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get members"})
+		return false
+	}
+	if others == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A store must keep at least one owner"})
+		return false
+	}
+	return true
+}
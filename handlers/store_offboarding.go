@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"savor-server/db"
+	"savor-server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Financial records of a closed store are kept with the owner's details for this many
+// years (STORE_RECORD_RETENTION_YEARS); owner PII is anonymized afterwards
+const defaultStoreRecordRetentionYears = 10
+
+// ReservationCancelledStoreClosed is reservations.cancellation_reason for reservations
+// cancelled because their store closed
+const ReservationCancelledStoreClosed = "store_closed"
+
+func storeRecordRetentionYears() int {
+	if years, err := strconv.Atoi(os.Getenv("STORE_RECORD_RETENTION_YEARS")); err == nil && years > 0 {
+		return years
+	}
+	return defaultStoreRecordRetentionYears
+}
+
+type CloseStoreRequest struct {
+	Reason string `json:"reason"`
+}
+
+// StoreOffboarding is the outcome of closing a store
+type StoreOffboarding struct {
+	StoreID               string    `json:"storeId"`
+	ReviewStatus          string    `json:"reviewStatus"`
+	ClosedAt              time.Time `json:"closedAt"`
+	RecordsRetainedUntil  time.Time `json:"recordsRetainedUntil"`
+	CancelledReservations int       `json:"cancelledReservations"`
+	WalletRefunded        float64   `json:"walletRefunded"`
+	CardRefundPending     float64   `json:"cardRefundPending"`
+}
+
+// offboardedReservation is an open reservation cancelled by its store closing
+type offboardedReservation struct {
+	ID              string         `db:"id"`
+	UserID          sql.NullString `db:"user_id"`
+	Email           sql.NullString `db:"email"`
+	PaymentID       string         `db:"payment_id"`
+	PaymentMethod   string         `db:"payment_method"`
+	PaymentStatus   string         `db:"payment_status"`
+	TotalAmount     float64        `db:"total_amount"`
+	WalletAmount    float64        `db:"wallet_amount"`
+	PickupTimestamp sql.NullTime   `db:"pickup_timestamp"`
+}
+
+// cardRefund is the part of a reservation paid by card, refunded through the gateway
+func (r offboardedReservation) cardRefund() float64 {
+	if r.PaymentMethod != PaymentMethodCard || r.PaymentStatus != PaymentStatusPaid || !strings.HasPrefix(r.PaymentID, "pi_") {
+		return 0
+	}
+	return fromMinorUnits(toMinorUnits(r.TotalAmount - r.WalletAmount))
+}
+
+// CloseStore takes the store off the platform for good (Owner only)
+func CloseStore(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req CloseStoreRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	storeID, ok := authorizeStore(c, PermCloseStore)
+	if !ok {
+		return
+	}
+	closeStore(c, storeID, strings.TrimSpace(req.Reason))
+}
+
+// AdminCloseStore offboards a store on the owner's behalf or for a policy breach (Admin only)
+func AdminCloseStore(c *gin.Context) {
+	var req CloseStoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+		return
+	}
+	closeStore(c, c.Param("id"), req.Reason)
+}
+
+// closeStore deactivates the store at once, cancels its reservations that have not been
+// picked up and refunds them: the wallet share goes straight back to the wallet and the
+// card share is refunded by RefundClosedStoreReservations. Affected customers are emailed.
+// Reservations and payments stay for storeRecordRetentionYears.
+func closeStore(c *gin.Context, storeID, reason string) {
+	actorID := c.GetString("user_id")
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var store struct {
+		Title        string `db:"title"`
+		Timezone     string `db:"timezone"`
+		ReviewStatus string `db:"review_status"`
+	}
+	err = tx.Get(&store, `SELECT title, timezone, review_status FROM stores WHERE id = $1 FOR UPDATE`, storeID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Store not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close store"})
+		return
+	}
+	if store.ReviewStatus == StoreReviewClosed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Store is already closed"})
+		return
+	}
+
+	audit, err := beginStoreAudit(tx, storeID)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close store"})
+		return
+	}
+
+	result := StoreOffboarding{StoreID: storeID, ReviewStatus: StoreReviewClosed}
+	review, err := changeStoreReviewStatus(tx, storeID, StoreReviewActionClosed, store.ReviewStatus, StoreReviewClosed, reason, actorID)
+	if err == nil {
+		err = tx.QueryRow(`
+			UPDATE stores SET
+				is_selling = false, sold_out_paused = false, items_left = 0, bags_available = 0,
+				closed_at = NOW(), closed_by = NULLIF($2, ''),
+				records_retained_until = NOW() + make_interval(years => $3)
+			WHERE id = $1
+			RETURNING closed_at, records_retained_until
+		`, storeID, actorID, storeRecordRetentionYears()).Scan(&result.ClosedAt, &result.RecordsRetainedUntil)
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to close store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close store"})
+		return
+	}
+
+	// Reservations whose pickup has not ended yet can no longer be honoured
+	var cancelled []offboardedReservation
+	err = tx.Select(&cancelled, `
+		UPDATE reservations r
+		SET status = 'cancelled', cancellation_reason = $2
+		WHERE r.store_id = $1
+		AND r.status IN ('pending', 'confirmed')
+		AND `+reservationPickupEndSQL+` > NOW()
+		RETURNING r.id, r.user_id,
+			COALESCE(r.customer_email, (SELECT u.email FROM users u WHERE u.id::text = r.user_id)) as email,
+			COALESCE(r.payment_id, '') as payment_id, r.payment_method, r.payment_status,
+			r.total_amount, r.wallet_amount, r.pickup_timestamp
+	`, storeID, ReservationCancelledStoreClosed)
+	if err != nil {
+		log.Printf("ERROR: Failed to cancel reservations of store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel reservations"})
+		return
+	}
+
+	for _, r := range cancelled {
+		walletMinor := toMinorUnits(r.WalletAmount)
+		if walletMinor > 0 && r.UserID.Valid && r.PaymentStatus == PaymentStatusPaid {
+			_, err := applyWalletTransaction(tx, r.UserID.String, walletMinor, WalletTxRefundCredit, r.ID,
+				"Refund for reservation cancelled because the store closed", actorID)
+			if err != nil {
+				log.Printf("ERROR: Failed to refund reservation %s to the wallet: %v", r.ID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refund reservations"})
+				return
+			}
+			result.WalletRefunded += r.WalletAmount
+		}
+		result.CardRefundPending += r.cardRefund()
+
+		// Nothing is left to refund once the wallet share is back. Cash taken at the store
+		// is for the store to return.
+		if r.PaymentStatus == PaymentStatusPaid && r.PaymentMethod != PaymentMethodPayAtStore && r.cardRefund() == 0 {
+			if _, err := tx.Exec(`UPDATE reservations SET payment_status = $1 WHERE id = $2`, PaymentStatusRefunded, r.ID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refund reservations"})
+				return
+			}
+		}
+	}
+	result.CancelledReservations = len(cancelled)
+
+	// Future pickup windows are cancelled; syncPickupWindows gives a closed store none
+	if err := syncPickupWindows(tx, storeID, time.Now()); err != nil {
+		log.Printf("ERROR: Failed to cancel pickup windows of store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel pickup windows"})
+		return
+	}
+
+	if err := audit.record(c, tx, StoreAuditStoreClosed); err != nil {
+		log.Printf("ERROR: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close store"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	log.Printf("Store %s closed by %s; %d reservations cancelled", storeID, actorID, len(cancelled))
+	go func() {
+		if err := RefundClosedStoreReservations(); err != nil {
+			log.Printf("WARNING: Failed to refund reservations of closed store %s: %v", storeID, err)
+		}
+	}()
+	go notifyStoreOffboarding(store.Title, store.Timezone, cancelled)
+	go notifyStoreReview(storeID, *review)
+
+	c.JSON(http.StatusOK, result)
+}
+
+// notifyStoreOffboarding emails each customer whose reservation was cancelled by the
+// store closing, with what they get back and where
+func notifyStoreOffboarding(storeName, timezone string, cancelled []offboardedReservation) {
+	emailService := services.GetEmailService()
+	if !emailService.IsConfigured() {
+		return
+	}
+
+	for _, r := range cancelled {
+		if !r.Email.Valid || r.Email.String == "" {
+			continue
+		}
+		data := services.StoreOffboardingEmailData{
+			StoreName:     storeName,
+			ReservationID: r.ID,
+			CardRefund:    r.cardRefund(),
+		}
+		if r.UserID.Valid && r.PaymentStatus == PaymentStatusPaid {
+			data.WalletRefund = r.WalletAmount
+		}
+		if r.PickupTimestamp.Valid {
+			data.PickupTime = r.PickupTimestamp.Time.In(storeLocation(timezone)).Format("02/01/2006 15:04")
+		}
+		if err := emailService.SendStoreOffboardingNotice(r.Email.String, data); err != nil {
+			log.Printf("WARNING: Failed to send store closure notice for reservation %s: %v", r.ID, err)
+		}
+	}
+}
+
+// RefundClosedStoreReservations refunds to the card the reservations cancelled because
+// their store closed. Refunds that fail are retried on the next run; the gateway makes
+// a retried refund a no-op.
+func RefundClosedStoreReservations() error {
+	var pending []offboardedReservation
+	err := db.DB.Select(&pending, `
+		SELECT id, payment_id, payment_method, payment_status, total_amount, wallet_amount
+		FROM reservations
+		WHERE cancellation_reason = $1 AND payment_status = $2 AND payment_method = $3
+		ORDER BY updated_at
+		LIMIT 100
+	`, ReservationCancelledStoreClosed, PaymentStatusPaid, PaymentMethodCard)
+	if err != nil {
+		return fmt.Errorf("failed to load reservations to refund: %v", err)
+	}
+
+	refunded := 0
+	for _, r := range pending {
+		if amount := r.cardRefund(); amount > 0 {
+			if err := services.Payments.RefundPaymentIntent(r.PaymentID, r.ID, toMinorUnits(amount)); err != nil {
+				log.Printf("WARNING: Failed to refund reservation %s: %v", r.ID, err)
+				continue
+			}
+		}
+		_, err := db.DB.Exec(`UPDATE reservations SET payment_status = $1 WHERE id = $2`, PaymentStatusRefunded, r.ID)
+		if err != nil {
+			log.Printf("WARNING: Failed to mark reservation %s refunded: %v", r.ID, err)
+			continue
+		}
+		refunded++
+	}
+	if refunded > 0 {
+		log.Printf("Refunded %d reservations of closed stores", refunded)
+	}
+	return nil
+}
+
+// AnonymizeClosedStores removes the owner's personal data from stores that have been
+// closed for longer than the record retention period: the store's phone and owner,
+// the partner application's contact details, invitations, memberships and the phone
+// numbers and IP addresses in the store's audit log. The store row and its
+// reservations stay, without the owner's details.
+func AnonymizeClosedStores() error {
+	var storeIDs []string
+	err := db.DB.Select(&storeIDs, `
+		SELECT id FROM stores
+		WHERE review_status = $1 AND pii_anonymized_at IS NULL AND records_retained_until < NOW()
+		ORDER BY records_retained_until
+		LIMIT 50
+	`, StoreReviewClosed)
+	if err != nil {
+		return fmt.Errorf("failed to load closed stores: %v", err)
+	}
+
+	for _, storeID := range storeIDs {
+		if err := anonymizeStoreOwner(storeID); err != nil {
+			log.Printf("WARNING: Failed to anonymize closed store %s: %v", storeID, err)
+		}
+	}
+	if len(storeIDs) > 0 {
+		log.Printf("Anonymized owner data of %d closed stores", len(storeIDs))
+	}
+	return nil
+}
+
+func anonymizeStoreOwner(storeID string) error {
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Recheck under the lock in case the store was reopened meanwhile
+	result, err := tx.Exec(`
+		UPDATE stores SET phone = NULL, owner_id = NULL, closed_by = NULL, pii_anonymized_at = NOW()
+		WHERE id = $1 AND review_status = $2 AND pii_anonymized_at IS NULL
+	`, storeID, StoreReviewClosed)
+	if err != nil {
+		return fmt.Errorf("failed to anonymize store: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil
+	}
+
+	var members int
+	if err := tx.Get(&members, `SELECT COUNT(*) FROM store_members WHERE store_id = $1`, storeID); err != nil {
+		return fmt.Errorf("failed to count members: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE partner_contacts SET name = '', email = '', phone = '', message = NULL, partner_user_id = NULL
+		WHERE store_id = $1
+	`, storeID)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM store_invitations WHERE store_id = $1`, storeID)
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM store_members WHERE store_id = $1`, storeID)
+	}
+	// The audit log is append-only except for this redaction (see migration 030)
+	if err == nil {
+		_, err = tx.Exec(`SET LOCAL savor.redacting_audit_log = 'on'`)
+	}
+	if err == nil {
+		_, err = tx.Exec(`
+			UPDATE store_audit_log SET changes = changes - 'phone', ip_address = NULL
+			WHERE store_id = $1
+		`, storeID)
+	}
+	if err == nil {
+		err = recordStoreAudit(nil, tx, storeID, StoreAuditOwnerDataAnonymized, map[string]StoreAuditChange{
+			"members": {Before: auditValue(members), After: auditValue(0)},
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 )
 
 type StoreOwnerReservation struct {
@@ -24,6 +25,9 @@ type StoreOwnerReservation struct {
 	StoreName       string     `json:"storeName"`
 	StoreImage      string     `json:"storeImage"`
 	StoreAddress    string     `json:"storeAddress"`
+	PaymentMethod   string     `json:"paymentMethod"`
+	PaymentStatus   string     `json:"paymentStatus"`
+	AmountCollected *float64   `json:"amountCollected,omitempty"`
 }
 
 type StoreOwnerSettings struct {
@@ -46,6 +50,9 @@ type StoreOwnerSettings struct {
 	SurpriseBoxes int    `json:"surpriseBoxes"`
 	PickupTime    string `json:"pickupTime"`
 	IsSelling     bool   `json:"isSelling"`
+
+	// Dynamic pricing
+	PricingRules []PricingRule `json:"pricingRules"`
 }
 
 type UpdateReservationStatusRequest struct {
@@ -72,6 +79,9 @@ type UpdateStoreSettingsRequest struct {
 	SurpriseBoxes int    `json:"surpriseBoxes"`
 	PickupTime    string `json:"pickupTime"`
 	IsSelling     bool   `json:"isSelling"`
+
+	// Dynamic pricing. Omit to keep the current rules, send [] to remove them all.
+	PricingRules *[]PricingRuleInput `json:"pricingRules"`
 }
 
 // GetStoreOwnerReservations gets all reservations for a store owner's store
@@ -84,11 +94,8 @@ func GetStoreOwnerReservations(c *gin.Context) {
 		return
 	}
 
-	// First, get the store ID for this user
-	var storeID string
-	err := db.DB.QueryRow(`
-		SELECT id FROM stores WHERE owner_id = $1
-	`, userID).Scan(&storeID)
+	// First, get the store this request is for
+	storeID, role, err := storeAccess(c)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -105,6 +112,19 @@ func GetStoreOwnerReservations(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get store"})
 		return
 	}
+	if !hasStorePermission(role, PermViewReservations) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to do this for this store"})
+		return
+	}
+
+	// Times are shown in the store's zone, and "today" is the store's day
+	loc := storeLocationByID(storeID)
+
+	// Staff only see today's reservations
+	var since sql.NullTime
+	if !hasStorePermission(role, PermViewAllReservations) {
+		since = sql.NullTime{Time: startOfDay(time.Now(), loc), Valid: true}
+	}
 
 	// Get all reservations for this store (including guest reservations with NULL user_id)
 	rows, err := db.DB.Query(`
@@ -121,13 +141,17 @@ func GetStoreOwnerReservations(c *gin.Context) {
 			r.created_at,
 			s.title as store_name,
 			s.image_url as store_image,
-			s.address as store_address
+			s.address as store_address,
+			r.payment_method,
+			r.payment_status,
+			r.amount_collected
 		FROM reservations r
 		LEFT JOIN users u ON r.user_id = u.id::text
 		JOIN stores s ON r.store_id = s.id
 		WHERE r.store_id = $1
+		AND ($2::timestamptz IS NULL OR r.created_at >= $2 OR r.pickup_timestamp >= $2)
 		ORDER BY r.pickup_timestamp DESC, r.created_at DESC
-	`, storeID)
+	`, storeID, since)
 
 	if err != nil {
 		fmt.Printf("ERROR: Failed to query reservations for store_id %s: %v\n", storeID, err)
@@ -158,11 +182,19 @@ func GetStoreOwnerReservations(c *gin.Context) {
 			&res.StoreName,
 			&res.StoreImage,
 			&res.StoreAddress,
+			&res.PaymentMethod,
+			&res.PaymentStatus,
+			&res.AmountCollected,
 		)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan reservation"})
 			return
 		}
+		res.CreatedAt = res.CreatedAt.In(loc)
+		if res.PickupTimestamp != nil {
+			pickup := res.PickupTimestamp.In(loc)
+			res.PickupTimestamp = &pickup
+		}
 
 		// Categorize based on 24-hour window
 		if res.CreatedAt.After(twentyFourHoursAgo) {
@@ -182,6 +214,16 @@ func GetStoreOwnerReservations(c *gin.Context) {
 	})
 }
 
+// reservationStatusTransitions says which statuses staff may move a reservation to from
+// each status. Staff may undo a pickup marked by mistake; cancelled and expired
+// reservations, including those refunded when a store closed, are final.
+var reservationStatusTransitions = map[string][]string{
+	"pending":   {"confirmed", "completed", "picked_up"},
+	"confirmed": {"completed", "picked_up"},
+	"completed": {"confirmed"},
+	"picked_up": {"confirmed"},
+}
+
 // UpdateReservationStatus updates the status of a reservation
 func UpdateReservationStatus(c *gin.Context) {
 	userID := c.GetString("user_id")
@@ -208,38 +250,38 @@ func UpdateReservationStatus(c *gin.Context) {
 		return
 	}
 
-	// First, verify that the reservation belongs to the user's store
-	var storeID string
-	err := db.DB.QueryRow(`
-		SELECT s.id 
-		FROM stores s
-		JOIN reservations r ON s.id = r.store_id
-		WHERE s.owner_id = $1 AND r.id = $2
-	`, userID, reservationID).Scan(&storeID)
+	// First, verify that the reservation belongs to one of the user's stores
+	// (the scoped store, if the request came through /stores/:storeId)
+	if _, ok := reservationStoreAccess(c, reservationID, PermVerifyPickups); !ok {
+		return
+	}
 
+	tx, err := db.DB.Beginx()
 	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Reservation not found or not authorized"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify reservation"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
 		return
 	}
+	defer tx.Rollback()
 
-	// Get reservation details before updating status
-	var quantity int
+	// Get the current status before updating it
 	var currentStatus string
-	err = db.DB.QueryRow(`
-		SELECT quantity, status FROM reservations WHERE id = $1
-	`, reservationID).Scan(&quantity, &currentStatus)
-
+	err = tx.Get(&currentStatus, `SELECT status FROM reservations WHERE id = $1 FOR UPDATE`, reservationID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reservation details"})
 		return
 	}
 
+	allowed := currentStatus == req.Status
+	for _, to := range reservationStatusTransitions[currentStatus] {
+		allowed = allowed || to == req.Status
+	}
+	if !allowed {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Reservation is %s and cannot be changed to %s", currentStatus, req.Status)})
+		return
+	}
+
 	// Update the reservation status
-	_, err = db.DB.Exec(`
+	_, err = tx.Exec(`
 		UPDATE reservations 
 		SET status = $1
 		WHERE id = $2
@@ -250,6 +292,11 @@ func UpdateReservationStatus(c *gin.Context) {
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
 	// If changing from confirmed to completed, don't change items_left (bags are already counted as unavailable)
 	// If changing from completed to confirmed, also don't change items_left (bags were already counted)
 	// The items_left count represents bags available for NEW reservations, not bags that have been picked up
@@ -271,36 +318,43 @@ func GetStoreOwnerSettings(c *gin.Context) {
 	}
 
 	var settings StoreOwnerSettings
-	err := db.DB.QueryRow(`
-		SELECT 
-			COALESCE(title, '') as title,
-			COALESCE(description, '') as description,
-			COALESCE(address, '') as address,
-			COALESCE(image_url, '') as image_url,
-			COALESCE(background_url, '') as background_url,
-			COALESCE(avatar_url, '') as avatar_url,
-			COALESCE(original_price, 0) as original_price,
-			COALESCE(discounted_price, 0) as discounted_price,
-			COALESCE(price, 0) as price,
-			COALESCE(items_left, 10) as surprise_boxes,
-			COALESCE(pickup_time, '') as pickup_time,
-			COALESCE(is_selling, false) as is_selling
-		FROM stores 
-		WHERE owner_id = $1
-	`, userID).Scan(
-		&settings.Title,
-		&settings.Description,
-		&settings.Address,
-		&settings.ImageUrl,
-		&settings.BackgroundUrl,
-		&settings.AvatarUrl,
-		&settings.OriginalPrice,
-		&settings.DiscountedPrice,
-		&settings.Price,
-		&settings.SurpriseBoxes,
-		&settings.PickupTime,
-		&settings.IsSelling,
-	)
+	storeID, role, err := storeAccess(c)
+	if err == nil && !hasStorePermission(role, PermManagePricing) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to do this for this store"})
+		return
+	}
+	if err == nil {
+		err = db.DB.QueryRow(`
+			SELECT 
+				COALESCE(title, '') as title,
+				COALESCE(description, '') as description,
+				COALESCE(address, '') as address,
+				COALESCE(image_url, '') as image_url,
+				COALESCE(background_url, '') as background_url,
+				COALESCE(avatar_url, '') as avatar_url,
+				COALESCE(original_price, 0) as original_price,
+				COALESCE(discounted_price, 0) as discounted_price,
+				COALESCE(price, 0) as price,
+				COALESCE(items_left, 10) as surprise_boxes,
+				COALESCE(pickup_time, '') as pickup_time,
+				COALESCE(is_selling, false) as is_selling
+			FROM stores 
+			WHERE id = $1
+		`, storeID).Scan(
+			&settings.Title,
+			&settings.Description,
+			&settings.Address,
+			&settings.ImageUrl,
+			&settings.BackgroundUrl,
+			&settings.AvatarUrl,
+			&settings.OriginalPrice,
+			&settings.DiscountedPrice,
+			&settings.Price,
+			&settings.SurpriseBoxes,
+			&settings.PickupTime,
+			&settings.IsSelling,
+		)
+	}
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -318,12 +372,20 @@ func GetStoreOwnerSettings(c *gin.Context) {
 				SurpriseBoxes:   10,
 				PickupTime:      "",
 				IsSelling:       false,
+				PricingRules:    []PricingRule{},
 			}
 		} else {
 			fmt.Printf("ERROR: Failed to query store settings for userID %s: %v\n", userID, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get store settings"})
 			return
 		}
+	} else {
+		settings.PricingRules, err = loadPricingRules(storeID)
+		if err != nil {
+			fmt.Printf("ERROR: Failed to load pricing rules for store %s: %v\n", storeID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get store settings"})
+			return
+		}
 	}
 
 	c.JSON(http.StatusOK, settings)
@@ -356,9 +418,40 @@ func UpdateStoreOwnerSettings(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Store title is required"})
 		return
 	}
+	if req.PricingRules != nil {
+		if err := validatePricingRules(*req.PricingRules); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	storeID, ok := authorizeStore(c, PermManagePricing)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store settings"})
+		return
+	}
+	defer tx.Rollback()
+
+	audit, err := beginStoreAudit(tx, storeID)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store settings"})
+		return
+	}
+
+	before, err := loadStoreMaterial(tx, storeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store settings"})
+		return
+	}
 
 	// Update store settings
-	_, err := db.DB.Exec(`
+	_, err = tx.Exec(`
 		UPDATE stores 
 		SET 
 			title = $1,
@@ -373,19 +466,47 @@ func UpdateStoreOwnerSettings(c *gin.Context) {
 			items_left = $10,
 			pickup_time = $11,
 			is_selling = $12,
+			sold_out_paused = false,
 			updated_at = NOW()
-		WHERE owner_id = $13
+		WHERE id = $13
 	`, req.Title, req.Description, req.Address,
 		req.ImageUrl, req.BackgroundUrl, req.AvatarUrl,
 		req.OriginalPrice, req.DiscountedPrice, req.Price,
 		req.SurpriseBoxes, req.PickupTime, req.IsSelling,
-		userID)
+		storeID)
 
+	if err == nil {
+		err = flagMaterialEdits(tx, storeID, userID, before)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store settings"})
 		return
 	}
 
+	if req.PricingRules != nil {
+		if err := replacePricingRules(tx, storeID, *req.PricingRules); err != nil {
+			fmt.Printf("ERROR: Failed to save pricing rules for store %s: %v\n", storeID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update pricing rules"})
+			return
+		}
+	}
+
+	if err := audit.record(c, tx, StoreAuditSettingsUpdated); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store settings"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store settings"})
+		return
+	}
+
+	pricingRules, err := loadPricingRules(storeID)
+	if err != nil {
+		fmt.Printf("WARNING: Failed to reload pricing rules for store %s: %v\n", storeID, err)
+	}
+
 	settings := StoreOwnerSettings{
 		Title:           req.Title,
 		Description:     req.Description,
@@ -399,6 +520,7 @@ func UpdateStoreOwnerSettings(c *gin.Context) {
 		SurpriseBoxes:   req.SurpriseBoxes,
 		PickupTime:      req.PickupTime,
 		IsSelling:       req.IsSelling,
+		PricingRules:    pricingRules,
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -407,6 +529,74 @@ func UpdateStoreOwnerSettings(c *gin.Context) {
 	})
 }
 
+// StoreReservationStats summarizes reservations with pickups either still ahead or already past
+type StoreReservationStats struct {
+	TotalReservations    int     `json:"totalReservations" db:"total_reservations"`
+	ActiveReservations   int     `json:"activeReservations" db:"active_reservations"`
+	PickedUpReservations int     `json:"pickedUpReservations" db:"picked_up_reservations"`
+	TotalRevenue         float64 `json:"totalRevenue" db:"total_revenue"`
+}
+
+func (s *StoreReservationStats) add(other StoreReservationStats) {
+	s.TotalReservations += other.TotalReservations
+	s.ActiveReservations += other.ActiveReservations
+	s.PickedUpReservations += other.PickedUpReservations
+	s.TotalRevenue = fromMinorUnits(toMinorUnits(s.TotalRevenue) + toMinorUnits(other.TotalRevenue))
+}
+
+type BranchStats struct {
+	StoreID   string                `json:"storeId"`
+	StoreName string                `json:"storeName"`
+	Current   StoreReservationStats `json:"current"`
+	Past      StoreReservationStats `json:"past"`
+}
+
+// loadReservationStats returns current (future pickup) and past stats per store
+func loadReservationStats(storeIDs []string, now time.Time) (map[string]*BranchStats, error) {
+	rows := make([]struct {
+		StoreID   string `db:"store_id"`
+		StoreName string `db:"store_name"`
+		IsCurrent bool   `db:"is_current"`
+		StoreReservationStats
+	}, 0)
+
+	// Current reservations have a future pickup time; past ones a past or missing pickup time.
+	// Cancelled and refunded reservations are kept as records but are neither sales nor revenue.
+	err := db.DB.Select(&rows, `
+		SELECT 
+			s.id as store_id,
+			s.title as store_name,
+			(r.pickup_timestamp IS NOT NULL AND r.pickup_timestamp > $2) as is_current,
+			COUNT(r.id) as total_reservations,
+			COUNT(CASE WHEN r.status = 'active' THEN 1 END) as active_reservations,
+			COUNT(CASE WHEN r.status = 'picked_up' THEN 1 END) as picked_up_reservations,
+			COALESCE(SUM(r.total_amount), 0) as total_revenue
+		FROM stores s
+		JOIN reservations r ON r.store_id = s.id
+			AND r.status <> 'cancelled' AND r.payment_status <> 'refunded'
+		WHERE s.id = ANY($1)
+		GROUP BY s.id, s.title, is_current
+	`, pq.Array(storeIDs), now)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*BranchStats, len(storeIDs))
+	for _, row := range rows {
+		branch := result[row.StoreID]
+		if branch == nil {
+			branch = &BranchStats{StoreID: row.StoreID, StoreName: row.StoreName}
+			result[row.StoreID] = branch
+		}
+		if row.IsCurrent {
+			branch.Current.add(row.StoreReservationStats)
+		} else {
+			branch.Past.add(row.StoreReservationStats)
+		}
+	}
+	return result, nil
+}
+
 // GetStoreOwnerStats gets statistics for the store owner
 func GetStoreOwnerStats(c *gin.Context) {
 	userID := c.GetString("user_id")
@@ -416,85 +606,134 @@ func GetStoreOwnerStats(c *gin.Context) {
 	}
 
 	// Get store ID
-	var storeID string
-	err := db.DB.QueryRow(`
-		SELECT id FROM stores WHERE owner_id = $1
-	`, userID).Scan(&storeID)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Store not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get store"})
+	storeID, ok := authorizeStore(c, PermViewRevenue)
+	if !ok {
 		return
 	}
 
-	// Get stats for current and past reservations
 	now := time.Now()
+	stats, err := loadReservationStats([]string{storeID}, now)
+	if err != nil {
+		fmt.Printf("ERROR: Failed to get stats for store %s: %v\n", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stats"})
+		return
+	}
 
-	var currentStats struct {
-		TotalReservations    int     `json:"totalReservations"`
-		ActiveReservations   int     `json:"activeReservations"`
-		PickedUpReservations int     `json:"pickedUpReservations"`
-		TotalRevenue         float64 `json:"totalRevenue"`
+	branch := stats[storeID]
+	if branch == nil {
+		branch = &BranchStats{StoreID: storeID}
 	}
 
-	var pastStats struct {
-		TotalReservations    int     `json:"totalReservations"`
-		ActiveReservations   int     `json:"activeReservations"`
-		PickedUpReservations int     `json:"pickedUpReservations"`
-		TotalRevenue         float64 `json:"totalRevenue"`
+	c.JSON(http.StatusOK, gin.H{
+		"current": branch.Current,
+		"past":    branch.Past,
+		"date":    now.Format("2006-01-02"),
+	})
+}
+
+// GetOwnerBranchesStats aggregates stats across the user's stores
+func GetOwnerBranchesStats(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
 	}
 
-	// Get current reservations stats (future pickup times)
-	err = db.DB.QueryRow(`
-		SELECT 
-			COUNT(*) as total_reservations,
-			COUNT(CASE WHEN status = 'active' THEN 1 END) as active_reservations,
-			COUNT(CASE WHEN status = 'picked_up' THEN 1 END) as picked_up_reservations,
-			COALESCE(SUM(total_amount), 0) as total_revenue
-		FROM reservations 
-		WHERE store_id = $1 
-		AND pickup_timestamp IS NOT NULL 
-		AND pickup_timestamp > $2
-	`, storeID, now).Scan(
-		&currentStats.TotalReservations,
-		&currentStats.ActiveReservations,
-		&currentStats.PickedUpReservations,
-		&currentStats.TotalRevenue,
-	)
+	// Only branches where the user may see revenue
+	storeIDs, err := memberStoreIDs(userID, PermViewRevenue)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stores"})
+		return
+	}
 
+	now := time.Now()
+	stats, err := loadReservationStats(storeIDs, now)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get current stats"})
+		fmt.Printf("ERROR: Failed to get branch stats for user %s: %v\n", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stats"})
 		return
 	}
 
-	// Get past reservations stats (past pickup times or no pickup_timestamp)
-	err = db.DB.QueryRow(`
-		SELECT 
-			COUNT(*) as total_reservations,
-			COUNT(CASE WHEN status = 'active' THEN 1 END) as active_reservations,
-			COUNT(CASE WHEN status = 'picked_up' THEN 1 END) as picked_up_reservations,
-			COALESCE(SUM(total_amount), 0) as total_revenue
-		FROM reservations 
-		WHERE store_id = $1 
-		AND (pickup_timestamp IS NULL OR pickup_timestamp <= $2)
-	`, storeID, now).Scan(
-		&pastStats.TotalReservations,
-		&pastStats.ActiveReservations,
-		&pastStats.PickedUpReservations,
-		&pastStats.TotalRevenue,
-	)
+	var current, past StoreReservationStats
+	branches := make([]BranchStats, 0, len(storeIDs))
+	for _, id := range storeIDs {
+		branch := stats[id]
+		if branch == nil {
+			branch = &BranchStats{StoreID: id}
+		}
+		current.add(branch.Current)
+		past.add(branch.Past)
+		branches = append(branches, *branch)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"current":  current,
+		"past":     past,
+		"branches": branches,
+		"date":     now.Format("2006-01-02"),
+	})
+}
+
+// OwnerStoreSummary is one row of the list-my-stores endpoint
+type OwnerStoreSummary struct {
+	ID            string    `json:"id" db:"id"`
+	Title         string    `json:"title" db:"title"`
+	Address       string    `json:"address" db:"address"`
+	ImageUrl      string    `json:"imageUrl" db:"image_url"`
+	IsSelling     bool      `json:"isSelling" db:"is_selling"`
+	ItemsLeft     int       `json:"itemsLeft" db:"items_left"`
+	IsPrimary     bool      `json:"isPrimary" db:"-"`
+	CreatedAt     time.Time `json:"createdAt" db:"created_at"`
+	TodayBookings int       `json:"todayReservations" db:"today_reservations"`
+	Role          string    `json:"role" db:"role"`
+	Permissions   []string  `json:"permissions" db:"-"`
+}
+
+// GetMyStores lists every store the user is a member of with their role there.
+// The primary store is the one used by the unscoped /api/store-owner routes.
+func GetMyStores(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
 
+	stores := make([]OwnerStoreSummary, 0)
+	err := db.DB.Select(&stores, `
+		SELECT 
+			s.id,
+			s.title,
+			COALESCE(s.address, '') as address,
+			COALESCE(s.image_url, '') as image_url,
+			COALESCE(s.is_selling, false) as is_selling,
+			COALESCE(s.items_left, 0) as items_left,
+			s.created_at,
+			(SELECT COUNT(*) FROM reservations r 
+			 WHERE r.store_id = s.id
+			 AND r.created_at >= date_trunc('day', NOW() AT TIME ZONE s.timezone) AT TIME ZONE s.timezone) as today_reservations,
+			m.role
+		FROM store_members m
+		JOIN stores s ON s.id = m.store_id
+		WHERE m.user_id = $1
+		ORDER BY s.created_at, s.id
+	`, userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get past stats"})
+		fmt.Printf("ERROR: Failed to list stores for user %s: %v\n", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stores"})
 		return
 	}
 
+	primaryID, _, err := primaryStoreMembership(userID)
+	if err != nil && err != sql.ErrNoRows {
+		fmt.Printf("WARNING: Failed to get primary store for user %s: %v\n", userID, err)
+	}
+	for i := range stores {
+		stores[i].IsPrimary = stores[i].ID == primaryID
+		stores[i].Permissions = storeRolePermissions[stores[i].Role]
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"current": currentStats,
-		"past":    pastStats,
-		"date":    now.Format("2006-01-02"),
+		"stores": stores,
+		"count":  len(stores),
 	})
 }
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"savor-server/db"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ways staff can collect a pay-at-store reservation (see check_collection_method)
+const (
+	CollectionMethodCash         = "cash"
+	CollectionMethodBankTransfer = "bank_transfer"
+)
+
+type RecordPaymentRequest struct {
+	Amount float64 `json:"amount" binding:"required,gt=0"`
+	Method string  `json:"method" binding:"required"`
+}
+
+type ReconciliationEntry struct {
+	ReservationID    string     `json:"reservationId" db:"id"`
+	CustomerName     string     `json:"customerName" db:"customer_name"`
+	Quantity         int        `json:"quantity" db:"quantity"`
+	ExpectedAmount   float64    `json:"expectedAmount" db:"total_amount"`
+	Status           string     `json:"status" db:"status"`
+	PaymentStatus    string     `json:"paymentStatus" db:"payment_status"`
+	AmountCollected  *float64   `json:"amountCollected" db:"amount_collected"`
+	CollectionMethod *string    `json:"collectionMethod" db:"collection_method"`
+	CollectedAt      *time.Time `json:"collectedAt" db:"collected_at"`
+	PickupTimestamp  *time.Time `json:"pickupTimestamp" db:"pickup_timestamp"`
+}
+
+type CashReconciliation struct {
+	Date                   string                `json:"date"`
+	ExpectedAmount         float64               `json:"expectedAmount"`
+	CollectedAmount        float64               `json:"collectedAmount"`
+	CollectedCash          float64               `json:"collectedCash"`
+	CollectedBankTransfer  float64               `json:"collectedBankTransfer"`
+	OutstandingAmount      float64               `json:"outstandingAmount"`
+	Difference             float64               `json:"difference"`
+	ReservationCount       int                   `json:"reservationCount"`
+	PaidCount              int                   `json:"paidCount"`
+	UnpaidCount            int                   `json:"unpaidCount"`
+	Reservations           []ReconciliationEntry `json:"reservations"`
+	MismatchedReservations []ReconciliationEntry `json:"mismatchedReservations"`
+}
+
+// RecordReservationPayment marks a pay-at-store reservation as paid at pickup
+func RecordReservationPayment(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	reservationID := c.Param("id")
+	if reservationID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reservation ID is required"})
+		return
+	}
+
+	var req RecordPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Method != CollectionMethodCash && req.Method != CollectionMethodBankTransfer {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid method. Must be 'cash' or 'bank_transfer'"})
+		return
+	}
+
+	// Verify that the reservation belongs to one of the user's stores
+	if _, ok := reservationStoreAccess(c, reservationID, PermRecordPayments); !ok {
+		return
+	}
+
+	var paymentMethod, paymentStatus string
+	err := db.DB.QueryRow(`
+		SELECT payment_method, payment_status FROM reservations WHERE id = $1
+	`, reservationID).Scan(&paymentMethod, &paymentStatus)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reservation not found or not authorized"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify reservation"})
+		return
+	}
+
+	if paymentMethod != PaymentMethodPayAtStore {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reservation is not a pay-at-store reservation"})
+		return
+	}
+	if paymentStatus != PaymentStatusUnpaid {
+		c.JSON(http.StatusConflict, gin.H{"error": "Reservation has already been paid"})
+		return
+	}
+
+	amount := fromMinorUnits(toMinorUnits(req.Amount))
+	var collectedAt time.Time
+	err = db.DB.QueryRow(`
+		UPDATE reservations
+		SET payment_status = $1,
+		    amount_collected = $2,
+		    collection_method = $3,
+		    collected_at = NOW(),
+		    collected_by = $4
+		WHERE id = $5 AND payment_status = $6
+		RETURNING collected_at
+	`, PaymentStatusPaid, amount, req.Method, userID, reservationID, PaymentStatusUnpaid).Scan(&collectedAt)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusConflict, gin.H{"error": "Reservation has already been paid"})
+			return
+		}
+		fmt.Printf("ERROR: Failed to record payment for reservation %s: %v\n", reservationID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Payment recorded successfully",
+		"paymentStatus":    PaymentStatusPaid,
+		"amountCollected":  amount,
+		"collectionMethod": req.Method,
+		"collectedAt":      collectedAt,
+	})
+}
+
+// GetCashReconciliation compares expected and collected pay-at-store amounts for one day
+func GetCashReconciliation(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	// Get store ID
+	storeID, ok := authorizeStore(c, PermViewRevenue)
+	if !ok {
+		return
+	}
+
+	// The day is the store's local day
+	loc := storeLocationByID(storeID)
+	dayStart := startOfDay(time.Now(), loc)
+	if dateParam := c.Query("date"); dateParam != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", dateParam, loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date. Use YYYY-MM-DD"})
+			return
+		}
+		dayStart = parsed
+	}
+	dayEnd := dayStart.AddDate(0, 0, 1)
+
+	var entries []ReconciliationEntry
+	err := db.DB.Select(&entries, `
+		SELECT
+			r.id,
+			COALESCE(r.customer_name, r.customer_email, 'Guest User') as customer_name,
+			r.quantity,
+			r.total_amount,
+			r.status,
+			r.payment_status,
+			r.amount_collected,
+			r.collection_method,
+			r.collected_at,
+			r.pickup_timestamp
+		FROM reservations r
+		WHERE r.store_id = $1
+		AND r.payment_method = $2
+		AND r.status <> 'cancelled'
+		AND COALESCE(r.pickup_timestamp, r.created_at) >= $3
+		AND COALESCE(r.pickup_timestamp, r.created_at) < $4
+		ORDER BY r.pickup_timestamp, r.created_at
+	`, storeID, PaymentMethodPayAtStore, dayStart, dayEnd)
+
+	if err != nil {
+		fmt.Printf("ERROR: Failed to load reconciliation for store %s: %v\n", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reconciliation"})
+		return
+	}
+
+	for i := range entries {
+		if entries[i].CollectedAt != nil {
+			collected := entries[i].CollectedAt.In(loc)
+			entries[i].CollectedAt = &collected
+		}
+		if entries[i].PickupTimestamp != nil {
+			pickup := entries[i].PickupTimestamp.In(loc)
+			entries[i].PickupTimestamp = &pickup
+		}
+	}
+
+	c.JSON(http.StatusOK, buildCashReconciliation(dayStart.Format("2006-01-02"), entries))
+}
+
+// buildCashReconciliation sums in minor units so the totals add up to the cent
+func buildCashReconciliation(date string, entries []ReconciliationEntry) CashReconciliation {
+	report := CashReconciliation{
+		Date:                   date,
+		Reservations:           make([]ReconciliationEntry, 0, len(entries)),
+		MismatchedReservations: make([]ReconciliationEntry, 0),
+	}
+
+	var expected, cash, bank, outstanding int64
+	for _, e := range entries {
+		report.Reservations = append(report.Reservations, e)
+		report.ReservationCount++
+
+		expectedMinor := toMinorUnits(e.ExpectedAmount)
+		expected += expectedMinor
+
+		if e.PaymentStatus != PaymentStatusPaid || e.AmountCollected == nil {
+			report.UnpaidCount++
+			outstanding += expectedMinor
+			continue
+		}
+
+		report.PaidCount++
+		collectedMinor := toMinorUnits(*e.AmountCollected)
+		if e.CollectionMethod != nil && *e.CollectionMethod == CollectionMethodBankTransfer {
+			bank += collectedMinor
+		} else {
+			cash += collectedMinor
+		}
+		if collectedMinor != expectedMinor {
+			report.MismatchedReservations = append(report.MismatchedReservations, e)
+		}
+	}
+
+	report.ExpectedAmount = fromMinorUnits(expected)
+	report.CollectedCash = fromMinorUnits(cash)
+	report.CollectedBankTransfer = fromMinorUnits(bank)
+	report.CollectedAmount = fromMinorUnits(cash + bank)
+	report.OutstandingAmount = fromMinorUnits(outstanding)
+	report.Difference = fromMinorUnits(cash + bank - expected)
+	return report
+}
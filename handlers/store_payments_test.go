@@ -0,0 +1,131 @@
+package handlers
+
+import "testing"
+
+func TestBuildCashReconciliation(t *testing.T) {
+	amount := func(v float64) *float64 { return &v }
+	method := func(m string) *string { return &m }
+
+	tests := []struct {
+		name    string
+		entries []ReconciliationEntry
+		want    CashReconciliation
+		// mismatched lists the reservations whose collected amount differs from the expected one
+		mismatched []string
+	}{
+		{
+			name: "no reservations",
+			want: CashReconciliation{},
+		},
+		{
+			name: "cash and bank transfers add up to the cent",
+			entries: []ReconciliationEntry{
+				{ReservationID: "r1", ExpectedAmount: 4.99, PaymentStatus: PaymentStatusPaid, AmountCollected: amount(4.99), CollectionMethod: method(CollectionMethodCash)},
+				{ReservationID: "r2", ExpectedAmount: 0.10, PaymentStatus: PaymentStatusPaid, AmountCollected: amount(0.10), CollectionMethod: method(CollectionMethodBankTransfer)},
+				{ReservationID: "r3", ExpectedAmount: 0.20, PaymentStatus: PaymentStatusPaid, AmountCollected: amount(0.20), CollectionMethod: method(CollectionMethodCash)},
+			},
+			want: CashReconciliation{
+				ExpectedAmount:        5.29,
+				CollectedAmount:       5.29,
+				CollectedCash:         5.19,
+				CollectedBankTransfer: 0.10,
+				ReservationCount:      3,
+				PaidCount:             3,
+			},
+		},
+		{
+			name: "unpaid reservations are outstanding",
+			entries: []ReconciliationEntry{
+				{ReservationID: "r1", ExpectedAmount: 10, PaymentStatus: PaymentStatusPaid, AmountCollected: amount(10), CollectionMethod: method(CollectionMethodCash)},
+				{ReservationID: "r2", ExpectedAmount: 7.50, PaymentStatus: PaymentStatusUnpaid},
+			},
+			want: CashReconciliation{
+				ExpectedAmount:    17.50,
+				CollectedAmount:   10,
+				CollectedCash:     10,
+				OutstandingAmount: 7.50,
+				Difference:        -7.50,
+				ReservationCount:  2,
+				PaidCount:         1,
+				UnpaidCount:       1,
+			},
+		},
+		{
+			name: "a payment without a method counts as cash",
+			entries: []ReconciliationEntry{
+				{ReservationID: "r1", ExpectedAmount: 3, PaymentStatus: PaymentStatusPaid, AmountCollected: amount(3)},
+			},
+			want: CashReconciliation{
+				ExpectedAmount:   3,
+				CollectedAmount:  3,
+				CollectedCash:    3,
+				ReservationCount: 1,
+				PaidCount:        1,
+			},
+		},
+		{
+			name: "over and under collection are mismatches",
+			entries: []ReconciliationEntry{
+				{ReservationID: "r1", ExpectedAmount: 5, PaymentStatus: PaymentStatusPaid, AmountCollected: amount(6), CollectionMethod: method(CollectionMethodCash)},
+				{ReservationID: "r2", ExpectedAmount: 5, PaymentStatus: PaymentStatusPaid, AmountCollected: amount(4.50), CollectionMethod: method(CollectionMethodBankTransfer)},
+				{ReservationID: "r3", ExpectedAmount: 5, PaymentStatus: PaymentStatusPaid, AmountCollected: amount(5), CollectionMethod: method(CollectionMethodCash)},
+			},
+			want: CashReconciliation{
+				ExpectedAmount:        15,
+				CollectedAmount:       15.50,
+				CollectedCash:         11,
+				CollectedBankTransfer: 4.50,
+				Difference:            0.50,
+				ReservationCount:      3,
+				PaidCount:             3,
+			},
+			mismatched: []string{"r1", "r2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildCashReconciliation("2024-03-01", tt.entries)
+
+			if got.Date != "2024-03-01" {
+				t.Errorf("Date = %q, want 2024-03-01", got.Date)
+			}
+			if len(got.Reservations) != len(tt.entries) {
+				t.Errorf("len(Reservations) = %d, want %d", len(got.Reservations), len(tt.entries))
+			}
+			checks := []struct {
+				field     string
+				got, want float64
+			}{
+				{"ExpectedAmount", got.ExpectedAmount, tt.want.ExpectedAmount},
+				{"CollectedAmount", got.CollectedAmount, tt.want.CollectedAmount},
+				{"CollectedCash", got.CollectedCash, tt.want.CollectedCash},
+				{"CollectedBankTransfer", got.CollectedBankTransfer, tt.want.CollectedBankTransfer},
+				{"OutstandingAmount", got.OutstandingAmount, tt.want.OutstandingAmount},
+				{"Difference", got.Difference, tt.want.Difference},
+			}
+			for _, c := range checks {
+				if c.got != c.want {
+					t.Errorf("%s = %v, want %v", c.field, c.got, c.want)
+				}
+			}
+			if got.ReservationCount != tt.want.ReservationCount || got.PaidCount != tt.want.PaidCount || got.UnpaidCount != tt.want.UnpaidCount {
+				t.Errorf("counts = %d/%d/%d, want %d/%d/%d", got.ReservationCount, got.PaidCount, got.UnpaidCount,
+					tt.want.ReservationCount, tt.want.PaidCount, tt.want.UnpaidCount)
+			}
+
+			var mismatched []string
+			for _, e := range got.MismatchedReservations {
+				mismatched = append(mismatched, e.ReservationID)
+			}
+			if len(mismatched) != len(tt.mismatched) {
+				t.Fatalf("mismatched = %v, want %v", mismatched, tt.mismatched)
+			}
+			for i := range mismatched {
+				if mismatched[i] != tt.mismatched[i] {
+					t.Errorf("mismatched = %v, want %v", mismatched, tt.mismatched)
+				}
+			}
+		})
+	}
+}
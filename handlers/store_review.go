@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"savor-server/db"
+	"savor-server/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Store review statuses (see check_store_review_status). Only approved stores are
+// shown on the home page and in search, and only they take reservations.
+const (
+	StoreReviewDraft         = "draft"
+	StoreReviewPendingReview = "pending_review"
+	StoreReviewApproved      = "approved"
+	StoreReviewSuspended     = "suspended"
+	// StoreReviewClosed stores have left the platform (see CloseStore)
+	StoreReviewClosed = "closed"
+)
+
+// Store review actions recorded in store_reviews
+const (
+	StoreReviewActionSubmitted = "submitted"
+	StoreReviewActionEdited    = "edited"
+	StoreReviewActionApproved  = "approved"
+	StoreReviewActionRejected  = "rejected"
+	StoreReviewActionSuspended = "suspended"
+	StoreReviewActionClosed    = "closed"
+)
+
+type StoreReview struct {
+	ID            string         `json:"id" db:"id"`
+	StoreID       string         `json:"storeId" db:"store_id"`
+	Action        string         `json:"action" db:"action"`
+	FromStatus    string         `json:"fromStatus" db:"from_status"`
+	ToStatus      string         `json:"toStatus" db:"to_status"`
+	Reason        *string        `json:"reason" db:"reason"`
+	ChangedFields pq.StringArray `json:"changedFields" db:"changed_fields"`
+	ActorID       *string        `json:"actorId" db:"actor_id"`
+	CreatedAt     time.Time      `json:"createdAt" db:"created_at"`
+}
+
+// storeMaterial holds the fields customers judge a store by; changing any of them on
+// an approved store needs another review
+type storeMaterial struct {
+	Title           string          `db:"title"`
+	Address         string          `db:"address"`
+	ImageURL        string          `db:"image_url"`
+	BackgroundURL   string          `db:"background_url"`
+	AvatarURL       sql.NullString  `db:"avatar_url"`
+	Price           sql.NullFloat64 `db:"price"`
+	OriginalPrice   sql.NullFloat64 `db:"original_price"`
+	DiscountedPrice sql.NullFloat64 `db:"discounted_price"`
+}
+
+func loadStoreMaterial(q sqlx.Queryer, storeID string) (storeMaterial, error) {
+	var m storeMaterial
+	err := sqlx.Get(q, &m, `
+		SELECT title, COALESCE(address, '') as address, COALESCE(image_url, '') as image_url,
+			COALESCE(background_url, '') as background_url, avatar_url, price, original_price, discounted_price
+		FROM stores WHERE id = $1
+	`, storeID)
+	return m, err
+}
+
+func (m storeMaterial) changedFields(after storeMaterial) []string {
+	var changed []string
+	if m.Title != after.Title {
+		changed = append(changed, "title")
+	}
+	if m.Address != after.Address {
+		changed = append(changed, "address")
+	}
+	if m.ImageURL != after.ImageURL || m.BackgroundURL != after.BackgroundURL || m.AvatarURL != after.AvatarURL {
+		changed = append(changed, "images")
+	}
+	if m.Price != after.Price || m.OriginalPrice != after.OriginalPrice || m.DiscountedPrice != after.DiscountedPrice {
+		changed = append(changed, "prices")
+	}
+	return changed
+}
+
+// flagMaterialEdits compares the store with a snapshot taken before an update and sends
+// an approved store back to review if its title, address, images or prices changed.
+// Call it in the same transaction as the update.
+func flagMaterialEdits(tx sqlx.Ext, storeID, userID string, before storeMaterial) error {
+	after, err := loadStoreMaterial(tx, storeID)
+	if err != nil {
+		return fmt.Errorf("failed to load store: %v", err)
+	}
+	changed := before.changedFields(after)
+	if len(changed) == 0 {
+		return nil
+	}
+
+	result, err := tx.Exec(`
+		UPDATE stores SET review_status = $1 WHERE id = $2 AND review_status = $3
+	`, StoreReviewPendingReview, storeID, StoreReviewApproved)
+	if err != nil {
+		return fmt.Errorf("failed to update review status: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO store_reviews (store_id, action, from_status, to_status, changed_fields, actor_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, storeID, StoreReviewActionEdited, StoreReviewApproved, StoreReviewPendingReview, pq.Array(changed), userID)
+	if err != nil {
+		return fmt.Errorf("failed to record store review: %v", err)
+	}
+	log.Printf("Store %s changed %v and is pending review again", storeID, changed)
+	return nil
+}
+
+// changeStoreReviewStatus moves a store between review statuses and records why
+func changeStoreReviewStatus(tx *sqlx.Tx, storeID, action, from, to, reason, actorID string) (*StoreReview, error) {
+	_, err := tx.Exec(`UPDATE stores SET review_status = $1, updated_at = NOW() WHERE id = $2`, to, storeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update review status: %v", err)
+	}
+
+	var review StoreReview
+	err = tx.Get(&review, `
+		INSERT INTO store_reviews (store_id, action, from_status, to_status, reason, actor_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)
+		RETURNING id, store_id, action, from_status, to_status, reason, changed_fields, actor_id, created_at
+	`, storeID, action, from, to, reason, actorID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record store review: %v", err)
+	}
+	return &review, nil
+}
+
+// recordStoreReviewAudit logs a review status change made by the owner or an admin
+func recordStoreReviewAudit(c *gin.Context, tx *sqlx.Tx, review StoreReview) error {
+	changes := map[string]StoreAuditChange{
+		"review_status": {Before: auditValue(review.FromStatus), After: auditValue(review.ToStatus)},
+	}
+	if review.Reason != nil {
+		changes["review_reason"] = StoreAuditChange{Before: auditValue(nil), After: auditValue(review.Reason)}
+	}
+	return recordStoreAudit(c, tx, review.StoreID, StoreAuditReviewChanged, changes)
+}
+
+// notifyStoreReview emails the store's owners about an admin decision
+func notifyStoreReview(storeID string, review StoreReview) {
+	emailService := services.GetEmailService()
+	if !emailService.IsConfigured() {
+		return
+	}
+
+	var owners []struct {
+		Email     string `db:"email"`
+		StoreName string `db:"store_name"`
+	}
+	err := db.DB.Select(&owners, `
+		SELECT DISTINCT u.email, s.title as store_name
+		FROM store_members m
+		JOIN stores s ON s.id = m.store_id
+		JOIN users u ON m.user_id = u.id::text
+		WHERE m.store_id = $1 AND m.role = $2
+	`, storeID, StoreRoleOwner)
+	if err != nil {
+		log.Printf("WARNING: Failed to load owners of store %s: %v", storeID, err)
+		return
+	}
+
+	reason := ""
+	if review.Reason != nil {
+		reason = *review.Reason
+	}
+	for _, owner := range owners {
+		err := emailService.SendStoreReviewUpdate(owner.Email, services.StoreReviewEmailData{
+			StoreName: owner.StoreName,
+			Action:    review.Action,
+			Reason:    reason,
+		})
+		if err != nil {
+			log.Printf("WARNING: Failed to send store review email to %s: %v", owner.Email, err)
+		}
+	}
+}
+
+// GetStoreReview shows the store's review status and history to its team
+func GetStoreReview(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermViewStore)
+	if !ok {
+		return
+	}
+
+	var status string
+	if err := db.DB.Get(&status, `SELECT review_status FROM stores WHERE id = $1`, storeID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get review status"})
+		return
+	}
+
+	history := make([]StoreReview, 0)
+	err := db.DB.Select(&history, `
+		SELECT id, store_id, action, from_status, to_status, reason, changed_fields, actor_id, created_at
+		FROM store_reviews
+		WHERE store_id = $1
+		ORDER BY created_at DESC
+		LIMIT 20
+	`, storeID)
+	if err != nil {
+		log.Printf("ERROR: Failed to load review history of store %s: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get review status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reviewStatus": status,
+		"history":      history,
+	})
+}
+
+// SubmitStoreForReview asks admins to approve a draft store or reopen a closed one
+func SubmitStoreForReview(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	storeID, ok := authorizeStore(c, PermManageStore)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	var anonymized bool
+	err = tx.QueryRow(`
+		SELECT review_status, pii_anonymized_at IS NOT NULL FROM stores WHERE id = $1 FOR UPDATE
+	`, storeID).Scan(&status, &anonymized)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get review status"})
+		return
+	}
+	// A closed store comes back only through an admin review, and not at all once the
+	// owner's data has been anonymized
+	if status == StoreReviewClosed && anonymized {
+		c.JSON(http.StatusConflict, gin.H{"error": "Store was closed and its records anonymized; it cannot be reopened"})
+		return
+	}
+	if status != StoreReviewDraft && status != StoreReviewClosed {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Store is %s and cannot be submitted", status)})
+		return
+	}
+
+	review, err := changeStoreReviewStatus(tx, storeID, StoreReviewActionSubmitted, status, StoreReviewPendingReview, "", userID)
+	if err == nil {
+		err = recordStoreReviewAudit(c, tx, *review)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to submit store %s for review: %v", storeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit store for review"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reviewStatus": StoreReviewPendingReview, "review": review})
+}
+
+// ListStoresForReview lists stores in a review status, pending_review by default, oldest first
+func ListStoresForReview(c *gin.Context) {
+	status := c.DefaultQuery("status", StoreReviewPendingReview)
+
+	var stores []struct {
+		ID           string    `json:"id" db:"id"`
+		Title        string    `json:"title" db:"title"`
+		Address      string    `json:"address" db:"address"`
+		ImageURL     string    `json:"imageUrl" db:"image_url"`
+		OwnerID      string    `json:"ownerId" db:"owner_id"`
+		ReviewStatus string    `json:"reviewStatus" db:"review_status"`
+		Since        time.Time `json:"since" db:"since"`
+	}
+	err := db.DB.Select(&stores, `
+		SELECT s.id, s.title, COALESCE(s.address, '') as address, COALESCE(s.image_url, '') as image_url,
+			s.owner_id, s.review_status,
+			COALESCE((SELECT MAX(r.created_at) FROM store_reviews r WHERE r.store_id = s.id), s.created_at) as since
+		FROM stores s
+		WHERE s.review_status = $1
+		ORDER BY since
+	`, status)
+	if err != nil {
+		log.Printf("ERROR: Failed to list stores for review: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list stores"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"stores": stores, "total": len(stores)})
+}
+
+// storeReviewTransitions says which statuses each admin action applies to and where it leads
+var storeReviewTransitions = map[string]struct {
+	from           []string
+	to             string
+	reasonRequired bool
+}{
+	StoreReviewActionApproved:  {[]string{StoreReviewPendingReview, StoreReviewSuspended}, StoreReviewApproved, false},
+	StoreReviewActionRejected:  {[]string{StoreReviewPendingReview}, StoreReviewDraft, true},
+	StoreReviewActionSuspended: {[]string{StoreReviewPendingReview, StoreReviewApproved}, StoreReviewSuspended, true},
+}
+
+// ReviewStore returns the admin handler that approves, rejects or suspends a store
+func ReviewStore(action string) gin.HandlerFunc {
+	transition := storeReviewTransitions[action]
+	return func(c *gin.Context) {
+		var req struct {
+			Reason string `json:"reason"`
+		}
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		req.Reason = strings.TrimSpace(req.Reason)
+		if transition.reasonRequired && req.Reason == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+			return
+		}
+
+		storeID := c.Param("id")
+		tx, err := db.DB.Beginx()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+			return
+		}
+		defer tx.Rollback()
+
+		var status string
+		err = tx.Get(&status, `SELECT review_status FROM stores WHERE id = $1 FOR UPDATE`, storeID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Store not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get review status"})
+			return
+		}
+
+		allowed := false
+		for _, from := range transition.from {
+			allowed = allowed || from == status
+		}
+		if !allowed {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Store is %s and cannot be %s", status, action)})
+			return
+		}
+
+		review, err := changeStoreReviewStatus(tx, storeID, action, status, transition.to, req.Reason, c.GetString("user_id"))
+		if err == nil {
+			err = recordStoreReviewAudit(c, tx, *review)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to %s store %s: %v", action, storeID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update store review"})
+			return
+		}
+
+		go notifyStoreReview(storeID, *review)
+
+		c.JSON(http.StatusOK, gin.H{"reviewStatus": transition.to, "review": review})
+	}
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"savor-server/db"
+
+	"github.com/lib/pq"
+)
+
+// defaultStoreTimezone is used for stores whose zone cannot be worked out; every
+// store so far is in Vietnam
+const defaultStoreTimezone = "Asia/Ho_Chi_Minh"
+
+// countryTimezones maps countries with a single zone, keyed by lower-cased name or ISO code
+var countryTimezones = map[string]string{
+	"vietnam":        "Asia/Ho_Chi_Minh",
+	"viet nam":       "Asia/Ho_Chi_Minh",
+	"việt nam":       "Asia/Ho_Chi_Minh",
+	"vn":             "Asia/Ho_Chi_Minh",
+	"thailand":       "Asia/Bangkok",
+	"th":             "Asia/Bangkok",
+	"cambodia":       "Asia/Phnom_Penh",
+	"kh":             "Asia/Phnom_Penh",
+	"laos":           "Asia/Vientiane",
+	"la":             "Asia/Vientiane",
+	"singapore":      "Asia/Singapore",
+	"sg":             "Asia/Singapore",
+	"malaysia":       "Asia/Kuala_Lumpur",
+	"my":             "Asia/Kuala_Lumpur",
+	"philippines":    "Asia/Manila",
+	"ph":             "Asia/Manila",
+	"japan":          "Asia/Tokyo",
+	"jp":             "Asia/Tokyo",
+	"south korea":    "Asia/Seoul",
+	"kr":             "Asia/Seoul",
+	"france":         "Europe/Paris",
+	"fr":             "Europe/Paris",
+	"germany":        "Europe/Berlin",
+	"de":             "Europe/Berlin",
+	"united kingdom": "Europe/London",
+	"uk":             "Europe/London",
+	"gb":             "Europe/London",
+}
+
+// defaultTimezoneFor picks a zone for a new store from its country, or failing that a
+// fixed-offset zone from its longitude. Owners can correct it afterwards.
+func defaultTimezoneFor(country string, latitude, longitude float64) string {
+	if tz, ok := countryTimezones[strings.ToLower(strings.TrimSpace(country))]; ok {
+		return tz
+	}
+	if latitude == 0 && longitude == 0 {
+		return defaultStoreTimezone
+	}
+
+	// Etc/GMT zones have inverted signs: Etc/GMT-7 is UTC+7
+	offset := int(math.Round(longitude / 15))
+	switch {
+	case offset == 0:
+		return "Etc/UTC"
+	case offset > 0:
+		return fmt.Sprintf("Etc/GMT-%d", offset)
+	default:
+		return fmt.Sprintf("Etc/GMT+%d", -offset)
+	}
+}
+
+// isValidTimezone reports whether name is an IANA zone such as "Asia/Ho_Chi_Minh"
+func isValidTimezone(name string) bool {
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+var (
+	locationCacheMu sync.RWMutex
+	locationCache   = make(map[string]*time.Location)
+)
+
+// storeLocation loads a store's zone, falling back to defaultStoreTimezone
+func storeLocation(name string) *time.Location {
+	if name == "" {
+		name = defaultStoreTimezone
+	}
+
+	locationCacheMu.RLock()
+	loc, ok := locationCache[name]
+	locationCacheMu.RUnlock()
+	if ok {
+		return loc
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("WARNING: Unknown store timezone %q, using %s: %v", name, defaultStoreTimezone, err)
+		if name == defaultStoreTimezone {
+			return time.UTC
+		}
+		return storeLocation(defaultStoreTimezone)
+	}
+
+	locationCacheMu.Lock()
+	locationCache[name] = loc
+	locationCacheMu.Unlock()
+	return loc
+}
+
+// storeLocationByID looks up the zone of one store
+func storeLocationByID(storeID string) *time.Location {
+	var name string
+	if err := db.DB.Get(&name, `SELECT timezone FROM stores WHERE id = $1`, storeID); err != nil {
+		log.Printf("WARNING: Failed to get timezone of store %s: %v", storeID, err)
+	}
+	return storeLocation(name)
+}
+
+// loadStoreLocations looks up the zones of several stores
+func loadStoreLocations(storeIDs []string) (map[string]*time.Location, error) {
+	var rows []struct {
+		ID       string `db:"id"`
+		Timezone string `db:"timezone"`
+	}
+	err := db.DB.Select(&rows, `SELECT id, timezone FROM stores WHERE id = ANY($1)`, pq.Array(storeIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load store timezones: %v", err)
+	}
+
+	locations := make(map[string]*time.Location, len(rows))
+	for _, r := range rows {
+		locations[r.ID] = storeLocation(r.Timezone)
+	}
+	return locations, nil
+}
+
+// startOfDay returns midnight of t's day in loc
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
@@ -0,0 +1,487 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"savor-server/db"
+	"savor-server/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// Wallet transaction kinds (see check_wallet_transaction_kind)
+const (
+	WalletTxRefundCredit       = "refund_credit"
+	WalletTxGiftCardRedemption = "gift_card_redemption"
+	WalletTxPromotion          = "promotion"
+	WalletTxReservationPayment = "reservation_payment"
+	WalletTxPaymentReversal    = "reservation_payment_reversal"
+)
+
+var errInsufficientWalletBalance = errors.New("insufficient wallet balance")
+
+type WalletTransaction struct {
+	ID                string         `json:"id" db:"id"`
+	UserID            string         `json:"userId" db:"user_id"`
+	AmountMinor       int64          `json:"amountMinor" db:"amount_minor"`
+	BalanceAfterMinor int64          `json:"balanceAfterMinor" db:"balance_after_minor"`
+	Kind              string         `json:"kind" db:"kind"`
+	ReferenceID       sql.NullString `json:"-" db:"reference_id"`
+	Description       sql.NullString `json:"-" db:"description"`
+	CreatedBy         sql.NullString `json:"-" db:"created_by"`
+	CreatedAt         time.Time      `json:"createdAt" db:"created_at"`
+}
+
+type WalletResponse struct {
+	Balance      float64                     `json:"balance"`
+	BalanceMinor int64                       `json:"balanceMinor"`
+	Currency     string                      `json:"currency"`
+	Transactions []WalletTransactionResponse `json:"transactions"`
+}
+
+type WalletTransactionResponse struct {
+	ID                string    `json:"id"`
+	Amount            float64   `json:"amount"`
+	AmountMinor       int64     `json:"amountMinor"`
+	BalanceAfterMinor int64     `json:"balanceAfterMinor"`
+	Kind              string    `json:"kind"`
+	ReferenceID       string    `json:"referenceId,omitempty"`
+	Description       string    `json:"description,omitempty"`
+	CreatedAt         time.Time `json:"createdAt"`
+}
+
+type PurchaseGiftCardRequest struct {
+	Amount         float64 `json:"amount" binding:"required,gt=0"`
+	RecipientEmail string  `json:"recipientEmail"`
+}
+
+type RedeemGiftCardRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type AdminWalletCreditRequest struct {
+	UserID string  `json:"userId" binding:"required"`
+	Amount float64 `json:"amount" binding:"required,gt=0"`
+	Reason string  `json:"reason" binding:"required"`
+}
+
+// toMinorUnits converts a decimal amount (as used by reservations and stores) to cents
+func toMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func fromMinorUnits(minor int64) float64 {
+	return float64(minor) / 100
+}
+
+// applyWalletTransaction locks the user's wallet row, applies a signed amount and
+// appends the matching log entry. It must run inside tx so the balance update and
+// the log entry commit together; concurrent callers serialize on the row lock.
+func applyWalletTransaction(tx *sqlx.Tx, userID string, amountMinor int64, kind, referenceID, description, createdBy string) (*WalletTransaction, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("wallet user is required")
+	}
+	if amountMinor == 0 {
+		return nil, fmt.Errorf("wallet transaction amount must not be zero")
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO wallets (user_id) VALUES ($1)
+		ON CONFLICT (user_id) DO NOTHING
+	`, userID); err != nil {
+		return nil, fmt.Errorf("failed to create wallet: %v", err)
+	}
+
+	var balance int64
+	if err := tx.Get(&balance, `SELECT balance_minor FROM wallets WHERE user_id = $1 FOR UPDATE`, userID); err != nil {
+		return nil, fmt.Errorf("failed to lock wallet: %v", err)
+	}
+
+	newBalance := balance + amountMinor
+	if newBalance < 0 {
+		return nil, errInsufficientWalletBalance
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE wallets SET balance_minor = $1, updated_at = NOW() WHERE user_id = $2
+	`, newBalance, userID); err != nil {
+		return nil, fmt.Errorf("failed to update wallet balance: %v", err)
+	}
+
+	var txn WalletTransaction
+	err := tx.Get(&txn, `
+		INSERT INTO wallet_transactions (
+			user_id, amount_minor, balance_after_minor, kind, reference_id, description, created_by
+		) VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''))
+		RETURNING id, user_id, amount_minor, balance_after_minor, kind, reference_id, description, created_by, created_at
+	`, userID, amountMinor, newBalance, kind, referenceID, description, createdBy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record wallet transaction: %v", err)
+	}
+
+	return &txn, nil
+}
+
+func toWalletTransactionResponse(t WalletTransaction) WalletTransactionResponse {
+	return WalletTransactionResponse{
+		ID:                t.ID,
+		Amount:            fromMinorUnits(t.AmountMinor),
+		AmountMinor:       t.AmountMinor,
+		BalanceAfterMinor: t.BalanceAfterMinor,
+		Kind:              t.Kind,
+		ReferenceID:       t.ReferenceID.String,
+		Description:       t.Description.String,
+		CreatedAt:         t.CreatedAt,
+	}
+}
+
+func loadWalletTransactions(userID string, limit, offset int) ([]WalletTransactionResponse, error) {
+	var txns []WalletTransaction
+	err := db.DB.Select(&txns, `
+		SELECT id, user_id, amount_minor, balance_after_minor, kind, reference_id, description, created_by, created_at
+		FROM wallet_transactions
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]WalletTransactionResponse, 0, len(txns))
+	for _, t := range txns {
+		response = append(response, toWalletTransactionResponse(t))
+	}
+	return response, nil
+}
+
+// GetWallet returns the authenticated user's balance and most recent transactions
+func GetWallet(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	wallet := WalletResponse{Currency: "usd"}
+	err := db.DB.QueryRow(`
+		SELECT balance_minor, currency FROM wallets WHERE user_id = $1
+	`, userID).Scan(&wallet.BalanceMinor, &wallet.Currency)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("ERROR: Failed to get wallet for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get wallet"})
+		return
+	}
+	wallet.Balance = fromMinorUnits(wallet.BalanceMinor)
+
+	wallet.Transactions, err = loadWalletTransactions(userID, 20, 0)
+	if err != nil {
+		log.Printf("ERROR: Failed to get wallet transactions for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get wallet transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, wallet)
+}
+
+// GetWalletTransactions returns a page of the authenticated user's transaction log
+func GetWalletTransactions(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+
+	transactions, err := loadWalletTransactions(userID, limit, offset)
+	if err != nil {
+		log.Printf("ERROR: Failed to get wallet transactions for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get wallet transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transactions": transactions,
+		"limit":        limit,
+		"offset":       offset,
+	})
+}
+
+// PurchaseGiftCard creates a card PaymentIntent for a gift card. The card is only
+// issued once the payment is confirmed through ConfirmGiftCardPurchase.
+func PurchaseGiftCard(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req PurchaseGiftCardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pi, err := services.Payments.CreatePaymentIntent(services.CreatePaymentIntentParams{
+		AmountMinor: toMinorUnits(req.Amount),
+		Currency:    "usd",
+		Metadata: map[string]string{
+			"type":              "gift_card",
+			"purchaser_user_id": userID,
+			"recipient_email":   strings.TrimSpace(req.RecipientEmail),
+		},
+	})
+	if err != nil {
+		log.Printf("ERROR: Failed to create gift card payment intent: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"clientSecret":    pi.ClientSecret,
+		"paymentIntentId": pi.ID,
+	})
+}
+
+// ConfirmGiftCardPurchase issues the gift card for a succeeded PaymentIntent.
+// Confirming the same PaymentIntent twice returns the already issued card.
+func ConfirmGiftCardPurchase(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req struct {
+		PaymentIntentId string `json:"paymentIntentId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request parameters"})
+		return
+	}
+
+	pi, err := services.Payments.GetPaymentIntent(req.PaymentIntentId)
+	if err != nil {
+		log.Printf("ERROR: Failed to verify gift card payment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify payment"})
+		return
+	}
+
+	if pi.Metadata["type"] != "gift_card" || pi.Metadata["purchaser_user_id"] != userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment is not a gift card purchase"})
+		return
+	}
+
+	if pi.Status != services.PaymentIntentStatusSucceeded {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment not completed"})
+		return
+	}
+
+	code, err := generateGiftCardCode()
+	if err != nil {
+		log.Printf("ERROR: Failed to generate gift card code: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue gift card"})
+		return
+	}
+
+	recipientEmail := pi.Metadata["recipient_email"]
+	_, err = db.DB.Exec(`
+		INSERT INTO gift_cards (code, amount_minor, currency, purchaser_user_id, recipient_email, payment_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)
+		ON CONFLICT (payment_id) DO NOTHING
+	`, code, pi.Amount, string(pi.Currency), userID, recipientEmail, pi.ID)
+	if err != nil {
+		log.Printf("ERROR: Failed to insert gift card for payment %s: %v", pi.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue gift card"})
+		return
+	}
+
+	var card struct {
+		Code        string    `db:"code"`
+		AmountMinor int64     `db:"amount_minor"`
+		Status      string    `db:"status"`
+		CreatedAt   time.Time `db:"created_at"`
+	}
+	err = db.DB.Get(&card, `
+		SELECT code, amount_minor, status, created_at FROM gift_cards WHERE payment_id = $1
+	`, pi.ID)
+	if err != nil {
+		log.Printf("ERROR: Failed to load gift card for payment %s: %v", pi.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue gift card"})
+		return
+	}
+
+	// Only email the recipient for the request that actually issued the card
+	if card.Code == code && recipientEmail != "" {
+		go func() {
+			emailService := services.GetEmailService()
+			if emailService != nil && emailService.IsConfigured() {
+				emailData := services.GiftCardEmailData{
+					Code:   card.Code,
+					Amount: fromMinorUnits(card.AmountMinor),
+				}
+				if err := emailService.SendGiftCard(recipientEmail, emailData); err != nil {
+					log.Printf("Failed to send gift card email: %v", err)
+				}
+			}
+		}()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":        card.Code,
+		"amount":      fromMinorUnits(card.AmountMinor),
+		"amountMinor": card.AmountMinor,
+		"status":      card.Status,
+		"createdAt":   card.CreatedAt,
+	})
+}
+
+// RedeemGiftCard credits the full value of an active gift card to the user's wallet
+func RedeemGiftCard(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req RedeemGiftCardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	code := normalizeGiftCardCode(req.Code)
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	var card struct {
+		ID          string `db:"id"`
+		AmountMinor int64  `db:"amount_minor"`
+		Status      string `db:"status"`
+	}
+	err = tx.Get(&card, `SELECT id, amount_minor, status FROM gift_cards WHERE code = $1 FOR UPDATE`, code)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+			return
+		}
+		log.Printf("ERROR: Failed to look up gift card: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redeem gift card"})
+		return
+	}
+
+	if card.Status != "active" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Gift card has already been redeemed"})
+		return
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE gift_cards SET status = 'redeemed', redeemed_by = $1, redeemed_at = NOW() WHERE id = $2
+	`, userID, card.ID); err != nil {
+		log.Printf("ERROR: Failed to mark gift card %s redeemed: %v", card.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redeem gift card"})
+		return
+	}
+
+	txn, err := applyWalletTransaction(tx, userID, card.AmountMinor, WalletTxGiftCardRedemption, card.ID, "Gift card redemption", userID)
+	if err != nil {
+		log.Printf("ERROR: Failed to credit gift card %s: %v", card.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redeem gift card"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Gift card redeemed successfully",
+		"transaction": toWalletTransactionResponse(*txn),
+		"balance":     fromMinorUnits(txn.BalanceAfterMinor),
+	})
+}
+
+// AdminCreditWallet grants promotional credit to a user
+func AdminCreditWallet(c *gin.Context) {
+	var req AdminWalletCreditRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	txn, err := applyWalletTransaction(tx, req.UserID, toMinorUnits(req.Amount), WalletTxPromotion, "", req.Reason, c.GetString("user_id"))
+	if err != nil {
+		log.Printf("ERROR: Failed to credit wallet for user %s: %v", req.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to credit wallet"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Wallet credited successfully",
+		"transaction": toWalletTransactionResponse(*txn),
+	})
+}
+
+const giftCardAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// generateGiftCardCode returns a random code like ABCD-EFGH-JKLM without ambiguous characters
+func generateGiftCardCode() (string, error) {
+	var b strings.Builder
+	for i := 0; i < 12; i++ {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(giftCardAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(giftCardAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+func normalizeGiftCardCode(code string) string {
+	code = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	if len(code) == 12 && !strings.Contains(code, "-") {
+		code = code[0:4] + "-" + code[4:8] + "-" + code[8:12]
+	}
+	return code
+}
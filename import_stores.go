@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"savor-server/db"
+	"savor-server/handlers"
+	"savor-server/services"
+)
+
+// runImportStores is the import-stores subcommand: it upserts the stores of a CSV file
+// (see handlers/store_import.go for the columns) and prints a line per row. It exits
+// non-zero if any row failed.
+func runImportStores(args []string) int {
+	flags := flag.NewFlagSet("import-stores", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "validate and geocode every row, then roll back instead of saving")
+	owner := flags.String("owner", "", "Firebase UID that owns stores whose row has no owner_id")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: savor-server import-stores [-dry-run] [-owner uid] file.csv")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return 1
+	}
+	defer file.Close()
+
+	if err := db.Init(); err != nil {
+		return 1
+	}
+	services.InitializeGoogleMaps()
+	if err := services.InitializeGeocoder(); err != nil {
+		log.Printf("ERROR: Failed to initialize geocoder: %v", err)
+		return 1
+	}
+
+	results, err := handlers.ImportStores(context.Background(), file, handlers.StoreImportOptions{
+		DryRun:  *dryRun,
+		OwnerID: *owner,
+	})
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return 1
+	}
+
+	counts := make(map[string]int)
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out, "ROW\tKEY\tRESULT\tSTORE\tDETAILS")
+	for _, r := range results {
+		counts[r.Action]++
+		details := r.Error
+		if details == "" && r.NeedsConfirmation {
+			details = "approximate location, check the pin"
+		}
+		fmt.Fprintf(out, "%d\t%s\t%s\t%s\t%s\n", r.Row, r.ExternalKey, r.Action, r.StoreID, details)
+	}
+	out.Flush()
+
+	summary := fmt.Sprintf("%d created, %d updated, %d failed",
+		counts[handlers.StoreImportCreated], counts[handlers.StoreImportUpdated], counts[handlers.StoreImportFailed])
+	if *dryRun {
+		summary += " (dry run, nothing was saved)"
+	}
+	fmt.Println(summary)
+
+	if counts[handlers.StoreImportFailed] > 0 {
+		return 1
+	}
+	return 0
+}
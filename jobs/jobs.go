@@ -0,0 +1,35 @@
+// Package jobs runs periodic background work inside the API process.
+package jobs
+
+import (
+	"log"
+	"time"
+)
+
+// Every runs fn now and then every interval until the process exits.
+// Errors are logged and the job keeps its schedule.
+func Every(name string, interval time.Duration, fn func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			run(name, fn)
+			<-ticker.C
+		}
+	}()
+	log.Printf("Scheduled job %s every %s", name, interval)
+}
+
+func run(name string, fn func() error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ERROR: Job %s panicked: %v", name, r)
+		}
+	}()
+
+	start := time.Now()
+	if err := fn(); err != nil {
+		log.Printf("ERROR: Job %s failed after %s: %v", name, time.Since(start), err)
+	}
+}
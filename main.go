@@ -5,6 +5,9 @@ import (
 	"context"
 	"log"
 	"os"
+	"strings"
+	"time"
+	_ "time/tzdata" // store timezones must resolve in the alpine image, which has no zoneinfo
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
@@ -15,6 +18,7 @@ import (
 
 	// "savor-server/db"
 	"savor-server/handlers"
+	"savor-server/jobs"
 	"savor-server/middleware"
 	"savor-server/services"
 
@@ -57,6 +61,11 @@ func main() {
 	log.SetOutput(os.Stdout)
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	// Subcommands run instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "import-stores" {
+		os.Exit(runImportStores(os.Args[2:]))
+	}
+
 	// Initialize Firebase
 	app, err := config.InitializeFirebase()
 	if err != nil {
@@ -87,23 +96,47 @@ func main() {
 
 	db.Init()
 
-	// Initialize Stripe
-	stripeKey := os.Getenv("STRIPE_SECRET_KEY")
-	if stripeKey == "" {
-		log.Fatal("STRIPE_SECRET_KEY is required")
+	// Initialize Stripe (PAYMENT_GATEWAY=fake keeps payments in memory for local development)
+	if os.Getenv("PAYMENT_GATEWAY") == "fake" {
+		services.Payments = services.NewFakePaymentGateway()
+		log.Printf("Warning: Using in-memory fake payment gateway")
+	} else {
+		stripeKey := os.Getenv("STRIPE_SECRET_KEY")
+		if stripeKey == "" {
+			log.Fatal("STRIPE_SECRET_KEY is required")
+		}
+		config.InitializeStripe(stripeKey)
+		services.InitializePayments()
 	}
-	config.InitializeStripe(stripeKey)
 
 	// Initialize Google Maps
 	services.InitializeGoogleMaps()
+	if err := services.InitializeGeocoder(); err != nil {
+		log.Fatalf("Error initializing geocoder: %v\n", err)
+	}
+	if err := services.InitializeDistanceProvider(); err != nil {
+		log.Fatalf("Error initializing distance provider: %v\n", err)
+	}
 
 	// Initialize Email Service
 	services.InitializeEmailService()
 
+	// Initialize object storage for uploaded store photos
+	if err := services.InitializeStorage(); err != nil {
+		log.Fatalf("Error initializing storage: %v\n", err)
+	}
+
 	// Initialize Notification Service
 	services.InitializeNotificationService()
 	log.Printf("Notification service initialized")
 
+	// Background jobs
+	jobs.Every("expire-abandoned-checkouts", 5*time.Minute, handlers.ExpireAbandonedCheckouts)
+	jobs.Every("refresh-pickup-windows", time.Hour, handlers.RefreshPickupWindows)
+	jobs.Every("reset-daily-inventory", 5*time.Minute, handlers.ResetDailyInventory)
+	jobs.Every("refund-closed-store-reservations", 15*time.Minute, handlers.RefundClosedStoreReservations)
+	jobs.Every("anonymize-closed-stores", 24*time.Hour, handlers.AnonymizeClosedStores)
+
 	// Initialize Gin router with appropriate mode
 	ginMode := os.Getenv("GIN_MODE")
 	if ginMode == "" {
@@ -112,6 +145,7 @@ func main() {
 	gin.SetMode(ginMode)
 	r := gin.Default()
 	r.Use(gin.Logger(), gin.Recovery())
+	r.Use(middleware.RequestIDMiddleware())
 
 	// Initialize session store with better configuration
 	sessionSecret := os.Getenv("SESSION_SECRET")
@@ -157,14 +191,19 @@ func main() {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     allowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", middleware.RequestIDHeader},
+		ExposeHeaders:    []string{"Content-Length", middleware.RequestIDHeader},
 		AllowCredentials: true,
 	}))
 
 	// Swagger route
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
+	// Uploaded store photos, when they are kept on local disk
+	if local, ok := services.Storage.(*services.LocalStorage); ok && strings.HasPrefix(local.BaseURL, "/") {
+		r.Static(local.BaseURL, local.Dir)
+	}
+
 	// Health check endpoint for Railway
 	r.GET("/api/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
@@ -190,13 +229,16 @@ func main() {
 	{
 		protected.GET("/profile", handlers.GetProfile)
 		protected.PUT("/profile", handlers.UpdateProfile)
+		protected.GET("/dietary-preferences", handlers.GetDietaryPreferences)
+		protected.PUT("/dietary-preferences", handlers.UpdateDietaryPreferences)
 	}
 
 	// Home routes - Direct Supabase PostgreSQL (Recommended)
 	homeGroup := r.Group("/api/home")
 	{
-		homeGroup.GET("", handlers.GetHomePageData)
-		homeGroup.GET("/search", handlers.SearchStores)
+		homeGroup.GET("", middleware.OptionalAuthMiddleware(authClient), handlers.GetHomePageData)
+		homeGroup.GET("/search", middleware.OptionalAuthMiddleware(authClient), handlers.SearchStores)
+		homeGroup.GET("/search/facets", middleware.OptionalAuthMiddleware(authClient), handlers.SearchFacets)
 		homeGroup.POST("/stores/:id/save", handlers.SaveStore)
 		homeGroup.POST("/stores/:id/unsave", handlers.UnsaveStore)
 		homeGroup.GET("/stores/favorites", handlers.GetFavorites)
@@ -204,11 +246,22 @@ func main() {
 
 	storesGroup := r.Group("/api/stores")
 	{
-		storesGroup.GET("/:id", handlers.GetStoreDetail)
+		storesGroup.GET("/:id", middleware.OptionalAuthMiddleware(authClient), handlers.GetStoreDetail)
+		storesGroup.GET("/:id/pickup-windows", handlers.GetStorePickupWindows)
 		storesGroup.POST("/:id/toggle-save", middleware.AuthMiddleware(authClient), handlers.ToggleSaveStore)
 		storesGroup.GET("/favorites", middleware.AuthMiddleware(authClient), handlers.GetFavorites)
 	}
 
+	categoriesGroup := r.Group("/api/categories")
+	{
+		categoriesGroup.GET("", handlers.ListCategories)
+		categoriesGroup.GET("/browse", middleware.OptionalAuthMiddleware(authClient), handlers.BrowseCategories)
+		categoriesGroup.GET("/:slug/stores", middleware.OptionalAuthMiddleware(authClient), handlers.GetCategoryStores)
+	}
+
+	// Dietary and allergen tags stores, bags and customer preferences are chosen from
+	r.GET("/api/dietary-tags", handlers.ListDietaryTags)
+
 	// Maps routes
 	mapsGroup := r.Group("/api/maps")
 	{
@@ -219,9 +272,17 @@ func main() {
 
 	paymentGroup := r.Group("/api/payment")
 	{
-		paymentGroup.POST("/create-intent", middleware.AuthMiddleware(authClient), handlers.CreateReservation)
-		paymentGroup.POST("/confirm", middleware.AuthMiddleware(authClient), handlers.ConfirmReservation)
-		paymentGroup.POST("/confirm-pay-at-store", middleware.AuthMiddleware(authClient), handlers.ConfirmPayAtStore)
+		paymentGroup.POST("/create-intent", middleware.VerifiedAuthMiddleware(authClient), handlers.CreateReservation)
+		paymentGroup.POST("/confirm", middleware.VerifiedAuthMiddleware(authClient), handlers.ConfirmReservation)
+		paymentGroup.POST("/confirm-pay-at-store", middleware.VerifiedAuthMiddleware(authClient), handlers.ConfirmPayAtStore)
+	}
+
+	paymentMethodsGroup := r.Group("/api/payment-methods")
+	paymentMethodsGroup.Use(middleware.VerifiedAuthMiddleware(authClient))
+	{
+		paymentMethodsGroup.GET("", handlers.GetPaymentMethods)
+		paymentMethodsGroup.POST("/setup-intent", handlers.CreateSetupIntent)
+		paymentMethodsGroup.DELETE("/:id", handlers.DeletePaymentMethod)
 	}
 
 	reservationsGroup := r.Group("/api/reservations")
@@ -234,18 +295,48 @@ func main() {
 		reservationsGroup.GET("/guest", handlers.GetGuestReservations)
 		reservationsGroup.POST("/guest", handlers.CreateGuestReservation)
 		reservationsGroup.DELETE("/guest/:id", handlers.DeleteGuestReservation)
-		reservationsGroup.DELETE("/:id", middleware.AuthMiddleware(authClient), handlers.DeleteReservation)
+		reservationsGroup.DELETE("/:id", middleware.VerifiedAuthMiddleware(authClient), handlers.DeleteReservation)
+	}
+
+	walletGroup := r.Group("/api/wallet")
+	walletGroup.Use(middleware.VerifiedAuthMiddleware(authClient))
+	{
+		walletGroup.GET("", handlers.GetWallet)
+		walletGroup.GET("/transactions", handlers.GetWalletTransactions)
+		walletGroup.POST("/gift-cards", handlers.PurchaseGiftCard)
+		walletGroup.POST("/gift-cards/confirm", handlers.ConfirmGiftCardPurchase)
+		walletGroup.POST("/gift-cards/redeem", handlers.RedeemGiftCard)
 	}
 
 	storeManagementGroup := r.Group("/api/store-management")
 	storeManagementGroup.Use(middleware.AuthMiddleware(authClient))
 	{
 		storeManagementGroup.POST("/create", handlers.CreateStore)
+		storeManagementGroup.POST("/locate", handlers.LocateStoreAddress)
 		storeManagementGroup.GET("/my-store", handlers.GetMyStore)
 		storeManagementGroup.PUT("/update", handlers.UpdateStore)
 		storeManagementGroup.POST("/toggle-selling", handlers.ToggleStoreSelling)
 		storeManagementGroup.POST("/bag-details", handlers.UpdateBagDetails)
 		storeManagementGroup.POST("/pickup-schedule", handlers.UpdatePickupSchedule)
+		storeManagementGroup.GET("/business-hours", handlers.GetBusinessHours)
+		storeManagementGroup.PUT("/business-hours", handlers.UpdateBusinessHours)
+		storeManagementGroup.GET("/audit-log", handlers.GetStoreAuditLog)
+		storeManagementGroup.POST("/close", middleware.VerifiedAuthMiddleware(authClient), handlers.CloseStore)
+		storeManagementGroup.GET("/closures", handlers.ListStoreClosures)
+		storeManagementGroup.POST("/closures", handlers.CreateStoreClosure)
+		storeManagementGroup.DELETE("/closures/:id", handlers.DeleteStoreClosure)
+		storeManagementGroup.GET("/inventory", handlers.GetStoreInventory)
+		storeManagementGroup.PUT("/inventory/settings", handlers.UpdateInventorySettings)
+		storeManagementGroup.PUT("/inventory/override", handlers.SetInventoryOverride)
+		storeManagementGroup.DELETE("/inventory/override/:date", handlers.DeleteInventoryOverride)
+		storeManagementGroup.POST("/images/:kind", handlers.UploadStoreImage)
+		storeManagementGroup.PUT("/dietary-tags", handlers.UpdateStoreDietaryTags)
+		storeManagementGroup.GET("/highlights", handlers.GetStoreHighlights)
+		storeManagementGroup.POST("/highlights", handlers.AddStoreHighlight)
+		storeManagementGroup.PUT("/highlights", handlers.ReplaceStoreHighlights)
+		storeManagementGroup.DELETE("/highlights/:id", handlers.DeleteStoreHighlight)
+		storeManagementGroup.GET("/review", handlers.GetStoreReview)
+		storeManagementGroup.POST("/review/submit", handlers.SubmitStoreForReview)
 	}
 
 	// Store Owner routes for managing reservations and settings
@@ -254,18 +345,93 @@ func main() {
 	{
 		storeOwnerGroup.GET("/reservations", handlers.GetStoreOwnerReservations)
 		storeOwnerGroup.PUT("/reservations/:id/status", handlers.UpdateReservationStatus)
+		storeOwnerGroup.PUT("/reservations/:id/payment", handlers.RecordReservationPayment)
+		storeOwnerGroup.GET("/cash-reconciliation", handlers.GetCashReconciliation)
 		storeOwnerGroup.GET("/settings", handlers.GetStoreOwnerSettings)
 		storeOwnerGroup.PUT("/settings", handlers.UpdateStoreOwnerSettings)
 		storeOwnerGroup.GET("/stats", handlers.GetStoreOwnerStats)
+		storeOwnerGroup.GET("/stores", handlers.GetMyStores)
+		storeOwnerGroup.GET("/stores/stats", handlers.GetOwnerBranchesStats)
+
+		// Per-store routes for owners with several branches and their staff
+		storeScoped := storeOwnerGroup.Group("/stores/:storeId")
+		storeScoped.Use(middleware.StoreMemberMiddleware())
+		{
+			storeScoped.GET("", handlers.GetMyStore)
+			storeScoped.PUT("", handlers.UpdateStore)
+			storeScoped.POST("/toggle-selling", handlers.ToggleStoreSelling)
+			storeScoped.POST("/bag-details", handlers.UpdateBagDetails)
+			storeScoped.POST("/pickup-schedule", handlers.UpdatePickupSchedule)
+			storeScoped.GET("/business-hours", handlers.GetBusinessHours)
+			storeScoped.PUT("/business-hours", handlers.UpdateBusinessHours)
+			storeScoped.GET("/audit-log", handlers.GetStoreAuditLog)
+			storeScoped.POST("/close", middleware.VerifiedAuthMiddleware(authClient), handlers.CloseStore)
+			storeScoped.GET("/closures", handlers.ListStoreClosures)
+			storeScoped.POST("/closures", handlers.CreateStoreClosure)
+			storeScoped.DELETE("/closures/:id", handlers.DeleteStoreClosure)
+			storeScoped.GET("/inventory", handlers.GetStoreInventory)
+			storeScoped.PUT("/inventory/settings", handlers.UpdateInventorySettings)
+			storeScoped.PUT("/inventory/override", handlers.SetInventoryOverride)
+			storeScoped.DELETE("/inventory/override/:date", handlers.DeleteInventoryOverride)
+			storeScoped.POST("/images/:kind", handlers.UploadStoreImage)
+			storeScoped.PUT("/dietary-tags", handlers.UpdateStoreDietaryTags)
+			storeScoped.GET("/highlights", handlers.GetStoreHighlights)
+			storeScoped.POST("/highlights", handlers.AddStoreHighlight)
+			storeScoped.PUT("/highlights", handlers.ReplaceStoreHighlights)
+			storeScoped.DELETE("/highlights/:id", handlers.DeleteStoreHighlight)
+			storeScoped.GET("/review", handlers.GetStoreReview)
+			storeScoped.POST("/review/submit", handlers.SubmitStoreForReview)
+			storeScoped.GET("/reservations", handlers.GetStoreOwnerReservations)
+			storeScoped.PUT("/reservations/:id/status", handlers.UpdateReservationStatus)
+			storeScoped.PUT("/reservations/:id/payment", handlers.RecordReservationPayment)
+			storeScoped.GET("/cash-reconciliation", handlers.GetCashReconciliation)
+			storeScoped.GET("/settings", handlers.GetStoreOwnerSettings)
+			storeScoped.PUT("/settings", handlers.UpdateStoreOwnerSettings)
+			storeScoped.GET("/stats", handlers.GetStoreOwnerStats)
+			storeScoped.GET("/members", handlers.ListStoreMembers)
+			storeScoped.PUT("/members/:userId", handlers.UpdateStoreMemberRole)
+			storeScoped.DELETE("/members/:userId", handlers.RemoveStoreMember)
+			storeScoped.GET("/invitations", handlers.ListStoreInvitations)
+			storeScoped.POST("/invitations", handlers.CreateStoreInvitation)
+			storeScoped.DELETE("/invitations/:id", handlers.RevokeStoreInvitation)
+		}
+	}
+
+	// Staff accept store invitations with the token from their email
+	storeInvitationGroup := r.Group("/api/store-invitations")
+	storeInvitationGroup.Use(middleware.AuthMiddleware(authClient))
+	{
+		storeInvitationGroup.POST("/accept", handlers.AcceptStoreInvitation)
 	}
 
 	// Partner routes
 	partnerGroup := r.Group("/api/partner")
 	{
 		partnerGroup.POST("/contact", handlers.SubmitPartnerContact)
-		// Admin routes (you can add auth middleware later if needed)
-		partnerGroup.GET("/contacts", handlers.GetPartnerContacts)
-		partnerGroup.PUT("/contacts/:id/status", handlers.UpdatePartnerContactStatus)
+	}
+
+	// Admin routes (users listed in ADMIN_USER_IDS), for verified ID tokens only
+	adminGroup := r.Group("/api/admin")
+	adminGroup.Use(middleware.VerifiedAuthMiddleware(authClient), middleware.AdminMiddleware())
+	{
+		adminGroup.POST("/wallet/credit", handlers.AdminCreditWallet)
+		adminGroup.GET("/checkout-funnel", handlers.GetCheckoutFunnel)
+		adminGroup.GET("/categories", handlers.ListAllCategories)
+		adminGroup.POST("/categories", handlers.CreateCategory)
+		adminGroup.PUT("/categories/:slug", handlers.UpdateCategory)
+		adminGroup.GET("/stores/review", handlers.ListStoresForReview)
+		adminGroup.GET("/audit-log", handlers.ListStoreAuditLog)
+		adminGroup.GET("/distance-cache", handlers.GetDistanceCacheStats)
+		adminGroup.POST("/stores/:id/approve", handlers.ReviewStore(handlers.StoreReviewActionApproved))
+		adminGroup.POST("/stores/:id/reject", handlers.ReviewStore(handlers.StoreReviewActionRejected))
+		adminGroup.POST("/stores/:id/suspend", handlers.ReviewStore(handlers.StoreReviewActionSuspended))
+		adminGroup.POST("/stores/:id/close", handlers.AdminCloseStore)
+		adminGroup.GET("/partner-applications", handlers.ListPartnerApplications)
+		adminGroup.GET("/partner-applications/:id", handlers.GetPartnerApplication)
+		adminGroup.PUT("/partner-applications/:id/stage", handlers.UpdatePartnerApplicationStage)
+		adminGroup.PUT("/partner-applications/:id/assignee", handlers.AssignPartnerApplication)
+		adminGroup.POST("/partner-applications/:id/notes", handlers.AddPartnerApplicationNote)
+		adminGroup.POST("/partner-applications/:id/approve", handlers.ApprovePartnerApplication(authClient))
 	}
 
 	// Start server with port from environment variable (Railway) or default to 8080
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IsAdmin reports whether the given Firebase UID is listed in ADMIN_USER_IDS
+// (comma separated).
+func IsAdmin(userID string) bool {
+	if userID == "" {
+		return false
+	}
+	for _, id := range strings.Split(os.Getenv("ADMIN_USER_IDS"), ",") {
+		if strings.TrimSpace(id) == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminMiddleware restricts a route to admin users. It must run after
+// VerifiedAuthMiddleware: a user_id that did not come from a verified ID token is refused.
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		if !c.GetBool("auth_verified") {
+			fmt.Printf("ERROR: Admin route called without a verified ID token\n")
+			c.AbortWithStatusJSON(401, gin.H{"error": "A verified sign-in is required"})
+			return
+		}
+		if !IsAdmin(userID) {
+			fmt.Printf("ERROR: User %s is not an admin\n", userID)
+			c.AbortWithStatusJSON(403, gin.H{"error": "Admin access required"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -36,6 +36,7 @@ func AuthMiddleware(client *auth.Client) gin.HandlerFunc {
 		if err == nil {
 			// Firebase token verification successful
 			c.Set("user_id", firebaseToken.UID)
+			c.Set("auth_verified", true)
 			c.Next()
 			return
 		}
@@ -86,6 +87,52 @@ func AuthMiddleware(client *auth.Client) gin.HandlerFunc {
 	}
 }
 
+// VerifiedAuthMiddleware accepts only Firebase ID tokens that pass VerifyIDToken. Admin
+// routes and routes that move money use it instead of AuthMiddleware, whose custom token
+// fallback trusts a uid claim nobody has checked the signature of.
+func VerifiedAuthMiddleware(client *auth.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			fmt.Println("ERROR: Authorization header is empty")
+			c.AbortWithStatusJSON(401, gin.H{"error": "Authorization header required"})
+			return
+		}
+
+		idToken := strings.Replace(authHeader, "Bearer ", "", 1)
+		firebaseToken, err := client.VerifyIDToken(context.Background(), idToken)
+		if err != nil {
+			fmt.Printf("ERROR: Failed to verify ID token: %v\n", err)
+			c.AbortWithStatusJSON(401, gin.H{"error": "Invalid or expired token"})
+			return
+		}
+
+		c.Set("user_id", firebaseToken.UID)
+		c.Set("auth_verified", true)
+		c.Next()
+	}
+}
+
+// OptionalAuthMiddleware sets user_id when the request carries a verified ID token and
+// treats every other request as anonymous, for public pages that personalise for
+// signed-in users
+func OptionalAuthMiddleware(client *auth.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.Next()
+			return
+		}
+
+		idToken := strings.Replace(authHeader, "Bearer ", "", 1)
+		if firebaseToken, err := client.VerifyIDToken(context.Background(), idToken); err == nil {
+			c.Set("user_id", firebaseToken.UID)
+			c.Set("auth_verified", true)
+		}
+		c.Next()
+	}
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
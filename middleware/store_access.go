@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"database/sql"
+	"log"
+
+	"savor-server/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StoreMemberMiddleware guards /stores/:storeId routes. It must run after AuthMiddleware
+// and sets "store_id" and "store_role" once the user is confirmed to be a member of the
+// store. Handlers check what the role is allowed to do.
+func StoreMemberMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		storeID := c.Param("storeId")
+		if storeID == "" {
+			c.AbortWithStatusJSON(400, gin.H{"error": "Store ID is required"})
+			return
+		}
+
+		var role string
+		err := db.DB.Get(&role, `
+			SELECT role FROM store_members WHERE store_id = $1 AND user_id = $2
+		`, storeID, c.GetString("user_id"))
+		if err == sql.ErrNoRows {
+			c.AbortWithStatusJSON(404, gin.H{"error": "Store not found or not authorized"})
+			return
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to check membership of store %s: %v", storeID, err)
+			c.AbortWithStatusJSON(500, gin.H{"error": "Failed to verify store"})
+			return
+		}
+
+		c.Set("store_id", storeID)
+		c.Set("store_role", role)
+		c.Next()
+	}
+}
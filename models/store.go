@@ -44,14 +44,31 @@ type Store struct {
 	BagsAvailable   sql.NullInt64   `json:"bagsAvailable" db:"bags_available"`
 	Latitude        float64         `json:"latitude" db:"latitude"`
 	Longitude       float64         `json:"longitude" db:"longitude"`
+	Timezone        string          `json:"timezone" db:"timezone"`
 	GoogleMapsURL   sql.NullString  `json:"googleMapsUrl" db:"google_maps_url"`
 	Highlights      pq.StringArray  `json:"highlights" db:"highlights"`
+	DietaryTags     pq.StringArray  `json:"dietaryTags" db:"dietary_tags"`
 	IsSaved         bool            `json:"isSaved" db:"is_saved"`
 	IsSelling       bool            `json:"isSelling" db:"is_selling"`
+	SoldOutPaused   bool            `json:"soldOutPaused" db:"sold_out_paused"`
+	InventoryReset  sql.NullString  `json:"inventoryResetTime" db:"inventory_reset_time"`
+	ReviewStatus    string          `json:"reviewStatus" db:"review_status"`
 	StoreType       sql.NullString  `json:"storeType" db:"store_type"`
+	CategorySlug    sql.NullString  `json:"category" db:"category_slug"`
 	BusinessHours   types.JSONText  `json:"businessHours" db:"business_hours"`
 	CreatedAt       time.Time       `json:"createdAt" db:"created_at"`
 	UpdatedAt       time.Time       `json:"updatedAt" db:"updated_at"`
+
+	// Computed at request time by dynamic pricing, not stored on the row
+	RegularPrice         sql.NullFloat64 `json:"regularPrice" db:"-"`
+	AppliedPricingRuleID string          `json:"appliedPricingRuleId" db:"-"`
+
+	// Computed at request time from BusinessHours in the store's zone
+	IsOpenNow       bool         `json:"isOpenNow" db:"-"`
+	NextOpeningTime sql.NullTime `json:"nextOpeningTime" db:"-"`
+
+	// Driving time from the customer, set only when it was looked up
+	TravelTime *string `json:"travelTime,omitempty" db:"-"`
 }
 
 func (s Store) MarshalJSON() ([]byte, error) {
@@ -67,6 +84,7 @@ func (s Store) MarshalJSON() ([]byte, error) {
 		Price           *float64   `json:"price"`
 		OriginalPrice   *float64   `json:"originalPrice"`
 		DiscountedPrice *float64   `json:"discountedPrice"`
+		RegularPrice    *float64   `json:"regularPrice,omitempty"`
 		BackgroundURL   string     `json:"backgroundUrl"`
 		AvatarURL       *string    `json:"avatarUrl"`
 		ImageURL        string     `json:"imageUrl"`
@@ -83,11 +101,17 @@ func (s Store) MarshalJSON() ([]byte, error) {
 		BagsAvailable   *int64     `json:"bagsAvailable"`
 		Latitude        float64    `json:"latitude"`
 		Longitude       float64    `json:"longitude"`
+		Timezone        string     `json:"timezone"`
 		GoogleMapsURL   *string    `json:"googleMapsUrl"`
 		Highlights      []string   `json:"highlights"`
+		DietaryTags     []string   `json:"dietaryTags"`
 		IsSaved         bool       `json:"isSaved"`
 		IsSelling       bool       `json:"isSelling"`
 		StoreType       *string    `json:"storeType"`
+		Category        *string    `json:"category"`
+		IsOpenNow       bool       `json:"isOpenNow"`
+		NextOpeningTime *time.Time `json:"nextOpeningTime"`
+		TravelTime      *string    `json:"travelTime,omitempty"`
 		CreatedAt       time.Time  `json:"createdAt"`
 		UpdatedAt       time.Time  `json:"updatedAt"`
 	}{
@@ -99,9 +123,13 @@ func (s Store) MarshalJSON() ([]byte, error) {
 		Address:       s.Address,
 		Latitude:      s.Latitude,
 		Longitude:     s.Longitude,
+		Timezone:      s.Timezone,
 		Highlights:    s.Highlights,
+		DietaryTags:   s.DietaryTags,
 		IsSaved:       s.IsSaved,
 		IsSelling:     s.IsSelling,
+		IsOpenNow:     s.IsOpenNow,
+		TravelTime:    s.TravelTime,
 		CreatedAt:     s.CreatedAt,
 		UpdatedAt:     s.UpdatedAt,
 	}
@@ -114,7 +142,12 @@ func (s Store) MarshalJSON() ([]byte, error) {
 		result.PickupTime = &s.PickupTime.String
 	}
 	if s.PickupTimestamp.Valid {
-		result.PickupTimestamp = &s.PickupTimestamp.Time
+		// Show the pickup time in the store's zone
+		pickup := s.PickupTimestamp.Time
+		if loc, err := time.LoadLocation(s.Timezone); err == nil && s.Timezone != "" {
+			pickup = pickup.In(loc)
+		}
+		result.PickupTimestamp = &pickup
 	}
 	if s.AvatarURL.Valid {
 		result.AvatarURL = &s.AvatarURL.String
@@ -140,6 +173,12 @@ func (s Store) MarshalJSON() ([]byte, error) {
 	if s.StoreType.Valid {
 		result.StoreType = &s.StoreType.String
 	}
+	if s.CategorySlug.Valid {
+		result.Category = &s.CategorySlug.String
+	}
+	if s.NextOpeningTime.Valid {
+		result.NextOpeningTime = &s.NextOpeningTime.Time
+	}
 
 	// Handle nullable floats
 	if s.Price.Valid {
@@ -151,6 +190,9 @@ func (s Store) MarshalJSON() ([]byte, error) {
 	if s.DiscountedPrice.Valid {
 		result.DiscountedPrice = &s.DiscountedPrice.Float64
 	}
+	if s.RegularPrice.Valid {
+		result.RegularPrice = &s.RegularPrice.Float64
+	}
 	if s.Rating.Valid {
 		result.Rating = &s.Rating.Float64
 	}
@@ -176,7 +218,8 @@ func (s Store) MarshalJSON() ([]byte, error) {
 }
 
 func (s *Store) GetBusinessHours() ([]BusinessHours, error) {
-	if len(s.BusinessHours) == 0 {
+	// JSONText scans NULL as {}
+	if len(s.BusinessHours) == 0 || string(s.BusinessHours) == "{}" || string(s.BusinessHours) == "null" {
 		return []BusinessHours{}, nil
 	}
 	var hours []BusinessHours
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DistanceModeDriving is the travel mode every provider routes with
+const DistanceModeDriving = "driving"
+
+const (
+	defaultDistanceLatencyBudget = 1500 * time.Millisecond
+	defaultDistanceCacheTTL      = 60 * time.Minute
+	// Google allows 25 destinations per Distance Matrix request
+	maxDistanceMatrixDestinations = 25
+	// Average city driving speed, including stops, for estimated travel times
+	haversineDrivingSpeedKmh = 25.0
+	// Roads are rarely straight; estimated distances are scaled up by this much
+	haversineRoadFactor = 1.3
+)
+
+// LatLng is a point in degrees
+type LatLng struct {
+	Lat float64
+	Lng float64
+}
+
+func (p LatLng) String() string {
+	return fmt.Sprintf("%f,%f", p.Lat, p.Lng)
+}
+
+// DistanceProvider measures the trip from an origin to a destination
+type DistanceProvider interface {
+	CalculateDistance(ctx context.Context, originLat, originLng, destLat, destLng float64) (*DistanceResult, error)
+	// CalculateDistances measures the trips from the origin to each destination in as
+	// few calls as the provider allows. A destination that cannot be reached is nil.
+	CalculateDistances(ctx context.Context, origin LatLng, destinations []LatLng) ([]*DistanceResult, error)
+}
+
+// Global distance provider; never nil after InitializeDistanceProvider
+var Distances DistanceProvider
+
+// InitializeDistanceProvider picks the provider from DISTANCE_PROVIDER: "google" (the
+// default, needs the Google Maps service), "osrm" to route with the OSRM server at
+// OSRM_URL, or "haversine" for offline estimates. Google and OSRM fall back to haversine
+// when they fail or take longer than DISTANCE_LATENCY_BUDGET_MS, and their results are
+// cached for DISTANCE_CACHE_TTL_MINUTES (0 disables the cache).
+func InitializeDistanceProvider() error {
+	budget := defaultDistanceLatencyBudget
+	if value := os.Getenv("DISTANCE_LATENCY_BUDGET_MS"); value != "" {
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms <= 0 {
+			return fmt.Errorf("invalid DISTANCE_LATENCY_BUDGET_MS %q", value)
+		}
+		budget = time.Duration(ms) * time.Millisecond
+	}
+	cacheTTL := defaultDistanceCacheTTL
+	if value := os.Getenv("DISTANCE_CACHE_TTL_MINUTES"); value != "" {
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes < 0 {
+			return fmt.Errorf("invalid DISTANCE_CACHE_TTL_MINUTES %q", value)
+		}
+		cacheTTL = time.Duration(minutes) * time.Minute
+	}
+	cached := func(provider DistanceProvider) DistanceProvider {
+		if cacheTTL == 0 {
+			return provider
+		}
+		return NewCachedDistance(provider, DistanceModeDriving, cacheTTL)
+	}
+
+	switch backend := strings.ToLower(os.Getenv("DISTANCE_PROVIDER")); backend {
+	case "", "google":
+		if GoogleMaps == nil {
+			Distances = HaversineDistance{}
+			log.Printf("Warning: Google Maps service not available, estimating distances offline")
+			return nil
+		}
+		Distances = cached(NewFallbackDistance("google", GoogleMaps, HaversineDistance{}, budget))
+		log.Printf("Using Google Maps distances")
+	case "osrm":
+		osrm, err := NewOSRMDistance(getEnvOrDefault("OSRM_URL", "http://localhost:5000"))
+		if err != nil {
+			return err
+		}
+		Distances = cached(NewFallbackDistance("osrm", osrm, HaversineDistance{}, budget))
+		log.Printf("Using OSRM distances from %s", osrm.BaseURL)
+	case "haversine":
+		Distances = HaversineDistance{}
+		log.Printf("Warning: Estimating distances offline")
+	default:
+		return fmt.Errorf("unknown DISTANCE_PROVIDER %q", backend)
+	}
+	return nil
+}
+
+// FallbackDistance asks the primary provider and, if it fails or does not answer within
+// the budget, the fallback
+type FallbackDistance struct {
+	name     string
+	primary  DistanceProvider
+	fallback DistanceProvider
+	budget   time.Duration
+}
+
+func NewFallbackDistance(name string, primary, fallback DistanceProvider, budget time.Duration) *FallbackDistance {
+	return &FallbackDistance{name: name, primary: primary, fallback: fallback, budget: budget}
+}
+
+func (f *FallbackDistance) CalculateDistance(ctx context.Context, originLat, originLng, destLat, destLng float64) (*DistanceResult, error) {
+	primaryCtx, cancel := context.WithTimeout(ctx, f.budget)
+	defer cancel()
+
+	result, err := f.primary.CalculateDistance(primaryCtx, originLat, originLng, destLat, destLng)
+	if err == nil {
+		return result, nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	log.Printf("Warning: %s distance failed, falling back: %v", f.name, err)
+	return f.fallback.CalculateDistance(ctx, originLat, originLng, destLat, destLng)
+}
+
+func (f *FallbackDistance) CalculateDistances(ctx context.Context, origin LatLng, destinations []LatLng) ([]*DistanceResult, error) {
+	primaryCtx, cancel := context.WithTimeout(ctx, f.budget)
+	defer cancel()
+
+	results, err := f.primary.CalculateDistances(primaryCtx, origin, destinations)
+	if err == nil {
+		return results, nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	log.Printf("Warning: %s distances failed, falling back: %v", f.name, err)
+	return f.fallback.CalculateDistances(ctx, origin, destinations)
+}
+
+// HaversineDistance estimates trips from the straight-line distance without any network
+// calls. Results are marked Estimated.
+type HaversineDistance struct{}
+
+func (HaversineDistance) CalculateDistance(ctx context.Context, originLat, originLng, destLat, destLng float64) (*DistanceResult, error) {
+	meters := HaversineMeters(originLat, originLng, destLat, destLng) * haversineRoadFactor
+	seconds := meters / (haversineDrivingSpeedKmh * 1000 / 3600)
+	return &DistanceResult{
+		Distance:  "~" + FormatDistance(meters),
+		Duration:  (time.Duration(seconds) * time.Second).String(),
+		Meters:    int(meters),
+		Seconds:   int(seconds),
+		Estimated: true,
+	}, nil
+}
+
+func (h HaversineDistance) CalculateDistances(ctx context.Context, origin LatLng, destinations []LatLng) ([]*DistanceResult, error) {
+	results := make([]*DistanceResult, len(destinations))
+	for i, destination := range destinations {
+		results[i], _ = h.CalculateDistance(ctx, origin.Lat, origin.Lng, destination.Lat, destination.Lng)
+	}
+	return results, nil
+}
+
+// FormatDistance renders a distance the way the maps service does, e.g. "850 m" or "1.2 km"
+func FormatDistance(meters float64) string {
+	if meters < 1000 {
+		return fmt.Sprintf("%.0f m", meters)
+	}
+	return fmt.Sprintf("%.1f km", meters/1000)
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// Coordinates are rounded to 3 decimals (about 110 m) so nearby customers share trips
+	distanceCachePrecision  = 1000
+	maxDistanceCacheEntries = 50000
+)
+
+type distanceCacheKey struct {
+	mode                                   string
+	originLat, originLng, destLat, destLng int64
+}
+
+type distanceCacheEntry struct {
+	result  *DistanceResult
+	expires time.Time
+}
+
+// DistanceCacheStats counts cache use since the server started
+type DistanceCacheStats struct {
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hitRate"`
+	// SharedLookups waited for an identical lookup already in flight instead of calling
+	// the provider themselves
+	SharedLookups int64 `json:"sharedLookups"`
+	ProviderCalls int64 `json:"providerCalls"`
+	Entries       int   `json:"entries"`
+	TTLSeconds    int   `json:"ttlSeconds"`
+}
+
+// CachedDistance remembers a provider's trips for ttl and sends only the destinations it
+// has not seen to the provider, in one batch. Concurrent identical batches share a call.
+// Estimates are not cached, so a provider that recovers is used again straight away.
+type CachedDistance struct {
+	provider DistanceProvider
+	mode     string
+	ttl      time.Duration
+
+	mu       sync.Mutex
+	entries  map[distanceCacheKey]distanceCacheEntry
+	inflight singleflight.Group
+
+	hits, misses, shared, providerCalls atomic.Int64
+}
+
+func NewCachedDistance(provider DistanceProvider, mode string, ttl time.Duration) *CachedDistance {
+	return &CachedDistance{
+		provider: provider,
+		mode:     mode,
+		ttl:      ttl,
+		entries:  make(map[distanceCacheKey]distanceCacheEntry),
+	}
+}
+
+func (c *CachedDistance) CalculateDistance(ctx context.Context, originLat, originLng, destLat, destLng float64) (*DistanceResult, error) {
+	results, err := c.CalculateDistances(ctx, LatLng{originLat, originLng}, []LatLng{{destLat, destLng}})
+	if err != nil {
+		return nil, err
+	}
+	if results[0] == nil {
+		return nil, fmt.Errorf("distance calculation failed: no route")
+	}
+	return results[0], nil
+}
+
+func (c *CachedDistance) CalculateDistances(ctx context.Context, origin LatLng, destinations []LatLng) ([]*DistanceResult, error) {
+	results := make([]*DistanceResult, len(destinations))
+	keys := make([]distanceCacheKey, len(destinations))
+	var missing []LatLng
+	var missingKeys []distanceCacheKey
+	seen := make(map[distanceCacheKey]bool)
+
+	now := time.Now()
+	c.mu.Lock()
+	for i, destination := range destinations {
+		keys[i] = c.key(origin, destination)
+		if entry, ok := c.entries[keys[i]]; ok && now.Before(entry.expires) {
+			results[i] = entry.result
+			continue
+		}
+		if !seen[keys[i]] {
+			seen[keys[i]] = true
+			missing = append(missing, destination)
+			missingKeys = append(missingKeys, keys[i])
+		}
+	}
+	c.mu.Unlock()
+
+	c.hits.Add(int64(len(destinations) - len(missing)))
+	c.misses.Add(int64(len(missing)))
+	if len(missing) == 0 {
+		return results, nil
+	}
+
+	// The call outlives a caller that gives up, since others may be waiting on it; the
+	// provider's own latency budget bounds it
+	leader := false
+	call := c.inflight.DoChan(flightKey(missingKeys), func() (interface{}, error) {
+		leader = true
+		c.providerCalls.Add(1)
+		fetched, err := c.provider.CalculateDistances(context.WithoutCancel(ctx), origin, missing)
+		if err != nil {
+			return nil, err
+		}
+		c.store(missingKeys, fetched)
+		return fetched, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-call:
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		if !leader {
+			c.shared.Add(1)
+		}
+		fetched := make(map[distanceCacheKey]*DistanceResult, len(missingKeys))
+		for i, result := range r.Val.([]*DistanceResult) {
+			fetched[missingKeys[i]] = result
+		}
+		for i := range results {
+			if results[i] == nil {
+				results[i] = fetched[keys[i]]
+			}
+		}
+		return results, nil
+	}
+}
+
+func (c *CachedDistance) key(origin, destination LatLng) distanceCacheKey {
+	round := func(deg float64) int64 { return int64(math.Round(deg * distanceCachePrecision)) }
+	return distanceCacheKey{
+		mode:      c.mode,
+		originLat: round(origin.Lat),
+		originLng: round(origin.Lng),
+		destLat:   round(destination.Lat),
+		destLng:   round(destination.Lng),
+	}
+}
+
+// flightKey identifies a batch of lookups for deduplication
+func flightKey(keys []distanceCacheKey) string {
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s:%d,%d:%d,%d;", k.mode, k.originLat, k.originLng, k.destLat, k.destLng)
+	}
+	return b.String()
+}
+
+func (c *CachedDistance) store(keys []distanceCacheKey, results []*DistanceResult) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries)+len(keys) > maxDistanceCacheEntries {
+		for key, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries)+len(keys) > maxDistanceCacheEntries {
+			c.entries = make(map[distanceCacheKey]distanceCacheEntry)
+		}
+	}
+	for i, result := range results {
+		if result == nil || result.Estimated {
+			continue
+		}
+		c.entries[keys[i]] = distanceCacheEntry{result: result, expires: now.Add(c.ttl)}
+	}
+}
+
+// Stats reports the cache's counters
+func (c *CachedDistance) Stats() DistanceCacheStats {
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+
+	stats := DistanceCacheStats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		SharedLookups: c.shared.Load(),
+		ProviderCalls: c.providerCalls.Load(),
+		Entries:       entries,
+		TTLSeconds:    int(c.ttl.Seconds()),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
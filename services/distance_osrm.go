@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OSRMDistance routes with an OSRM-compatible HTTP server, such as the osrm-backend
+// container run against an OpenStreetMap extract
+type OSRMDistance struct {
+	// BaseURL is the server root, e.g. http://localhost:5000
+	BaseURL string
+	// Profile is the routing profile in the URL; osrm-routed serves any name with the
+	// profile it was started with
+	Profile string
+
+	client *http.Client
+}
+
+func NewOSRMDistance(baseURL string) (*OSRMDistance, error) {
+	baseURL = strings.TrimRight(baseURL, "/")
+	if _, err := url.ParseRequestURI(baseURL); err != nil {
+		return nil, fmt.Errorf("invalid OSRM_URL: %v", err)
+	}
+	return &OSRMDistance{
+		BaseURL: baseURL,
+		Profile: DistanceModeDriving,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type osrmRouteResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Routes  []struct {
+		Distance float64 `json:"distance"` // meters
+		Duration float64 `json:"duration"` // seconds
+	} `json:"routes"`
+}
+
+func (o *OSRMDistance) CalculateDistance(ctx context.Context, originLat, originLng, destLat, destLng float64) (*DistanceResult, error) {
+	// OSRM takes coordinates as longitude,latitude
+	endpoint := fmt.Sprintf("%s/route/v1/%s/%f,%f;%f,%f?overview=false",
+		o.BaseURL, o.Profile, originLng, originLat, destLng, destLat)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("OSRM route request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var route osrmRouteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&route); err != nil {
+		return nil, fmt.Errorf("failed to decode OSRM response (status %d): %v", resp.StatusCode, err)
+	}
+	if route.Code != "Ok" || len(route.Routes) == 0 {
+		return nil, fmt.Errorf("OSRM found no route: %s %s", route.Code, route.Message)
+	}
+
+	meters, seconds := route.Routes[0].Distance, route.Routes[0].Duration
+	return &DistanceResult{
+		Distance: FormatDistance(meters),
+		Duration: (time.Duration(seconds) * time.Second).String(),
+		Meters:   int(meters),
+		Seconds:  int(seconds),
+	}, nil
+}
+
+type osrmTableResponse struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Durations [][]*float64 `json:"durations"` // seconds, null when unreachable
+	Distances [][]*float64 `json:"distances"` // meters, null when unreachable
+}
+
+// CalculateDistances asks the table service for every destination in one request
+func (o *OSRMDistance) CalculateDistances(ctx context.Context, origin LatLng, destinations []LatLng) ([]*DistanceResult, error) {
+	results := make([]*DistanceResult, len(destinations))
+	if len(destinations) == 0 {
+		return results, nil
+	}
+
+	coordinates := make([]string, 0, len(destinations)+1)
+	for _, point := range append([]LatLng{origin}, destinations...) {
+		coordinates = append(coordinates, fmt.Sprintf("%f,%f", point.Lng, point.Lat))
+	}
+	endpoint := fmt.Sprintf("%s/table/v1/%s/%s?sources=0&annotations=duration,distance",
+		o.BaseURL, o.Profile, strings.Join(coordinates, ";"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("OSRM table request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var table osrmTableResponse
+	if err := json.NewDecoder(resp.Body).Decode(&table); err != nil {
+		return nil, fmt.Errorf("failed to decode OSRM response (status %d): %v", resp.StatusCode, err)
+	}
+	if table.Code != "Ok" || len(table.Durations) == 0 || len(table.Distances) == 0 {
+		return nil, fmt.Errorf("OSRM table failed: %s %s", table.Code, table.Message)
+	}
+
+	// Column 0 is the origin itself
+	durations, distances := table.Durations[0], table.Distances[0]
+	if len(durations) != len(destinations)+1 || len(distances) != len(destinations)+1 {
+		return nil, fmt.Errorf("OSRM table has %d columns for %d destinations", len(durations), len(destinations))
+	}
+	for i := range destinations {
+		seconds, meters := durations[i+1], distances[i+1]
+		if seconds == nil || meters == nil {
+			continue
+		}
+		results[i] = &DistanceResult{
+			Distance: FormatDistance(*meters),
+			Duration: (time.Duration(*seconds) * time.Second).String(),
+			Meters:   int(*meters),
+			Seconds:  int(*seconds),
+		}
+	}
+	return results, nil
+}
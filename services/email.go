@@ -35,6 +35,57 @@ type ReservationEmailData struct {
 	DiscountedPrice float64
 }
 
+type GiftCardEmailData struct {
+	Code   string
+	Amount float64
+}
+
+type CheckoutReminderEmailData struct {
+	StoreName     string
+	Quantity      int
+	BagsAvailable int
+}
+
+type StoreInvitationEmailData struct {
+	StoreName string
+	Role      string
+	AcceptURL string
+	ExpiresAt time.Time
+}
+
+type StoreClosureEmailData struct {
+	StoreName     string
+	ReservationID string
+	PickupTime    string
+	Closed        bool
+	SpecialHours  string
+	Reason        string
+}
+
+type PartnerApplicationEmailData struct {
+	Name      string
+	StoreName string
+	Stage     string
+	// SetupURL lets an approved partner set the password of their new account
+	SetupURL string
+}
+
+type StoreReviewEmailData struct {
+	StoreName string
+	Action    string // approved, rejected or suspended
+	Reason    string
+}
+
+// StoreOffboardingEmailData tells a customer their reservation was cancelled because
+// the store left Savor, and how they were refunded
+type StoreOffboardingEmailData struct {
+	StoreName     string
+	ReservationID string
+	PickupTime    string
+	CardRefund    float64
+	WalletRefund  float64
+}
+
 var emailService *EmailService
 
 // InitializeEmailService initializes the email service with environment variables
@@ -97,6 +148,151 @@ func (e *EmailService) SendReservationConfirmation(toEmail string, data Reservat
 	return e.sendEmail(toEmail, subject, body)
 }
 
+// SendGiftCard emails a newly issued gift card code to its recipient
+func (e *EmailService) SendGiftCard(toEmail string, data GiftCardEmailData) error {
+	if !e.IsConfigured() {
+		log.Println("Email service not configured, skipping email send")
+		return nil
+	}
+
+	subject := "Bạn nhận được thẻ quà tặng Savor"
+	body, err := renderEmailTemplate("gift_card", giftCardEmailTemplate, data)
+	if err != nil {
+		log.Printf("Failed to generate email template: %v", err)
+		return err
+	}
+
+	return e.sendEmail(toEmail, subject, body)
+}
+
+// SendCheckoutReminder reminds a customer about a checkout they did not finish
+func (e *EmailService) SendCheckoutReminder(toEmail string, data CheckoutReminderEmailData) error {
+	if !e.IsConfigured() {
+		log.Println("Email service not configured, skipping email send")
+		return nil
+	}
+
+	subject := fmt.Sprintf("Túi bất ngờ tại %s vẫn đang chờ bạn", data.StoreName)
+	body, err := renderEmailTemplate("checkout_reminder", checkoutReminderEmailTemplate, data)
+	if err != nil {
+		log.Printf("Failed to generate email template: %v", err)
+		return err
+	}
+
+	return e.sendEmail(toEmail, subject, body)
+}
+
+// SendStoreInvitation invites someone to join a store's team
+func (e *EmailService) SendStoreInvitation(toEmail string, data StoreInvitationEmailData) error {
+	if !e.IsConfigured() {
+		log.Println("Email service not configured, skipping email send")
+		return nil
+	}
+
+	subject := fmt.Sprintf("Lời mời tham gia %s trên Savor", data.StoreName)
+	body, err := renderEmailTemplate("store_invitation", storeInvitationEmailTemplate, data)
+	if err != nil {
+		log.Printf("Failed to generate email template: %v", err)
+		return err
+	}
+
+	return e.sendEmail(toEmail, subject, body)
+}
+
+// SendStoreClosureNotice tells a customer their pickup day is affected by a store closure
+func (e *EmailService) SendStoreClosureNotice(toEmail string, data StoreClosureEmailData) error {
+	if !e.IsConfigured() {
+		log.Println("Email service not configured, skipping email send")
+		return nil
+	}
+
+	subject := fmt.Sprintf("Thay đổi giờ nhận hàng tại %s", data.StoreName)
+	body, err := renderEmailTemplate("store_closure", storeClosureEmailTemplate, data)
+	if err != nil {
+		log.Printf("Failed to generate email template: %v", err)
+		return err
+	}
+
+	return e.sendEmail(toEmail, subject, body)
+}
+
+// partnerStageSubjects are the subjects of the emails sent at each onboarding stage
+var partnerStageSubjects = map[string]string{
+	"lead":               "Savor đã nhận được đăng ký đối tác của %s",
+	"contacted":          "Savor sẽ liên hệ với %s",
+	"documents_received": "Savor đã nhận đủ hồ sơ của %s",
+	"approved":           "%s đã được duyệt trở thành đối tác Savor",
+	"live":               "%s đã lên Savor",
+	"rejected":           "Kết quả đăng ký đối tác của %s",
+}
+
+// SendPartnerApplicationUpdate tells a partner applicant their application changed stage
+func (e *EmailService) SendPartnerApplicationUpdate(toEmail string, data PartnerApplicationEmailData) error {
+	if !e.IsConfigured() {
+		log.Println("Email service not configured, skipping email send")
+		return nil
+	}
+
+	subjectFormat, ok := partnerStageSubjects[data.Stage]
+	if !ok {
+		return fmt.Errorf("no email for partner stage %q", data.Stage)
+	}
+	subject := fmt.Sprintf(subjectFormat, data.StoreName)
+	body, err := renderEmailTemplate("partner_application", partnerApplicationEmailTemplate, data)
+	if err != nil {
+		log.Printf("Failed to generate email template: %v", err)
+		return err
+	}
+
+	return e.sendEmail(toEmail, subject, body)
+}
+
+// SendStoreReviewUpdate tells a store owner the outcome of an admin review
+func (e *EmailService) SendStoreReviewUpdate(toEmail string, data StoreReviewEmailData) error {
+	if !e.IsConfigured() {
+		log.Println("Email service not configured, skipping email send")
+		return nil
+	}
+
+	var subject string
+	switch data.Action {
+	case "approved":
+		subject = fmt.Sprintf("%s đã được duyệt và hiển thị trên Savor", data.StoreName)
+	case "rejected":
+		subject = fmt.Sprintf("%s cần chỉnh sửa trước khi được duyệt", data.StoreName)
+	case "suspended":
+		subject = fmt.Sprintf("%s đã bị tạm ngưng trên Savor", data.StoreName)
+	default:
+		return fmt.Errorf("no email for store review action %q", data.Action)
+	}
+
+	body, err := renderEmailTemplate("store_review", storeReviewEmailTemplate, data)
+	if err != nil {
+		log.Printf("Failed to generate email template: %v", err)
+		return err
+	}
+
+	return e.sendEmail(toEmail, subject, body)
+}
+
+// SendStoreOffboardingNotice tells a customer their reservation was cancelled and refunded
+// because the store closed its account
+func (e *EmailService) SendStoreOffboardingNotice(toEmail string, data StoreOffboardingEmailData) error {
+	if !e.IsConfigured() {
+		log.Println("Email service not configured, skipping email send")
+		return nil
+	}
+
+	subject := fmt.Sprintf("Đặt chỗ tại %s đã được hủy và hoàn tiền", data.StoreName)
+	body, err := renderEmailTemplate("store_offboarding", storeOffboardingEmailTemplate, data)
+	if err != nil {
+		log.Printf("Failed to generate email template: %v", err)
+		return err
+	}
+
+	return e.sendEmail(toEmail, subject, body)
+}
+
 // sendEmail sends an email using SMTP
 func (e *EmailService) sendEmail(to, subject, body string) error {
 	// Set up authentication
@@ -123,7 +319,7 @@ func (e *EmailService) sendEmail(to, subject, body string) error {
 		return err
 	}
 
-	log.Printf("Sent reservation confirmation email to %s", to)
+	log.Printf("Sent email %q to %s", subject, to)
 	return nil
 }
 
@@ -339,3 +535,158 @@ func (e *EmailService) generateReservationEmail(data ReservationEmailData) (stri
 
 	return buf.String(), nil
 }
+
+// renderEmailTemplate executes a simple HTML template with the shared helper funcs
+func renderEmailTemplate(name, tmpl string, data interface{}) (string, error) {
+	t, err := template.New(name).Funcs(template.FuncMap{
+		"sub": func(a, b float64) float64 { return a - b },
+	}).Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+const giftCardEmailTemplate = `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+</head>
+<body style="font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif; color: #333; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1 style="color: #036B52;">🎁 Thẻ quà tặng Savor</h1>
+    <p>Bạn vừa nhận được một thẻ quà tặng trị giá <strong>{{printf "%.2f" .Amount}}</strong>.</p>
+    <p>Mã thẻ của bạn:</p>
+    <p style="font-size: 24px; font-weight: bold; letter-spacing: 2px; color: #036B52;">{{.Code}}</p>
+    <p>Nhập mã này trong mục Ví của ứng dụng Savor để nạp vào tài khoản.</p>
+    <p style="font-size: 12px; color: #999;">Email này được gửi tự động, vui lòng không trả lời.</p>
+</body>
+</html>
+`
+
+const checkoutReminderEmailTemplate = `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+</head>
+<body style="font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif; color: #333; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1 style="color: #036B52;">🛍️ Bạn chưa hoàn tất đặt hàng</h1>
+    <p>Bạn đã bắt đầu đặt {{.Quantity}} túi bất ngờ tại <strong>{{.StoreName}}</strong> nhưng chưa thanh toán.</p>
+    <p>Cửa hàng vẫn còn <strong>{{.BagsAvailable}}</strong> túi. Mở ứng dụng Savor để đặt lại trước khi hết hàng!</p>
+    <p style="font-size: 12px; color: #999;">Email này được gửi tự động, vui lòng không trả lời.</p>
+</body>
+</html>
+`
+
+const storeInvitationEmailTemplate = `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+</head>
+<body style="font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif; color: #333; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1 style="color: #036B52;">👋 Lời mời tham gia cửa hàng</h1>
+    <p>Bạn được mời tham gia <strong>{{.StoreName}}</strong> trên Savor với vai trò <strong>{{.Role}}</strong>.</p>
+    <p><a href="{{.AcceptURL}}" style="display: inline-block; background: #036B52; color: #fff; padding: 12px 24px; border-radius: 6px; text-decoration: none;">Chấp nhận lời mời</a></p>
+    <p>Lời mời có hiệu lực đến {{.ExpiresAt.Format "02/01/2006 15:04"}}.</p>
+    <p style="font-size: 12px; color: #999;">Email này được gửi tự động, vui lòng không trả lời.</p>
+</body>
+</html>
+`
+
+const storeClosureEmailTemplate = `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+</head>
+<body style="font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif; color: #333; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1 style="color: #036B52;">📅 Thay đổi lịch nhận hàng</h1>
+    {{if .Closed}}
+    <p><strong>{{.StoreName}}</strong> sẽ đóng cửa vào ngày bạn hẹn nhận hàng ({{.PickupTime}}).</p>
+    {{else}}
+    <p><strong>{{.StoreName}}</strong> thay đổi giờ nhận hàng vào ngày bạn hẹn ({{.PickupTime}}). Giờ nhận hàng mới: <strong>{{.SpecialHours}}</strong>.</p>
+    {{end}}
+    {{if .Reason}}<p>Lý do: {{.Reason}}</p>{{end}}
+    <p>Mã đặt chỗ: <strong>{{.ReservationID}}</strong></p>
+    <p>Vui lòng liên hệ cửa hàng hoặc hủy đặt chỗ trong ứng dụng Savor nếu bạn không thể đến.</p>
+    <p style="font-size: 12px; color: #999;">Email này được gửi tự động, vui lòng không trả lời.</p>
+</body>
+</html>
+`
+
+const partnerApplicationEmailTemplate = `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+</head>
+<body style="font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif; color: #333; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1 style="color: #036B52;">🤝 Đối tác Savor</h1>
+    <p>Xin chào {{.Name}},</p>
+    {{if eq .Stage "lead"}}
+    <p>Cảm ơn bạn đã đăng ký đưa <strong>{{.StoreName}}</strong> lên Savor. Chúng tôi sẽ liên hệ với bạn trong thời gian sớm nhất.</p>
+    {{else if eq .Stage "contacted"}}
+    <p>Nhân viên Savor đang làm việc với bạn về <strong>{{.StoreName}}</strong>. Vui lòng chuẩn bị giấy phép kinh doanh và giấy chứng nhận an toàn thực phẩm.</p>
+    {{else if eq .Stage "documents_received"}}
+    <p>Chúng tôi đã nhận đủ hồ sơ của <strong>{{.StoreName}}</strong> và đang xem xét.</p>
+    {{else if eq .Stage "approved"}}
+    <p><strong>{{.StoreName}}</strong> đã được duyệt. Tài khoản cửa hàng của bạn đã sẵn sàng.</p>
+    {{if .SetupURL}}<p><a href="{{.SetupURL}}" style="display: inline-block; background: #036B52; color: #fff; padding: 12px 24px; border-radius: 6px; text-decoration: none;">Đặt mật khẩu</a></p>{{end}}
+    <p>Sau khi đăng nhập, hãy thêm ảnh, túi bất ngờ và lịch nhận hàng để bắt đầu bán.</p>
+    {{else if eq .Stage "live"}}
+    <p>🎉 <strong>{{.StoreName}}</strong> đã xuất hiện trên Savor. Khách hàng có thể bắt đầu đặt túi bất ngờ của bạn.</p>
+    {{else if eq .Stage "rejected"}}
+    <p>Rất tiếc, hiện tại chúng tôi chưa thể hợp tác với <strong>{{.StoreName}}</strong>. Cảm ơn bạn đã quan tâm đến Savor.</p>
+    {{end}}
+    <p style="font-size: 12px; color: #999;">Email này được gửi tự động, vui lòng không trả lời.</p>
+</body>
+</html>
+`
+
+const storeReviewEmailTemplate = `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+</head>
+<body style="font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif; color: #333; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1 style="color: #036B52;">🏪 Kết quả duyệt cửa hàng</h1>
+    {{if eq .Action "approved"}}
+    <p><strong>{{.StoreName}}</strong> đã được duyệt và sẽ hiển thị với khách hàng khi cửa hàng đang mở bán.</p>
+    {{else if eq .Action "rejected"}}
+    <p><strong>{{.StoreName}}</strong> chưa được duyệt. Vui lòng chỉnh sửa thông tin cửa hàng và gửi duyệt lại.</p>
+    {{else if eq .Action "suspended"}}
+    <p><strong>{{.StoreName}}</strong> đã bị tạm ngưng và không còn hiển thị với khách hàng.</p>
+    {{end}}
+    {{if .Reason}}<p>Lý do: {{.Reason}}</p>{{end}}
+    <p style="font-size: 12px; color: #999;">Email này được gửi tự động, vui lòng không trả lời.</p>
+</body>
+</html>
+`
+
+const storeOffboardingEmailTemplate = `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+</head>
+<body style="font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif; color: #333; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1 style="color: #036B52;">🏪 Cửa hàng đã ngừng hoạt động</h1>
+    <p><strong>{{.StoreName}}</strong> đã ngừng hoạt động trên Savor nên đặt chỗ của bạn{{if .PickupTime}} ({{.PickupTime}}){{end}} đã được hủy.</p>
+    <p>Mã đặt chỗ: <strong>{{.ReservationID}}</strong></p>
+    {{if .CardRefund}}<p>Số tiền <strong>{{printf "%.2f" .CardRefund}}</strong> sẽ được hoàn về thẻ của bạn trong 5-10 ngày làm việc.</p>{{end}}
+    {{if .WalletRefund}}<p>Số tiền <strong>{{printf "%.2f" .WalletRefund}}</strong> đã được hoàn vào ví Savor của bạn.</p>{{end}}
+    {{if not (or .CardRefund .WalletRefund)}}<p>Bạn chưa thanh toán cho đặt chỗ này nên không cần hoàn tiền.</p>{{end}}
+    <p>Hãy khám phá những cửa hàng khác gần bạn trong ứng dụng Savor.</p>
+    <p style="font-size: 12px; color: #999;">Email này được gửi tự động, vui lòng không trả lời.</p>
+</body>
+</html>
+`
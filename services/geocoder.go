@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ErrAddressNotFound is returned when a geocoder has no result for an address
+var ErrAddressNotFound = errors.New("address not found")
+
+// GeocodeResult is the location of an address. Precise is false when the geocoder only
+// matched an area (a street, ward or city) rather than the building, in which case the
+// owner should confirm the pin.
+type GeocodeResult struct {
+	Latitude         float64 `json:"latitude"`
+	Longitude        float64 `json:"longitude"`
+	FormattedAddress string  `json:"formattedAddress"`
+	City             string  `json:"city"`
+	PlaceID          string  `json:"placeId"`
+	Precise          bool    `json:"precise"`
+}
+
+// Geocoder turns a postal address into coordinates
+type Geocoder interface {
+	Geocode(ctx context.Context, address string) (*GeocodeResult, error)
+}
+
+// Global geocoder instance; nil when no geocoder is configured
+var Geocoding Geocoder
+
+// InitializeGeocoder picks the geocoder from GEOCODER: "google" (the default, needs the
+// Google Maps service), "fake" to answer from the GEOCODER_FIXTURES JSON file, or "none"
+func InitializeGeocoder() error {
+	switch backend := strings.ToLower(os.Getenv("GEOCODER")); backend {
+	case "", "google":
+		if GoogleMaps == nil {
+			log.Printf("Warning: Geocoding not available without the Google Maps service")
+			return nil
+		}
+		Geocoding = GoogleMaps
+		log.Printf("Using Google Maps geocoding")
+	case "fake":
+		path := getEnvOrDefault("GEOCODER_FIXTURES", "services/fixtures/geocode.json")
+		fake, err := NewFakeGeocoder(path)
+		if err != nil {
+			return err
+		}
+		Geocoding = fake
+		log.Printf("Warning: Using fake geocoder with fixtures from %s", path)
+	case "none":
+		log.Printf("Warning: Geocoding disabled")
+	default:
+		return fmt.Errorf("unknown GEOCODER %q", backend)
+	}
+	return nil
+}
+
+// MapsURL links to the location on Google Maps, pinned to the place when it is known
+func MapsURL(lat, lng float64, placeID string) string {
+	params := url.Values{}
+	params.Set("api", "1")
+	params.Set("query", fmt.Sprintf("%f,%f", lat, lng))
+	if placeID != "" {
+		params.Set("query_place_id", placeID)
+	}
+	return "https://www.google.com/maps/search/?" + params.Encode()
+}
+
+// HaversineMeters is the great-circle distance between two points
+func HaversineMeters(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadiusMeters = 6371000
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FakeGeocoder answers from a fixture file so development and demos work without a
+// Google Maps key. Addresses are matched case-insensitively; if the full address is not
+// listed, leading parts are dropped ("12 Hang Bac, Hoan Kiem, Hanoi" falls back to
+// "Hoan Kiem, Hanoi", then "Hanoi") and the result is marked imprecise.
+type FakeGeocoder struct {
+	results map[string]GeocodeResult
+}
+
+type geocodeFixture struct {
+	Address string `json:"address"`
+	GeocodeResult
+}
+
+// NewFakeGeocoder loads a JSON array of {"address", "latitude", "longitude",
+// "formattedAddress", "city", "placeId", "precise"} objects
+func NewFakeGeocoder(path string) (*FakeGeocoder, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read geocoder fixtures: %v", err)
+	}
+	var fixtures []geocodeFixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("invalid geocoder fixtures: %v", err)
+	}
+
+	g := &FakeGeocoder{results: make(map[string]GeocodeResult, len(fixtures))}
+	for _, f := range fixtures {
+		g.results[strings.Join(addressParts(f.Address), ", ")] = f.GeocodeResult
+	}
+	return g, nil
+}
+
+func (g *FakeGeocoder) Geocode(ctx context.Context, address string) (*GeocodeResult, error) {
+	parts := addressParts(address)
+	for i := range parts {
+		if result, ok := g.results[strings.Join(parts[i:], ", ")]; ok {
+			if i > 0 {
+				result.Precise = false
+			}
+			return &result, nil
+		}
+	}
+	return nil, ErrAddressNotFound
+}
+
+// addressParts lowercases an address and splits it on commas, dropping empty parts
+func addressParts(address string) []string {
+	var parts []string
+	for _, p := range strings.Split(strings.ToLower(address), ",") {
+		if p = strings.Join(strings.Fields(p), " "); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
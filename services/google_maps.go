@@ -19,6 +19,8 @@ type DistanceResult struct {
 	Duration string `json:"duration"`
 	Meters   int    `json:"meters"`
 	Seconds  int    `json:"seconds"`
+	// Estimated is true when the trip was not routed, only guessed from the straight line
+	Estimated bool `json:"estimated"`
 }
 
 type DirectionsResult struct {
@@ -41,44 +43,62 @@ func NewGoogleMapsService() (*GoogleMapsService, error) {
 	return &GoogleMapsService{client: client}, nil
 }
 
-func (g *GoogleMapsService) CalculateDistance(originLat, originLng, destLat, destLng float64) (*DistanceResult, error) {
-	origin := fmt.Sprintf("%f,%f", originLat, originLng)
-	destination := fmt.Sprintf("%f,%f", destLat, destLng)
-
-	req := &maps.DistanceMatrixRequest{
-		Origins:      []string{origin},
-		Destinations: []string{destination},
-		Mode:         maps.TravelModeDriving,
-		Units:        maps.UnitsMetric,
-	}
-
-	log.Printf("Sending Distance Matrix request to Google Maps: %+v", req)
-
-	resp, err := g.client.DistanceMatrix(context.Background(), req)
+func (g *GoogleMapsService) CalculateDistance(ctx context.Context, originLat, originLng, destLat, destLng float64) (*DistanceResult, error) {
+	results, err := g.CalculateDistances(ctx, LatLng{originLat, originLng}, []LatLng{{destLat, destLng}})
 	if err != nil {
-		log.Printf("ERROR: Google Maps Distance Matrix API call failed: %v", err)
-		return nil, fmt.Errorf("failed to calculate distance: %v", err)
+		return nil, err
 	}
-
-	if len(resp.Rows) == 0 || len(resp.Rows[0].Elements) == 0 {
-		log.Printf("ERROR: No distance data returned from Google Maps. Response: %+v", resp)
-		return nil, fmt.Errorf("no distance data returned")
+	if results[0] == nil {
+		return nil, fmt.Errorf("distance calculation failed: no route")
 	}
+	return results[0], nil
+}
 
-	element := resp.Rows[0].Elements[0]
-	if element.Status != "OK" {
-		log.Printf("ERROR: Google Maps Distance Matrix element status not OK: %s. Response: %+v", element.Status, resp)
-		return nil, fmt.Errorf("distance calculation failed: %s", element.Status)
+// CalculateDistances asks the Distance Matrix API for every destination at once, in
+// requests of up to maxDistanceMatrixDestinations. Destinations Google cannot route to
+// are nil.
+func (g *GoogleMapsService) CalculateDistances(ctx context.Context, origin LatLng, destinations []LatLng) ([]*DistanceResult, error) {
+	results := make([]*DistanceResult, len(destinations))
+	for start := 0; start < len(destinations); start += maxDistanceMatrixDestinations {
+		batch := destinations[start:min(start+maxDistanceMatrixDestinations, len(destinations))]
+
+		req := &maps.DistanceMatrixRequest{
+			Origins:      []string{origin.String()},
+			Destinations: make([]string, len(batch)),
+			Mode:         maps.Mode(DistanceModeDriving),
+			Units:        maps.UnitsMetric,
+		}
+		for i, destination := range batch {
+			req.Destinations[i] = destination.String()
+		}
+
+		log.Printf("Sending Distance Matrix request to Google Maps for %d destinations", len(batch))
+
+		resp, err := g.client.DistanceMatrix(ctx, req)
+		if err != nil {
+			log.Printf("ERROR: Google Maps Distance Matrix API call failed: %v", err)
+			return nil, fmt.Errorf("failed to calculate distance: %v", err)
+		}
+
+		if len(resp.Rows) == 0 || len(resp.Rows[0].Elements) != len(batch) {
+			log.Printf("ERROR: No distance data returned from Google Maps. Response: %+v", resp)
+			return nil, fmt.Errorf("no distance data returned")
+		}
+
+		for i, element := range resp.Rows[0].Elements {
+			if element.Status != "OK" {
+				log.Printf("ERROR: Google Maps Distance Matrix element status not OK for %s: %s", req.Destinations[i], element.Status)
+				continue
+			}
+			results[start+i] = &DistanceResult{
+				Distance: element.Distance.HumanReadable,
+				Duration: element.Duration.String(),
+				Meters:   element.Distance.Meters,
+				Seconds:  int(element.Duration.Seconds()),
+			}
+		}
 	}
-
-	log.Printf("Successfully received distance data from Google Maps: %s, %s", element.Distance.HumanReadable, element.Duration.String())
-
-	return &DistanceResult{
-		Distance: element.Distance.HumanReadable,
-		Duration: element.Duration.String(),
-		Meters:   element.Distance.Meters,
-		Seconds:  int(element.Duration.Seconds()),
-	}, nil
+	return results, nil
 }
 
 func (g *GoogleMapsService) GetDirectionsURL(originLat, originLng, destLat, destLng float64) string {
@@ -130,6 +150,37 @@ func (g *GoogleMapsService) GetDirections(originLat, originLng, destLat, destLng
 	}, nil
 }
 
+// Geocode looks up an address with the Geocoding API. Only ROOFTOP results count as
+// precise; interpolated and area results need the owner to confirm the pin.
+func (g *GoogleMapsService) Geocode(ctx context.Context, address string) (*GeocodeResult, error) {
+	results, err := g.client.Geocode(ctx, &maps.GeocodingRequest{Address: address})
+	if err != nil {
+		log.Printf("ERROR: Google Maps Geocoding API call failed: %v", err)
+		return nil, fmt.Errorf("failed to geocode address: %v", err)
+	}
+	if len(results) == 0 {
+		return nil, ErrAddressNotFound
+	}
+
+	r := results[0]
+	result := &GeocodeResult{
+		Latitude:         r.Geometry.Location.Lat,
+		Longitude:        r.Geometry.Location.Lng,
+		FormattedAddress: r.FormattedAddress,
+		PlaceID:          r.PlaceID,
+		Precise:          r.Geometry.LocationType == "ROOFTOP" && !r.PartialMatch,
+	}
+	for _, component := range r.AddressComponents {
+		for _, t := range component.Types {
+			// Vietnamese cities are usually first-level administrative areas
+			if (t == "locality" || (t == "administrative_area_level_1" && result.City == "")) && component.LongName != "" {
+				result.City = component.LongName
+			}
+		}
+	}
+	return result, nil
+}
+
 // Global instance
 var GoogleMaps *GoogleMapsService
 
@@ -0,0 +1,283 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// maxImagePixels rejects images that would take too much memory to decode
+const maxImagePixels = 40_000_000
+
+// minImageSide rejects icons and tracking pixels that cannot be cropped sensibly
+const minImageSide = 64
+
+const imageJPEGQuality = 85
+
+var ErrUnsupportedImage = errors.New("only JPEG and PNG images are supported")
+
+// ImageVariant is one size an uploaded image is resized to. Crop fills Width x Height
+// exactly; otherwise the image is scaled to fit inside it. Images are never upscaled.
+type ImageVariant struct {
+	Name   string
+	Width  int
+	Height int
+	Crop   bool
+}
+
+// StoreImageVariants are generated for every store photo
+var StoreImageVariants = []ImageVariant{
+	{Name: "thumbnail", Width: 240, Height: 240, Crop: true},
+	{Name: "card", Width: 800, Height: 500, Crop: true},
+	{Name: "hero", Width: 1920, Height: 1080},
+}
+
+// ProcessedImage is an encoded variant ready to store
+type ProcessedImage struct {
+	Variant ImageVariant
+	Data    []byte
+	Width   int
+	Height  int
+}
+
+// DetectImageType returns the MIME type of a supported image, sniffed from its content
+func DetectImageType(data []byte) (string, error) {
+	switch contentType := http.DetectContentType(data); contentType {
+	case "image/jpeg", "image/png":
+		return contentType, nil
+	default:
+		return "", ErrUnsupportedImage
+	}
+}
+
+// ProcessImage decodes an uploaded JPEG or PNG, applies its EXIF orientation and
+// re-encodes every variant as JPEG. Re-encoding drops EXIF and all other metadata,
+// including GPS positions from phone cameras.
+func ProcessImage(data []byte, variants []ImageVariant) ([]ProcessedImage, error) {
+	contentType, err := DetectImageType(data)
+	if err != nil {
+		return nil, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %v", err)
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, fmt.Errorf("image is too large (%dx%d)", config.Width, config.Height)
+	}
+	if config.Width < minImageSide || config.Height < minImageSide {
+		return nil, fmt.Errorf("image is too small (%dx%d)", config.Width, config.Height)
+	}
+
+	var src image.Image
+	if contentType == "image/png" {
+		src, err = png.Decode(bytes.NewReader(data))
+	} else {
+		src, err = jpeg.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %v", err)
+	}
+
+	// Flatten onto white: JPEG has no alpha channel
+	b := src.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), src, b.Min, draw.Over)
+
+	if contentType == "image/jpeg" {
+		flat = applyOrientation(flat, jpegOrientation(data))
+	}
+
+	processed := make([]ProcessedImage, 0, len(variants))
+	for _, v := range variants {
+		img := resizeVariant(flat, v)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: imageJPEGQuality}); err != nil {
+			return nil, fmt.Errorf("failed to encode %s image: %v", v.Name, err)
+		}
+		processed = append(processed, ProcessedImage{
+			Variant: v,
+			Data:    buf.Bytes(),
+			Width:   img.Bounds().Dx(),
+			Height:  img.Bounds().Dy(),
+		})
+	}
+	return processed, nil
+}
+
+// resizeVariant crops to the variant's aspect ratio if needed and scales down
+func resizeVariant(src *image.RGBA, v ImageVariant) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	if v.Crop {
+		// Largest centered rectangle with the variant's aspect ratio
+		cropW, cropH := w, w*v.Height/v.Width
+		if cropH > h {
+			cropW, cropH = h*v.Width/v.Height, h
+		}
+		x0, y0 := (w-cropW)/2, (h-cropH)/2
+		src = src.SubImage(image.Rect(x0, y0, x0+cropW, y0+cropH)).(*image.RGBA)
+		w, h = cropW, cropH
+
+		if w > v.Width {
+			return resizeArea(src, v.Width, v.Height)
+		}
+		return src
+	}
+
+	if w <= v.Width && h <= v.Height {
+		return src
+	}
+	// Fit inside the box, keeping the aspect ratio
+	targetW, targetH := v.Width, h*v.Width/w
+	if targetH > v.Height {
+		targetW, targetH = w*v.Height/h, v.Height
+	}
+	return resizeArea(src, max(targetW, 1), max(targetH, 1))
+}
+
+// resizeArea downscales by averaging the source pixels under each destination pixel,
+// which avoids the aliasing of nearest-neighbour or bilinear sampling at large ratios
+func resizeArea(src *image.RGBA, width, height int) *image.RGBA {
+	b := src.Bounds()
+	srcW, srcH := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		sy0 := b.Min.Y + y*srcH/height
+		sy1 := b.Min.Y + max((y+1)*srcH/height, y*srcH/height+1)
+		for x := 0; x < width; x++ {
+			sx0 := b.Min.X + x*srcW/width
+			sx1 := b.Min.X + max((x+1)*srcW/width, x*srcW/width+1)
+
+			var r, g, bl, a, n uint32
+			for sy := sy0; sy < sy1; sy++ {
+				i := src.PixOffset(sx0, sy)
+				for sx := sx0; sx < sx1; sx++ {
+					r += uint32(src.Pix[i])
+					g += uint32(src.Pix[i+1])
+					bl += uint32(src.Pix[i+2])
+					a += uint32(src.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8(r / n)
+			dst.Pix[j+1] = uint8(g / n)
+			dst.Pix[j+2] = uint8(bl / n)
+			dst.Pix[j+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// applyOrientation rotates and flips the image so it displays upright once the EXIF
+// orientation tag is gone. Orientation values follow the EXIF spec (1 = upright).
+func applyOrientation(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise to display
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90 counter-clockwise to display
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation tag from a JPEG's APP1 segment, or 1 if
+// there is none or it cannot be parsed
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		// Start of scan: image data follows and there are no more metadata segments
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation finds tag 0x0112 in IFD0 of a TIFF-structured EXIF block
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd : ifd+2]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8 : entry+10]))
+		}
+	}
+	return 1
+}
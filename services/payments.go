@@ -0,0 +1,236 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/customer"
+	"github.com/stripe/stripe-go/v74/paymentintent"
+	"github.com/stripe/stripe-go/v74/paymentmethod"
+	"github.com/stripe/stripe-go/v74/refund"
+	"github.com/stripe/stripe-go/v74/setupintent"
+)
+
+// PaymentIntent statuses the handlers care about (same values as Stripe)
+const (
+	PaymentIntentStatusSucceeded      = "succeeded"
+	PaymentIntentStatusRequiresAction = "requires_action"
+	PaymentIntentStatusCanceled       = "canceled"
+)
+
+// PaymentIntent is the subset of a Stripe PaymentIntent used by the handlers
+type PaymentIntent struct {
+	ID              string
+	ClientSecret    string
+	Status          string
+	Amount          int64
+	Currency        string
+	CustomerID      string
+	PaymentMethodID string
+	Metadata        map[string]string
+}
+
+// CreatePaymentIntentParams describes a card payment in minor units
+type CreatePaymentIntentParams struct {
+	AmountMinor int64
+	Currency    string
+	CustomerID  string
+	// PaymentMethodID attaches a saved card; charge it with ConfirmPaymentIntent
+	PaymentMethodID string
+	// SaveCard keeps the card entered for this payment on the customer
+	SaveCard bool
+	Metadata map[string]string
+}
+
+// SetupIntent lets the client collect and save a card without charging it
+type SetupIntent struct {
+	ID           string
+	ClientSecret string
+	Status       string
+}
+
+// SavedPaymentMethod is a card attached to a customer
+type SavedPaymentMethod struct {
+	ID       string `json:"id"`
+	Brand    string `json:"brand"`
+	Last4    string `json:"last4"`
+	ExpMonth int64  `json:"expMonth"`
+	ExpYear  int64  `json:"expYear"`
+}
+
+// PaymentGateway is everything the server asks of the payment provider.
+// StripeGateway talks to Stripe; FakePaymentGateway keeps state in memory.
+type PaymentGateway interface {
+	CreateCustomer(userID, email string) (string, error)
+	CreatePaymentIntent(params CreatePaymentIntentParams) (*PaymentIntent, error)
+	GetPaymentIntent(id string) (*PaymentIntent, error)
+	ConfirmPaymentIntent(id string) (*PaymentIntent, error)
+	CancelPaymentIntent(id string) error
+	// RefundPaymentIntent returns amountMinor of a succeeded payment to the card.
+	// reference names what the refund is for, e.g. the reservation; a retry with the
+	// same reference and amount succeeds without refunding again.
+	RefundPaymentIntent(id, reference string, amountMinor int64) error
+	CreateSetupIntent(customerID string) (*SetupIntent, error)
+	ListPaymentMethods(customerID string) ([]SavedPaymentMethod, error)
+	DetachPaymentMethod(paymentMethodID string) error
+}
+
+// Global payment gateway instance
+var Payments PaymentGateway
+
+// InitializePayments uses Stripe; config.InitializeStripe must have set the key
+func InitializePayments() {
+	Payments = &StripeGateway{}
+}
+
+// StripeGateway implements PaymentGateway with the Stripe API
+type StripeGateway struct{}
+
+func (g *StripeGateway) CreateCustomer(userID, email string) (string, error) {
+	params := &stripe.CustomerParams{}
+	if email != "" {
+		params.Email = stripe.String(email)
+	}
+	params.AddMetadata("firebase_uid", userID)
+
+	cus, err := customer.New(params)
+	if err != nil {
+		return "", err
+	}
+	return cus.ID, nil
+}
+
+func (g *StripeGateway) CreatePaymentIntent(p CreatePaymentIntentParams) (*PaymentIntent, error) {
+	currency := p.Currency
+	if currency == "" {
+		currency = string(stripe.CurrencyUSD)
+	}
+
+	params := &stripe.PaymentIntentParams{
+		Amount:             stripe.Int64(p.AmountMinor),
+		Currency:           stripe.String(currency),
+		PaymentMethodTypes: []*string{stripe.String("card")},
+	}
+	if p.CustomerID != "" {
+		params.Customer = stripe.String(p.CustomerID)
+	}
+	if p.PaymentMethodID != "" {
+		params.PaymentMethod = stripe.String(p.PaymentMethodID)
+	}
+	if p.SaveCard && p.CustomerID != "" {
+		params.SetupFutureUsage = stripe.String(string(stripe.PaymentIntentSetupFutureUsageOnSession))
+	}
+	for k, v := range p.Metadata {
+		params.AddMetadata(k, v)
+	}
+
+	pi, err := paymentintent.New(params)
+	if err != nil {
+		return nil, err
+	}
+	return fromStripePaymentIntent(pi), nil
+}
+
+func (g *StripeGateway) GetPaymentIntent(id string) (*PaymentIntent, error) {
+	pi, err := paymentintent.Get(id, nil)
+	if err != nil {
+		return nil, err
+	}
+	return fromStripePaymentIntent(pi), nil
+}
+
+func (g *StripeGateway) ConfirmPaymentIntent(id string) (*PaymentIntent, error) {
+	pi, err := paymentintent.Confirm(id, nil)
+	if err != nil {
+		return nil, err
+	}
+	return fromStripePaymentIntent(pi), nil
+}
+
+func (g *StripeGateway) CancelPaymentIntent(id string) error {
+	_, err := paymentintent.Cancel(id, nil)
+	return err
+}
+
+// refundIdempotencyKey identifies one refund, so a second or partial refund of the same
+// payment is not mistaken for a retry of the first
+func refundIdempotencyKey(id, reference string, amountMinor int64) string {
+	return fmt.Sprintf("refund-%s-%s-%d", id, reference, amountMinor)
+}
+
+func (g *StripeGateway) RefundPaymentIntent(id, reference string, amountMinor int64) error {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(id),
+		Amount:        stripe.Int64(amountMinor),
+	}
+	params.AddMetadata("reference", reference)
+	params.SetIdempotencyKey(refundIdempotencyKey(id, reference, amountMinor))
+
+	_, err := refund.New(params)
+	if stripeErr, ok := err.(*stripe.Error); ok && stripeErr.Code == stripe.ErrorCodeChargeAlreadyRefunded {
+		return nil
+	}
+	return err
+}
+
+func (g *StripeGateway) CreateSetupIntent(customerID string) (*SetupIntent, error) {
+	params := &stripe.SetupIntentParams{
+		Customer:           stripe.String(customerID),
+		PaymentMethodTypes: []*string{stripe.String("card")},
+		Usage:              stripe.String(string(stripe.SetupIntentUsageOnSession)),
+	}
+
+	si, err := setupintent.New(params)
+	if err != nil {
+		return nil, err
+	}
+	return &SetupIntent{ID: si.ID, ClientSecret: si.ClientSecret, Status: string(si.Status)}, nil
+}
+
+func (g *StripeGateway) ListPaymentMethods(customerID string) ([]SavedPaymentMethod, error) {
+	params := &stripe.PaymentMethodListParams{
+		Customer: stripe.String(customerID),
+		Type:     stripe.String(string(stripe.PaymentMethodTypeCard)),
+	}
+
+	methods := make([]SavedPaymentMethod, 0)
+	iter := paymentmethod.List(params)
+	for iter.Next() {
+		pm := iter.PaymentMethod()
+		method := SavedPaymentMethod{ID: pm.ID}
+		if pm.Card != nil {
+			method.Brand = string(pm.Card.Brand)
+			method.Last4 = pm.Card.Last4
+			method.ExpMonth = pm.Card.ExpMonth
+			method.ExpYear = pm.Card.ExpYear
+		}
+		methods = append(methods, method)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return methods, nil
+}
+
+func (g *StripeGateway) DetachPaymentMethod(paymentMethodID string) error {
+	_, err := paymentmethod.Detach(paymentMethodID, nil)
+	return err
+}
+
+func fromStripePaymentIntent(pi *stripe.PaymentIntent) *PaymentIntent {
+	result := &PaymentIntent{
+		ID:           pi.ID,
+		ClientSecret: pi.ClientSecret,
+		Status:       string(pi.Status),
+		Amount:       pi.Amount,
+		Currency:     string(pi.Currency),
+		Metadata:     pi.Metadata,
+	}
+	if pi.Customer != nil {
+		result.CustomerID = pi.Customer.ID
+	}
+	if pi.PaymentMethod != nil {
+		result.PaymentMethodID = pi.PaymentMethod.ID
+	}
+	return result
+}
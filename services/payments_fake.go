@@ -0,0 +1,223 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+)
+
+// FakePaymentGateway is an in-memory PaymentGateway for tests and local development.
+// Intents confirmed with a saved card succeed; others wait for SucceedPaymentIntent.
+type FakePaymentGateway struct {
+	mu        sync.Mutex
+	seq       int
+	customers map[string]string
+	intents   map[string]*PaymentIntent
+	setups    map[string]string
+	methods   map[string][]SavedPaymentMethod
+	// refunded is the amount refunded per intent; refunds holds each refund's idempotency key
+	refunded map[string]int64
+	refunds  map[string]bool
+}
+
+func NewFakePaymentGateway() *FakePaymentGateway {
+	return &FakePaymentGateway{
+		customers: make(map[string]string),
+		intents:   make(map[string]*PaymentIntent),
+		setups:    make(map[string]string),
+		methods:   make(map[string][]SavedPaymentMethod),
+		refunded:  make(map[string]int64),
+		refunds:   make(map[string]bool),
+	}
+}
+
+func (f *FakePaymentGateway) nextID(prefix string) string {
+	f.seq++
+	return fmt.Sprintf("%s_fake_%d", prefix, f.seq)
+}
+
+func (f *FakePaymentGateway) CreateCustomer(userID, email string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := f.nextID("cus")
+	f.customers[id] = userID
+	return id, nil
+}
+
+func (f *FakePaymentGateway) CreatePaymentIntent(p CreatePaymentIntentParams) (*PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if p.AmountMinor <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+
+	pi := &PaymentIntent{
+		ID:         f.nextID("pi"),
+		Status:     "requires_payment_method",
+		Amount:     p.AmountMinor,
+		Currency:   p.Currency,
+		CustomerID: p.CustomerID,
+		Metadata:   make(map[string]string),
+	}
+	if pi.Currency == "" {
+		pi.Currency = "usd"
+	}
+	pi.ClientSecret = pi.ID + "_secret"
+	for k, v := range p.Metadata {
+		pi.Metadata[k] = v
+	}
+
+	if p.PaymentMethodID != "" {
+		if !f.hasMethod(p.CustomerID, p.PaymentMethodID) {
+			return nil, fmt.Errorf("payment method %s does not belong to customer %s", p.PaymentMethodID, p.CustomerID)
+		}
+		pi.PaymentMethodID = p.PaymentMethodID
+		pi.Status = "requires_confirmation"
+	}
+
+	f.intents[pi.ID] = pi
+	copied := *pi
+	return &copied, nil
+}
+
+func (f *FakePaymentGateway) GetPaymentIntent(id string) (*PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pi, ok := f.intents[id]
+	if !ok {
+		return nil, fmt.Errorf("no such payment intent: %s", id)
+	}
+	copied := *pi
+	return &copied, nil
+}
+
+func (f *FakePaymentGateway) ConfirmPaymentIntent(id string) (*PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pi, ok := f.intents[id]
+	if !ok {
+		return nil, fmt.Errorf("no such payment intent: %s", id)
+	}
+	if pi.PaymentMethodID == "" {
+		return nil, fmt.Errorf("payment intent %s has no payment method", id)
+	}
+	if pi.Status == PaymentIntentStatusCanceled {
+		return nil, fmt.Errorf("payment intent %s was canceled", id)
+	}
+	pi.Status = PaymentIntentStatusSucceeded
+	copied := *pi
+	return &copied, nil
+}
+
+func (f *FakePaymentGateway) CancelPaymentIntent(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pi, ok := f.intents[id]
+	if !ok {
+		return fmt.Errorf("no such payment intent: %s", id)
+	}
+	if pi.Status == PaymentIntentStatusSucceeded {
+		return fmt.Errorf("payment intent %s has already succeeded", id)
+	}
+	pi.Status = PaymentIntentStatusCanceled
+	return nil
+}
+
+func (f *FakePaymentGateway) RefundPaymentIntent(id, reference string, amountMinor int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pi, ok := f.intents[id]
+	if !ok {
+		return fmt.Errorf("no such payment intent: %s", id)
+	}
+	if pi.Status != PaymentIntentStatusSucceeded {
+		return fmt.Errorf("payment intent %s has not succeeded", id)
+	}
+	key := refundIdempotencyKey(id, reference, amountMinor)
+	if f.refunds[key] {
+		return nil
+	}
+	if left := pi.Amount - f.refunded[id]; amountMinor <= 0 || amountMinor > left {
+		return fmt.Errorf("refund amount must be between 1 and %d", left)
+	}
+	f.refunds[key] = true
+	f.refunded[id] += amountMinor
+	return nil
+}
+
+func (f *FakePaymentGateway) CreateSetupIntent(customerID string) (*SetupIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.customers[customerID]; !ok {
+		return nil, fmt.Errorf("no such customer: %s", customerID)
+	}
+	id := f.nextID("seti")
+	f.setups[id] = customerID
+	return &SetupIntent{ID: id, ClientSecret: id + "_secret", Status: "requires_payment_method"}, nil
+}
+
+func (f *FakePaymentGateway) ListPaymentMethods(customerID string) ([]SavedPaymentMethod, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	methods := make([]SavedPaymentMethod, len(f.methods[customerID]))
+	copy(methods, f.methods[customerID])
+	return methods, nil
+}
+
+func (f *FakePaymentGateway) DetachPaymentMethod(paymentMethodID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for customerID, methods := range f.methods {
+		for i, m := range methods {
+			if m.ID == paymentMethodID {
+				f.methods[customerID] = append(methods[:i], methods[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no such payment method: %s", paymentMethodID)
+}
+
+// SucceedPaymentIntent simulates the customer completing payment on the client
+func (f *FakePaymentGateway) SucceedPaymentIntent(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pi, ok := f.intents[id]
+	if !ok {
+		return fmt.Errorf("no such payment intent: %s", id)
+	}
+	pi.Status = PaymentIntentStatusSucceeded
+	return nil
+}
+
+// CompleteSetupIntent simulates the client saving a card through a SetupIntent
+func (f *FakePaymentGateway) CompleteSetupIntent(id, brand, last4 string) (SavedPaymentMethod, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	customerID, ok := f.setups[id]
+	if !ok {
+		return SavedPaymentMethod{}, fmt.Errorf("no such setup intent: %s", id)
+	}
+	method := SavedPaymentMethod{ID: f.nextID("pm"), Brand: brand, Last4: last4, ExpMonth: 12, ExpYear: 2030}
+	f.methods[customerID] = append(f.methods[customerID], method)
+	return method, nil
+}
+
+func (f *FakePaymentGateway) hasMethod(customerID, paymentMethodID string) bool {
+	for _, m := range f.methods[customerID] {
+		if m.ID == paymentMethodID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package services
+
+import "testing"
+
+func TestFakePaymentGatewayRefunds(t *testing.T) {
+	gateway := NewFakePaymentGateway()
+
+	customerID, err := gateway.CreateCustomer("user-1", "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setup, err := gateway.CreateSetupIntent(customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := gateway.CompleteSetupIntent(setup.ID, "visa", "4242")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pi, err := gateway.CreatePaymentIntent(CreatePaymentIntentParams{AmountMinor: 1000, CustomerID: customerID, PaymentMethodID: card.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gateway.RefundPaymentIntent(pi.ID, "reservation-1", 400); err == nil {
+		t.Error("refunded a payment that has not succeeded")
+	}
+	if _, err := gateway.ConfirmPaymentIntent(pi.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := gateway.CancelPaymentIntent(pi.ID); err == nil {
+		t.Error("cancelled a succeeded payment")
+	}
+
+	steps := []struct {
+		name        string
+		reference   string
+		amountMinor int64
+		wantErr     bool
+	}{
+		{name: "partial refund", reference: "reservation-1", amountMinor: 400},
+		{name: "retry of the same refund", reference: "reservation-1", amountMinor: 400},
+		{name: "second refund of the rest", reference: "reservation-2", amountMinor: 600},
+		{name: "nothing left to refund", reference: "reservation-3", amountMinor: 1, wantErr: true},
+		{name: "zero refund", reference: "reservation-4", amountMinor: 0, wantErr: true},
+	}
+	for _, step := range steps {
+		err := gateway.RefundPaymentIntent(pi.ID, step.reference, step.amountMinor)
+		if (err != nil) != step.wantErr {
+			t.Errorf("%s: err = %v, want error %v", step.name, err, step.wantErr)
+		}
+	}
+}
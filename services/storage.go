@@ -0,0 +1,40 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// ObjectStorage stores uploaded files and returns the public URL they are served from.
+// LocalStorage writes to disk; S3Storage talks to S3 or an S3-compatible server such as MinIO.
+type ObjectStorage interface {
+	Put(ctx context.Context, key, contentType string, data []byte) (string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Global object storage instance
+var Storage ObjectStorage
+
+// InitializeStorage picks the backend from STORAGE_BACKEND ("local" by default, or "s3")
+func InitializeStorage() error {
+	switch backend := strings.ToLower(os.Getenv("STORAGE_BACKEND")); backend {
+	case "", "local":
+		dir := getEnvOrDefault("LOCAL_STORAGE_DIR", "uploads")
+		baseURL := getEnvOrDefault("LOCAL_STORAGE_BASE_URL", "/uploads")
+		Storage = NewLocalStorage(dir, baseURL)
+		log.Printf("Using local file storage in %s served from %s", dir, baseURL)
+	case "s3":
+		s3, err := NewS3StorageFromEnv()
+		if err != nil {
+			return err
+		}
+		Storage = s3
+		log.Printf("Using S3 storage bucket %s at %s", s3.Bucket, s3.Endpoint)
+	default:
+		return fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+	return nil
+}